@@ -0,0 +1,71 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// appendOutput puts the existing output first among files, leaving it out
+// of the rest, and sets m.appendTo to it.  Without an existing output,
+// files are merged into a new one.
+func (m *Merger) appendOutput(files []string) ([]string, error) {
+	out, err := os.Stat(m.opts.OutFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		m.log.Info("no output to append to, writing a new one", "phase", "find", "file", m.opts.OutFile)
+		return files, nil
+	}
+	if err != nil {
+		return nil, markError(ErrRead, err)
+	}
+	m.appendTo = m.opts.OutFile
+	appended := []string{m.appendTo}
+	for _, file := range files {
+		if !isRemote(file) {
+			if in, err := os.Stat(file); err == nil && os.SameFile(in, out) {
+				continue
+			}
+		}
+		appended = append(appended, file)
+	}
+	m.log.Info("appending to the existing output", "phase", "find", "file", m.appendTo, "files", len(appended)-1)
+	return appended, nil
+}
+
+// appendedFirst moves the output being appended to to the front of
+// scanned, so its rows are copied before the new ones.
+func appendedFirst(scanned []scannedFile, file string) {
+	for i, sf := range scanned {
+		if sf.file == file {
+			copy(scanned[1:i+1], scanned[:i])
+			scanned[0] = sf
+			return
+		}
+	}
+}
+
+// withoutFile returns scanned without file.
+func withoutFile(scanned []scannedFile, file string) []scannedFile {
+	var out []scannedFile
+	for _, sf := range scanned {
+		if sf.file != file {
+			out = append(out, sf)
+		}
+	}
+	return out
+}
+
+// appendError returns the error for an output being appended to that the
+// merge would leave out, which would lose its rows.
+func (m *Merger) appendError() error {
+	for _, f := range m.stats.Skipped {
+		if f.File == m.appendTo && f.Detail != "" {
+			return fmt.Errorf("cannot append to %s: %s: %s", m.appendTo, f.Reason, f.Detail)
+		}
+		if f.File == m.appendTo {
+			return fmt.Errorf("cannot append to %s: %s", m.appendTo, f.Reason)
+		}
+	}
+	return fmt.Errorf("cannot append to %s: it would be left out of the merge", m.appendTo)
+}
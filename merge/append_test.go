@@ -0,0 +1,32 @@
+package merge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMixedInputs(t, dir)
+	out := filepath.Join(dir, "merged.parquet")
+	runMerge(t, testOptions(out, files[1]))
+
+	opts := testOptions(out, files[0], files[2])
+	opts.Append = true
+	// The rows appended to are not filtered.
+	opts.Where = "id != 3 AND id != 5"
+	runMerge(t, opts)
+	if got, want := outputIDs(t, out), []int64{3, 4, 1, 2, 6}; !slices.Equal(got, want) {
+		t.Errorf("merged the ids %v, want %v", got, want)
+	}
+	schema, _ := readParquet(t, out)
+	for _, column := range []string{"name", "score", "zone", "flag"} {
+		if _, ok := schema.Lookup(column); !ok {
+			t.Errorf("the output has no %s column", column)
+		}
+	}
+	if zone, _ := fieldByName(schema, "zone"); zone.Required() {
+		t.Error("zone is required although the appended files lack it")
+	}
+}
@@ -0,0 +1,47 @@
+package merge
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// badFile is an input file left out of the merge, entirely or partly, by
+// -skip-bad-files.
+type badFile struct {
+	file string
+	err  error
+	// copied is the number of rows of the file written before the error.
+	copied int64
+}
+
+type badFiles []badFile
+
+// skip logs err and records file as bad.
+func (b *badFiles) skip(logger *slog.Logger, file string, err error, copied int64) {
+	if copied > 0 {
+		logger.Warn("skipping the rest of a bad file", "file", file, "rows", copied, "error", err)
+	} else {
+		logger.Warn("skipping a bad file", "file", file, "error", err)
+	}
+	*b = append(*b, badFile{file: file, err: err, copied: copied})
+}
+
+// report logs the skipped files and fails if there are more than max of
+// them, unless max is negative.
+func (b badFiles) report(logger *slog.Logger, max int) error {
+	if len(b) == 0 {
+		return nil
+	}
+	logger.Warn("skipped bad files", "files", len(b))
+	for _, f := range b {
+		if f.copied > 0 {
+			logger.Warn("skipped a bad file", "file", f.file, "partial", true, "rows", f.copied, "error", f.err)
+		} else {
+			logger.Warn("skipped a bad file", "file", f.file, "error", f.err)
+		}
+	}
+	if max >= 0 && len(b) > max {
+		return markError(ErrRead, fmt.Errorf("%d bad files is more than -max-bad-files %d", len(b), max))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package merge
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// BenchmarkCopyBatchSize compares copying a million rows one at a time
+// with copying them in batches, decoding each row as files that need
+// converting are.
+func BenchmarkCopyBatchSize(b *testing.B) {
+	dir := b.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	const n = 1000000
+	rows := make([]map[string]any, n)
+	for i := range rows {
+		rows[i] = map[string]any{"id": int64(i), "name": fmt.Sprintf("name-%d", i%1000), "value": float64(i) / 3}
+	}
+	writeParquet(b, in, parquet.Group{
+		"id":    parquet.Int(64),
+		"name":  parquet.String(),
+		"value": parquet.Leaf(parquet.DoubleType),
+	}, rows)
+	for _, batchSize := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				opts := testOptions(filepath.Join(dir, "merged.parquet"), in)
+				opts.BatchSize = batchSize
+				opts.NoFastpath = true
+				runMerge(b, opts)
+			}
+			b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkPageBufferSize merges a wide table with pages of several sizes,
+// reporting the size of the output and the pages it has.
+func BenchmarkPageBufferSize(b *testing.B) {
+	dir := b.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	g := parquet.Group{}
+	for c := 0; c < 50; c++ {
+		g[fmt.Sprintf("c%02d", c)] = parquet.Optional(parquet.Int(64))
+	}
+	rows := make([]map[string]any, 100000)
+	for i := range rows {
+		row := map[string]any{}
+		for c := 0; c < 50; c++ {
+			if (i+c)%7 != 0 {
+				row[fmt.Sprintf("c%02d", c)] = int64(i * c)
+			}
+		}
+		rows[i] = row
+	}
+	writeParquet(b, in, g, rows)
+	for _, size := range []int{minBufferSize, 64 << 10, parquet.DefaultPageBufferSize, 1 << 20} {
+		b.Run(fmt.Sprintf("page=%d", size), func(b *testing.B) {
+			out := filepath.Join(dir, "merged.parquet")
+			for i := 0; i < b.N; i++ {
+				opts := testOptions(out, in)
+				opts.PageBufferSize = size
+				runMerge(b, opts)
+			}
+			f := openOutput(b, out)
+			pages := 0
+			for _, rg := range f.RowGroups() {
+				for _, c := range rg.ColumnChunks() {
+					index, err := c.OffsetIndex()
+					if err != nil {
+						b.Fatal(err)
+					}
+					pages += index.NumPages()
+				}
+			}
+			b.ReportMetric(float64(f.Size()), "bytes")
+			b.ReportMetric(float64(pages), "pages")
+		})
+	}
+}
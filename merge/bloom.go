@@ -0,0 +1,21 @@
+package merge
+
+import (
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// bloomFilters returns split block bloom filters for the leaf columns of
+// schema named by columns, as dotted paths.
+func bloomFilters(schema *parquet.Schema, columns []string, bitsPerValue uint) ([]parquet.BloomFilterColumn, error) {
+	var filters []parquet.BloomFilterColumn
+	for _, column := range columns {
+		leaf, ok := lookupColumn(schema, column)
+		if !ok {
+			return nil, fmt.Errorf("bloom column %s is not a leaf column of the merged schema", column)
+		}
+		filters = append(filters, parquet.SplitBlockFilter(bitsPerValue, schema.Columns()[leaf.ColumnIndex]...))
+	}
+	return filters, nil
+}
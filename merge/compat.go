@@ -0,0 +1,200 @@
+package merge
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// compatReport is the -report written after the scan: what each file
+// holds and why it was or was not merged.  A nil report records nothing.
+type compatReport struct {
+	Files []*compatFile `json:"files"`
+	// SchemaGroups lists the distinct schemas of the scanned files, in
+	// order of first appearance.
+	SchemaGroups []*compatGroup `json:"schema_groups"`
+	// Fields lists, for each leaf column merged from the inputs, the
+	// merged files that have it and the non-null values they hold.
+	Fields []*fieldProvenance `json:"fields,omitempty"`
+	// CopyCounted is set once the values of the files whose statistics do
+	// not count them have been counted as they were copied, and added to
+	// Fields.
+	CopyCounted bool `json:"copy_counted"`
+	byName      map[string]*compatFile
+	byPrint     map[string]*compatGroup
+	byColumn    map[string]*fieldProvenance
+	// uncounted lists, by file, the columns whose values are counted as
+	// the file is copied.
+	uncounted map[string][]string
+}
+
+type compatFile struct {
+	File string `json:"file"`
+	// Columns maps the file's top-level columns to their types, and
+	// SchemaFingerprint identifies them.
+	Columns           map[string]string `json:"columns,omitempty"`
+	SchemaFingerprint string            `json:"schema_fingerprint,omitempty"`
+	// MissingRequired lists, for each -requireFields group, the fields the
+	// file lacks.  It is empty if the file has a group.
+	MissingRequired [][]string       `json:"missing_required,omitempty"`
+	Conflicts       []columnConflict `json:"conflicts,omitempty"`
+	// Unsupported lists the columns -on-unsupported dropped or stringified.
+	Unsupported []unsupportedColumn `json:"unsupported_columns,omitempty"`
+	// NonNull counts the non-null values of the file's merged leaf
+	// columns.
+	NonNull  map[string]int64 `json:"non_null_values,omitempty"`
+	Included bool             `json:"included"`
+	Reason   string           `json:"reason,omitempty"`
+}
+
+// fieldProvenance is where the values of a merged column came from.
+type fieldProvenance struct {
+	Column string `json:"column"`
+	// Files is the number of merged files that have the column, and
+	// NonNull the non-null values they hold: as the statistics of their
+	// column chunks give them or, for FilesCounted of them, as they were
+	// counted while being copied.
+	Files        int   `json:"files"`
+	NonNull      int64 `json:"non_null_values"`
+	FilesCounted int   `json:"files_counted_in_copy"`
+}
+
+// compatGroup is the schema shared by the files with one fingerprint.
+type compatGroup struct {
+	Fingerprint string            `json:"fingerprint"`
+	Files       int               `json:"files"`
+	FirstFile   string            `json:"first_file"`
+	Columns     map[string]string `json:"columns"`
+}
+
+func newCompatReport() *compatReport {
+	return &compatReport{byName: map[string]*compatFile{}, byPrint: map[string]*compatGroup{}, byColumn: map[string]*fieldProvenance{}, uncounted: map[string][]string{}}
+}
+
+func (r *compatReport) file(name string) *compatFile {
+	f, ok := r.byName[name]
+	if !ok {
+		f = &compatFile{File: name}
+		r.byName[name] = f
+		r.Files = append(r.Files, f)
+	}
+	return f
+}
+
+// scanned records the columns of a scanned file.
+func (r *compatReport) scanned(sf scannedFile) {
+	if r == nil {
+		return
+	}
+	f := r.file(sf.file)
+	f.Columns = make(map[string]string, len(sf.nodes))
+	for k, v := range sf.nodes {
+		f.Columns[k] = nodeTypeName(v)
+	}
+	f.SchemaFingerprint = sf.fingerprint
+	f.Unsupported = sf.unsupported
+	g, ok := r.byPrint[sf.fingerprint]
+	if !ok {
+		g = &compatGroup{Fingerprint: sf.fingerprint, FirstFile: sf.file, Columns: f.Columns}
+		r.byPrint[sf.fingerprint] = g
+		r.SchemaGroups = append(r.SchemaGroups, g)
+	}
+	g.Files++
+}
+
+// exclude records why file was not merged, along with the columns that
+// conflicted if err is a *mismatchError.
+func (r *compatReport) exclude(file, reason string, err error) {
+	if r == nil {
+		return
+	}
+	f := r.file(file)
+	f.Reason = reason
+	var mismatch *mismatchError
+	if errors.As(err, &mismatch) {
+		f.Conflicts = mismatch.conflicts
+	}
+}
+
+// missing records the required fields file lacks.
+func (r *compatReport) missing(file string, nodes map[string]parquet.Node, groups [][]requiredField) {
+	if r == nil {
+		return
+	}
+	f := r.file(file)
+	for _, group := range groups {
+		f.MissingRequired = append(f.MissingRequired, missingFields(nodes, group))
+	}
+}
+
+func (r *compatReport) include(file string) {
+	if r != nil {
+		r.file(file).Included = true
+	}
+}
+
+// provenance records, for each leaf column of schema, the columns merged
+// from the inputs, the files of included that have it and the non-null
+// values their statistics give.
+func (r *compatReport) provenance(schema *parquet.Schema, included []scannedFile) {
+	if r == nil {
+		return
+	}
+	for _, path := range schema.Columns() {
+		column := strings.Join(path, ".")
+		p := &fieldProvenance{Column: column}
+		for _, sf := range included {
+			n, ok := sf.nonNull[column]
+			if !ok {
+				continue
+			}
+			p.Files++
+			if n < 0 {
+				p.FilesCounted++
+				r.uncounted[sf.file] = append(r.uncounted[sf.file], column)
+				continue
+			}
+			p.NonNull += n
+			r.counted(sf.file, column, n)
+		}
+		r.byColumn[column] = p
+		r.Fields = append(r.Fields, p)
+	}
+}
+
+// counted records n non-null values of column in file.
+func (r *compatReport) counted(file, column string, n int64) {
+	f := r.file(file)
+	if f.NonNull == nil {
+		f.NonNull = map[string]int64{}
+	}
+	f.NonNull[column] = n
+}
+
+// copied adds the non-null values counted as file was copied, by leaf
+// column of schema, to those of the columns its statistics do not count.
+func (r *compatReport) copied(file string, schema *parquet.Schema, counts []int64) {
+	if r == nil {
+		return
+	}
+	for _, column := range r.uncounted[file] {
+		var n int64
+		if leaf, ok := lookupColumn(schema, column); ok {
+			n = counts[leaf.ColumnIndex]
+		}
+		r.byColumn[column].NonNull += n
+		r.counted(file, column, n)
+	}
+}
+
+// write writes the report to name as indented JSON.
+func (r *compatReport) write(name string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o644)
+}
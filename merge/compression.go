@@ -0,0 +1,102 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// codecNames lists the values -compression accepts, in the order they are
+// listed in errors.
+var codecNames = []string{"zstd", "snappy", "gzip", "lz4", "brotli", "none"}
+
+var codecs = map[string]compress.Codec{
+	"zstd":   &parquet.Zstd,
+	"snappy": &parquet.Snappy,
+	"gzip":   &parquet.Gzip,
+	"lz4":    &parquet.Lz4Raw,
+	"brotli": &parquet.Brotli,
+	"none":   &parquet.Uncompressed,
+}
+
+// parseCodec returns the codec called name.
+func parseCodec(name string) (compress.Codec, error) {
+	codec, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q: must be one of %s", name, strings.Join(codecNames, ", "))
+	}
+	return codec, nil
+}
+
+// parseColumnCodecs looks up the codecs of -column-compression, which maps
+// columns to codec names.
+func parseColumnCodecs(names map[string]string) (map[string]compress.Codec, error) {
+	out := map[string]compress.Codec{}
+	for column, name := range names {
+		codec, err := parseCodec(name)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column, err)
+		}
+		out[column] = codec
+	}
+	return out, nil
+}
+
+// compressColumns returns a copy of nodes with the leaf columns named in
+// overrides, as dotted paths, compressed with their codecs.
+func compressColumns(nodes map[string]parquet.Node, overrides map[string]compress.Codec) (map[string]parquet.Node, error) {
+	out := make(map[string]parquet.Node, len(nodes))
+	for k, v := range nodes {
+		out[k] = v
+	}
+	for column, codec := range overrides {
+		path := strings.Split(column, ".")
+		node, ok := out[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("compressed column %s is not in the merged schema", column)
+		}
+		node, err := compressLeaf(column, node, path[1:], codec)
+		if err != nil {
+			return nil, err
+		}
+		out[path[0]] = node
+	}
+	return out, nil
+}
+
+// compressLeaf returns node with the leaf at path below it compressed with
+// codec.  Only plain groups are descended into, since parquet.List and
+// parquet.Map nodes cannot be rebuilt from their fields.
+func compressLeaf(column string, node parquet.Node, path []string, codec compress.Codec) (parquet.Node, error) {
+	if len(path) == 0 {
+		if !node.Leaf() {
+			return nil, fmt.Errorf("compressed column %s is not a leaf column", column)
+		}
+		return parquet.Compressed(node, codec), nil
+	}
+	if node.Leaf() {
+		return nil, fmt.Errorf("compressed column %s is not in the merged schema", column)
+	}
+	if node.Type().LogicalType() != nil {
+		return nil, fmt.Errorf("compressed column %s is inside a list or map", column)
+	}
+	fields := parquet.Group{}
+	found := false
+	for _, f := range node.Fields() {
+		fields[f.Name()] = f
+		if f.Name() == path[0] {
+			c, err := compressLeaf(column, f, path[1:], codec)
+			if err != nil {
+				return nil, err
+			}
+			fields[f.Name()] = c
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("compressed column %s is not in the merged schema", column)
+	}
+	return withRepetition(fields, node), nil
+}
@@ -0,0 +1,148 @@
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// conflictResolver applies -on-conflict to columns whose types cannot be
+// merged, and remembers what it did for the summary.
+type conflictResolver struct {
+	strategy string
+	// resolved maps the top-level columns that were stringified or
+	// skipped to the strategy used.
+	resolved map[string]string
+	done     []resolvedConflict
+}
+
+// resolvedConflict is a conflict found in file and what was done about it.
+type resolvedConflict struct {
+	columnConflict
+	file, action string
+}
+
+func newConflictResolver(strategy string) *conflictResolver {
+	return &conflictResolver{strategy: strategy, resolved: map[string]string{}}
+}
+
+// resolves reports whether the strategy keeps the file and changes the
+// merged schema instead.
+func (r *conflictResolver) resolves() bool {
+	return r.strategy == "stringify" || r.strategy == "skip-field"
+}
+
+// unresolved returns nodes without the columns already stringified or
+// skipped, which need not be merged again.
+func (r *conflictResolver) unresolved(nodes map[string]parquet.Node) map[string]parquet.Node {
+	if len(r.resolved) == 0 {
+		return nodes
+	}
+	out := make(map[string]parquet.Node, len(nodes))
+	for k, v := range nodes {
+		if _, ok := r.resolved[k]; !ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// resolve stringifies or skips the columns of mismatch in mergedSchema.
+func (r *conflictResolver) resolve(mismatch *mismatchError, mergedSchema map[string]parquet.Node, mergedFrom map[string]string) {
+	for _, c := range mismatch.conflicts {
+		r.resolved[c.key] = r.strategy
+		if r.strategy == "skip-field" {
+			delete(mergedSchema, c.key)
+			r.done = append(r.done, resolvedConflict{c, mismatch.file, "dropped column " + c.key})
+		} else {
+			mergedSchema[c.key] = parquet.Optional(parquet.String())
+			r.done = append(r.done, resolvedConflict{c, mismatch.file, "stored column " + c.key + " as STRING"})
+		}
+		mergedFrom[c.key] = mismatch.file
+	}
+}
+
+// skipFile records that the file of mismatch was left out.
+func (r *conflictResolver) skipFile(mismatch *mismatchError) {
+	for _, c := range mismatch.conflicts {
+		r.done = append(r.done, resolvedConflict{c, mismatch.file, "skipped " + mismatch.file})
+	}
+}
+
+// skipped returns the columns dropped by skip-field.
+func (r *conflictResolver) skipped() []string {
+	var out []string
+	for _, k := range sortedStrings(r.resolved) {
+		if r.resolved[k] == "skip-field" {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// report logs every conflict and what was done about it.
+func (r *conflictResolver) report(logger *slog.Logger) {
+	if len(r.done) == 0 {
+		return
+	}
+	logger.Info("resolved column conflicts", "conflicts", len(r.done), "strategy", r.strategy)
+	for _, c := range r.done {
+		logger.Info("resolved a column conflict", "column", c.Column, "merged_type", c.Merged, "merged_from", c.MergedFrom,
+			"type", c.Type, "file", c.file, "action", c.action)
+	}
+}
+
+// stringified reports whether values of from are formatted as strings to
+// be written as to, a column stringified by -on-conflict.
+func stringified(from, to parquet.Node) bool {
+	if !to.Leaf() || nodeTypeName(to) != "STRING" {
+		return false
+	}
+	if from.Repeated() || !from.Leaf() {
+		return true
+	}
+	name := nodeTypeName(from)
+	return name != "STRING" && name != "UUID" && !textTypes[name]
+}
+
+// formatString formats a value read from a file as from as a string.
+// Lists, maps and groups are written as JSON.
+func formatString(v any, from parquet.Node) (any, error) {
+	if isDecimal(from) {
+		return formatDecimal(v, from)
+	}
+	if isList(from) {
+		items, err := listItems(v)
+		if err != nil {
+			return nil, err
+		}
+		l := make([]any, len(items))
+		for i, item := range items {
+			l[i] = item["element"]
+		}
+		v = l
+	}
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case []byte:
+		return string(x), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case []any, map[string]any:
+		b, err := json.Marshal(x)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,89 @@
+package merge
+
+import (
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// withConvertedTypes returns a copy of elements in which elements that
+// only carry a legacy ConvertedType, as older writers leave them, have the
+// LogicalType it stands for, so they build the same nodes as elements
+// annotated with it.
+func withConvertedTypes(elements []format.SchemaElement) []format.SchemaElement {
+	out := make([]format.SchemaElement, len(elements))
+	for i, e := range elements {
+		if e.LogicalType == nil && e.ConvertedType != nil {
+			e.LogicalType = convertedLogicalType(e)
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// convertedLogicalType returns the LogicalType equivalent to the
+// ConvertedType of e, or nil for those without one, such as INTERVAL.
+// Legacy timestamps and times are adjusted to UTC.
+func convertedLogicalType(e format.SchemaElement) *format.LogicalType {
+	millis := format.TimeUnit{Millis: &format.MilliSeconds{}}
+	micros := format.TimeUnit{Micros: &format.MicroSeconds{}}
+	switch *e.ConvertedType {
+	case deprecated.UTF8:
+		return &format.LogicalType{UTF8: &format.StringType{}}
+	case deprecated.Map, deprecated.MapKeyValue:
+		// MAP_KEY_VALUE was often put on the map group itself.
+		if e.Type == nil {
+			return &format.LogicalType{Map: &format.MapType{}}
+		}
+	case deprecated.List:
+		return &format.LogicalType{List: &format.ListType{}}
+	case deprecated.Enum:
+		return &format.LogicalType{Enum: &format.EnumType{}}
+	case deprecated.Decimal:
+		d := &format.DecimalType{}
+		if e.Precision != nil {
+			d.Precision = *e.Precision
+		}
+		if e.Scale != nil {
+			d.Scale = *e.Scale
+		}
+		return &format.LogicalType{Decimal: d}
+	case deprecated.Date:
+		return &format.LogicalType{Date: &format.DateType{}}
+	case deprecated.TimeMillis:
+		return &format.LogicalType{Time: &format.TimeType{IsAdjustedToUTC: true, Unit: millis}}
+	case deprecated.TimeMicros:
+		return &format.LogicalType{Time: &format.TimeType{IsAdjustedToUTC: true, Unit: micros}}
+	case deprecated.TimestampMillis:
+		return &format.LogicalType{Timestamp: &format.TimestampType{IsAdjustedToUTC: true, Unit: millis}}
+	case deprecated.TimestampMicros:
+		return &format.LogicalType{Timestamp: &format.TimestampType{IsAdjustedToUTC: true, Unit: micros}}
+	case deprecated.Int8, deprecated.Int16, deprecated.Int32, deprecated.Int64,
+		deprecated.Uint8, deprecated.Uint16, deprecated.Uint32, deprecated.Uint64:
+		return &format.LogicalType{Integer: convertedIntType(*e.ConvertedType)}
+	case deprecated.Json:
+		return &format.LogicalType{Json: &format.JsonType{}}
+	case deprecated.Bson:
+		return &format.LogicalType{Bson: &format.BsonType{}}
+	}
+	return nil
+}
+
+func convertedIntType(t deprecated.ConvertedType) *format.IntType {
+	switch t {
+	case deprecated.Int8:
+		return &format.IntType{BitWidth: 8, IsSigned: true}
+	case deprecated.Int16:
+		return &format.IntType{BitWidth: 16, IsSigned: true}
+	case deprecated.Int32:
+		return &format.IntType{BitWidth: 32, IsSigned: true}
+	case deprecated.Int64:
+		return &format.IntType{BitWidth: 64, IsSigned: true}
+	case deprecated.Uint8:
+		return &format.IntType{BitWidth: 8}
+	case deprecated.Uint16:
+		return &format.IntType{BitWidth: 16}
+	case deprecated.Uint32:
+		return &format.IntType{BitWidth: 32}
+	}
+	return &format.IntType{BitWidth: 64}
+}
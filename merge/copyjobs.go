@@ -0,0 +1,123 @@
+package merge
+
+import "github.com/parquet-go/parquet-go"
+
+// copyUnit is a run of row groups of an input, decoded by one of the
+// -copy-jobs workers into batches.
+type copyUnit struct {
+	input   inputFile
+	batches chan rowBatch
+	// done is closed when the file the unit belongs to is closed.
+	done chan struct{}
+}
+
+// splitInput returns the units input is copied as, its row groups that
+// are not skipped in up to jobs runs of about the same number of groups,
+// and the rows the footer gives for them.  The skip function of input is
+// called here, in order, rather than by the workers, since it may have
+// state.
+func (m *Merger) splitInput(input inputFile, jobs int) ([]inputFile, int64, error) {
+	if input.rows == 0 {
+		return []inputFile{input}, 0, nil
+	}
+	pf, closer, err := m.openInputFile(input.file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closer.Close()
+	var kept []int
+	var footer int64
+	for i, rg := range pf.RowGroups() {
+		if input.skip == nil || !input.skip(pf, i) {
+			kept = append(kept, i)
+			footer += rg.NumRows()
+		}
+	}
+	units := max(min(jobs, len(kept)), 1)
+	out := make([]inputFile, 0, units)
+	for u := 0; u < units; u++ {
+		out = append(out, unitInput(input, kept[u*len(kept)/units:(u+1)*len(kept)/units]))
+	}
+	return out, footer, nil
+}
+
+// unitInput returns input limited to the row groups in groups.
+func unitInput(input inputFile, groups []int) inputFile {
+	in := make(map[int]bool, len(groups))
+	for _, i := range groups {
+		in[i] = true
+	}
+	input.skip = func(_ *parquet.File, i int) bool { return !in[i] }
+	return input
+}
+
+// dispatch splits the inputs into units and hands them to the workers in
+// order, sending each file to the reader before its units, so that the
+// unit the reader waits for is always being decoded.
+func (p *prefetcher) dispatch() {
+	defer p.wg.Done()
+	defer close(p.files)
+	defer close(p.work)
+	for _, input := range p.inputs {
+		f := &prefetchedFile{keep: input.keep, redact: input.redact, done: make(chan struct{})}
+		inputs, footer, err := p.m.splitInput(input, p.jobs)
+		if err != nil {
+			f.openErr = err
+		} else {
+			f.footer = footer
+			f.units = make(chan *copyUnit, len(inputs))
+		}
+		var units []*copyUnit
+		for _, in := range inputs {
+			u := &copyUnit{input: in, batches: make(chan rowBatch, prefetchBatches), done: f.done}
+			f.units <- u
+			units = append(units, u)
+		}
+		if f.units != nil {
+			close(f.units)
+		}
+		select {
+		case p.files <- f:
+		case <-p.stop:
+			return
+		}
+		for _, u := range units {
+			select {
+			case p.work <- u:
+			case <-p.stop:
+				return
+			}
+		}
+	}
+}
+
+// decode reads the units handed to it until there are no more.
+func (p *prefetcher) decode() {
+	defer p.wg.Done()
+	for u := range p.work {
+		p.decodeUnit(u)
+	}
+}
+
+// decodeUnit sends batches of the rows of u until they end, fail, or its
+// file is closed.
+func (p *prefetcher) decodeUnit(u *copyUnit) {
+	defer close(u.batches)
+	select {
+	case <-u.done:
+		return
+	case <-p.stop:
+		return
+	default:
+	}
+	rows, err := p.m.openFileRows(u.input, p.merged, p.rowSchema)
+	if err != nil {
+		select {
+		case u.batches <- rowBatch{err: err}:
+		case <-u.done:
+		case <-p.stop:
+		}
+		return
+	}
+	p.send(u.batches, u.done, rows)
+}
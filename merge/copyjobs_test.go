@@ -0,0 +1,43 @@
+package merge
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestCopyJobs(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for f := 0; f < 3; f++ {
+		var chunks [][]map[string]any
+		for g := 0; g < 5; g++ {
+			var rows []map[string]any
+			for i := 0; i < 100; i++ {
+				rows = append(rows, map[string]any{"id": int64(f*1000 + g*100 + i), "name": fmt.Sprint(i)})
+			}
+			chunks = append(chunks, rows)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("in%d.parquet", f))
+		writeParquet(t, file, parquet.Group{"id": parquet.Int(64), "name": parquet.String()}, chunks...)
+		files = append(files, file)
+	}
+	var sums [2][32]byte
+	for i, jobs := range []int{1, 4} {
+		out := filepath.Join(dir, fmt.Sprintf("merged%d.parquet", jobs))
+		opts := testOptions(out, files...)
+		opts.CopyJobs, opts.BatchSize, opts.Reproducible = jobs, 30, true
+		// Row groups transplanted are not decoded by the jobs.
+		opts.NoFastpath = true
+		stats := runMerge(t, opts)
+		if stats.RowsWritten != 1500 || stats.RowGroupsTransplanted != 0 {
+			t.Errorf("-copy-jobs %d wrote %d rows, transplanting %d row groups, want 1500 and none", jobs, stats.RowsWritten, stats.RowGroupsTransplanted)
+		}
+		sums[i] = fileSum(t, out)
+	}
+	if sums[0] != sums[1] {
+		t.Error("-copy-jobs 4 wrote other output than -copy-jobs 1")
+	}
+}
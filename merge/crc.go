@@ -0,0 +1,59 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// crcRowGroup is a row group whose page read errors, such as checksum
+// mismatches, name the column, row group and page that failed.  parquet-go
+// checks the CRC of every page that has one, but does not say where the
+// page is.
+type crcRowGroup struct {
+	parquet.RowGroup
+	index int
+}
+
+func (g crcRowGroup) ColumnChunks() []parquet.ColumnChunk {
+	columns := g.Schema().Columns()
+	chunks := g.RowGroup.ColumnChunks()
+	out := make([]parquet.ColumnChunk, len(chunks))
+	for i, chunk := range chunks {
+		out[i] = crcColumnChunk{ColumnChunk: chunk, column: strings.Join(columns[i], "."), rowGroup: g.index}
+	}
+	return out
+}
+
+func (g crcRowGroup) Rows() parquet.Rows { return parquet.NewRowGroupRowReader(g) }
+
+type crcColumnChunk struct {
+	parquet.ColumnChunk
+	column   string
+	rowGroup int
+}
+
+func (c crcColumnChunk) Pages() parquet.Pages {
+	return &crcPages{Pages: c.ColumnChunk.Pages(), column: c.column, rowGroup: c.rowGroup}
+}
+
+// crcPages counts the data pages read from a column chunk.  The merger
+// reads every chunk from its start, so the count is the page's position.
+type crcPages struct {
+	parquet.Pages
+	column   string
+	rowGroup int
+	page     int
+}
+
+func (p *crcPages) ReadPage() (parquet.Page, error) {
+	page, err := p.Pages.ReadPage()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return page, fmt.Errorf("column %s, row group %d, page %d: %w", p.column, p.rowGroup, p.page, err)
+	}
+	p.page++
+	return page, err
+}
@@ -0,0 +1,147 @@
+package merge
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// maxDecimalPrecision is the widest precision we will produce when
+// rescaling, matching the 16-byte decimals most engines support.
+const maxDecimalPrecision = 38
+
+// decimalNode builds a DECIMAL node from a schema element, preferring the
+// parameters in the logical type and falling back to the legacy fields.
+func decimalNode(e format.SchemaElement) (parquet.Node, error) {
+	scale, precision := int(e.LogicalType.Decimal.Scale), int(e.LogicalType.Decimal.Precision)
+	if precision == 0 && e.Precision != nil {
+		precision = int(*e.Precision)
+		if e.Scale != nil {
+			scale = int(*e.Scale)
+		}
+	}
+	var typ parquet.Type
+	switch *e.Type {
+	case format.Int32:
+		typ = parquet.Int32Type
+	case format.Int64:
+		typ = parquet.Int64Type
+	case format.FixedLenByteArray:
+		if e.TypeLength == nil {
+			return nil, fmt.Errorf("decimal %s: missing type length", e.Name)
+		}
+		typ = parquet.FixedLenByteArrayType(int(*e.TypeLength))
+	default:
+		return nil, fmt.Errorf("decimal %s: unsupported physical type %s", e.Name, e.Type)
+	}
+	return parquet.Optional(parquet.Decimal(scale, precision, typ)), nil
+}
+
+func isDecimal(node parquet.Node) bool {
+	if !node.Leaf() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	return lt != nil && lt.Decimal != nil
+}
+
+func decimalParams(node parquet.Node) (scale, precision int) {
+	d := node.Type().LogicalType().Decimal
+	return int(d.Scale), int(d.Precision)
+}
+
+// mergeDecimal returns a DECIMAL node wide enough to hold the values of both
+// a and b: the larger scale, and enough precision for the larger integer part.
+func mergeDecimal(a, b parquet.Node) (parquet.Node, error) {
+	as, ap := decimalParams(a)
+	bs, bp := decimalParams(b)
+	scale := max(as, bs)
+	precision := max(ap-as, bp-bs) + scale
+	if precision > maxDecimalPrecision {
+		return nil, fmt.Errorf("cannot rescale DECIMAL(%d,%d) and DECIMAL(%d,%d): precision %d exceeds %d",
+			ap, as, bp, bs, precision, maxDecimalPrecision)
+	}
+	var typ parquet.Type
+	switch {
+	case precision <= 9:
+		typ = parquet.Int32Type
+	case precision <= 18:
+		typ = parquet.Int64Type
+	default:
+		typ = parquet.FixedLenByteArrayType(16)
+	}
+	return parquet.Optional(parquet.Decimal(scale, precision, typ)), nil
+}
+
+// rescaleDecimal converts the unscaled value v of decimal node from into the
+// representation and scale of decimal node to.
+func rescaleDecimal(v any, from, to parquet.Node) (any, error) {
+	if !isDecimal(from) {
+		return nil, fmt.Errorf("cannot convert %s to %s", nodeTypeName(from), nodeTypeName(to))
+	}
+	unscaled, err := unscaledDecimal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	fromScale, _ := decimalParams(from)
+	toScale, _ := decimalParams(to)
+	if toScale < fromScale {
+		return nil, fmt.Errorf("cannot reduce decimal scale from %d to %d", fromScale, toScale)
+	}
+	unscaled.Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(toScale-fromScale)), nil))
+
+	switch to.Type().Kind() {
+	case parquet.Int32:
+		return int32(unscaled.Int64()), nil
+	case parquet.Int64:
+		return unscaled.Int64(), nil
+	default:
+		return decimalBytes(unscaled, to.Type().Length()), nil
+	}
+}
+
+// unscaledDecimal returns the unscaled value of a decimal read as v.
+func unscaledDecimal(v any) (*big.Int, error) {
+	unscaled := new(big.Int)
+	switch x := v.(type) {
+	case int32:
+		unscaled.SetInt64(int64(x))
+	case int64:
+		unscaled.SetInt64(x)
+	case []byte:
+		unscaled.SetBytes(x)
+		if len(x) > 0 && x[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(x)*8)))
+		}
+	default:
+		return nil, fmt.Errorf("cannot convert %T to decimal", v)
+	}
+	return unscaled, nil
+}
+
+// formatDecimal formats the decimal v of node with its scale.
+func formatDecimal(v any, node parquet.Node) (string, error) {
+	unscaled, err := unscaledDecimal(v)
+	if err != nil {
+		return "", err
+	}
+	scale, _ := decimalParams(node)
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(unscaled, exp).FloatString(scale), nil
+}
+
+// decimalBytes encodes n as a big-endian two's complement value of the
+// given length.
+func decimalBytes(n *big.Int, length int) []byte {
+	b := make([]byte, length)
+	if n.Sign() >= 0 {
+		n.FillBytes(b)
+		return b
+	}
+	m := new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(length*8)))
+	m.FillBytes(b)
+	return b
+}
@@ -0,0 +1,286 @@
+package merge
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowKey is a 128-bit hash of the key columns of a row.
+type rowKey [16]byte
+
+// deduper remembers the keys of rows already written so that later rows
+// with the same key can be dropped.  Keys are held in memory until there
+// are maxKeys of them, then spilled to a sorted run file on disk.
+type deduper struct {
+	columns []int
+	seen    map[rowKey]struct{}
+	maxKeys int
+	runs    []*os.File
+	dropped map[string]int64
+}
+
+// keyColumns returns the leaf column indexes of the named top-level
+// columns of schema.
+func keyColumns(schema *parquet.Schema, names []string) ([]int, error) {
+	var columns []int
+	for _, name := range names {
+		leaf, ok := schema.Lookup(name)
+		if !ok || leaf.MaxRepetitionLevel > 0 {
+			return nil, fmt.Errorf("key column %s must be a non-repeated primitive column of the merged schema", name)
+		}
+		columns = append(columns, leaf.ColumnIndex)
+	}
+	return columns, nil
+}
+
+func newDeduper(schema *parquet.Schema, keys []string, maxKeys int) (*deduper, error) {
+	columns, err := keyColumns(schema, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &deduper{
+		columns: columns,
+		seen:    map[rowKey]struct{}{},
+		maxKeys: maxKeys,
+		dropped: map[string]int64{},
+	}, nil
+}
+
+// keyOf hashes the values of columns in row.  Nulls hash differently from
+// every non-null value.
+func keyOf(row parquet.Row, columns []int) rowKey {
+	h := fnv.New128a()
+	var buf []byte
+	for _, c := range columns {
+		buf = buf[:0]
+		for _, v := range row {
+			if v.Column() != c {
+				continue
+			}
+			if v.IsNull() {
+				buf = append(buf, 0)
+			} else {
+				buf = append(buf, 1)
+				buf = v.AppendBytes(buf)
+			}
+		}
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], uint32(len(buf)))
+		h.Write(n[:])
+		h.Write(buf)
+	}
+	var key rowKey
+	h.Sum(key[:0])
+	return key
+}
+
+// first returns a keep function for file that keeps only the first row
+// seen with each key among the rows accepted by prev.
+func (d *deduper) first(file string, prev func(parquet.Row, int64) (bool, error)) func(parquet.Row, int64) (bool, error) {
+	return func(row parquet.Row, index int64) (bool, error) {
+		if prev != nil {
+			if ok, err := prev(row, index); !ok || err != nil {
+				return false, err
+			}
+		}
+		key := keyOf(row, d.columns)
+		seen, err := d.contains(key)
+		if err != nil {
+			return false, err
+		}
+		if seen {
+			d.dropped[file]++
+			return false, nil
+		}
+		d.seen[key] = struct{}{}
+		if d.maxKeys > 0 && len(d.seen) >= d.maxKeys {
+			return true, d.spill()
+		}
+		return true, nil
+	}
+}
+
+func (d *deduper) contains(key rowKey) (bool, error) {
+	if _, ok := d.seen[key]; ok {
+		return true, nil
+	}
+	for _, run := range d.runs {
+		found, err := runContains(run, key)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// spill writes the keys held in memory to a new sorted run file.
+func (d *deduper) spill() error {
+	keys := make([]rowKey, 0, len(d.seen))
+	for k := range d.seen {
+		keys = append(keys, k)
+	}
+	f, err := writeRun("merger-dedup-*", keys)
+	if err != nil {
+		return err
+	}
+	d.runs = append(d.runs, f)
+	clear(d.seen)
+	return nil
+}
+
+// writeRun sorts keys and writes them to a new temporary run file named by
+// pattern, which is removed again if the write fails.
+func writeRun(pattern string, keys []rowKey) (*os.File, error) {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(keys)*len(rowKey{}))
+	for _, k := range keys {
+		buf = append(buf, k[:]...)
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// runContains binary searches a sorted run file for key.
+func runContains(run *os.File, key rowKey) (bool, error) {
+	stat, err := run.Stat()
+	if err != nil {
+		return false, err
+	}
+	var k rowKey
+	var readErr error
+	n := int(stat.Size()) / len(k)
+	i := sort.Search(n, func(i int) bool {
+		if _, err := run.ReadAt(k[:], int64(i*len(k))); err != nil && !errors.Is(err, io.EOF) {
+			readErr = err
+			return true
+		}
+		return bytes.Compare(k[:], key[:]) >= 0
+	})
+	if readErr != nil || i == n {
+		return false, readErr
+	}
+	if _, err := run.ReadAt(k[:], int64(i*len(k))); err != nil {
+		return false, err
+	}
+	return k == key, nil
+}
+
+// report logs the number of duplicates dropped from each input.
+func (d *deduper) report(logger *slog.Logger, inputs []inputFile) {
+	var total int64
+	for _, input := range inputs {
+		total += d.dropped[input.file]
+	}
+	logger.Info("dropped duplicate rows", "rows", total)
+	for _, input := range inputs {
+		if n := d.dropped[input.file]; n > 0 {
+			logger.Debug("dropped duplicate rows", "file", input.file, "rows", n)
+		}
+	}
+}
+
+// Close removes the run files.
+func (d *deduper) Close() {
+	for _, run := range d.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+}
+
+// latest reads every input once and, for each key, picks the row with the
+// greatest value of the time column, preferring earlier rows on ties.  It
+// sets the keep function of every input to write only the picked rows.
+func (d *deduper) latest(ctx context.Context, m *Merger, inputs []inputFile, merged, rowSchema *parquet.Schema, timeColumn string) error {
+	tc, err := keyColumns(merged, []string{timeColumn})
+	if err != nil {
+		return err
+	}
+	leaf, _ := merged.Lookup(timeColumn)
+	typ := leaf.Node.Type()
+	type pick struct {
+		file  int
+		row   int64
+		value parquet.Value
+	}
+	picks := map[rowKey]pick{}
+	for i, input := range inputs {
+		rows, err := m.openFileRows(input, merged, rowSchema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.file, err)
+		}
+		rows.keep = func(row parquet.Row, index int64) (bool, error) {
+			key := keyOf(row, d.columns)
+			if input.keep != nil {
+				if ok, err := input.keep(row, index); !ok || err != nil {
+					return false, err
+				}
+			}
+			value := columnValue(row, tc[0])
+			p, ok := picks[key]
+			if !ok || newer(typ, value, p.value) {
+				picks[key] = pick{file: i, row: index, value: value.Clone()}
+			}
+			return false, nil
+		}
+		_, err = m.copyRows(ctx, discardRows{}, rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.file, err)
+		}
+	}
+
+	keep := make([]map[int64]bool, len(inputs))
+	for i := range keep {
+		keep[i] = map[int64]bool{}
+	}
+	for _, p := range picks {
+		keep[p.file][p.row] = true
+	}
+	for i := range inputs {
+		file, rows, prev := inputs[i].file, keep[i], inputs[i].keep
+		inputs[i].keep = func(row parquet.Row, index int64) (bool, error) {
+			if prev != nil {
+				if ok, err := prev(row, index); !ok || err != nil {
+					return false, err
+				}
+			}
+			if rows[index] {
+				return true, nil
+			}
+			d.dropped[file]++
+			return false, nil
+		}
+	}
+	return nil
+}
+
+// newer reports whether a is a later time than b.  Nulls are never newer.
+func newer(typ parquet.Type, a, b parquet.Value) bool {
+	if a.IsNull() {
+		return false
+	}
+	return b.IsNull() || typ.Compare(a, b) > 0
+}
+
+// discardRows is a parquet.RowWriter that drops everything written to it.
+type discardRows struct{}
+
+func (discardRows) WriteRows(rows []parquet.Row) (int, error) { return len(rows), nil }
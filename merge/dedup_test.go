@@ -0,0 +1,14 @@
+package merge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDedupLatestMaxKeys(t *testing.T) {
+	opts := testOptions("merged.parquet", "in.parquet")
+	opts.DedupKeys, opts.DedupPreferLatest, opts.DedupMaxKeys = []string{"id"}, true, 10
+	if _, err := New(opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("New error = %v, want ErrInvalidOptions", err)
+	}
+}
@@ -0,0 +1,45 @@
+package merge
+
+import (
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// compileDefaults parses each default as the type of its column in
+// schema.  Defaults are only supported for top-level, non-repeated leaf
+// columns.
+func compileDefaults(schema *parquet.Schema, defaults map[string]string) (map[string]parquet.Value, error) {
+	out := make(map[string]parquet.Value, len(defaults))
+	for _, name := range sortedStrings(defaults) {
+		leaf, ok := schema.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("default for %s: not a top-level leaf column of the merged schema", name)
+		}
+		if !leaf.Node.Leaf() || leaf.MaxRepetitionLevel > 0 {
+			return nil, fmt.Errorf("default for %s: only non-repeated columns can have defaults", name)
+		}
+		typ := leaf.Node.Type()
+		if k := typ.Kind(); k == parquet.FixedLenByteArray || k == parquet.Int96 {
+			return nil, fmt.Errorf("default for %s: defaults for %s columns are not supported", name, k)
+		}
+		v, err := literalValue(typ, defaults[name], typ.Kind() == parquet.ByteArray)
+		if err != nil {
+			return nil, fmt.Errorf("default for %s: %w", name, err)
+		}
+		out[name] = v.Level(0, leaf.MaxDefinitionLevel, leaf.ColumnIndex)
+	}
+	return out, nil
+}
+
+// missingDefaults returns the defaults for the columns that nodes, the
+// columns of a file, lacks.
+func missingDefaults(defaults map[string]parquet.Value, nodes map[string]parquet.Node) []parquet.Value {
+	var out []parquet.Value
+	for name, v := range defaults {
+		if _, ok := nodes[name]; !ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
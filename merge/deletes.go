@@ -0,0 +1,266 @@
+package merge
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/bloom"
+)
+
+// deleteBloomBits is the bits per key of the -delete-keys bloom filter,
+// which passes about one key in a hundred that is not in the set.
+const deleteBloomBits = 10
+
+// deleteRunKeys is the most keys held in memory while -delete-keys is
+// read, 16 MiB of them, before they are sorted into a run file.
+const deleteRunKeys = 1 << 20
+
+// deleteSet is the set of keys loaded from -delete-keys.  Keys are
+// compared as text, by a hash of it.  The hashes are kept in sorted run
+// files on disk, and a bloom filter held in memory spares looking up most
+// of the rows that are not deleted there.
+type deleteSet struct {
+	filter bloom.SplitBlockFilter
+	runs   []*os.File
+	// column is the leaf column of the key in the merged schema.
+	column  int
+	deleted map[string]int64
+}
+
+// loadDeleteKeys reads the keys in the DeleteKeyColumn of the DeleteKeys
+// file, a CSV file with a header row if its name ends in .csv and a
+// parquet file otherwise.  A file without keys is an error, since it would
+// delete nothing.
+func (m *Merger) loadDeleteKeys() (*deleteSet, error) {
+	file, column := m.opts.DeleteKeys, m.opts.DeleteKeyColumn
+	d := &deleteSet{deleted: map[string]int64{}}
+	var keys []rowKey
+	var n int64
+	// spill sorts the keys read so far into a new run file.
+	spill := func() error {
+		run, err := writeRun("merger-deletes-*", keys)
+		if err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing delete keys: %w", err))
+		}
+		d.runs = append(d.runs, run)
+		keys = keys[:0]
+		return nil
+	}
+	add := func(k rowKey) error {
+		keys = append(keys, k)
+		n++
+		if len(keys) < deleteRunKeys {
+			return nil
+		}
+		return spill()
+	}
+	var err error
+	if strings.HasSuffix(strings.ToLower(file), ".csv") {
+		err = csvKeys(file, column, add)
+	} else {
+		err = m.parquetKeys(file, column, add)
+	}
+	if err == nil && n == 0 {
+		err = markError(ErrInvalidOptions, fmt.Errorf("delete keys file %s has no %s values", file, column))
+	}
+	if err == nil && len(keys) > 0 {
+		err = spill()
+	}
+	keys = nil
+	if err == nil {
+		// The filter is sized for every key, so it is only filled once they
+		// have all been read, from the runs.
+		d.filter = bloom.MakeSplitBlockFilter(make([]byte, bloom.NumSplitBlocksOf(n, deleteBloomBits)*bloom.BlockSize))
+		err = d.fillFilter()
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	m.log.Info("loaded delete keys", "phase", "find", "file", file, "column", column, "keys", n)
+	return d, nil
+}
+
+// fillFilter inserts the keys of every run into the bloom filter.
+func (d *deleteSet) fillFilter() error {
+	buf := make([]byte, 4096*len(rowKey{}))
+	for _, run := range d.runs {
+		for off := int64(0); ; {
+			n, err := run.ReadAt(buf, off)
+			for i := 0; i+len(rowKey{}) <= n; i += len(rowKey{}) {
+				d.filter.Insert(binary.LittleEndian.Uint64(buf[i:]))
+			}
+			off += int64(n)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return markError(ErrRead, fmt.Errorf("error reading delete keys: %w", err))
+			}
+		}
+	}
+	return nil
+}
+
+// deleteKey hashes the text of a key.
+func deleteKey(s string) rowKey {
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	var key rowKey
+	h.Sum(key[:0])
+	return key
+}
+
+// csvKeys passes the hashed values of column in the CSV file name, whose
+// first row names the columns, to add.  Empty values are taken as nulls
+// and left out.
+func csvKeys(name, column string, add func(rowKey) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return markError(ErrRead, fmt.Errorf("error opening delete keys file: %w", err))
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return markError(ErrInvalidOptions, fmt.Errorf("delete keys file %s is empty", name))
+	}
+	if err != nil {
+		return markError(ErrRead, fmt.Errorf("%s: %w", name, err))
+	}
+	index := -1
+	for i, h := range header {
+		if h == column {
+			index = i
+		}
+	}
+	if index < 0 {
+		return markError(ErrInvalidOptions, fmt.Errorf("delete keys file %s has no %s column", name, column))
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return markError(ErrRead, fmt.Errorf("%s: %w", name, err))
+		}
+		if record[index] != "" {
+			if err := add(deleteKey(record[index])); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parquetKeys passes the hashed non-null values of the top-level column of
+// the parquet file name to add.
+func (m *Merger) parquetKeys(name, column string, add func(rowKey) error) error {
+	pf, closer, err := m.openInputFile(name)
+	if err != nil {
+		return markError(ErrRead, fmt.Errorf("error opening delete keys file: %w", err))
+	}
+	defer closer.Close()
+	leaf, ok := pf.Schema().Lookup(column)
+	if !ok || leaf.MaxRepetitionLevel > 0 {
+		return markError(ErrInvalidOptions, fmt.Errorf("delete keys file %s has no non-repeated %s column", name, column))
+	}
+	values := make([]parquet.Value, 1024)
+	for _, rg := range pf.RowGroups() {
+		pages := rg.ColumnChunks()[leaf.ColumnIndex].Pages()
+		for {
+			page, err := pages.ReadPage()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				pages.Close()
+				return markError(ErrRead, fmt.Errorf("%s: %w", name, err))
+			}
+			r := page.Values()
+			for {
+				n, err := r.ReadValues(values)
+				for _, v := range values[:n] {
+					if v.IsNull() {
+						continue
+					}
+					if err := add(deleteKey(v.String())); err != nil {
+						pages.Close()
+						return err
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					pages.Close()
+					return markError(ErrRead, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+		}
+		pages.Close()
+	}
+	return nil
+}
+
+// keep returns a keep function for file that drops the rows accepted by
+// prev whose key is in the set.  Null keys are never deleted.
+func (d *deleteSet) keep(file string, prev func(parquet.Row, int64) (bool, error)) func(parquet.Row, int64) (bool, error) {
+	return func(row parquet.Row, index int64) (bool, error) {
+		if prev != nil {
+			if ok, err := prev(row, index); !ok || err != nil {
+				return false, err
+			}
+		}
+		v := columnValue(row, d.column)
+		if v.IsNull() {
+			return true, nil
+		}
+		key := deleteKey(v.String())
+		if !d.filter.Check(binary.LittleEndian.Uint64(key[:])) {
+			return true, nil
+		}
+		found, err := d.contains(key)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			d.deleted[file]++
+		}
+		return !found, nil
+	}
+}
+
+// contains reports whether key is in one of the runs.
+func (d *deleteSet) contains(key rowKey) (bool, error) {
+	for _, run := range d.runs {
+		found, err := runContains(run, key)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// count returns the rows deleted from file, for a nil set too.
+func (d *deleteSet) count(file string) int64 {
+	if d == nil {
+		return 0
+	}
+	return d.deleted[file]
+}
+
+// Close removes the run files.
+func (d *deleteSet) Close() {
+	for _, run := range d.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+}
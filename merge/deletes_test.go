@@ -0,0 +1,85 @@
+package merge
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// mergeDeleting merges the inputs of writeMixedInputs, deleting the keys
+// of the id column in keys, and returns the ids left.
+func mergeDeleting(t *testing.T, dir, keys string) []int64 {
+	t.Helper()
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, writeMixedInputs(t, dir)...)
+	opts.DeleteKeys, opts.DeleteKeyColumn = keys, "id"
+	stats := runMerge(t, opts)
+	_, rows := readParquet(t, out)
+	var ids []int64
+	for _, row := range rows {
+		id, _ := row["id"].(int64)
+		ids = append(ids, id)
+	}
+	if want := int64(6 - len(ids)); stats.RowsDeleted != want {
+		t.Errorf("deleted %d rows, want %d", stats.RowsDeleted, want)
+	}
+	return ids
+}
+
+func TestDeleteKeysParquet(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.parquet")
+	writeParquet(t, keys, parquet.Group{"id": parquet.Optional(parquet.Int(64))},
+		[]map[string]any{{"id": int64(2)}, {}, {"id": int64(5)}}, []map[string]any{{"id": int64(9)}})
+	if got, want := mergeDeleting(t, dir, keys), []int64{1, 3, 4, 6}; !slices.Equal(got, want) {
+		t.Errorf("merged the ids %v, want %v", got, want)
+	}
+}
+
+func TestDeleteKeysRuns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes more delete keys than are held in memory")
+	}
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.csv")
+	f, err := os.Create(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "name,id")
+	fmt.Fprintln(w, "a,1")
+	fmt.Fprintln(w, "b,")
+	for i := 0; i < deleteRunKeys; i++ {
+		fmt.Fprintf(w, "x,x%d\n", i)
+	}
+	// In the second run.
+	fmt.Fprintln(w, "c,6")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	m, err := New(testOptions("merged.parquet", keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.opts.DeleteKeys, m.opts.DeleteKeyColumn = keys, "id"
+	d, err := m.loadDeleteKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := len(d.runs)
+	d.Close()
+	if runs != 2 {
+		t.Errorf("wrote %d runs of delete keys, want 2", runs)
+	}
+	if got, want := mergeDeleting(t, dir, keys), []int64{2, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("merged the ids %v, want %v", got, want)
+	}
+}
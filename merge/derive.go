@@ -0,0 +1,419 @@
+package merge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// A deriveExpr is a parsed -derive expression: a call of one of the derive
+// functions, a column, or a string or integer literal.
+type deriveExpr struct {
+	fn      string
+	args    []*deriveExpr
+	column  string
+	literal string
+	quoted  bool
+	number  bool
+}
+
+// derivedColumn is a column computed from the others of each row.
+type derivedColumn struct {
+	name string
+	expr *deriveExpr
+}
+
+// parseDerives parses the -derive expressions, in order of column name.
+func parseDerives(derive map[string]string) ([]derivedColumn, error) {
+	var out []derivedColumn
+	for _, name := range sortedStrings(derive) {
+		if name == "" || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("derive: %q is not a top-level column name", name)
+		}
+		expr, err := parseDerive(derive[name])
+		if err != nil {
+			return nil, fmt.Errorf("derive %s: %w", name, err)
+		}
+		out = append(out, derivedColumn{name: name, expr: expr})
+	}
+	return out, nil
+}
+
+// parseDerive parses an expression such as `split(service, "-", 1)`.
+func parseDerive(s string) (*deriveExpr, error) {
+	p := &deriveParser{}
+	if err := p.tokenize(s); err != nil {
+		return nil, err
+	}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+type deriveParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *deriveParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			p.tokens = append(p.tokens, whereToken{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return fmt.Errorf("bad string at %d: %w", i, err)
+			}
+			p.tokens = append(p.tokens, whereToken{text: text, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune("_.-", rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, whereToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *deriveParser) next() (whereToken, error) {
+	if p.pos >= len(p.tokens) {
+		return whereToken{}, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// is reports whether the next token is the punctuation text.
+func (p *deriveParser) is(text string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text
+}
+
+func (p *deriveParser) expr() (*deriveExpr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.quoted {
+		return &deriveExpr{literal: tok.text, quoted: true}, nil
+	}
+	if strings.ContainsAny(tok.text, "(),") {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	if _, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+		return &deriveExpr{literal: tok.text, number: true}, nil
+	}
+	if !p.is("(") {
+		return &deriveExpr{column: tok.text}, nil
+	}
+	p.pos++
+	e := &deriveExpr{fn: strings.ToLower(tok.text)}
+	for !p.is(")") {
+		if len(e.args) > 0 {
+			if !p.is(",") {
+				if p.pos >= len(p.tokens) {
+					return nil, fmt.Errorf("missing ) after the arguments of %s", tok.text)
+				}
+				return nil, fmt.Errorf("expected , or ) after an argument of %s, not %q", tok.text, p.tokens[p.pos].text)
+			}
+			p.pos++
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		e.args = append(e.args, arg)
+	}
+	p.pos++
+	return e, nil
+}
+
+// deriveEval computes a value of a row, null if it cannot be computed.
+type deriveEval func(row parquet.Row) parquet.Value
+
+// compile returns the node of the values of e, given the columns of
+// schema, and the function computing them.
+func (e *deriveExpr) compile(schema *parquet.Schema) (parquet.Node, deriveEval, error) {
+	switch {
+	case e.quoted:
+		v := parquet.ValueOf(e.literal)
+		return parquet.String(), func(parquet.Row) parquet.Value { return v }, nil
+	case e.number:
+		n, _ := strconv.ParseInt(e.literal, 10, 64)
+		v := parquet.ValueOf(n)
+		return parquet.Int(64), func(parquet.Row) parquet.Value { return v }, nil
+	case e.fn == "":
+		leaf, ok := lookupColumn(schema, e.column)
+		if !ok {
+			return nil, nil, fmt.Errorf("column %s is not in any input file", e.column)
+		}
+		if leaf.MaxRepetitionLevel > 0 {
+			return nil, nil, fmt.Errorf("column %s is repeated", e.column)
+		}
+		if !derivable(leaf.Node) {
+			return nil, nil, fmt.Errorf("column %s is %s, which derive cannot read", e.column, leafSignature(leaf.Node))
+		}
+		column := leaf.ColumnIndex
+		return parquet.Required(leaf.Node), func(row parquet.Row) parquet.Value { return columnValue(row, column) }, nil
+	}
+	nodes := make([]parquet.Node, len(e.args))
+	args := make([]deriveEval, len(e.args))
+	for i, arg := range e.args {
+		var err error
+		if nodes[i], args[i], err = arg.compile(schema); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch e.fn {
+	case "date_trunc":
+		if len(args) != 1 {
+			return nil, nil, fmt.Errorf("date_trunc takes a timestamp, not %d arguments", len(args))
+		}
+		perDay, ok := unitsPerDay(nodes[0])
+		if !ok {
+			return nil, nil, fmt.Errorf("date_trunc takes a TIMESTAMP, DATE or integer, not %s", leafSignature(nodes[0]))
+		}
+		arg := args[0]
+		return parquet.Date(), func(row parquet.Row) parquet.Value {
+			v := arg(row)
+			if v.IsNull() {
+				return v
+			}
+			n := v.Int64()
+			if v.Kind() == parquet.Int32 {
+				n = int64(v.Int32())
+			}
+			days := n / perDay
+			if n%perDay < 0 {
+				days--
+			}
+			return parquet.ValueOf(int32(days))
+		}, nil
+	case "split":
+		if len(args) != 3 || !e.args[1].quoted || !e.args[2].number {
+			return nil, nil, fmt.Errorf(`split takes a string, a quoted separator and a field number, as in split(service, "-", 1)`)
+		}
+		if e.args[1].literal == "" {
+			return nil, nil, fmt.Errorf("split needs a separator that is not empty")
+		}
+		arg, sep := args[0], e.args[1].literal
+		field, _ := strconv.Atoi(e.args[2].literal)
+		node := nodes[0]
+		return parquet.String(), func(row parquet.Row) parquet.Value {
+			v := arg(row)
+			if v.IsNull() {
+				return v
+			}
+			parts := strings.Split(deriveString(v, node), sep)
+			if field < 0 || field >= len(parts) {
+				return parquet.Value{}
+			}
+			return parquet.ValueOf(parts[field])
+		}, nil
+	case "lower", "upper":
+		if len(args) != 1 {
+			return nil, nil, fmt.Errorf("%s takes a string, not %d arguments", e.fn, len(args))
+		}
+		arg, node, convert := args[0], nodes[0], strings.ToLower
+		if e.fn == "upper" {
+			convert = strings.ToUpper
+		}
+		return parquet.String(), func(row parquet.Row) parquet.Value {
+			v := arg(row)
+			if v.IsNull() {
+				return v
+			}
+			return parquet.ValueOf(convert(deriveString(v, node)))
+		}, nil
+	case "concat":
+		if len(args) == 0 {
+			return nil, nil, fmt.Errorf("concat takes at least one argument")
+		}
+		return parquet.String(), func(row parquet.Row) parquet.Value {
+			var b strings.Builder
+			null := true
+			for i, arg := range args {
+				if v := arg(row); !v.IsNull() {
+					b.WriteString(deriveString(v, nodes[i]))
+					null = false
+				}
+			}
+			if null {
+				return parquet.Value{}
+			}
+			return parquet.ValueOf(b.String())
+		}, nil
+	case "coalesce":
+		if len(args) == 0 {
+			return nil, nil, fmt.Errorf("coalesce takes at least one argument")
+		}
+		for _, node := range nodes[1:] {
+			if leafSignature(node) != leafSignature(nodes[0]) {
+				return nil, nil, fmt.Errorf("coalesce takes arguments of one type, not %s and %s", leafSignature(nodes[0]), leafSignature(node))
+			}
+		}
+		return nodes[0], func(row parquet.Row) parquet.Value {
+			for _, arg := range args {
+				if v := arg(row); !v.IsNull() {
+					return v
+				}
+			}
+			return parquet.Value{}
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown function %s: must be date_trunc, split, lower, upper, concat or coalesce", e.fn)
+}
+
+// derivable reports whether node is a leaf derive can read: a string,
+// integer, floating point, boolean, DATE or TIMESTAMP column.
+func derivable(node parquet.Node) bool {
+	typ := node.Type()
+	if lt := typ.LogicalType(); lt != nil && lt.Decimal != nil {
+		return false
+	}
+	switch typ.Kind() {
+	case parquet.Boolean, parquet.Int32, parquet.Int64, parquet.Float, parquet.Double, parquet.ByteArray:
+		return true
+	}
+	return false
+}
+
+// unitsPerDay returns the units of a day in the time column node: those of
+// a TIMESTAMP, 1 for a DATE, and milliseconds for a plain integer column.
+func unitsPerDay(node parquet.Node) (int64, bool) {
+	const day = 24 * time.Hour
+	typ := node.Type()
+	if lt := typ.LogicalType(); lt != nil {
+		switch {
+		case lt.Timestamp != nil:
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				return day.Milliseconds(), true
+			case lt.Timestamp.Unit.Micros != nil:
+				return day.Microseconds(), true
+			default:
+				return day.Nanoseconds(), true
+			}
+		case lt.Date != nil:
+			return 1, true
+		case lt.Integer == nil:
+			return 0, false
+		}
+	}
+	switch typ.Kind() {
+	case parquet.Int32, parquet.Int64:
+		return day.Milliseconds(), true
+	}
+	return 0, false
+}
+
+// deriveString formats v, a non-null value of node, as a string: DATE and
+// TIMESTAMP values in RFC 3339, in UTC.
+func deriveString(v parquet.Value, node parquet.Node) string {
+	typ := node.Type()
+	if lt := typ.LogicalType(); lt != nil {
+		switch {
+		case lt.Date != nil:
+			return time.Unix(int64(v.Int32())*24*60*60, 0).UTC().Format(time.DateOnly)
+		case lt.Timestamp != nil:
+			var t time.Time
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				t = time.UnixMilli(v.Int64())
+			case lt.Timestamp.Unit.Micros != nil:
+				t = time.UnixMicro(v.Int64())
+			default:
+				t = time.Unix(0, v.Int64())
+			}
+			return t.UTC().Format(time.RFC3339Nano)
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			if v.Kind() == parquet.Int32 {
+				return strconv.FormatUint(uint64(v.Uint32()), 10)
+			}
+			return strconv.FormatUint(v.Uint64(), 10)
+		}
+	}
+	switch v.Kind() {
+	case parquet.Boolean:
+		return strconv.FormatBool(v.Boolean())
+	case parquet.Int32:
+		return strconv.FormatInt(int64(v.Int32()), 10)
+	case parquet.Int64:
+		return strconv.FormatInt(v.Int64(), 10)
+	case parquet.Float:
+		return strconv.FormatFloat(float64(v.Float()), 'g', -1, 32)
+	case parquet.Double:
+		return strconv.FormatFloat(v.Double(), 'g', -1, 64)
+	}
+	return string(v.ByteArray())
+}
+
+// derivedNodes returns the nodes of the derived columns, optional columns
+// of the type their expressions compute, given the schema of the inputs.
+func derivedNodes(derived []derivedColumn, schema *parquet.Schema) (map[string]parquet.Node, error) {
+	out := make(map[string]parquet.Node, len(derived))
+	for _, d := range derived {
+		node, _, err := d.expr.compile(schema)
+		if err != nil {
+			return nil, fmt.Errorf("derive %s: %w", d.name, err)
+		}
+		out[d.name] = parquet.Optional(node)
+	}
+	return out, nil
+}
+
+// deriveColumns returns the function writing the derived columns of a row
+// of schema, the merged schema.
+func deriveColumns(derived []derivedColumn, schema *parquet.Schema) (func(parquet.Row), error) {
+	type column struct {
+		index int
+		eval  deriveEval
+	}
+	columns := make([]column, len(derived))
+	for i, d := range derived {
+		_, eval, err := d.expr.compile(schema)
+		if err != nil {
+			return nil, fmt.Errorf("derive %s: %w", d.name, err)
+		}
+		leaf, _ := schema.Lookup(d.name)
+		columns[i] = column{index: leaf.ColumnIndex, eval: eval}
+	}
+	return func(row parquet.Row) {
+		for _, c := range columns {
+			if v := c.eval(row); v.IsNull() {
+				stampValue(row, parquet.Value{}.Level(0, 0, c.index))
+			} else {
+				stampValue(row, v.Level(0, 1, c.index))
+			}
+		}
+	}, nil
+}
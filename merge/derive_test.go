@@ -0,0 +1,48 @@
+package merge
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestDerive(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	ts := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC)
+	writeParquet(t, in, parquet.Group{
+		"ts":      parquet.Timestamp(parquet.Millisecond),
+		"service": parquet.Optional(parquet.String()),
+		"owner":   parquet.Optional(parquet.String()),
+	}, []map[string]any{
+		{"ts": ts.UnixMilli(), "service": "api-prod", "owner": "ops"},
+		{"ts": ts.Add(time.Hour).UnixMilli(), "service": "db"},
+	})
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, in)
+	opts.Derive = map[string]string{
+		"day":   "date_trunc(ts)",
+		"env":   `split(service, "-", 1)`,
+		"label": `concat(upper(service), "/", coalesce(owner, "nobody"))`,
+	}
+	runMerge(t, opts)
+	schema, rows := readParquet(t, out)
+	day, _ := fieldByName(schema, "day")
+	if typ := nodeTypeName(day); typ != "INT32 DATE" || !day.Optional() {
+		t.Errorf("day is %s, optional %t, want an optional DATE", typ, day.Optional())
+	}
+	days := int32(ts.Unix() / 86400)
+	want := []map[string]any{
+		{"day": days, "env": "prod", "label": "API-PROD/ops"},
+		{"day": days + 1, "env": nil, "label": "DB/nobody"},
+	}
+	for i, row := range rows {
+		for k, v := range want[i] {
+			if row[k] != v {
+				t.Errorf("row %d has %s %v, want %v", i, k, row[k], v)
+			}
+		}
+	}
+}
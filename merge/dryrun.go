@@ -0,0 +1,53 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// plan records what a -dry-run merge would do.
+type plan struct {
+	included []scannedFile
+	excluded []excludedFile
+	// failed is set if the merge would fail.
+	failed bool
+}
+
+type excludedFile struct {
+	file, reason string
+}
+
+func (p *plan) include(sf scannedFile) {
+	p.included = append(p.included, sf)
+}
+
+// exclude records that file would not be merged.  If fail is set, the
+// merge would fail because of it.
+func (p *plan) exclude(file, reason string, fail bool) {
+	p.excluded = append(p.excluded, excludedFile{file: file, reason: reason})
+	p.failed = p.failed || fail
+}
+
+// print writes the files to merge, the files left out, the schemas of the
+// files to merge, the merged schema and the size of the input to w.
+func (p *plan) print(w io.Writer, schema *parquet.Schema) {
+	var rows, size, uncompressed int64
+	for _, sf := range p.included {
+		fmt.Fprintf(w, "include %s: %d rows, %d bytes\n", sf.file, sf.rows, sf.size)
+		rows += sf.rows
+		size += sf.size
+		uncompressed += sf.uncompressed
+	}
+	for _, ef := range p.excluded {
+		fmt.Fprintf(w, "exclude %s: %s\n", ef.file, ef.reason)
+	}
+	order, groups := fingerprintGroups(p.included)
+	for _, fp := range order {
+		files := groups[fp]
+		fmt.Fprintf(w, "schema %s: %d files, such as %s\n", fp, len(files), files[0].file)
+	}
+	fmt.Fprintln(w, schema)
+	fmt.Fprintf(w, "%d files, %d rows, %d bytes (%d uncompressed)\n", len(p.included), rows, size, uncompressed)
+}
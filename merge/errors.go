@@ -0,0 +1,39 @@
+package merge
+
+import "errors"
+
+// The errors returned by New and Run are marked with one of these kinds,
+// which errors.Is reports without changing the error's message, so that
+// callers can tell failures apart.  Errors of no kind are returned too.
+var (
+	// ErrInvalidOptions marks options that are invalid or do not fit the
+	// input files, such as a -where naming a column no file has.
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrNoInputs marks a run that found no input files.
+	ErrNoInputs = errors.New("no input files")
+	// ErrSchemaConflict marks input schemas that cannot be merged or read.
+	ErrSchemaConflict = errors.New("schema conflict")
+	// ErrRead marks an error reading an input file.
+	ErrRead = errors.New("error reading input")
+	// ErrWrite marks an error writing the output or the report.
+	ErrWrite = errors.New("error writing output")
+	// ErrVerify marks output that failed -verify.
+	ErrVerify = errors.New("verification failed")
+)
+
+// kindError marks err with one of the error kinds.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// markError returns err marked with kind, or nil if err is nil.
+func markError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
@@ -0,0 +1,193 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// externalSorter sorts all the rows written to it before writing them to
+// its output, without holding more than runRows of them in memory: every
+// runRows rows are sorted and written to a temporary run file in dir, and
+// the runs are merged into the output when it is closed.
+type externalSorter struct {
+	mergeOutput
+	sorting []parquet.SortingColumn
+	dir     string
+	runRows int64
+	// batchSize is the number of rows merged from the runs at a time.
+	batchSize int
+	// buf holds the rows of the next run.  Unlike a parquet.Buffer, which
+	// puts the nulls of descending columns first, it sorts them as the
+	// merge of the runs does.
+	buf *parquet.RowBuffer[any]
+	// runs are the run files written, and runBytes their total size.
+	runs     []*os.File
+	runBytes int64
+	// done is set once the runs are merged and removed.
+	done bool
+}
+
+func newExternalSorter(output mergeOutput, sorting []parquet.SortingColumn, dir string, runRows int64, batchSize int) *externalSorter {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &externalSorter{
+		mergeOutput: output,
+		sorting:     sorting,
+		dir:         dir,
+		runRows:     runRows,
+		batchSize:   batchSize,
+		buf:         parquet.NewRowBuffer[any](output.Schema(), parquet.SortingRowGroupConfig(parquet.SortingColumns(sorting...))),
+	}
+}
+
+func (s *externalSorter) WriteRows(rows []parquet.Row) (int, error) {
+	written := 0
+	for len(rows) > 0 {
+		batch := rows
+		if room := s.runRows - int64(s.buf.NumRows()); int64(len(batch)) > room {
+			batch = batch[:room]
+		}
+		n, err := s.buf.WriteRows(batch)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		rows = rows[n:]
+		if int64(s.buf.NumRows()) >= s.runRows {
+			if err := s.writeRun(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeRun sorts the buffered rows and writes them to a new run file.
+func (s *externalSorter) writeRun() error {
+	if s.buf.NumRows() == 0 {
+		return nil
+	}
+	sort.Stable(s.buf)
+	f, err := os.CreateTemp(s.dir, "merger-sort-run.*.parquet")
+	if err != nil {
+		return fmt.Errorf("error creating sort run: %w", err)
+	}
+	s.runs = append(s.runs, f)
+	w := parquet.NewWriter(f, s.buf.Schema(), parquet.Compression(&parquet.Snappy))
+	if _, err := parquet.CopyRows(w, s.buf.Rows()); err != nil {
+		return fmt.Errorf("error writing sort run: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing sort run: %w", err)
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	s.runBytes += size
+	s.buf.Reset()
+	return nil
+}
+
+// Flush does nothing: rows are only written once they are all sorted.
+func (s *externalSorter) Flush() error { return nil }
+
+// Close merges the runs into the output, removes them and closes the
+// output.
+func (s *externalSorter) Close() error {
+	if s.done {
+		return s.mergeOutput.Close()
+	}
+	defer s.removeRuns()
+	if err := s.writeRun(); err != nil {
+		return err
+	}
+	groups := make([]parquet.RowGroup, 0, len(s.runs))
+	for _, f := range s.runs {
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		pf, err := parquet.OpenFile(f, size)
+		if err != nil {
+			return fmt.Errorf("error reading sort run: %w", err)
+		}
+		for _, rg := range pf.RowGroups() {
+			groups = append(groups, sortedRun{RowGroup: rg, sorting: s.sorting})
+		}
+	}
+	merged, err := parquet.MergeRowGroups(groups, parquet.SortingRowGroupConfig(parquet.SortingColumns(s.sorting...)))
+	if err != nil {
+		return err
+	}
+	// The rows are read in batches rather than copied with
+	// parquet.CopyRows, whose merge buffer does not keep them in order.
+	rows := merged.Rows()
+	defer rows.Close()
+	batch := make([]parquet.Row, s.batchSize)
+	for {
+		n, err := rows.ReadRows(batch)
+		if n > 0 {
+			if _, err := s.mergeOutput.WriteRows(batch[:n]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("error reading sort run: %w", err)
+		}
+	}
+	return s.mergeOutput.Close()
+}
+
+// removeRuns removes the run files.
+func (s *externalSorter) removeRuns() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.done = true
+}
+
+// discard removes the run files as well as the output.
+func (s *externalSorter) discard() {
+	s.removeRuns()
+	s.mergeOutput.discard()
+}
+
+func (s *externalSorter) report(logger *slog.Logger) {
+	logger.Info("sorted the output externally", "runs", len(s.runs), "temp_bytes", s.runBytes, "dir", s.dir)
+	s.mergeOutput.report(logger)
+}
+
+// sortedRun is a row group of a run file, which is sorted although its
+// metadata does not say so.
+type sortedRun struct {
+	parquet.RowGroup
+	sorting []parquet.SortingColumn
+}
+
+func (r sortedRun) SortingColumns() []parquet.SortingColumn { return r.sorting }
+
+// Rows clones the rows read, whose values otherwise point into pages the
+// run's reader reuses while the merge still holds them.
+func (r sortedRun) Rows() parquet.Rows { return clonedRows{r.RowGroup.Rows()} }
+
+type clonedRows struct{ parquet.Rows }
+
+func (r clonedRows) ReadRows(rows []parquet.Row) (int, error) {
+	n, err := r.Rows.ReadRows(rows)
+	for i := range rows[:n] {
+		rows[i] = rows[i].Clone()
+	}
+	return n, err
+}
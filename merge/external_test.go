@@ -0,0 +1,65 @@
+package merge
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExternalSortNulls(t *testing.T) {
+	dir := t.TempDir()
+	g := parquet.Group{"id": parquet.Int(64), "k": parquet.Optional(parquet.Int(64))}
+	var files []string
+	for f := 0; f < 3; f++ {
+		var rows []map[string]any
+		for i := 0; i < 700; i++ {
+			id := int64(f*700 + i)
+			row := map[string]any{"id": id}
+			if id%7 != 0 {
+				row["k"] = id * 31 % 1000
+			}
+			rows = append(rows, row)
+		}
+		files = append(files, filepath.Join(dir, fmt.Sprintf("%d.parquet", f)))
+		writeParquet(t, files[f], g, rows)
+	}
+	for _, spec := range []string{"k:desc", "k"} {
+		for _, nulls := range []string{"last", "first"} {
+			// Rows of equal keys may come in any order, so only the keys
+			// are compared.
+			var keys [2][]any
+			for i, external := range []bool{false, true} {
+				out := filepath.Join(dir, fmt.Sprintf("%v.parquet", external))
+				opts := testOptions(out, files...)
+				opts.SortBy, opts.SortNulls = spec, nulls
+				opts.SortExternal = external
+				opts.SortBufferRows = 100
+				runMerge(t, opts)
+				_, rows := readParquet(t, out)
+				for _, row := range rows {
+					keys[i] = append(keys[i], row["k"])
+				}
+			}
+			if len(keys[0]) != 2100 {
+				t.Fatalf("-sortby %s: sorted %d rows, want 2100", spec, len(keys[0]))
+			}
+			if !reflect.DeepEqual(keys[0], keys[1]) {
+				t.Errorf("-sortby %s -sort-nulls %s: sorting externally gave another order than in memory", spec, nulls)
+			}
+			// One row in seven has a null key.
+			at := keys[1][:300]
+			if nulls == "last" {
+				at = keys[1][len(keys[1])-300:]
+			}
+			for _, k := range at {
+				if k != nil {
+					t.Errorf("-sortby %s -sort-nulls %s: nulls are not %s", spec, nulls, nulls)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// fieldID is the field ID of a merged column and the file it came from.
+type fieldID struct {
+	id   int
+	from string
+}
+
+// nodeFieldIDs returns the field IDs of node, found at path, and of the
+// nodes under it, by their paths.
+func nodeFieldIDs(path string, node parquet.Node) map[string]int {
+	ids := map[string]int{}
+	addFieldIDs(ids, path, node)
+	return ids
+}
+
+func addFieldIDs(ids map[string]int, path string, node parquet.Node) {
+	if id := node.ID(); id != 0 {
+		ids[path] = id
+	}
+	if node.Leaf() {
+		return
+	}
+	for _, f := range node.Fields() {
+		addFieldIDs(ids, path+"."+f.Name(), f)
+	}
+}
+
+// fieldIDConflicts returns the columns under key whose field IDs in node
+// differ from those merged before.  Columns with an ID on one side only do
+// not conflict.
+func fieldIDConflicts(ids map[string]fieldID, key string, node parquet.Node) []columnConflict {
+	var conflicts []columnConflict
+	found := nodeFieldIDs(key, node)
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if merged, ok := ids[path]; ok && merged.id != found[path] {
+			conflicts = append(conflicts, columnConflict{
+				key:        key,
+				Column:     path,
+				Merged:     fmt.Sprintf("field id %d", merged.id),
+				MergedFrom: merged.from,
+				Type:       fmt.Sprintf("field id %d", found[path]),
+			})
+		}
+	}
+	return conflicts
+}
+
+// recordFieldIDs adds the field IDs of nodes, from file, that no file
+// merged before had to ids.
+func recordFieldIDs(ids map[string]fieldID, file string, nodes map[string]parquet.Node) {
+	for k, node := range nodes {
+		for path, id := range nodeFieldIDs(k, node) {
+			if _, ok := ids[path]; !ok {
+				ids[path] = fieldID{id: id, from: file}
+			}
+		}
+	}
+}
+
+// withFieldIDs returns node, found at path, with the field IDs in ids put
+// back on it and the nodes under it, since merging nodes builds new ones.
+func withFieldIDs(node parquet.Node, path string, ids map[string]fieldID) parquet.Node {
+	if node.Leaf() {
+		return withFieldID(node, ids[path].id)
+	}
+	var out parquet.Node
+	switch {
+	case isList(node):
+		out = withRepetition(parquet.List(withFieldIDs(listElement(node), path+".list.element", ids)), node)
+	case isMap(node):
+		key := fieldOf(node.Fields()[0], "key")
+		out = withRepetition(parquet.Map(withFieldIDs(key, path+".key_value.key", ids),
+			withFieldIDs(mapValue(node), path+".key_value.value", ids)), node)
+	default:
+		fields := parquet.Group{}
+		for _, f := range node.Fields() {
+			fields[f.Name()] = withFieldIDs(f, path+"."+f.Name(), ids)
+		}
+		out = withRepetition(fields, node)
+	}
+	return withFieldID(out, ids[path].id)
+}
@@ -0,0 +1,305 @@
+package merge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inputFiles returns the files named by SourceDir, FileList and Patterns,
+// without repeats or the files matching Exclude.
+func (m *Merger) inputFiles() ([]string, error) {
+	logger := m.log.With("phase", "find")
+	var files []string
+	var err error
+	if m.opts.FileList != "" {
+		files, err = readFileList(m.opts.FileList)
+	} else if m.opts.SourceDir != "" {
+		if m.opts.Recursive {
+			files, err = findFilesRecursive(logger, m.opts.SourceDir, m.opts.Extensions)
+		} else {
+			files, err = findFiles(m.opts.SourceDir, m.opts.Extensions)
+		}
+		if err == nil && m.opts.MinAge > 0 {
+			files = m.settledFiles(logger, files, time.Now())
+		}
+	}
+	if err != nil {
+		return nil, markError(ErrRead, err)
+	}
+	globbed, err := globFiles(logger, m.opts.Patterns)
+	if err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	files = dedupFiles(append(files, globbed...))
+	if len(m.opts.Exclude) > 0 {
+		n := len(files)
+		if files, err = excludeFiles(files, m.opts.Exclude); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+		logger.Debug("excluded files", "excluded", n-len(files), "files", n)
+	}
+	if len(files) == 0 {
+		return nil, markError(ErrNoInputs, errors.New("no input files found"))
+	}
+	return files, nil
+}
+
+// tempPrefixes are the prefixes of the temporary files the merger creates,
+// which are never inputs.
+var tempPrefixes = []string{"merger-sort-run.", "merger-rows.", "merger-pages.", "merger-dedup-", "merger-deletes-"}
+
+// searchedFile reports whether a file named name found in a directory is
+// an input: it ends in one of exts and is neither hidden nor temporary.
+func searchedFile(name string, exts []string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	for _, prefix := range tempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	for _, ext := range exts {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if len(name) > len(ext) && strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// findFiles returns the parquet files in dir, those ending in one of exts.
+func findFiles(dir string, exts []string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if searchedFile(file.Name(), exts) {
+			out = append(out, dir+"/"+file.Name())
+		}
+	}
+	return out, nil
+}
+
+// settledFiles returns the files last modified at least -min-age before
+// now, skipping the others, which may still be being written.  Files that
+// cannot be stated are kept, to fail when they are read.
+func (m *Merger) settledFiles(logger *slog.Logger, files []string, now time.Time) []string {
+	var out []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			if age := now.Sub(info.ModTime()); age < m.opts.MinAge {
+				logger.Debug("skipping a file modified less than -min-age ago", "file", file, "age", age)
+				m.skipFile(file, "modified recently", fmt.Sprintf("modified %s ago", age.Round(time.Millisecond)))
+				continue
+			}
+		}
+		out = append(out, file)
+	}
+	return out
+}
+
+// readFileList reads the files named in a manifest, one per line, skipping
+// blank lines and # comments.  Relative paths are resolved against the
+// manifest's directory, or the working directory when reading stdin.
+func readFileList(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	dir := "."
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+		dir = filepath.Dir(name)
+	}
+	var out []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" || strings.HasPrefix(path, "#") {
+			continue
+		}
+		if isRemote(path) {
+			out = append(out, path)
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		out = append(out, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// globFiles expands each pattern, warning about patterns that match nothing.
+// URLs are kept as they are.
+func globFiles(logger *slog.Logger, patterns []string) ([]string, error) {
+	var out []string
+	for _, pattern := range patterns {
+		if isRemote(pattern) {
+			out = append(out, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			logger.Warn("pattern matched no files", "pattern", pattern)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// dedupFiles removes repeated paths, keeping the first occurrence.
+func dedupFiles(files []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, file := range files {
+		clean := filepath.Clean(file)
+		if seen[clean] {
+			continue
+		}
+		seen[clean] = true
+		out = append(out, file)
+	}
+	return out
+}
+
+// excludeFiles drops the files matching any of patterns.
+func excludeFiles(files, patterns []string) ([]string, error) {
+	var out []string
+	for _, file := range files {
+		skip, err := excluded(file, patterns)
+		if err != nil {
+			return nil, err
+		}
+		if !skip {
+			out = append(out, file)
+		}
+	}
+	return out, nil
+}
+
+// excluded reports whether path matches one of patterns.  A pattern matches
+// the path or any trailing part of it, so *.tmp.parquet matches by file
+// name, and a pattern ending in / matches any directory on the path.
+func excluded(path string, patterns []string) (bool, error) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			for _, part := range parts[:len(parts)-1] {
+				match, err := filepath.Match(dir, part)
+				if err != nil {
+					return false, fmt.Errorf("bad exclude pattern %q: %w", pattern, err)
+				}
+				if match {
+					return true, nil
+				}
+			}
+			continue
+		}
+		for i := range parts {
+			match, err := filepath.Match(pattern, strings.Join(parts[i:], "/"))
+			if err != nil {
+				return false, fmt.Errorf("bad exclude pattern %q: %w", pattern, err)
+			}
+			if match {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// findFilesRecursive returns the parquet files under dir, those ending in
+// one of exts, in sorted order.  Symlinked directories are followed, each
+// at most once, hidden directories are skipped, and subdirectories that
+// cannot be read are reported and skipped.
+func findFilesRecursive(logger *slog.Logger, dir string, exts []string) ([]string, error) {
+	var out []string
+	visited := map[string]bool{}
+	var walk func(root string) error
+	walk = func(root string) error {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+		return filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+			// Report paths under root rather than its resolved target.
+			path = filepath.Join(root, strings.TrimPrefix(path, real))
+			if err != nil {
+				if path != root && errors.Is(err, fs.ErrPermission) {
+					logger.Warn("skipping a directory", "path", path, "error", err)
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				info, err := os.Stat(path)
+				if err != nil {
+					logger.Warn("skipping a symlink", "path", path, "error", err)
+					return nil
+				}
+				if info.IsDir() {
+					if hiddenDir(path, root) {
+						return nil
+					}
+					return walk(path)
+				}
+			} else if d.IsDir() {
+				if hiddenDir(path, root) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if searchedFile(filepath.Base(path), exts) {
+				out = append(out, path)
+			}
+			return nil
+		})
+	}
+	if err := walk(dir); err != nil {
+		return nil, err
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// hiddenDir reports whether path, a directory found under root, is hidden.
+func hiddenDir(path, root string) bool {
+	return path != root && strings.HasPrefix(filepath.Base(path), ".")
+}
@@ -0,0 +1,143 @@
+package merge
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestSearchedFile(t *testing.T) {
+	parquet := []string{".parquet"}
+	tests := []struct {
+		name string
+		exts []string
+		want bool
+	}{
+		{"a.parquet", parquet, true},
+		{"a.parquet.gz", parquet, false},
+		{"a.csv", parquet, false},
+		{".parquet", parquet, false},
+		{".a.parquet", parquet, false},
+		{"a.parquet.tmp", parquet, false},
+		{"a.tmp", []string{".tmp"}, false},
+		{"merger-sort-run.123.parquet", parquet, false},
+		{"merger-rows.1.parquet", parquet, false},
+		{"merger-pages.1.parquet", parquet, false},
+		{"merger-dedup-1.parquet", parquet, false},
+		{"merger-deletes-1.parquet", parquet, false},
+		{"merger.parquet", parquet, true},
+		{"a.pq", []string{".parquet", "pq"}, true},
+		{"a.parquet", []string{"pq"}, false},
+		{"apq", []string{"pq"}, false},
+	}
+	for _, tt := range tests {
+		if got := searchedFile(tt.name, tt.exts); got != tt.want {
+			t.Errorf("searchedFile(%q, %q) = %v, want %v", tt.name, tt.exts, got, tt.want)
+		}
+	}
+}
+
+// makeTree creates files, by slash-separated path, under dir.
+func makeTree(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindFilesRecursive(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir,
+		"a.parquet",
+		"b.pq",
+		".hidden.parquet",
+		"c.parquet.tmp",
+		"merger-sort-run.1.parquet",
+		"notes.txt",
+		"sub/d.parquet",
+		"sub/deeper/e.parquet",
+		"sub/merger-rows.2.parquet",
+		".cache/f.parquet",
+		"sub/.git/g.parquet",
+	)
+	tests := []struct {
+		exts []string
+		want []string
+	}{
+		{[]string{".parquet"}, []string{"a.parquet", "sub/d.parquet", "sub/deeper/e.parquet"}},
+		{[]string{".parquet", "pq"}, []string{"a.parquet", "b.pq", "sub/d.parquet", "sub/deeper/e.parquet"}},
+		{[]string{".txt"}, []string{"notes.txt"}},
+	}
+	for _, tt := range tests {
+		got, err := findFilesRecursive(discardLogger, dir, tt.exts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, file := range tt.want {
+			want = append(want, filepath.Join(dir, filepath.FromSlash(file)))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("findFilesRecursive(%q) = %q, want %q", tt.exts, got, want)
+		}
+	}
+
+	got, err := findFiles(dir, []string{".parquet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dir + "/a.parquet"}; !slices.Equal(got, want) {
+		t.Errorf("findFiles = %q, want %q", got, want)
+	}
+}
+
+func TestFindFilesRecursiveSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "data/a.parquet", "other/b.parquet")
+	// A directory reached by several links is walked once, and a hidden
+	// link not at all.
+	for link, target := range map[string]string{"data/loop": ".", "data/.other": "../other", "data/other": "../other", "data/same": "../other"} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skip("cannot create symlinks:", err)
+		}
+	}
+	got, err := findFilesRecursive(discardLogger, filepath.Join(dir, "data"), []string{".parquet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "data/a.parquet"), filepath.Join(dir, "data/other/b.parquet")}
+	if !slices.Equal(got, want) {
+		t.Errorf("findFilesRecursive = %q, want %q", got, want)
+	}
+}
+
+func TestSettledFiles(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "old.parquet", "new.parquet")
+	now := time.Now()
+	old := filepath.Join(dir, "old.parquet")
+	if err := os.Chtimes(old, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.parquet")
+	m := &Merger{opts: Options{MinAge: time.Hour}}
+	got := m.settledFiles(discardLogger, []string{old, filepath.Join(dir, "new.parquet"), missing}, now)
+	if want := []string{old, missing}; !slices.Equal(got, want) {
+		t.Errorf("settledFiles = %q, want %q", got, want)
+	}
+	if len(m.stats.Skipped) != 1 || m.stats.Skipped[0].File != filepath.Join(dir, "new.parquet") || m.stats.Skipped[0].Reason != "modified recently" {
+		t.Errorf("skipped %+v, want new.parquet as modified recently", m.stats.Skipped)
+	}
+}
@@ -0,0 +1,137 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// flattens reports whether -flatten expands node into its leaf columns:
+// it is a group, neither repeated nor a LIST or MAP.
+func flattens(node parquet.Node) bool {
+	return !node.Leaf() && !node.Repeated() && !isList(node) && !isMap(node)
+}
+
+// flattenNodes returns nodes with their groups expanded into top-level
+// columns named by the dotted paths of their leaves, and the groups
+// expanded by name.  Leaves of optional groups become optional.
+func flattenNodes(nodes map[string]parquet.Node) (map[string]parquet.Node, map[string]parquet.Node, error) {
+	out := make(map[string]parquet.Node, len(nodes))
+	var flattened map[string]parquet.Node
+	add := func(name, from string, node parquet.Node) error {
+		if _, ok := out[name]; ok {
+			return fmt.Errorf("flattening %s makes a second column %s", from, name)
+		}
+		out[name] = node
+		return nil
+	}
+	var expand func(prefix, top string, group parquet.Node, optional bool) error
+	expand = func(prefix, top string, group parquet.Node, optional bool) error {
+		for _, f := range group.Fields() {
+			name := prefix + "." + f.Name()
+			if flattens(f) {
+				if err := expand(name, top, f, optional || f.Optional()); err != nil {
+					return err
+				}
+				continue
+			}
+			node := parquet.Node(f)
+			if optional && !f.Optional() && !f.Repeated() {
+				node = parquet.Optional(f)
+			}
+			if err := add(name, top, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range sortedKeys(nodes) {
+		node := nodes[name]
+		if !flattens(node) {
+			if err := add(name, name, node); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if flattened == nil {
+			flattened = map[string]parquet.Node{}
+		}
+		flattened[name] = node
+		if err := expand(name, name, node, node.Optional()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return out, flattened, nil
+}
+
+// unflattenNodes returns nodes with the columns flattened from the groups
+// of flattened replaced by those groups, as the file stores them.
+func unflattenNodes(nodes map[string]parquet.Node, flattened map[string]parquet.Node) map[string]parquet.Node {
+	if len(flattened) == 0 {
+		return nodes
+	}
+	out := make(map[string]parquet.Node, len(nodes))
+	for name, node := range nodes {
+		out[name] = node
+		for top, group := range flattened {
+			if strings.HasPrefix(name, top+".") {
+				delete(out, name)
+				out[top] = group
+				break
+			}
+		}
+	}
+	return out
+}
+
+// flattenRecord replaces the values of the groups of flattened in record,
+// decoded as the file stores them, with those of their leaf columns.
+func flattenRecord(record map[string]any, flattened map[string]parquet.Node) {
+	for name, group := range flattened {
+		v, _ := record[name].(map[string]any)
+		delete(record, name)
+		flattenValue(record, name, group, v)
+	}
+}
+
+func flattenValue(record map[string]any, prefix string, group parquet.Node, v map[string]any) {
+	for _, f := range group.Fields() {
+		name := prefix + "." + f.Name()
+		if flattens(f) {
+			sub, _ := v[f.Name()].(map[string]any)
+			flattenValue(record, name, f, sub)
+			continue
+		}
+		if x, ok := v[f.Name()]; ok {
+			record[name] = x
+		}
+	}
+}
+
+// lookupColumn looks up the leaf column name in schema: a top-level
+// column, whose name may hold dots with -flatten, or a dotted path.
+func lookupColumn(schema *parquet.Schema, name string) (parquet.LeafColumn, bool) {
+	if leaf, ok := schema.Lookup(name); ok {
+		return leaf, true
+	}
+	return schema.Lookup(strings.Split(name, ".")...)
+}
+
+// fileColumn looks up the merged column name in schema, the schema of a
+// file whose top-level columns renamed maps from their output names.  A
+// name that is not a column of the file is taken as the dotted path of a
+// leaf flattened by -flatten.
+func fileColumn(schema *parquet.Schema, name string, renamed map[string]string) (parquet.LeafColumn, bool) {
+	if old, ok := renamed[name]; ok {
+		return schema.Lookup(old)
+	}
+	if leaf, ok := schema.Lookup(name); ok {
+		return leaf, true
+	}
+	path := strings.Split(name, ".")
+	if old, ok := renamed[path[0]]; ok {
+		path[0] = old
+	}
+	return schema.Lookup(path...)
+}
@@ -0,0 +1,53 @@
+package merge
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestFlatten(t *testing.T) {
+	dir := t.TempDir()
+	nested, flat := filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet")
+	writeParquet(t, nested, parquet.Group{
+		"id": parquet.Int(64),
+		"resource": parquet.Optional(parquet.Group{
+			"host":   parquet.String(),
+			"region": parquet.String(),
+		}),
+	}, []map[string]any{
+		{"id": int64(1), "resource": map[string]any{"host": "h1", "region": "eu"}},
+		{"id": int64(2)},
+	})
+	writeParquet(t, flat, parquet.Group{
+		"id":            parquet.Int(64),
+		"resource.host": parquet.String(),
+	}, []map[string]any{{"id": int64(3), "resource.host": "h3"}})
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, nested, flat)
+	opts.Flatten = true
+	runMerge(t, opts)
+	schema, rows := readParquet(t, out)
+	if got, want := schema.Columns(), [][]string{{"id"}, {"resource.host"}, {"resource.region"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote the columns %q, want %q", got, want)
+	}
+	for _, name := range []string{"resource.host", "resource.region"} {
+		if f, _ := fieldByName(schema, name); !f.Optional() {
+			t.Errorf("%s is not optional", name)
+		}
+	}
+	want := []map[string]any{
+		{"id": int64(1), "resource.host": "h1", "resource.region": "eu"},
+		{"id": int64(2), "resource.host": nil, "resource.region": nil},
+		{"id": int64(3), "resource.host": "h3", "resource.region": nil},
+	}
+	for i, row := range rows {
+		for k, v := range want[i] {
+			if row[k] != v {
+				t.Errorf("row %d has %s %v, want %v", i, k, row[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package merge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// schemaGroup is what the merged files with one schema fingerprint share:
+// their nodes, the schema they are read with, the conversions their
+// columns need, the defaults of the columns they lack and the columns
+// -utf8 checks.
+type schemaGroup struct {
+	nodes    map[string]parquet.Node
+	schema   *parquet.Schema
+	coerce   map[string]conversion
+	defaults []parquet.Value
+	utf8     []bool
+	// tightened lists the columns -tighten-nullability made required
+	// that the files store as optional.
+	tightened []string
+}
+
+// schemaFingerprint returns a hash identifying the columns of a file by
+// their names, types and renames, the same for every file with the same
+// schema.
+func schemaFingerprint(nodes map[string]parquet.Node, renamed map[string]string) string {
+	h := sha256.New()
+	parquet.PrintSchemaIndent(h, "", parquet.Group(nodes), "", " ")
+	for _, k := range sortedStrings(renamed) {
+		fmt.Fprintf(h, " %s=%s", k, renamed[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// fingerprintGroups returns the fingerprints of files in order of first
+// appearance, and the files with each.
+func fingerprintGroups(files []scannedFile) ([]string, map[string][]scannedFile) {
+	var order []string
+	groups := map[string][]scannedFile{}
+	for _, sf := range files {
+		if _, ok := groups[sf.fingerprint]; !ok {
+			order = append(order, sf.fingerprint)
+		}
+		groups[sf.fingerprint] = append(groups[sf.fingerprint], sf)
+	}
+	return order, groups
+}
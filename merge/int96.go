@@ -0,0 +1,101 @@
+package merge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+)
+
+// julianUnixEpoch is the Julian day number of 1970-01-01, the day INT96
+// timestamps are counted from.
+const julianUnixEpoch = 2440588
+
+func isInt96(node parquet.Node) bool {
+	return node.Leaf() && node.Type().Kind() == parquet.Int96
+}
+
+// int96Target returns node with INT96 leaves replaced by the type as,
+// selected with -int96-as.  Files are still read with their INT96 columns, and the
+// values are rewritten by convertInt96 during copy.
+func int96Target(node parquet.Node, as string) parquet.Node {
+	if node.Leaf() {
+		if !isInt96(node) {
+			return node
+		}
+		switch as {
+		case "timestamp-millis":
+			return withRepetition(parquet.Timestamp(parquet.Millisecond), node)
+		default:
+			return withRepetition(parquet.Leaf(parquet.FixedLenByteArrayType(12)), node)
+		}
+	}
+	if isList(node) {
+		return withRepetition(parquet.List(int96Target(listElement(node), as)), node)
+	}
+	if isMap(node) {
+		return withRepetition(parquet.Map(parquet.String(), int96Target(mapValue(node), as)), node)
+	}
+	fields := parquet.Group{}
+	for _, f := range node.Fields() {
+		fields[f.Name()] = int96Target(f, as)
+	}
+	return withRepetition(fields, node)
+}
+
+// int96Column returns the path of the first INT96 leaf of nodes, in name
+// order, or "" if there is none.
+func int96Column(nodes map[string]parquet.Node) string {
+	for _, k := range sortedKeys(nodes) {
+		node := nodes[k]
+		switch {
+		case node.Leaf():
+			if isInt96(node) {
+				return k
+			}
+		case isList(node):
+			if isInt96(listElement(node)) {
+				return k + ".element"
+			}
+		case isMap(node):
+			if isInt96(mapValue(node)) {
+				return k + ".value"
+			}
+		default:
+			children := map[string]parquet.Node{}
+			for _, f := range node.Fields() {
+				children[f.Name()] = f
+			}
+			if column := int96Column(children); column != "" {
+				return k + "." + column
+			}
+		}
+	}
+	return ""
+}
+
+// convertInt96 rewrites an INT96 value as a timestamp in the unit of to, or
+// as its 12 raw little-endian bytes.
+func convertInt96(v any, to parquet.Node) (any, error) {
+	x, ok := v.(deprecated.Int96)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %T to %s", v, nodeTypeName(to))
+	}
+	if isTemporal(to) && to.Type().LogicalType().Timestamp != nil {
+		nanos := int64(x[1])<<32 | int64(x[0])
+		days := int64(x[2]) - julianUnixEpoch
+		n := days*int64(24*time.Hour) + nanos
+		unit, _ := temporalUnit(to)
+		return n / int64(timeUnitOf(unit).Duration()), nil
+	}
+	if to.Type().Kind() == parquet.FixedLenByteArray && to.Type().Length() == 12 {
+		b := make([]byte, 12)
+		for i, w := range x {
+			binary.LittleEndian.PutUint32(b[i*4:], w)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot convert INT96 to %s", nodeTypeName(to))
+}
@@ -0,0 +1,102 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrInterrupted is returned by Run when its context is canceled during the
+// merge.  What is left of the output depends on Options.OnInterrupt.
+var ErrInterrupted = errors.New("merge interrupted")
+
+// partialKey is the key/value metadata key set to true in files closed
+// early with -on-interrupt finish.
+const partialKey = "merged.partial"
+
+// keyValueSetter is the part of parquet.GenericWriter and
+// parquet.SortingWriter used to mark a file as partial.
+type keyValueSetter interface {
+	SetKeyValueMetadata(key, value string)
+}
+
+// partialWriter marks the file it writes as partial if interrupted is set
+// when it is closed.
+type partialWriter struct {
+	mergeWriter
+	kv          keyValueSetter
+	interrupted *bool
+}
+
+func (w *partialWriter) Close() error {
+	if *w.interrupted {
+		w.kv.SetKeyValueMetadata(partialKey, "true")
+	}
+	return w.mergeWriter.Close()
+}
+
+// interrupt ends a merge whose context was canceled, finishing the output
+// as partial files or removing it, and returns an error wrapping
+// ErrInterrupted.
+func (m *Merger) interrupt(output mergeOutput, writer mergeWriter, counted *rowCounter, interrupted *bool, cause error) error {
+	*interrupted = true
+	if m.appendTo != "" {
+		// Finishing would replace the output appended to with part of it.
+		output.discard()
+		m.log.Warn("interrupted, left the output as it was", "phase", "copy", "file", m.appendTo)
+		return fmt.Errorf("%w: %w", ErrInterrupted, cause)
+	}
+	if m.opts.OnInterrupt == "discard" {
+		names := output.names()
+		output.discard()
+		if len(names) > 0 {
+			m.log.Warn("interrupted, removed the output", "phase", "copy", "rows", counted.rows, "files", names)
+		}
+		return fmt.Errorf("%w: %w", ErrInterrupted, cause)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("%w: error closing writer: %w", ErrInterrupted, err)
+	}
+	m.stats.RowsWritten = counted.rows
+	m.stats.BytesWritten = output.written()
+	m.stats.OutputFiles = output.names()
+	if names := output.names(); len(names) > 0 {
+		m.log.Warn("interrupted, marked the output partial", "phase", "copy", "rows", counted.rows, "files", names)
+	}
+	return fmt.Errorf("%w: %w", ErrInterrupted, cause)
+}
+
+// discard abandons the current piece and removes every piece written.
+func (w *outputWriter) discard() {
+	if w.writer != nil {
+		w.writer = nil
+		w.file.Close()
+		os.Remove(w.pieces[len(w.pieces)-1].name + ".tmp")
+		w.pieces = w.pieces[:len(w.pieces)-1]
+	}
+	for _, p := range w.pieces {
+		os.Remove(p.name)
+	}
+}
+
+// discard removes the files of every partition, and the partition
+// directories if that leaves them empty.
+func (w *partitionWriter) discard() {
+	for _, out := range w.writers {
+		out.discard()
+		os.Remove(filepath.Dir(out.template))
+	}
+	w.open = nil
+}
+
+// discard removes the files of every shard.
+func (w *shardWriter) discard() {
+	for _, out := range w.shards {
+		out.discard()
+	}
+}
+
+// discard does nothing: what was written to the stream cannot be taken
+// back, and the truncated file is left without a footer.
+func (w *streamWriter) discard() {}
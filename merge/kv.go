@@ -0,0 +1,73 @@
+package merge
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reproducibleCreatedBy is the created_by of the files written with
+// Options.Reproducible.
+const reproducibleCreatedBy = "merger"
+
+// mergeKeyValues merges the key/value metadata of the merged files.  A key
+// with the same value in every file that has it is kept as it is; how keys
+// with differing values are written depends on mode:
+//
+//	join:  one key per file, with the file's base name appended to the key
+//	array: a JSON array of the distinct values, in file order
+//	drop:  left out
+//
+// The merged.tool and merged.inputs keys are always added, and
+// merged.timestamp unless now is zero.
+func mergeKeyValues(files []scannedFile, mode string, now time.Time) map[string]string {
+	type source struct{ file, value string }
+	values := map[string][]source{}
+	for _, sf := range files {
+		for _, k := range sortedStrings(sf.metadata) {
+			values[k] = append(values[k], source{sf.file, sf.metadata[k]})
+		}
+	}
+	out := map[string]string{}
+	for k, sources := range values {
+		var distinct []string
+		seen := map[string]bool{}
+		for _, s := range sources {
+			if !seen[s.value] {
+				seen[s.value] = true
+				distinct = append(distinct, s.value)
+			}
+		}
+		if len(distinct) == 1 {
+			out[k] = distinct[0]
+			continue
+		}
+		switch mode {
+		case "join":
+			for _, s := range sources {
+				out[k+"."+filepath.Base(s.file)] = s.value
+			}
+		case "array":
+			b, _ := json.Marshal(distinct)
+			out[k] = string(b)
+		}
+	}
+	out["merged.tool"] = "merger"
+	out["merged.inputs"] = strconv.Itoa(len(files))
+	if !now.IsZero() {
+		out["merged.timestamp"] = now.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// sortedStrings returns the keys of m in sorted order.
+func sortedStrings(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
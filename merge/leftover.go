@@ -0,0 +1,15 @@
+package merge
+
+import "strings"
+
+// writeLeftover writes the -leftover list of files, one per line, left for
+// a later run.  An empty list is written too, so that a stale one does not
+// stay behind.
+func writeLeftover(name string, files []string) error {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f)
+		b.WriteByte('\n')
+	}
+	return replaceFile(name, []byte(b.String()))
+}
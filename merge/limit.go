@@ -0,0 +1,42 @@
+package merge
+
+import (
+	"errors"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// errLimit is returned by limitWriter when it is given rows past -limit.
+var errLimit = errors.New("row limit reached")
+
+// limitWriter skips the first offset rows written to it and writes at most
+// limit of the rest, or all of them if limit is 0.
+type limitWriter struct {
+	mergeWriter
+	offset, limit    int64
+	skipped, written int64
+}
+
+// WriteRows returns errLimit, along with the number of rows used, once it
+// is given a row past the limit.
+func (w *limitWriter) WriteRows(rows []parquet.Row) (int, error) {
+	used := 0
+	if w.skipped < w.offset {
+		n := min(w.offset-w.skipped, int64(len(rows)))
+		w.skipped += n
+		used += int(n)
+		rows = rows[n:]
+	}
+	var err error
+	if w.limit > 0 && w.written+int64(len(rows)) > w.limit {
+		rows = rows[:w.limit-w.written]
+		err = errLimit
+	}
+	n, werr := w.mergeWriter.WriteRows(rows)
+	w.written += int64(n)
+	used += n
+	if werr != nil {
+		return used, werr
+	}
+	return used, err
+}
@@ -0,0 +1,163 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by Run when another merger holds the lock on the
+// output.
+var ErrLocked = errors.New("output locked")
+
+// outputLock is the content of the lock file of an output: who holds it,
+// and since when.
+type outputLock struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// locks reports whether the run takes the lock on its output: unless
+// Options.NoLock is set, whenever it writes files.
+func (m *Merger) locks() bool {
+	o := m.opts
+	return !o.NoLock && o.Output == nil && !o.Bench && !o.DryRun
+}
+
+// lockOutput takes the lock on the output, OutFile with a .lock extension,
+// and returns the function releasing it.  A lock held by a process of this
+// host that is no longer running, or older than Options.LockStaleAge, is
+// broken with a warning.
+func (m *Merger) lockOutput() (func(), error) {
+	name := m.opts.OutFile + ".lock"
+	host, _ := os.Hostname()
+	ours := outputLock{PID: os.Getpid(), Host: host, Started: time.Now().UTC()}
+	b, err := json.Marshal(ours)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(append(b, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(name)
+				return nil, markError(ErrWrite, fmt.Errorf("error writing lock %s: %w", name, err))
+			}
+			return func() { releaseLock(name, ours) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, markError(ErrWrite, fmt.Errorf("error creating lock %s: %w", name, err))
+		}
+		held, since, read, err := readLock(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released since.
+			continue
+		}
+		if err != nil {
+			return nil, markError(ErrRead, fmt.Errorf("error reading lock %s: %w", name, err))
+		}
+		reason := ""
+		switch {
+		case held.Host == host && held.PID > 0 && !processAlive(held.PID):
+			reason = "its process is no longer running"
+		case m.opts.LockStaleAge > 0 && time.Since(since) > m.opts.LockStaleAge:
+			reason = "it is older than -lock-stale-age"
+		}
+		if reason == "" {
+			return nil, markError(ErrLocked, fmt.Errorf("%s is locked by process %d on %s since %s", m.opts.OutFile, held.PID, held.Host, since.Format(time.RFC3339)))
+		}
+		broken, err := breakLock(name, read)
+		if err != nil {
+			return nil, markError(ErrWrite, fmt.Errorf("error removing stale lock %s: %w", name, err))
+		}
+		if broken {
+			m.log.Warn("broke a stale lock", "lock", name, "pid", held.PID, "host", held.Host, "since", since, "reason", reason)
+		}
+	}
+}
+
+// breakLock removes the lock file name if it is still the one read.  It is
+// moved aside first, so that of two runs finding the same stale lock, only
+// one breaks it: the other moves aside the lock taken since, and puts it
+// back.
+func breakLock(name string, read lockFile) (bool, error) {
+	stale := fmt.Sprintf("%s.%d-%d.stale", name, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(name, stale); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Broken by another run.
+			return false, nil
+		}
+		return false, err
+	}
+	defer os.Remove(stale)
+	moved, err := openLock(stale)
+	if err != nil {
+		return false, err
+	}
+	// The file of a lock removed can be reused by the next one, so that
+	// the content, which has the time the lock was taken, is compared too.
+	if !os.SameFile(read.info, moved.info) || !bytes.Equal(read.content, moved.content) {
+		// Fails if yet another lock was taken, which then holds.
+		os.Link(stale, name)
+		return false, nil
+	}
+	return true, nil
+}
+
+// lockFile is a lock file as read.
+type lockFile struct {
+	info    fs.FileInfo
+	content []byte
+}
+
+// openLock reads the lock file name.
+func openLock(name string) (lockFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return lockFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return lockFile{}, err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return lockFile{}, err
+	}
+	return lockFile{info: info, content: b}, nil
+}
+
+// readLock reads the lock file name, and returns the time it was taken,
+// or the modification time of the file if it cannot be parsed.
+func readLock(name string) (outputLock, time.Time, lockFile, error) {
+	var held outputLock
+	read, err := openLock(name)
+	if err != nil {
+		return held, time.Time{}, read, err
+	}
+	if json.Unmarshal(read.content, &held) == nil && !held.Started.IsZero() {
+		return held, held.Started, read, nil
+	}
+	// A lock being written, or not written by the merger.
+	return outputLock{}, read.info.ModTime(), read, nil
+}
+
+// releaseLock removes the lock file name if it is still ours, and not one
+// taken after ours was broken.
+func releaseLock(name string, ours outputLock) {
+	held, _, _, err := readLock(name)
+	if err == nil && held.PID == ours.PID && held.Host == ours.Host && held.Started.Equal(ours.Started) {
+		os.Remove(name)
+	}
+}
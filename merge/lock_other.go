@@ -0,0 +1,7 @@
+//go:build !unix
+
+package merge
+
+// processAlive reports true: whether a process is running is only known
+// on Unix, so elsewhere only Options.LockStaleAge breaks stale locks.
+func processAlive(pid int) bool { return true }
@@ -0,0 +1,94 @@
+package merge
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "merged.parquet")
+	b, err := json.Marshal(outputLock{PID: 1, Host: "elsewhere", Started: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(out+".lock", b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Of the runs finding the same stale lock, only one takes it.
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		unlocks []func()
+		locked  int
+	)
+	for i := 0; i < 8; i++ {
+		m, err := New(testOptions(out, filepath.Join(dir, "in.parquet")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.opts.LockStaleAge = time.Hour
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := m.lockOutput()
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				unlocks = append(unlocks, unlock)
+			case errors.Is(err, ErrLocked):
+				locked++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(unlocks) != 1 || locked != 7 {
+		t.Fatalf("%d runs took the lock and %d found it locked, want 1 and 7", len(unlocks), locked)
+	}
+	unlocks[0]()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("left %s behind", e.Name())
+	}
+}
+
+func TestBreakLockTaken(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "merged.parquet.lock")
+	if err := os.WriteFile(name, []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, read, err := readLock(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another run breaks the stale lock and takes its own before this one
+	// gets to it.
+	if broken, err := breakLock(name, read); err != nil || !broken {
+		t.Fatalf("breakLock = %v, %v, want true", broken, err)
+	}
+	if err := os.WriteFile(name, []byte("taken\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if broken, err := breakLock(name, read); err != nil || broken {
+		t.Fatalf("breakLock of a lock taken since = %v, %v, want false", broken, err)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "taken\n" {
+		t.Errorf("lock holds %q, %v, want the one taken", b, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("left %d files, want the lock only", len(entries))
+	}
+}
@@ -0,0 +1,104 @@
+package merge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// manifest is the -manifest file: the files a merge wrote and the inputs
+// merged into them.
+type manifest struct {
+	// Schema is the fingerprint of the schema of the output, as the
+	// schema groups of the inputs are fingerprinted.
+	Schema  string           `json:"schema_fingerprint"`
+	Rows    int64            `json:"rows"`
+	Outputs []manifestOutput `json:"outputs"`
+	Inputs  []manifestInput  `json:"inputs"`
+}
+
+type manifestOutput struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Rows      int64  `json:"rows"`
+	RowGroups int    `json:"row_groups"`
+	SHA256    string `json:"sha256"`
+}
+
+type manifestInput struct {
+	Path string `json:"path"`
+	Rows int64  `json:"rows_read"`
+}
+
+// removeManifest removes the manifest of an earlier merge, so that it does
+// not stand for outputs this one is about to replace.
+func (m *Merger) removeManifest() error {
+	if err := os.Remove(m.opts.Manifest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeManifest writes the -manifest of outputs, written with schema from
+// the inputs read, less those skipped as bad files.
+func (m *Merger) writeManifest(schema *parquet.Schema, outputs []string) error {
+	nodes := make(map[string]parquet.Node, len(schema.Fields()))
+	for _, f := range schema.Fields() {
+		nodes[f.Name()] = f
+	}
+	man := manifest{Schema: schemaFingerprint(nodes, nil), Outputs: []manifestOutput{}, Inputs: []manifestInput{}}
+	for _, name := range outputs {
+		out, err := describeOutput(name)
+		if err != nil {
+			return err
+		}
+		man.Rows += out.Rows
+		man.Outputs = append(man.Outputs, out)
+	}
+	skipped := map[string]bool{}
+	for _, f := range m.stats.Skipped {
+		skipped[f.File] = true
+	}
+	for _, f := range m.stats.Files {
+		if skipped[f.File] {
+			continue
+		}
+		man.Inputs = append(man.Inputs, manifestInput{Path: f.File, Rows: f.RowsRead})
+	}
+	b, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(m.opts.Manifest, append(b, '\n'))
+}
+
+// describeOutput reads the size, counts and SHA-256 of the output file
+// name.
+func describeOutput(name string) (manifestOutput, error) {
+	out := manifestOutput{Path: name}
+	pf, closer, err := openParquet(name)
+	if err != nil {
+		return out, err
+	}
+	out.Size = pf.Size()
+	out.Rows = pf.NumRows()
+	out.RowGroups = len(pf.Metadata().RowGroups)
+	closer.Close()
+	f, err := os.Open(name)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return out, err
+	}
+	out.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return out, nil
+}
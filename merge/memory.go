@@ -0,0 +1,53 @@
+package merge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes accepted by ParseSize, longest first so that
+// KB is not read as B.
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a size such as 512MB or 2G.  Units are powers of 1024,
+// and a number without one is a count of bytes.
+func ParseSize(s string) (int64, error) {
+	num, unit := strings.TrimSpace(s), int64(1)
+	upper := strings.ToUpper(num)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			num, unit = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// bufferLimit returns the size at which each of up to writers open writers
+// must flush its row group to keep the rows they buffer to half of a
+// -max-memory budget.
+func bufferLimit(budget int64, writers int) int64 {
+	return max(budget/2/int64(writers), 1)
+}
+
+// batchLimit returns the number of rows of about rowSize bytes to copy at
+// a time to keep a batch to a quarter of a -max-memory budget, and never
+// more than batchSize.
+func batchLimit(budget, rowSize int64, batchSize int) int {
+	if rowSize <= 0 {
+		return batchSize
+	}
+	return int(min(max(budget/4/rowSize, 1), int64(batchSize)))
+}
@@ -0,0 +1,81 @@
+package merge
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// peakHeap runs f and returns the most the heap in use grew by while it
+// ran, sampled every millisecond.  The collector runs often meanwhile, so
+// that the heap in use is about the memory live.
+func peakHeap(f func()) uint64 {
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	base, peak := ms.HeapInuse, ms.HeapInuse
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				var ms runtime.MemStats
+				runtime.ReadMemStats(&ms)
+				peak = max(peak, ms.HeapInuse)
+			}
+		}
+	}()
+	f()
+	close(done)
+	wg.Wait()
+	return peak - base
+}
+
+func TestMaxMemoryStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes and merges a million rows")
+	}
+	dir := t.TempDir()
+	g := parquet.Group{"id": parquet.Int(64), "name": parquet.String(), "value": parquet.Leaf(parquet.DoubleType)}
+	var files []string
+	for f := 0; f < 4; f++ {
+		// Hashes do not compress, so the pages buffered take about the
+		// size of the rows.
+		rows := make([]map[string]any, 250000)
+		for i := range rows {
+			rows[i] = map[string]any{"id": int64(f*len(rows) + i), "name": fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(f, i)))), "value": float64(i)}
+		}
+		files = append(files, filepath.Join(dir, fmt.Sprint(f)+".parquet"))
+		writeParquet(t, files[f], g, rows)
+	}
+	const budget = 4 << 20
+	peaks := map[int64]uint64{}
+	for _, maxMemory := range []int64{0, budget} {
+		opts := testOptions(filepath.Join(dir, "merged.parquet"), files...)
+		opts.MaxMemory = maxMemory
+		peaks[maxMemory] = peakHeap(func() { runMerge(t, opts) })
+	}
+	// Without a budget the million rows are buffered as a single row
+	// group, so the merge is big enough for the budget to matter.
+	if peaks[0] <= 4*budget {
+		t.Fatalf("the heap grew by %d bytes without -max-memory, too little to test it", peaks[0])
+	}
+	if peaks[budget] > 4*budget {
+		t.Errorf("the heap grew by %d bytes with -max-memory %d, more than 4 times the budget", peaks[budget], budget)
+	}
+}
@@ -0,0 +1,1371 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// mergeWriter is the part of parquet.GenericWriter and parquet.SortingWriter
+// used to write the merged rows.
+type mergeWriter interface {
+	WriteRows(rows []parquet.Row) (int, error)
+	Schema() *parquet.Schema
+	Flush() error
+	Close() error
+}
+
+// Merger merges parquet files with different schemas into one.  A Merger
+// is run once.
+type Merger struct {
+	opts    Options
+	rfields [][]requiredField
+	// rowFilter is the parsed Where expression, if any.
+	rowFilter *whereExpr
+	// derived are the parsed Derive expressions.
+	derived []derivedColumn
+	// rowGroups are the parsed RowGroups ranges, by cleaned file name.
+	rowGroups map[string]rowGroupRange
+	// retention, if set, drops the rows older than RetainDays of each run.
+	retention *retention
+	// rowTimes is the range set with After and Before, if any.
+	rowTimes *timeRange
+	// overrides holds the nodes of Options.TypeOverrides, and castNulls
+	// counts the values nulled because they could not be cast to them.
+	overrides map[string]parquet.Node
+	castNulls atomic.Int64
+	// normalized holds the nodes of Options.TimeNormalize, assumeUnit the
+	// unit of its plain INT64 columns, and suspiciousTimes counts the
+	// values written outside plausibleTimes.
+	normalized      map[string]parquet.Node
+	assumeUnit      time.Duration
+	suspiciousTimes atomic.Int64
+	// codec compresses the output, except for the columns in columnCodec.
+	codec       compress.Codec
+	columnCodec map[string]compress.Codec
+	log         *slog.Logger
+	// state is the -state file, and merged the inputs read to the end.
+	state  *mergeState
+	merged []FileStats
+	// appendTo is the existing output with -append, merged first.
+	appendTo string
+	// deletes is the -delete-keys set, if any.
+	deletes *deleteSet
+	// openFiles bounds the input files open at once.
+	openFiles fileBudget
+	// http makes the requests for remote inputs, with the context ctx of
+	// the run or of the merge under way.
+	http  *http.Client
+	ctx   context.Context
+	stats Stats
+}
+
+// New checks opts and returns a Merger for them.
+func New(opts Options) (*Merger, error) {
+	if err := opts.check(); err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	m := &Merger{opts: opts, log: opts.Logger, openFiles: newFileBudget(maxOpenFiles(opts)), http: &http.Client{Timeout: opts.HTTPTimeout}, ctx: context.Background()}
+	// check has parsed RequireFields already.
+	m.rfields, _ = parseRequireFields(opts.RequireFields)
+	if m.log == nil {
+		m.log = slog.Default()
+	}
+	if opts.OnConflict == "fail" {
+		m.opts.Strict = true
+	}
+	if m.opts.OutFile == "" {
+		m.opts.OutFile = "merged.parquet"
+		if opts.PartitionBy != "" {
+			m.opts.OutFile = "merged"
+		}
+	}
+	if (opts.MaxOutputRows > 0 || opts.MaxOutputBytes > 0 || opts.StateFile != "") && opts.PartitionBy == "" {
+		if m.opts.OutputTemplate == "" {
+			m.opts.OutputTemplate = defaultTemplate(m.opts.OutFile)
+		}
+		if err := checkOutputTemplate(m.opts.OutputTemplate); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+	}
+	if opts.Where != "" {
+		var err error
+		if m.rowFilter, err = parseWhere(opts.Where); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+	}
+	if len(opts.Derive) > 0 {
+		var err error
+		if m.derived, err = parseDerives(opts.Derive); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+		if _, ok := opts.Derive[opts.SourceColumn]; ok {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("derive: %s is the source column", opts.SourceColumn))
+		}
+	}
+	if len(opts.RowGroups) > 0 {
+		var err error
+		if m.rowGroups, err = parseRowGroups(opts.RowGroups); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+	}
+	if len(opts.TypeOverrides) > 0 {
+		m.overrides = map[string]parquet.Node{}
+		for k, name := range opts.TypeOverrides {
+			// check has parsed the types already.
+			m.overrides[k], _ = overrideNode(name)
+		}
+	}
+	if len(opts.TimeNormalize) > 0 {
+		m.normalized = map[string]parquet.Node{}
+		for k, unit := range opts.TimeNormalize {
+			// check has parsed the units already.
+			m.normalized[k], _ = normalizeNode(unit)
+		}
+		m.assumeUnit = normalizeUnits[opts.AssumeUnit]
+	}
+	if !opts.After.IsZero() || !opts.Before.IsZero() {
+		m.rowTimes = &timeRange{column: opts.TimeColumn, after: opts.After, before: opts.Before}
+		m.normalizeTimes(m.rowTimes)
+	}
+	var err error
+	if m.codec, err = parseCodec(opts.Compression); err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	if len(opts.ColumnCompression) > 0 {
+		if m.columnCodec, err = parseColumnCodecs(opts.ColumnCompression); err != nil {
+			return nil, markError(ErrInvalidOptions, err)
+		}
+	}
+	return m, nil
+}
+
+// normalizeTimes makes tr read the time column of the files in the units
+// -time-normalize takes them to be in, if it normalizes the column.
+func (m *Merger) normalizeTimes(tr *timeRange) {
+	if _, ok := m.normalized[tr.column]; ok {
+		tr.normalized, tr.assume = true, m.assumeUnit
+	}
+}
+
+// Run finds the input files and merges them or, with Options.Watch, keeps
+// merging new ones until ctx is canceled.
+func (m *Merger) Run(ctx context.Context) (Stats, error) {
+	start := time.Now()
+	m.ctx = ctx
+	var err error
+	if m.locks() {
+		// Taken before the state is read, so that it covers the state too.
+		unlock, err := m.lockOutput()
+		if err != nil {
+			return m.stats, err
+		}
+		defer unlock()
+	}
+	if m.opts.StateFile != "" {
+		if m.state, err = loadState(m.opts.StateFile); err != nil {
+			return m.stats, markError(ErrRead, err)
+		}
+	}
+	if m.opts.DeleteKeys != "" {
+		if m.deletes, err = m.loadDeleteKeys(); err != nil {
+			return m.stats, err
+		}
+		defer m.deletes.Close()
+	}
+	if m.opts.Watch {
+		return m.watch(ctx)
+	}
+	files, err := m.inputFiles()
+	if err == nil && m.opts.Append {
+		files, err = m.appendOutput(files)
+	}
+	if err == nil {
+		err = m.merge(ctx, files)
+	}
+	m.stats.Duration = time.Since(start)
+	if err == nil {
+		m.logSummary()
+	}
+	return m.stats, err
+}
+
+// mergeRun is the state of one merge, handed from phase to phase.
+type mergeRun struct {
+	files []string
+	start time.Time
+
+	// scan sets the files scanned and merges their schemas.  fileNodes
+	// and fileSchema hold the columns and reader schema of each file
+	// merged, shared by the files of a group, and present counts the
+	// files each column is in.
+	scanned      []scannedFile
+	mergedSchema map[string]parquet.Node
+	fieldIDs     map[string]fieldID
+	fileNodes    map[string]map[string]parquet.Node
+	fileSchema   map[string]*parquet.Schema
+	groups       map[string]*schemaGroup
+	present      map[string]int
+	conflicts    *conflictResolver
+	report       *compatReport
+	dry          *plan
+	bad          badFiles
+
+	// buildSchema sets the schemas of the rows: schema is the merged one,
+	// rowSchema the one rows are read as, outSchema the one written and
+	// nestedSchema the one printed.
+	tightened                                  []string
+	schema, rowSchema, outSchema, nestedSchema *parquet.Schema
+	outNodes                                   map[string]parquet.Node
+
+	// compileRows sets what is done to the rows, and the schemas the
+	// writers take rows of and write.
+	defaults      map[string]parquet.Value
+	derived       func(parquet.Row)
+	redact        func(parquet.Row)
+	match         func(parquet.Row) bool
+	inRange       func(parquet.Row) bool
+	pruner        *rowGroupPruner
+	sorting       []parquet.SortingColumn
+	blooms        []parquet.BloomFilterColumn
+	writerSchema  *parquet.Schema
+	writerNodes   map[string]parquet.Node
+	writtenSchema *parquet.Schema
+	shardColumn   int
+
+	// openOutput sets the output and the writer rows are copied to.
+	// interrupted is set when ctx is canceled, so the files closed after
+	// it are marked partial.
+	included    []scannedFile
+	groupBytes  int64
+	interrupted bool
+	transplant  *transplantWriter
+	output      mergeOutput
+	shards      *shardWriter
+	sorter      *externalSorter
+	progressOut io.Writer
+	counted     *rowCounter
+	writer      mergeWriter
+
+	// copyMerged sets the inputs copied.
+	inputs       []inputFile
+	uncompressed int64
+	sampled      *sampler
+	dedup        *deduper
+	prog         *progress
+}
+
+// merge merges files into Options.Output as a single parquet file or, if
+// it is nil, into Options.OutFile.
+func (m *Merger) merge(ctx context.Context, files []string) error {
+	defer func(ctx context.Context) { m.ctx = ctx }(m.ctx)
+	m.ctx = ctx
+	m.stats.FilesScanned = len(files)
+	if m.state != nil {
+		var err error
+		if files, err = m.unmergedFiles(files); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			m.log.Info("no new input files since the last merge", "phase", "find")
+			m.stats.FilesSkipped = m.stats.FilesScanned
+			return nil
+		}
+	}
+	r := &mergeRun{files: files, start: time.Now()}
+	if err := m.scan(r); err != nil {
+		return err
+	}
+	if err := m.buildSchema(r); err != nil {
+		return err
+	}
+	if err := m.compileRows(r); err != nil {
+		return err
+	}
+	if r.dry != nil {
+		r.conflicts.report(m.log.With("phase", "scan"))
+		if r.dry.failed {
+			return errors.New("dry run: some files would fail the merge")
+		}
+		return nil
+	}
+	m.stats.ScanDuration = time.Since(r.start)
+	r.start = time.Now()
+	if err := m.openOutput(r); err != nil {
+		return err
+	}
+	if err := m.copyMerged(ctx, r); err != nil {
+		return err
+	}
+	return m.finish(r)
+}
+
+// scan reads the schemas of r.files, leaves out those that cannot be
+// merged, and merges the schemas of the others.
+func (m *Merger) scan(r *mergeRun) error {
+	rfields := m.rfields
+	scanLog := m.log.With("phase", "scan")
+	r.mergedSchema = map[string]parquet.Node{}
+	mergedFrom := map[string]string{}
+	r.fieldIDs = map[string]fieldID{}
+	r.fileSchema = map[string]*parquet.Schema{}
+	r.fileNodes = map[string]map[string]parquet.Node{}
+	r.present = map[string]int{}
+	if m.opts.DryRun {
+		r.dry = &plan{}
+	}
+	scanned, failed := m.scanSchemas(r.files, m.opts.ScanJobs)
+	r.scanned = scanned
+	failed, empty := emptyFiles(failed)
+	if m.opts.Deterministic {
+		orderFiles(r.scanned, m.opts.Order)
+		orderFiles(failed, "name")
+		orderFiles(empty, "name")
+	}
+	if m.appendTo != "" {
+		appendedFirst(r.scanned, m.appendTo)
+	}
+	if len(failed) > 0 && !m.opts.SkipBadFiles && r.dry == nil {
+		return scanErrors(failed)
+	}
+	if m.opts.ReportFile != "" {
+		r.report = newCompatReport()
+	}
+	m.excludeUnscanned(r, failed, empty)
+	if m.opts.SourceColumn != "" {
+		// The output appended to has the column already.
+		if err := checkProjection(withoutFile(r.scanned, m.appendTo), []string{m.opts.SourceColumn}); err == nil {
+			return markError(ErrInvalidOptions, fmt.Errorf("source column %s is already in an input file", m.opts.SourceColumn))
+		}
+	}
+	for _, d := range m.derived {
+		if err := checkProjection(withoutFile(r.scanned, m.appendTo), []string{d.name}); err == nil {
+			return markError(ErrInvalidOptions, fmt.Errorf("derived column %s is already in an input file", d.name))
+		}
+	}
+	if err := checkRowGroups(m.rowGroups, r.scanned); err != nil {
+		return markError(ErrInvalidOptions, err)
+	}
+	var projection []string
+	if len(m.opts.Columns) > 0 {
+		projection = m.opts.Columns
+		if err := checkProjection(r.scanned, projection); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	var dropped []string
+	if len(m.opts.DropColumns) > 0 {
+		dropped = m.opts.DropColumns
+		for _, name := range dropped {
+			if checkProjection(r.scanned, []string{name}) != nil {
+				scanLog.Warn("dropped column is not in any input file", "column", name)
+			}
+		}
+	}
+	// With -report, a schema mismatch is only fatal once every file has
+	// been looked at.
+	var mismatch error
+	r.conflicts = newConflictResolver(m.opts.OnConflict)
+	for _, sf := range r.scanned {
+		file, nodes := sf.file, sf.nodes
+		r.report.scanned(sf)
+		for _, c := range sf.unsupported {
+			scanLog.Warn("unsupported column", "file", file, "column", c.Column, "action", c.Action, "error", c.Error)
+		}
+		group, reason := matchRequired(nodes, rfields)
+		if group < 0 {
+			r.report.exclude(file, reason, nil)
+			r.report.missing(file, nodes, rfields)
+			if r.dry != nil {
+				r.dry.exclude(file, reason, false)
+				continue
+			}
+			if hasTypedFields(rfields) {
+				scanLog.Info("skipping a file", "file", file, "reason", reason)
+			} else {
+				scanLog.Debug("skipping a file", "file", file, "reason", reason)
+			}
+			m.skipFile(file, "missing required fields", reason)
+			continue
+		}
+		if len(rfields) > 1 {
+			scanLog.Debug("merging a file", "file", file, "group", group+1, "fields", joinFields(rfields[group]))
+		}
+		if dropped != nil {
+			nodes = dropNodes(nodes, dropped)
+		}
+		if projection != nil {
+			nodes = projectNodes(nodes, projection)
+		}
+		if len(nodes) == 0 {
+			r.report.exclude(file, "it has none of the selected columns", nil)
+			if r.dry != nil {
+				r.dry.exclude(file, "it has none of the selected columns", false)
+				continue
+			}
+			scanLog.Debug("skipping a file", "file", file, "reason", "it has none of the selected columns")
+			m.skipFile(file, "no selected columns", "")
+			continue
+		}
+		merging, err := overriddenNodes(stringifiedNodes(nodes, sf.unsupported), m.overrides)
+		if err == nil {
+			merging, err = normalizedNodes(merging, m.normalized, m.assumeUnit)
+		}
+		if err != nil {
+			return markError(ErrInvalidOptions, fmt.Errorf("%s: %w", file, err))
+		}
+		changed, err := m.mergeFileNodes(r.mergedSchema, mergedFrom, r.fieldIDs, file, r.conflicts.unresolved(merging))
+		var conflict *mismatchError
+		if errors.As(err, &conflict) && r.conflicts.resolves() {
+			r.conflicts.resolve(conflict, r.mergedSchema, mergedFrom)
+			changed, err = m.mergeFileNodes(r.mergedSchema, mergedFrom, r.fieldIDs, file, r.conflicts.unresolved(merging))
+		}
+		if errors.As(err, &conflict) && m.opts.OnConflict == "skip-file" {
+			r.conflicts.skipFile(conflict)
+			r.report.exclude(file, err.Error(), err)
+			if r.dry != nil {
+				r.dry.exclude(file, err.Error(), false)
+				continue
+			}
+			scanLog.Info("skipping a file", "file", file, "error", err)
+			m.skipFile(file, "schema conflict", err.Error())
+			continue
+		}
+		if err != nil {
+			r.report.exclude(file, err.Error(), err)
+			if r.dry != nil {
+				r.dry.exclude(file, err.Error(), true)
+				continue
+			}
+			if r.report == nil {
+				return markError(ErrSchemaConflict, err)
+			}
+			if mismatch == nil {
+				mismatch = err
+			}
+			continue
+		}
+		for k, v := range changed {
+			r.mergedSchema[k] = v
+			mergedFrom[k] = file
+		}
+		recordFieldIDs(r.fieldIDs, file, r.conflicts.unresolved(merging))
+		for k := range nodes {
+			r.present[k]++
+		}
+		r.fileNodes[file] = nodes
+		r.report.include(file)
+		if r.dry != nil {
+			r.dry.include(sf)
+		}
+	}
+	skipped := r.conflicts.skipped()
+	for _, k := range skipped {
+		delete(r.present, k)
+	}
+	r.groupFiles(skipped)
+	scanLog.Debug("grouped files by schema", "files", len(r.fileNodes), "schemas", len(r.groups))
+	if r.report != nil {
+		var included []scannedFile
+		for _, sf := range r.scanned {
+			if _, ok := r.fileNodes[sf.file]; ok {
+				included = append(included, sf)
+			}
+		}
+		r.report.provenance(parquet.NewSchema("merged", parquet.Group(r.mergedSchema)), included)
+		if err := r.report.write(m.opts.ReportFile); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing report: %w", err))
+		}
+	}
+	if mismatch != nil {
+		return markError(ErrSchemaConflict, mismatch)
+	}
+	if _, ok := r.fileNodes[m.appendTo]; m.appendTo != "" && !ok && r.dry == nil {
+		return markError(ErrSchemaConflict, m.appendError())
+	}
+	if len(r.fileNodes) == 0 && r.dry == nil {
+		// There is no schema to write even an empty file with.
+		m.stats.FilesSkipped = m.stats.FilesScanned
+		return markError(ErrNoInputs, fmt.Errorf("none of the %d input files can be merged", len(r.files)))
+	}
+	return nil
+}
+
+// excludeUnscanned skips the empty files and those that could not be
+// scanned.
+func (m *Merger) excludeUnscanned(r *mergeRun, failed, empty []scannedFile) {
+	scanLog := m.log.With("phase", "scan")
+	for _, sf := range empty {
+		// A zero-byte file holds nothing to merge, so it is always skipped.
+		r.report.exclude(sf.file, "it is empty (zero bytes)", nil)
+		if r.dry != nil {
+			r.dry.exclude(sf.file, "it is empty (zero bytes)", false)
+			continue
+		}
+		scanLog.Warn("skipping an empty file", "file", sf.file)
+		m.skipFile(sf.file, "empty file", "")
+	}
+	for _, sf := range failed {
+		// Scan errors already name the file.
+		err := sf.err
+		var kind *kindError
+		if errors.As(err, &kind) {
+			err = kind.err
+		}
+		if inner := errors.Unwrap(err); inner != nil {
+			err = inner
+		}
+		r.report.exclude(sf.file, err.Error(), nil)
+		if r.dry != nil {
+			r.dry.exclude(sf.file, err.Error(), !m.opts.SkipBadFiles)
+			continue
+		}
+		r.bad.skip(scanLog, sf.file, err, 0)
+		m.skipFile(sf.file, "bad file", err.Error())
+	}
+}
+
+// groupFiles puts files with the same schema in one group, so that many
+// files with few schemas do not need a reader schema and conversions each.
+func (r *mergeRun) groupFiles(skipped []string) {
+	r.groups = map[string]*schemaGroup{}
+	for _, sf := range r.scanned {
+		nodes, ok := r.fileNodes[sf.file]
+		if !ok {
+			continue
+		}
+		g, ok := r.groups[sf.fingerprint]
+		if !ok {
+			if skipped != nil {
+				nodes = dropNodes(nodes, skipped)
+			}
+			g = &schemaGroup{nodes: nodes, schema: parquet.NewSchema(sf.fingerprint, plainNode(parquet.Group(originalNames(unflattenNodes(nodes, sf.flattened), sf.renamed))))}
+			r.groups[sf.fingerprint] = g
+		}
+		r.fileNodes[sf.file] = g.nodes
+		r.fileSchema[sf.file] = g.schema
+	}
+}
+
+// buildSchema settles the merged schema and the schemas rows are read,
+// written and printed with.
+func (m *Merger) buildSchema(r *mergeRun) error {
+	scanLog := m.log.With("phase", "scan")
+	// A column can only stay required if every merged file has it.
+	for k, n := range r.present {
+		if n < len(r.fileNodes) && r.mergedSchema[k].Required() {
+			r.mergedSchema[k] = parquet.Optional(r.mergedSchema[k])
+		}
+	}
+	if m.opts.TightenNullability {
+		r.tightened = m.tightenedColumns(r.scanned, r.fileNodes, r.mergedSchema)
+		for _, k := range r.tightened {
+			r.mergedSchema[k] = parquet.Required(r.mergedSchema[k])
+		}
+		if len(r.tightened) > 0 {
+			scanLog.Info("made columns required, the statistics of every file show no nulls in them", "columns", r.tightened)
+		}
+	}
+	if m.opts.UTF8 == "binary" {
+		invalid, err := m.invalidStrings(r.scanned, r.fileNodes)
+		if err != nil {
+			return err
+		}
+		for _, path := range sortedStrings(invalid) {
+			k, _, _ := strings.Cut(path, ".")
+			r.mergedSchema[k] = binaryStrings(r.mergedSchema[k], k, invalid)
+			scanLog.Warn("writing a STRING column as BYTE_ARRAY, it holds invalid UTF-8", "column", path, "file", invalid[path])
+		}
+	}
+	if len(r.fieldIDs) > 0 {
+		for k, node := range r.mergedSchema {
+			// Stringified columns are new ones, whose fields have no IDs.
+			if _, ok := r.conflicts.resolved[k]; !ok {
+				r.mergedSchema[k] = withFieldIDs(node, k, r.fieldIDs)
+			}
+		}
+	}
+	if m.opts.SourceColumn != "" {
+		// There are as many values as files, so a dictionary holds them well.
+		r.mergedSchema[m.opts.SourceColumn] = parquet.Encoded(parquet.Optional(parquet.String()), &parquet.RLEDictionary)
+	}
+	if len(m.derived) > 0 {
+		// The types of the derived columns follow from those of the
+		// columns they are computed from.
+		nodes, err := derivedNodes(m.derived, parquet.NewSchema("merged", parquet.Group(r.mergedSchema)))
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+		for k, node := range nodes {
+			r.mergedSchema[k] = node
+		}
+	}
+	r.outNodes = r.mergedSchema
+	if m.columnCodec != nil {
+		var err error
+		if r.outNodes, err = compressColumns(r.mergedSchema, m.columnCodec); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	r.schema = parquet.NewSchema("merged", parquet.Group(r.outNodes))
+	r.rowSchema = parquet.NewSchema("merged", plainNode(parquet.Group(r.mergedSchema)))
+	// outSchema is the schema of the files written, without the columns
+	// -redact drops, which rows keep until they are written so that they
+	// can still be filtered on.
+	r.outSchema = r.schema
+	if dropped := redactDropped(m.opts.Redact); len(dropped) > 0 {
+		nodes := map[string]parquet.Node{}
+		for k, v := range r.outNodes {
+			if !slices.Contains(dropped, k) {
+				nodes[k] = v
+			}
+		}
+		r.outNodes = nodes
+		r.outSchema = parquet.NewSchema("merged", parquet.Group(r.outNodes))
+	}
+	// nestedSchema is outSchema with the columns nested by dots, as the
+	// files are written with -nest-by-dots.
+	r.nestedSchema = r.outSchema
+	if m.opts.NestByDots {
+		nodes, err := nestByDots(r.outNodes)
+		if err != nil {
+			return markError(ErrSchemaConflict, err)
+		}
+		r.nestedSchema = parquet.NewSchema("merged", parquet.Group(nodes))
+	}
+	if r.dry != nil {
+		w := m.opts.DryRunOutput
+		if w == nil {
+			w = os.Stdout
+		}
+		r.dry.print(w, r.nestedSchema)
+	}
+	return nil
+}
+
+// compileRows compiles what the options do to the rows of the merged
+// schema: filters, defaults, derived and redacted columns, and sorting.
+func (m *Merger) compileRows(r *mergeRun) error {
+	if m.opts.SortedBy != "" {
+		if err := checkSortKey(r.mergedSchema, m.opts.SortedBy); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	var err error
+	r.defaults, err = compileDefaults(r.rowSchema, m.opts.Defaults)
+	if err != nil {
+		return markError(ErrInvalidOptions, err)
+	}
+	if len(m.derived) > 0 {
+		if r.derived, err = deriveColumns(m.derived, r.schema); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	r.redact, err = redactValues(r.schema, m.opts.Redact, m.opts.RedactSalt)
+	if err != nil {
+		return markError(ErrInvalidOptions, err)
+	}
+	if m.rowFilter != nil {
+		r.match, err = m.rowFilter.compile(r.schema)
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	if m.deletes != nil {
+		columns, err := keyColumns(r.schema, []string{m.opts.DeleteKeyColumn})
+		if err != nil {
+			return markError(ErrInvalidOptions, fmt.Errorf("delete key column: %w", err))
+		}
+		m.deletes.column = columns[0]
+	}
+	if m.rowTimes != nil {
+		r.inRange, err = m.rowTimes.match(r.schema)
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	m.retention = nil
+	if m.opts.RetainDays > 0 {
+		m.retention = newRetention(m.opts.TimeColumn, m.opts.RetainDays, time.Now())
+		m.normalizeTimes(m.retention.times)
+		if err := m.retention.compile(r.schema); err != nil {
+			return markError(ErrInvalidOptions, fmt.Errorf("retain-days: %w", err))
+		}
+	}
+	if m.rowFilter != nil || m.rowTimes != nil || m.retention != nil {
+		r.pruner = &rowGroupPruner{where: m.rowFilter, schema: r.schema, times: m.rowTimes, retention: m.retention}
+	}
+	if m.opts.SortBy != "" {
+		r.sorting, err = parseSortColumns(m.opts.SortBy, m.opts.SortNulls, r.mergedSchema)
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+		for _, c := range r.sorting {
+			if m.opts.Redact[c.Path()[0]] == "drop" {
+				return markError(ErrInvalidOptions, fmt.Errorf("redact cannot drop %s, a sortby column", c.Path()[0]))
+			}
+		}
+	}
+	if len(m.opts.BloomColumns) > 0 {
+		r.blooms, err = bloomFilters(r.nestedSchema, m.opts.BloomColumns, m.opts.BloomBits)
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	r.writerSchema, r.writerNodes = r.outSchema, r.outNodes
+	if m.opts.PartitionBy != "" {
+		if err := checkPartitionColumn(r.mergedSchema, m.opts.PartitionBy); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+		if m.opts.DropPartitionColumn {
+			if err := checkDroppedPartition(m.opts.PartitionBy, m.opts.SortedBy, r.sorting, r.blooms); err != nil {
+				return markError(ErrInvalidOptions, err)
+			}
+			nodes := map[string]parquet.Node{}
+			for k, v := range r.outNodes {
+				if k != m.opts.PartitionBy {
+					nodes[k] = v
+				}
+			}
+			r.writerSchema, r.writerNodes = parquet.NewSchema("merged", parquet.Group(nodes)), nodes
+		}
+	}
+	// The writers take rows of writerSchema and write them as
+	// writtenSchema, nested by dots with -nest-by-dots.
+	r.writtenSchema = r.writerSchema
+	if m.opts.NestByDots {
+		nodes, err := nestByDots(r.writerNodes)
+		if err != nil {
+			return markError(ErrSchemaConflict, err)
+		}
+		r.writtenSchema = parquet.NewSchema("merged", parquet.Group(nodes))
+	}
+	r.shardColumn = 0
+	if m.opts.ShardBy != "" {
+		if r.shardColumn, err = checkShardColumn(r.outSchema, m.opts.ShardBy); err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+	}
+	return nil
+}
+
+// openOutput configures the writers and opens the output they write.
+func (m *Merger) openOutput(r *mergeRun) error {
+	if m.opts.Manifest != "" {
+		if err := m.removeManifest(); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error removing manifest: %w", err))
+		}
+	}
+
+	options := []parquet.WriterOption{
+		r.writtenSchema,
+		parquet.Compression(m.codec),
+		parquet.PageBufferSize(m.opts.PageBufferSize),
+		parquet.WriteBufferSize(m.opts.WriteBufferSize),
+	}
+	if len(r.blooms) > 0 {
+		options = append(options, parquet.BloomFilters(r.blooms...))
+	}
+	for _, sf := range r.scanned {
+		if _, ok := r.fileSchema[sf.file]; ok {
+			r.included = append(r.included, sf)
+		}
+	}
+	now := time.Now()
+	if m.opts.Reproducible {
+		now = time.Time{}
+	}
+	metadata := mergeKeyValues(r.included, m.opts.KVConflict, now)
+	for _, k := range sortedStrings(metadata) {
+		options = append(options, parquet.KeyValueMetadata(k, metadata[k]))
+	}
+	if m.opts.PageBufferPool == "file" {
+		options = append(options, parquet.ColumnPageBuffers(parquet.NewFileBufferPool(os.TempDir(), "merger-pages.*")))
+	}
+	if m.opts.SortedBy != "" {
+		sortedBy := []parquet.SortingColumn{parquet.Ascending(m.opts.SortedBy)}
+		if m.opts.NestByDots {
+			sortedBy = nestedSorting(sortedBy)
+		}
+		options = append(options, parquet.SortingWriterConfig(parquet.SortingColumns(sortedBy...)))
+	}
+	if len(r.sorting) > 0 {
+		written := r.sorting
+		if m.opts.NestByDots {
+			written = nestedSorting(r.sorting)
+		}
+		options = append(options, parquet.SortingWriterConfig(parquet.SortingColumns(written...)))
+	}
+	wc, err := parquet.NewWriterConfig(options...)
+	if err != nil {
+		return markError(ErrInvalidOptions, fmt.Errorf("error creating writer config: %w", err))
+	}
+	if m.opts.Reproducible {
+		// parquet.CreatedBy would add a version and build.
+		wc.CreatedBy = reproducibleCreatedBy
+	}
+	r.groupBytes = m.opts.RowGroupBytes
+	if m.opts.MaxMemory > 0 {
+		writers := 1
+		if m.opts.PartitionBy != "" {
+			writers = m.opts.MaxOpenWriters
+		}
+		if m.opts.ShardBy != "" {
+			writers = m.opts.Shards
+		}
+		if limit := bufferLimit(m.opts.MaxMemory, writers); r.groupBytes == 0 || limit < r.groupBytes {
+			r.groupBytes = limit
+		}
+	}
+	nest := func(w mergeWriter) mergeWriter {
+		if r.writtenSchema == r.writerSchema {
+			return w
+		}
+		return newColumnNester(w, r.writerSchema)
+	}
+	newWriter := func(out io.Writer) mergeWriter {
+		var writer mergeWriter
+		if len(r.sorting) > 0 && !m.opts.SortExternal {
+			w := parquet.NewSortingWriter[map[string]any](out, m.opts.SortBufferRows, wc)
+			writer = &partialWriter{mergeWriter: nest(w), kv: w, interrupted: &r.interrupted}
+		} else {
+			// WriterConfig.ConfigureWriter does not copy MaxRowsPerRowGroup,
+			// so it has to be passed on its own.
+			writerOptions := []parquet.WriterOption{wc}
+			if m.opts.RowGroupRows > 0 {
+				writerOptions = append(writerOptions, parquet.MaxRowsPerRowGroup(m.opts.RowGroupRows))
+			}
+			w := parquet.NewGenericWriter[map[string]any](out, writerOptions...)
+			writer = &partialWriter{mergeWriter: nest(w), kv: w, interrupted: &r.interrupted}
+		}
+		if r.groupBytes > 0 {
+			writer = &rowGroupWriter{mergeWriter: writer, maxRows: m.opts.RowGroupRows, maxBytes: r.groupBytes}
+		}
+		return writer
+	}
+	if m.transplants() {
+		if r.transplant, err = m.newTransplantWriter(r.included, newWriter, &r.interrupted); err != nil {
+			return markError(ErrWrite, err)
+		}
+	}
+	r.progressOut = io.Writer(os.Stdout)
+	if m.opts.Output != nil {
+		r.output = newStreamWriter(m.opts.Output, newWriter)
+		r.progressOut = os.Stderr
+	} else if m.opts.Bench {
+		r.output = newStreamWriter(io.Discard, newWriter)
+	} else if m.opts.PartitionBy != "" {
+		r.output = newPartitionWriter(m.opts.OutFile, m.opts.PartitionBy, r.outSchema, m.opts.DropPartitionColumn, m.opts.MaxOpenWriters, m.opts.MaxOutputRows, m.opts.MaxOutputBytes, newWriter)
+	} else if m.opts.ShardBy != "" {
+		r.shards, err = newShardWriter(m.opts.OutFile, m.opts.Shards, r.shardColumn, r.outSchema, newWriter)
+		if err != nil {
+			return markError(ErrWrite, err)
+		}
+		r.output = r.shards
+	} else if r.transplant != nil {
+		r.output = r.transplant
+	} else {
+		outfile, first := m.opts.OutFile, 1
+		if m.state != nil && len(m.state.Outputs) > 0 {
+			// Later runs add files to the series instead of replacing
+			// the first.
+			outfile, first = "", len(m.state.Outputs)+1
+		}
+		r.output, err = newOutputWriter(outfile, m.opts.OutputTemplate, first, m.opts.MaxOutputRows, m.opts.MaxOutputBytes, newWriter)
+		if err != nil {
+			return markError(ErrWrite, err)
+		}
+	}
+	if m.opts.SortExternal {
+		r.sorter = newExternalSorter(r.output, r.sorting, m.opts.SortTempDir, m.opts.SortBufferRows, m.opts.BatchSize)
+		r.output = r.sorter
+	}
+	if r.outSchema != r.schema {
+		r.output = newColumnDropper(r.output, r.schema, r.outSchema)
+	}
+	if m.opts.ProgressOutput != nil {
+		r.progressOut = m.opts.ProgressOutput
+	}
+	r.counted = &rowCounter{mergeWriter: r.output}
+	r.writer = r.counted
+	var limited *limitWriter
+	if m.opts.Limit > 0 || m.opts.Offset > 0 {
+		limited = &limitWriter{mergeWriter: r.writer, offset: m.opts.Offset, limit: m.opts.Limit}
+		r.writer = limited
+	}
+	return nil
+}
+
+// stop returns err, removing the unfinished output of r, or ends the merge
+// as interrupted if ctx was canceled.
+func (m *Merger) stop(ctx context.Context, r *mergeRun, err error) error {
+	if cause := ctx.Err(); cause != nil {
+		return m.interrupt(r.output, r.writer, r.counted, &r.interrupted, cause)
+	}
+	if names := r.output.names(); len(names) > 0 {
+		m.log.Warn("merge failed, removed the unfinished output", "phase", "copy", "files", names)
+	}
+	r.output.discard()
+	return err
+}
+
+// buildInputs sets up the reading of every merged file and returns the
+// number of rows in them.
+func (m *Merger) buildInputs(r *mergeRun) int64 {
+	var totalRows int64
+	bounds := timeBounds(r.rowSchema, m.opts.TimeNormalize)
+	for _, sf := range r.scanned {
+		schema, ok := r.fileSchema[sf.file]
+		if !ok {
+			continue
+		}
+		totalRows += sf.rows
+		m.stats.InputBytes += sf.size
+		r.uncompressed += sf.uncompressed
+		g := r.groups[sf.fingerprint]
+		if g.coerce == nil {
+			g.coerce = map[string]conversion{}
+			for k, v := range g.nodes {
+				// Required columns merged as optional need no conversion:
+				// their values are copied as non-null ones.  Nor do
+				// optional ones made required, once checked for nulls.
+				target := r.mergedSchema[k]
+				if slices.Contains(r.tightened, k) && v.Optional() {
+					g.tightened = append(g.tightened, k)
+					target = parquet.Optional(target)
+				}
+				if !relaxes(v, target, true) {
+					_, cast := m.overrides[k]
+					c := conversion{from: v, to: target, cast: cast}
+					if _, ok := m.normalized[k]; ok {
+						c.unit, _ = normalizeFrom(v, m.assumeUnit)
+					}
+					g.coerce[k] = c
+				}
+			}
+			g.defaults = missingDefaults(r.defaults, g.nodes)
+			if m.opts.UTF8 == "reject" || m.opts.UTF8 == "replace" {
+				g.utf8 = utf8Columns(g.nodes, r.rowSchema)
+			}
+		}
+		input := inputFile{file: sf.file, rows: sf.rows, schema: schema, coerce: g.coerce, renamed: sf.renamed, defaults: g.defaults, utf8: g.utf8}
+		input.flattened = sf.flattened
+		input.tightened = g.tightened
+		input.timeBounds = bounds
+		input.transplant = r.transplant != nil && r.transplant.files[sf.file]
+		if uncounted(sf) {
+			input.nonNull = make([]int64, len(r.rowSchema.Columns()))
+		}
+		if sf.file == m.appendTo {
+			// The rows already merged are copied as they are.
+			r.inputs = append(r.inputs, input)
+			continue
+		}
+		if r.match != nil || r.inRange != nil {
+			input.keep = func(row parquet.Row, _ int64) (bool, error) {
+				return (r.match == nil || r.match(row)) && (r.inRange == nil || r.inRange(row)), nil
+			}
+		}
+		if r.pruner != nil {
+			input.skip = r.pruner.skipper(sf.file, sf.renamed)
+		}
+		if rg, ok := m.rowGroups[filepath.Clean(sf.file)]; ok {
+			input.skip = rg.skipper(input.skip)
+		}
+		if m.opts.SourceColumn != "" {
+			input.source = m.sourcePath(sf.file)
+		}
+		input.derived = r.derived
+		input.redact = r.redact
+		r.inputs = append(r.inputs, input)
+	}
+	return totalRows
+}
+
+// copyMerged copies the rows of the included files to the output, as
+// filtered, sampled and deduplicated.
+func (m *Merger) copyMerged(ctx context.Context, r *mergeRun) error {
+	copyLog := m.log.With("phase", "copy")
+	totalRows := m.buildInputs(r)
+	if m.retention != nil {
+		// The rows appended to expire too.
+		for i := range r.inputs {
+			r.inputs[i].keep = m.retention.keep(r.inputs[i].file, r.inputs[i].keep)
+		}
+	}
+	if m.deletes != nil {
+		// The rows appended to are checked too.
+		for i := range r.inputs {
+			r.inputs[i].keep = m.deletes.keep(r.inputs[i].file, r.inputs[i].keep)
+		}
+	}
+	fresh := r.inputs
+	if m.appendTo != "" {
+		fresh = r.inputs[1:]
+	}
+	if m.opts.Sample > 0 || m.opts.SamplePerFile > 0 {
+		if m.opts.Seed == 0 {
+			m.opts.Seed = time.Now().UnixNano()
+		}
+		r.sampled = newSampler(m.opts.Seed)
+		if m.opts.SamplePerFile > 0 {
+			if err := r.sampled.perFile(ctx, m, fresh, r.writer.Schema(), r.rowSchema, m.opts.SamplePerFile); err != nil {
+				return m.stop(ctx, r, err)
+			}
+		} else {
+			for i := range fresh {
+				fresh[i].keep = r.sampled.fraction(fresh[i].file, m.opts.Sample, fresh[i].keep)
+			}
+		}
+	}
+	if len(m.opts.DedupKeys) > 0 {
+		var err error
+		r.dedup, err = newDeduper(r.writer.Schema(), m.opts.DedupKeys, m.opts.DedupMaxKeys)
+		if err != nil {
+			return markError(ErrInvalidOptions, err)
+		}
+		defer r.dedup.Close()
+		if m.opts.DedupPreferLatest {
+			if err := r.dedup.latest(ctx, m, r.inputs, r.writer.Schema(), r.rowSchema, m.opts.DedupTimeColumn); err != nil {
+				return m.stop(ctx, r, err)
+			}
+		} else {
+			for i := range r.inputs {
+				r.inputs[i].keep = r.dedup.first(r.inputs[i].file, r.inputs[i].keep)
+			}
+		}
+	}
+	if m.opts.MaxMemory > 0 && totalRows > 0 {
+		m.opts.BatchSize = batchLimit(m.opts.MaxMemory, r.uncompressed/totalRows, m.opts.BatchSize)
+		copyLog.Debug("bounding memory", "max_memory", m.opts.MaxMemory, "row_group_bytes", r.groupBytes, "batch_size", m.opts.BatchSize)
+	}
+	// Sampling and dedup read the inputs once already, and only the copy
+	// is counted.
+	if m.deletes != nil {
+		clear(m.deletes.deleted)
+	}
+	if m.retention != nil {
+		m.retention.reset()
+	}
+	if r.sampled != nil {
+		r.sampled.reset()
+	}
+	for _, input := range r.inputs {
+		clear(input.nonNull)
+	}
+	m.suspiciousTimes.Store(0)
+	r.prog = m.newProgress(len(r.inputs), totalRows, r.output.written, r.progressOut)
+	if m.opts.SortedBy != "" {
+		r.prog.startFile(len(r.inputs))
+		err := m.mergeSorted(ctx, progressWriter{r.writer, r.prog}, r.inputs, r.rowSchema, r.fileNodes)
+		if errors.Is(err, errLimit) {
+			copyLog.Info("stopped early at the row limit", "limit", m.opts.Limit)
+		} else if err != nil {
+			return m.stop(ctx, r, err)
+		}
+	} else {
+		before := len(r.bad)
+		if err := m.copyInputs(ctx, r.writer, r.inputs, r.rowSchema, r.prog, &r.bad); err != nil {
+			return m.stop(ctx, r, err)
+		}
+		for _, b := range r.bad[before:] {
+			if b.copied == 0 {
+				m.stats.FilesIncluded--
+				m.skipFile(b.file, "bad file", b.err.Error())
+				for _, sf := range r.scanned {
+					if sf.file == b.file {
+						m.stats.InputBytes -= sf.size
+					}
+				}
+			}
+		}
+		// The files left for a later run are neither merged nor skipped.
+		for _, sf := range r.scanned {
+			if slices.Contains(m.stats.Leftover, sf.file) {
+				m.stats.FilesIncluded--
+				m.stats.InputBytes -= sf.size
+			}
+		}
+	}
+	m.stats.FilesIncluded += len(r.inputs)
+	m.stats.FilesSkipped = m.stats.FilesScanned - m.stats.FilesIncluded - len(m.stats.Leftover)
+	// Too many bad files fail the run before the output is finished, so
+	// that neither it nor the state, reports and manifest are left behind.
+	if err := r.bad.report(copyLog, m.opts.MaxBadFiles); err != nil {
+		return m.stop(ctx, r, err)
+	}
+	return nil
+}
+
+// finish closes the output and writes what goes with it, then records
+// the stats of the merge and logs it.
+func (m *Merger) finish(r *mergeRun) error {
+	scanLog := m.log.With("phase", "scan")
+	copyLog := m.log.With("phase", "copy")
+	writeLog := m.log.With("phase", "write")
+	if err := r.writer.Close(); err != nil {
+		return markError(ErrWrite, fmt.Errorf("error closing writer: %w", err))
+	}
+	m.stats.CopyDuration = time.Since(r.start)
+	if m.opts.Verify {
+		if err := m.verifyOutput(r.output.names(), r.counted.rows); err != nil {
+			quarantine(m.log.With("phase", "verify"), r.output.names())
+			return markError(ErrVerify, fmt.Errorf("verification failed, output renamed to .bad: %w", err))
+		}
+		m.log.Info("verified the output", "phase", "verify", "rows", r.counted.rows, "files", len(r.output.names()))
+	}
+	if m.opts.SchemaOut != "" {
+		// The output is in place, so the schema never describes a file
+		// that was not written.
+		if err := m.writeSchemaOut(r.writtenSchema); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing schema: %w", err))
+		}
+	}
+	if m.state != nil {
+		m.state.record(r.output.names(), m.merged, r.scanned)
+		if err := m.state.write(m.opts.StateFile); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing state: %w", err))
+		}
+	}
+	if r.report != nil {
+		for _, input := range r.inputs {
+			if input.nonNull != nil {
+				r.report.copied(input.file, r.rowSchema, input.nonNull)
+			}
+		}
+		r.report.CopyCounted = true
+		if err := r.report.write(m.opts.ReportFile); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing report: %w", err))
+		}
+	}
+	if m.opts.RetentionReport != "" {
+		if err := m.retention.write(m.opts.RetentionReport, inputFiles(r.inputs)); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing retention report: %w", err))
+		}
+	}
+	if m.opts.Leftover != "" {
+		if err := writeLeftover(m.opts.Leftover, m.stats.Leftover); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing leftover list: %w", err))
+		}
+	}
+	if m.opts.Manifest != "" {
+		// Written last, so that it only exists once everything else is.
+		if err := m.writeManifest(r.writtenSchema, r.output.names()); err != nil {
+			return markError(ErrWrite, fmt.Errorf("error writing manifest: %w", err))
+		}
+	}
+	m.stats.RowsWritten = r.counted.rows
+	m.stats.BytesWritten = r.output.written()
+	m.stats.OutputFiles = r.output.names()
+	m.stats.CastNulls = m.castNulls.Load()
+	m.stats.SuspiciousTimes = m.suspiciousTimes.Load()
+	m.stats.Redacted = m.opts.Redact
+	if r.shards != nil {
+		m.stats.ShardRows = r.shards.rows()
+	}
+	if r.sorter != nil {
+		m.stats.SortRuns, m.stats.SortTempBytes = len(r.sorter.runs), r.sorter.runBytes
+	}
+	if r.transplant != nil {
+		m.stats.RowGroupsTransplanted, m.stats.RowGroupsReencoded = r.transplant.transplanted, r.transplant.reencoded
+	}
+	r.prog.finish()
+	r.output.report(writeLog)
+	if names := r.output.names(); len(names) > 0 {
+		m.stats.RowGroups = reportOutput(writeLog, names...)
+	}
+	if r.uncompressed > 0 {
+		writeLog.Info("wrote the output", "bytes", r.output.written(), "compression", strings.ToLower(m.opts.Compression),
+			"ratio", float64(r.output.written())/float64(r.uncompressed), "uncompressed_input_bytes", r.uncompressed)
+	}
+	r.conflicts.report(scanLog)
+	if r.sampled != nil {
+		r.sampled.report(copyLog)
+	}
+	if r.dedup != nil {
+		r.dedup.report(copyLog, r.inputs)
+	}
+	if r.pruner != nil {
+		r.pruner.report(copyLog)
+	}
+	if m.retention != nil {
+		m.retention.log(copyLog, inputFiles(r.inputs))
+	}
+	return nil
+}
+
+// addedColumns returns the output columns computed rather than read: the
+// -source-column and the -derive columns.
+func (m *Merger) addedColumns() []string {
+	added := []string{m.opts.SourceColumn}
+	for _, d := range m.derived {
+		added = append(added, d.name)
+	}
+	return added
+}
+
+// sourcePath returns the path of file written to -source-column: relative
+// to -sourcedir if the file is under it, and as given otherwise.
+func (m *Merger) sourcePath(file string) string {
+	if m.opts.SourceDir != "" {
+		if rel, err := filepath.Rel(m.opts.SourceDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return file
+}
+
+// copyInputs copies the rows of inputs to writer in order, reading up to
+// -prefetch files ahead, or transplants their row groups.  Files that
+// cannot be read are recorded in bad with -skip-bad-files.
+func (m *Merger) copyInputs(ctx context.Context, writer mergeWriter, inputs []inputFile, rowSchema *parquet.Schema, prog *progress, bad *badFiles) error {
+	var decoded []inputFile
+	for _, input := range inputs {
+		if !input.transplant {
+			decoded = append(decoded, input)
+		}
+	}
+	fetch := m.startPrefetch(decoded, writer.Schema(), rowSchema)
+	defer fetch.Close()
+	passed := false
+	for i, input := range inputs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if target := m.opts.TargetOutputBytes; target > 0 && !passed {
+			if size := prog.estimatedBytes(); size >= target {
+				passed = true
+				if m.opts.StopAtBudget {
+					m.stats.Leftover = inputFiles(inputs[i:])
+					m.log.Info("stopped at the output budget", "phase", "copy", "target_bytes", target, "estimated_bytes", size, "files_left", len(inputs)-i)
+					return nil
+				}
+				m.log.Warn("the output passed its target size", "phase", "copy", "target_bytes", target, "estimated_bytes", size, "file", input.file)
+			}
+		}
+		prog.startFile(i + 1)
+		if input.transplant {
+			copied, err := progressWriter{writer, prog}.copyRowGroups(input)
+			if err == nil || copied > 0 {
+				m.countRows(input.file, copied, input.rows, err == nil)
+			}
+			var rerr *readError
+			if err != nil && m.opts.SkipBadFiles && errors.As(err, &rerr) {
+				bad.skip(m.log.With("phase", "copy"), input.file, err, copied)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error copying %s: %w", input.file, err)
+			}
+			continue
+		}
+		rows, err := fetch.next()
+		if err != nil {
+			if m.opts.SkipBadFiles && input.file != m.appendTo {
+				bad.skip(m.log.With("phase", "copy"), input.file, err, 0)
+				continue
+			}
+			return fmt.Errorf("error copying %s: %w", input.file, err)
+		}
+		copied, err := m.copyRows(ctx, progressWriter{writer, prog}, rows)
+		rows.Close()
+		if read, footer := rows.counts(); err == nil || errors.Is(err, errLimit) || copied > 0 {
+			m.countRows(input.file, read, footer, err == nil)
+		}
+		if errors.Is(err, errLimit) {
+			m.log.Info("stopped early at the row limit", "phase", "copy", "limit", m.opts.Limit, "file", input.file, "rows", copied)
+			return nil
+		}
+		if err != nil {
+			var rerr *readError
+			if m.opts.SkipBadFiles && errors.As(err, &rerr) && !errors.Is(err, errTightenedNull) && input.file != m.appendTo {
+				bad.skip(m.log.With("phase", "copy"), input.file, err, copied)
+				continue
+			}
+			return fmt.Errorf("error copying %s: %w", input.file, err)
+		}
+	}
+	return nil
+}
+
+// mergeFileNodes merges the nodes of file into mergedSchema, whose columns
+// came from the files in mergedFrom and have the field IDs in ids, and
+// returns the columns that change.  mergedSchema itself is left alone, so
+// a file that conflicts can be left out.
+func (m *Merger) mergeFileNodes(mergedSchema map[string]parquet.Node, mergedFrom map[string]string, ids map[string]fieldID, file string, nodes map[string]parquet.Node) (map[string]parquet.Node, error) {
+	changed := map[string]parquet.Node{}
+	mismatch := &mismatchError{file: file}
+	for _, k := range sortedKeys(nodes) {
+		v := int96Target(nodes[k], m.opts.Int96As)
+		currentNode, ok := mergedSchema[k]
+		if !ok {
+			changed[k] = v
+			continue
+		}
+		merged, err := mergeNode(k, currentNode, v, m.promotion())
+		if err != nil {
+			var conflict *schemaConflict
+			if !errors.As(err, &conflict) {
+				return nil, err
+			}
+			mismatch.conflicts = append(mismatch.conflicts, columnConflict{
+				key:        k,
+				Column:     conflict.path,
+				Merged:     nodeTypeName(conflict.a),
+				MergedFrom: mergedFrom[k],
+				Type:       nodeTypeName(conflict.b),
+			})
+			continue
+		}
+		if c := fieldIDConflicts(ids, k, nodes[k]); len(c) > 0 {
+			mismatch.conflicts = append(mismatch.conflicts, c...)
+			continue
+		}
+		if !sameNode(merged, currentNode) {
+			changed[k] = merged
+		}
+	}
+	if len(mismatch.conflicts) > 0 {
+		return nil, mismatch
+	}
+	return changed, nil
+}
+
+// mismatchError lists the columns of file that conflict with the merged
+// schema.
+type mismatchError struct {
+	file      string
+	conflicts []columnConflict
+}
+
+// columnConflict is a column whose type in a file, Type, cannot be merged
+// with its type in the merged schema, which came from MergedFrom.
+type columnConflict struct {
+	// key is the top-level column holding Column.
+	key        string
+	Column     string `json:"column"`
+	Merged     string `json:"merged_type"`
+	MergedFrom string `json:"merged_from"`
+	Type       string `json:"type"`
+}
+
+func (e *mismatchError) Error() string {
+	c := e.conflicts[0]
+	msg := fmt.Sprintf("schema mismatch: %s: %s in %s, %s in %s", c.Column, c.Merged, c.MergedFrom, c.Type, e.file)
+	if len(e.conflicts) > 1 {
+		msg += fmt.Sprintf(" (and %d more columns)", len(e.conflicts)-1)
+	}
+	return msg
+}
@@ -0,0 +1,253 @@
+package merge
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// writeParquet writes rows of a file of the columns of g to path, one row
+// group per chunk.
+func writeParquet(t testing.TB, path string, g parquet.Group, chunks ...[]map[string]any) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := parquet.NewGenericWriter[map[string]any](f, parquet.NewSchema("test", g))
+	for _, rows := range chunks {
+		if _, err := w.Write(rows); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testOptions returns the default options, quiet and logging nowhere,
+// merging files into out.
+func testOptions(out string, files ...string) Options {
+	opts := DefaultOptions()
+	opts.Quiet = true
+	opts.Logger = discardLogger
+	opts.OutFile = out
+	opts.Patterns = files
+	return opts
+}
+
+// runMerge merges as opts say, failing the test on an error.
+func runMerge(t testing.TB, opts Options) Stats {
+	t.Helper()
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+// openOutput opens the parquet file path, read into memory.
+func openOutput(t testing.TB, path string) *parquet.File {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// readParquet returns the schema and rows of the parquet file path.
+func readParquet(t testing.TB, path string) (*parquet.Schema, []map[string]any) {
+	t.Helper()
+	pf := openOutput(t, path)
+	var out []map[string]any
+	for _, rg := range pf.RowGroups() {
+		rows := rg.Rows()
+		buf := make([]parquet.Row, 64)
+		for {
+			n, err := rows.ReadRows(buf)
+			for _, row := range buf[:n] {
+				m := map[string]any{}
+				if err := pf.Schema().Reconstruct(&m, row); err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, m)
+			}
+			if err != nil {
+				break
+			}
+		}
+		rows.Close()
+	}
+	return pf.Schema(), out
+}
+
+// outputIDs returns the id column of the rows of the parquet file path,
+// INT32 or INT64.
+func outputIDs(t testing.TB, path string) []int64 {
+	t.Helper()
+	_, rows := readParquet(t, path)
+	var ids []int64
+	for _, row := range rows {
+		switch id := row["id"].(type) {
+		case int32:
+			ids = append(ids, int64(id))
+		case int64:
+			ids = append(ids, id)
+		default:
+			t.Fatalf("row %v has no INT32 or INT64 id", row)
+		}
+	}
+	return ids
+}
+
+// fileSum returns the SHA-256 of the file path.
+func fileSum(t testing.TB, path string) [sha256.Size]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(data)
+}
+
+// writeMixedInputs writes three files of overlapping columns to dir and
+// returns their paths.
+func writeMixedInputs(t testing.TB, dir string) []string {
+	t.Helper()
+	files := []string{filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet"), filepath.Join(dir, "c.parquet")}
+	writeParquet(t, files[0], parquet.Group{
+		"id":   parquet.Int(64),
+		"name": parquet.String(),
+	}, []map[string]any{{"id": int64(1), "name": "one"}, {"id": int64(2), "name": "two"}})
+	writeParquet(t, files[1], parquet.Group{
+		"id":    parquet.Int(64),
+		"score": parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		"zone":  parquet.String(),
+	}, []map[string]any{{"id": int64(3), "score": 0.5, "zone": "eu"}}, []map[string]any{{"id": int64(4), "zone": "us"}})
+	writeParquet(t, files[2], parquet.Group{
+		"id":   parquet.Int(32),
+		"name": parquet.Optional(parquet.String()),
+		"flag": parquet.Leaf(parquet.BooleanType),
+	}, []map[string]any{{"id": int32(5), "name": "five", "flag": true}, {"id": int32(6), "flag": false}})
+	return files
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMixedInputs(t, dir)
+	var sums [2][sha256.Size]byte
+	for i := range sums {
+		// The second run lists the files the other way round, and finds
+		// them modified at other times.
+		listed := append([]string(nil), files...)
+		if i == 1 {
+			for j, file := range files {
+				listed[len(files)-1-j] = file
+				when := time.Now().Add(time.Duration(j) * time.Hour)
+				if err := os.Chtimes(file, when, when); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		out := filepath.Join(dir, "out", string(rune('0'+i)), "merged.parquet")
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		opts := testOptions(out, listed...)
+		opts.Reproducible = true
+		runMerge(t, opts)
+		sums[i] = fileSum(t, out)
+	}
+	if sums[0] != sums[1] {
+		t.Errorf("two runs wrote outputs of SHA-256 %x and %x", sums[0], sums[1])
+	}
+
+	out := filepath.Join(dir, "out", "0", "merged.parquet")
+	_, rows := readParquet(t, out)
+	if len(rows) != 6 {
+		t.Fatalf("merged %d rows, want 6", len(rows))
+	}
+	for i, row := range rows {
+		if row["id"] != int64(i+1) {
+			t.Errorf("row %d has id %v, want %d", i, row["id"], i+1)
+		}
+	}
+	f := openOutput(t, out)
+	if got := f.Metadata().CreatedBy; got != reproducibleCreatedBy {
+		t.Errorf("created_by = %q, want %q", got, reproducibleCreatedBy)
+	}
+	if v, ok := f.Lookup("merged.timestamp"); ok {
+		t.Errorf("merged.timestamp = %q, want none", v)
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMixedInputs(t, dir)
+	// c, b, a by modification time.
+	for i, file := range files {
+		when := time.Now().Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(file, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		order string
+		ids   []int64
+	}{
+		{"name", []int64{1, 2, 3, 4, 5, 6}},
+		{"mtime", []int64{5, 6, 3, 4, 1, 2}},
+	}
+	for _, tt := range tests {
+		var columns [2][][]string
+		var rows [2][]map[string]any
+		for i := range columns {
+			listed := []string{files[1], files[2], files[0]}
+			if i == 1 {
+				listed = []string{files[2], files[0], files[1]}
+			}
+			out := filepath.Join(dir, tt.order+string(rune('0'+i))+".parquet")
+			opts := testOptions(out, listed...)
+			opts.Order = tt.order
+			runMerge(t, opts)
+			var schema *parquet.Schema
+			schema, rows[i] = readParquet(t, out)
+			columns[i] = schema.Columns()
+		}
+		if !reflect.DeepEqual(columns[0], columns[1]) {
+			t.Errorf("-order %s: two runs wrote the columns %q and %q", tt.order, columns[0], columns[1])
+		}
+		if !reflect.DeepEqual(rows[0], rows[1]) {
+			t.Errorf("-order %s: two runs wrote the rows %v and %v", tt.order, rows[0], rows[1])
+		}
+		var ids []int64
+		for _, row := range rows[0] {
+			id, _ := row["id"].(int64)
+			ids = append(ids, id)
+		}
+		if !slices.Equal(ids, tt.ids) {
+			t.Errorf("-order %s: merged the ids %v, want %v", tt.order, ids, tt.ids)
+		}
+	}
+}
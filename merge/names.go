@@ -0,0 +1,49 @@
+package merge
+
+import (
+	"strings"
+	"unicode"
+)
+
+// normalizeName applies -normalize-names to a column name: lower folds it
+// to lower case, snake also splits words with underscores, so HostName,
+// hostName and host-name all become host_name, and none leaves it alone.
+func normalizeName(name, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(name)
+	case "snake":
+		return snakeCase(name)
+	}
+	return name
+}
+
+// snakeCase lower cases name, starting a new word at each change from
+// lower case or a digit to upper case, at the last capital of a run of
+// capitals followed by lower case (HTTPServer is http_server), and at any
+// run of characters other than letters and digits.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	pendingBreak := false
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pendingBreak = b.Len() > 0
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				pendingBreak = true
+			} else if unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+				pendingBreak = true
+			}
+		}
+		if pendingBreak && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		pendingBreak = false
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
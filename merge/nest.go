@@ -0,0 +1,142 @@
+package merge
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// nestByDots returns nodes with the columns whose names hold dots moved
+// into required groups named by the parts before the dots, so that
+// k8s.pod.name becomes name in group pod of group k8s (-nest-by-dots).  The
+// groups being required, the values of the columns keep their levels.  A
+// column whose name is also the group of another, such as k8s and
+// k8s.pod.name, is an error.
+func nestByDots(nodes map[string]parquet.Node) (map[string]parquet.Node, error) {
+	root := map[string]any{}
+	// owner holds the column each group or column placed was made for.
+	owner := map[string]string{}
+	for _, name := range sortedKeys(nodes) {
+		parts := strings.Split(name, ".")
+		if slices.Contains(parts, "") {
+			return nil, fmt.Errorf("column %s cannot be nested by dots: it has an empty name part", name)
+		}
+		group := root
+		for i, part := range parts {
+			path := strings.Join(parts[:i+1], ".")
+			last := i == len(parts)-1
+			switch v := group[part].(type) {
+			case nil:
+				owner[path] = name
+				if last {
+					group[part] = nodes[name]
+				} else {
+					sub := map[string]any{}
+					group[part] = sub
+					group = sub
+				}
+			case map[string]any:
+				if last {
+					return nil, fmt.Errorf("column %s is also the group of %s nested by dots", name, owner[path])
+				}
+				group = v
+			default:
+				return nil, fmt.Errorf("column %s is also the group of %s nested by dots", path, name)
+			}
+		}
+	}
+	return nestedGroup(root), nil
+}
+
+func nestedGroup(fields map[string]any) parquet.Group {
+	g := parquet.Group{}
+	for name, v := range fields {
+		if sub, ok := v.(map[string]any); ok {
+			g[name] = nestedGroup(sub)
+		} else {
+			g[name] = v.(parquet.Node)
+		}
+	}
+	return g
+}
+
+// nestedPath returns the path of column path once nested by dots.
+func nestedPath(path []string) []string {
+	return append(strings.Split(path[0], "."), path[1:]...)
+}
+
+// nestedSorting returns sorting with the paths of its columns nested by
+// dots.
+func nestedSorting(sorting []parquet.SortingColumn) []parquet.SortingColumn {
+	nested := make([]parquet.SortingColumn, len(sorting))
+	for i, c := range sorting {
+		if c.Descending() {
+			nested[i] = parquet.Descending(nestedPath(c.Path())...)
+		} else {
+			nested[i] = parquet.Ascending(nestedPath(c.Path())...)
+		}
+		if c.NullsFirst() {
+			nested[i] = parquet.NullsFirst(nested[i])
+		}
+	}
+	return nested
+}
+
+// columnNester writes rows of schema to a writer of the same columns
+// nested by dots, moving the values of each column to its place there.
+type columnNester struct {
+	mergeWriter
+	schema *parquet.Schema
+	// columns maps the columns of schema to those of the writer, and order
+	// lists the columns of schema in the order of the writer's.
+	columns     []int
+	order       []int
+	first, last []int
+	rows        []parquet.Row
+}
+
+func newColumnNester(w mergeWriter, schema *parquet.Schema) *columnNester {
+	n := &columnNester{mergeWriter: w, schema: schema}
+	paths := schema.Columns()
+	n.columns = make([]int, len(paths))
+	n.order = make([]int, len(paths))
+	for i, path := range paths {
+		leaf, _ := w.Schema().Lookup(nestedPath(path)...)
+		n.columns[i] = leaf.ColumnIndex
+		n.order[leaf.ColumnIndex] = i
+	}
+	n.first = make([]int, len(paths))
+	n.last = make([]int, len(paths))
+	return n
+}
+
+// Schema returns the schema of the rows written, before nesting.
+func (n *columnNester) Schema() *parquet.Schema { return n.schema }
+
+func (n *columnNester) WriteRows(rows []parquet.Row) (int, error) {
+	if cap(n.rows) < len(rows) {
+		n.rows = make([]parquet.Row, len(rows))
+	}
+	out := n.rows[:len(rows)]
+	for i, row := range rows {
+		// The values of each column are together, in the order of the
+		// columns of schema.
+		for j, v := range row {
+			c := v.Column()
+			if j == 0 || row[j-1].Column() != c {
+				n.first[c] = j
+			}
+			n.last[c] = j + 1
+		}
+		o := out[i][:0]
+		for _, c := range n.order {
+			for _, v := range row[n.first[c]:n.last[c]] {
+				o = append(o, v.Level(v.RepetitionLevel(), v.DefinitionLevel(), n.columns[c]))
+			}
+		}
+		out[i] = o
+	}
+	return n.mergeWriter.WriteRows(out)
+}
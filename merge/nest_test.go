@@ -0,0 +1,52 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestNestByDots(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	writeParquet(t, in, parquet.Group{
+		"id":           parquet.Int(64),
+		"k8s.pod.name": parquet.String(),
+		"k8s.pod.uid":  parquet.Optional(parquet.String()),
+		"k8s.node":     parquet.String(),
+	}, []map[string]any{{"id": int64(1), "k8s.pod.name": "web", "k8s.pod.uid": "u1", "k8s.node": "n1"}})
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, in)
+	opts.NestByDots = true
+	runMerge(t, opts)
+	schema, rows := readParquet(t, out)
+	if got, want := schema.Columns(), [][]string{{"id"}, {"k8s", "node"}, {"k8s", "pod", "name"}, {"k8s", "pod", "uid"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote the columns %q, want %q", got, want)
+	}
+	k8s, _ := fieldByName(schema, "k8s")
+	pod, _ := fieldByName(k8s, "pod")
+	uid, _ := fieldByName(pod, "uid")
+	if !k8s.Required() || !pod.Required() || !uid.Optional() {
+		t.Errorf("k8s is required %t, k8s.pod %t and k8s.pod.uid optional %t, want true, true and true", k8s.Required(), pod.Required(), uid.Optional())
+	}
+	want := map[string]any{"node": "n1", "pod": map[string]any{"name": "web", "uid": "u1"}}
+	if len(rows) != 1 || !reflect.DeepEqual(rows[0]["k8s"], want) {
+		t.Errorf("wrote the rows %v, want k8s %v", rows, want)
+	}
+
+	clash := filepath.Join(dir, "clash.parquet")
+	writeParquet(t, clash, parquet.Group{"k8s": parquet.String()}, []map[string]any{{"k8s": "x"}})
+	opts = testOptions(filepath.Join(dir, "clash-merged.parquet"), in, clash)
+	opts.NestByDots = true
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrSchemaConflict) {
+		t.Errorf("merging k8s with k8s.node: error %v, want ErrSchemaConflict", err)
+	}
+}
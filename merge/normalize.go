@@ -0,0 +1,182 @@
+package merge
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// normalizeUnits are the units of -time-normalize and -assume-unit; parquet
+// has no TIMESTAMP in seconds, so they can only be assumed.
+var normalizeUnits = map[string]time.Duration{
+	"seconds": time.Second,
+	"millis":  time.Millisecond,
+	"micros":  time.Microsecond,
+	"nanos":   time.Nanosecond,
+}
+
+// unitName returns the name of a unit of normalizeUnits.
+func unitName(unit time.Duration) string {
+	for name, d := range normalizeUnits {
+		if d == unit {
+			return name
+		}
+	}
+	return unit.String()
+}
+
+// plausibleTimes is the range of the times -time-normalize writes without
+// counting them as suspicious.
+var plausibleTimes = [2]time.Time{
+	time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// normalizeNode returns the TIMESTAMP node of a -time-normalize unit.
+func normalizeNode(unit string) (parquet.Node, error) {
+	switch unit {
+	case "millis":
+		return parquet.Timestamp(parquet.Millisecond), nil
+	case "micros":
+		return parquet.Timestamp(parquet.Microsecond), nil
+	case "nanos":
+		return parquet.Timestamp(parquet.Nanosecond), nil
+	}
+	return nil, fmt.Errorf("unknown unit %q: must be millis, micros or nanos", unit)
+}
+
+// normalizeFrom returns the unit of the values of node, a column of a file
+// normalized with -time-normalize: the unit of a TIMESTAMP, or assume for
+// a plain INT64.
+func normalizeFrom(node parquet.Node, assume time.Duration) (time.Duration, error) {
+	if !node.Leaf() || node.Repeated() {
+		return 0, fmt.Errorf("column is %s, not a non-repeated leaf", nodeTypeName(node))
+	}
+	lt := node.Type().LogicalType()
+	switch {
+	case lt != nil && lt.Timestamp != nil:
+		unit, _ := temporalUnit(node)
+		return timeUnitOf(unit).Duration(), nil
+	case node.Type().Kind() != parquet.Int64 || (lt != nil && (lt.Integer == nil || !lt.Integer.IsSigned)):
+		return 0, fmt.Errorf("column is %s, neither a TIMESTAMP nor a plain INT64", nodeTypeName(node))
+	case assume == 0:
+		return 0, fmt.Errorf("column is a plain INT64; -assume-unit gives the unit of its values")
+	}
+	return assume, nil
+}
+
+// normalizedNodes returns nodes with the -time-normalize columns given
+// their TIMESTAMP types, which is how they are merged.
+func normalizedNodes(nodes map[string]parquet.Node, targets map[string]parquet.Node, assume time.Duration) (map[string]parquet.Node, error) {
+	var out map[string]parquet.Node
+	for k, node := range nodes {
+		target, ok := targets[k]
+		if !ok {
+			continue
+		}
+		if _, err := normalizeFrom(node, assume); err != nil {
+			return nil, fmt.Errorf("time normalize %s: %w", k, err)
+		}
+		if out == nil {
+			out = make(map[string]parquet.Node, len(nodes))
+			for k, v := range nodes {
+				out[k] = v
+			}
+		}
+		if node.Optional() {
+			target = parquet.Optional(target)
+		}
+		out[k] = target
+	}
+	if out == nil {
+		return nodes, nil
+	}
+	return out, nil
+}
+
+// rescaleTime converts v, an int64 time in units of from, to units of to,
+// rounding down, failing if it overflows.
+func rescaleTime(v any, from, to time.Duration) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return nil, fmt.Errorf("cannot normalize %T as a time", v)
+	}
+	if from >= to {
+		f := int64(from / to)
+		if n > math.MaxInt64/f || n < math.MinInt64/f {
+			return nil, fmt.Errorf("%d overflows a TIMESTAMP in %s", n, unitName(to))
+		}
+		return n * f, nil
+	}
+	d := int64(to / from)
+	q := n / d
+	if n%d < 0 {
+		q--
+	}
+	return q, nil
+}
+
+// timeBound is the plausible range of the values of a -time-normalize
+// column, in its unit.
+type timeBound struct {
+	column string
+	set    bool
+	lo, hi int64
+}
+
+// timeBounds returns the plausible ranges of the -time-normalize columns
+// of schema, by leaf column, or nil if there are none.
+func timeBounds(schema *parquet.Schema, normalize map[string]string) []timeBound {
+	if len(normalize) == 0 {
+		return nil
+	}
+	bounds := make([]timeBound, len(schema.Columns()))
+	for _, k := range sortedStrings(normalize) {
+		leaf, ok := schema.Lookup(k)
+		if !ok {
+			continue
+		}
+		unit := normalizeUnits[normalize[k]]
+		bounds[leaf.ColumnIndex] = timeBound{
+			column: k,
+			set:    true,
+			lo:     plausibleTimes[0].UnixNano() / int64(unit),
+			hi:     plausibleTimes[1].Unix() * int64(time.Second/unit),
+		}
+	}
+	return bounds
+}
+
+// checkTimes reports the values of rows, the first at index first in the
+// file, outside the plausible range of their -time-normalize column.
+func (r *fileRows) checkTimes(rows []parquet.Row, first int64) {
+	for i, row := range rows {
+		for _, v := range row {
+			b := r.timeBounds[v.Column()]
+			if !b.set || v.IsNull() {
+				continue
+			}
+			if n := v.Int64(); n < b.lo || n >= b.hi {
+				r.suspectTime(fmt.Errorf("row %d: column %s: %d is not between %d and %d", first+int64(i), b.column, n, plausibleTimes[0].Year(), plausibleTimes[1].Year()))
+			}
+		}
+	}
+}
+
+// suspectTime returns the fileRows.suspectTime of file: it counts the
+// suspicious times and logs the first of them.
+func (m *Merger) suspectTime(file string) func(error) {
+	logged := false
+	return func(err error) {
+		m.suspiciousTimes.Add(1)
+		if !logged {
+			logged = true
+			m.log.Warn("writing a time outside the plausible range", "phase", "copy", "file", file, "error", err)
+		}
+	}
+}
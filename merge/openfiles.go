@@ -0,0 +1,87 @@
+package merge
+
+import (
+	"fmt"
+	"sync"
+)
+
+// openFileHeadroom is the part of the open file limit left to the outputs,
+// temporary files, connections and the runtime when the budget of open
+// input files is derived from the limit.
+const openFileHeadroom = 64
+
+// fileBudget bounds the number of input files open at once: opening one
+// more waits for another to be closed.  A nil fileBudget is unbounded.
+type fileBudget chan struct{}
+
+func newFileBudget(n int) fileBudget {
+	if n <= 0 {
+		return nil
+	}
+	return make(fileBudget, n)
+}
+
+func (b fileBudget) acquire() {
+	if b != nil {
+		b <- struct{}{}
+	}
+}
+
+func (b fileBudget) release() {
+	if b != nil {
+		<-b
+	}
+}
+
+// budgetedInput is an input file holding a place in a fileBudget until it
+// is closed.
+type budgetedInput struct {
+	inputReader
+	budget fileBudget
+	once   sync.Once
+}
+
+func (r *budgetedInput) Close() error {
+	err := r.inputReader.Close()
+	r.once.Do(r.budget.release)
+	return err
+}
+
+// minOpenFiles returns the fewest input files a merge with o can need open
+// at once: the files read ahead or by the copy workers, one more being
+// opened, and one opened by the copy itself.
+func minOpenFiles(o Options) int {
+	return max(o.Prefetch, o.CopyJobs) + 2
+}
+
+// maxOpenFiles returns the size of the open file budget: Options.MaxOpenFiles
+// or, if it is 0, the open file limit of the process less the headroom and
+// the output files, but no less than minOpenFiles.  It returns 0, no
+// budget, if the process has no limit.
+func maxOpenFiles(o Options) int {
+	if o.MaxOpenFiles > 0 {
+		return o.MaxOpenFiles
+	}
+	limit := openFileLimit()
+	if limit == 0 {
+		return 0
+	}
+	n := limit - openFileHeadroom
+	if o.PartitionBy != "" {
+		n -= o.MaxOpenWriters
+	}
+	if o.ShardBy != "" {
+		n -= o.Shards
+	}
+	return max(n, minOpenFiles(o))
+}
+
+// checkSortedOpenFiles checks that the budget leaves room for the k-way
+// merge of -sorted-by, which keeps every input open and opens one more to
+// check that each is sorted.
+func (m *Merger) checkSortedOpenFiles(inputs int) error {
+	if m.openFiles != nil && cap(m.openFiles) < inputs+1 {
+		return markError(ErrInvalidOptions, fmt.Errorf("sorted-by keeps all %d inputs open, more than -max-open-files %d allows", inputs, cap(m.openFiles)))
+	}
+	return nil
+}
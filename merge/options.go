@@ -0,0 +1,665 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Options configures a Merger.  Each field corresponds to the merger flag
+// named in its comment; DefaultOptions returns the flag defaults.
+type Options struct {
+	// SourceDir, FileList and Patterns name the files to merge: the
+	// parquet files in a directory (-sourcedir, and -recursive for its
+	// subdirectories), the files listed in a manifest or - for stdin
+	// (-filelist), and glob patterns or URLs (the arguments).
+	SourceDir string
+	Recursive bool
+	FileList  string
+	Patterns  []string
+	// Exclude skips files matching any of these patterns (-exclude).
+	Exclude []string
+	// Extensions are the extensions of the files searched for in
+	// SourceDir (-ext), and MinAge skips those modified less than this
+	// long ago (-min-age).  Hidden and temporary files are never searched
+	// for.
+	Extensions []string
+	MinAge     time.Duration
+	// Deterministic merges files in Order, name or mtime (-deterministic,
+	// -order).
+	Deterministic bool
+	Order         string
+	// Reproducible writes byte-identical output for the same inputs and
+	// options: files are merged in name order, and the footer is written
+	// without merged.timestamp and with a created-by that does not depend
+	// on the parquet-go version (-reproducible).
+	Reproducible bool
+	// ScanJobs is the number of files read concurrently while scanning
+	// schemas (-scan-jobs).
+	ScanJobs int
+	// SkipBadFiles skips files that cannot be read, failing without
+	// writing the output, state or manifest if there are more than
+	// MaxBadFiles of them, unless it is negative (-skip-bad-files,
+	// -max-bad-files).
+	SkipBadFiles bool
+	MaxBadFiles  int
+
+	// RequireFields lists the fields a file must have to be merged, in the
+	// syntax of -requireFields.
+	RequireFields string
+	// OnConflict is what to do with columns whose types cannot be merged:
+	// fail, widen, stringify, skip-field or skip-file (-on-conflict).
+	// Strict is the same as fail (-strict).
+	OnConflict    string
+	Strict        bool
+	CoerceDecimal bool
+	UUIDAsString  bool
+	// Int96As is how INT96 columns are merged: timestamp-millis, bytes, or
+	// empty to reject them (-int96-as).
+	Int96As string
+	// UTF8 is what to do with STRING values that are not valid UTF-8:
+	// reject, replace, binary, or empty not to check them (-utf8).
+	UTF8 string
+	// OnUnsupported is what to do with columns of types the merger cannot
+	// read: fail, drop, or stringify (-on-unsupported).
+	OnUnsupported string
+	// Columns, if not empty, lists the only columns to merge, and
+	// DropColumns the columns to leave out (-columns, -drop-columns).
+	Columns     []string
+	DropColumns []string
+	// Renames maps column names to the names they are merged as (-rename,
+	// -rename-file), and NormalizeNames is lower, snake or none
+	// (-normalize-names).
+	Renames        map[string]string
+	NormalizeNames string
+	// Defaults maps columns to the values written for files without them
+	// (-default, -defaults-file).
+	Defaults map[string]string
+	// TypeOverrides maps top-level columns to the types they are merged
+	// as, whatever their types in the files (-type-overrides), and
+	// OnCastError is fail or null: what to do with values that cannot be
+	// cast (-on-cast-error).
+	TypeOverrides map[string]string
+	OnCastError   string
+	// TimeNormalize maps top-level columns to the unit, millis, micros or
+	// nanos, of the TIMESTAMP they are merged as: TIMESTAMP columns are
+	// converted from their own unit, and plain INT64 ones from AssumeUnit,
+	// seconds, millis, micros or nanos (-time-normalize, -assume-unit).
+	TimeNormalize map[string]string
+	AssumeUnit    string
+	// Flatten expands the groups of the files, other than LISTs, MAPs
+	// and repeated ones, into top-level columns named by the dotted paths
+	// of their leaves, so that they merge with files storing those columns
+	// flat (-flatten).
+	Flatten bool
+	// NestByDots writes the merged columns whose names hold dots in
+	// groups named by the parts before the dots, so that k8s.pod.name is
+	// written as name in group pod of group k8s (-nest-by-dots).
+	NestByDots bool
+	// TightenNullability makes required the optional columns that every
+	// merged file has and whose column chunk statistics show no nulls in
+	// any of them (-tighten-nullability).  A null read from one fails the
+	// merge.
+	TightenNullability bool
+	// ReportFile is where the JSON compatibility report is written, if set
+	// (-report).
+	ReportFile string
+	// SchemaOut is where a description of the merged schema is written
+	// once the output is in place, if set, as JSON, or with SchemaOutFormat
+	// text also as text beside it, with a .txt extension (-schema-out,
+	// -schema-out-format).
+	SchemaOut       string
+	SchemaOutFormat string
+	// Manifest is where a JSON manifest of the output files, with their
+	// checksums, and of the inputs merged into them is written as the last
+	// step of a successful merge, if set (-manifest).
+	Manifest string
+
+	// Where is an expression rows must match (-where).
+	Where string
+	// After and Before, if not zero, bound TimeColumn (-after, -before,
+	// -time-column).
+	TimeColumn    string
+	After, Before time.Time
+	// RetainDays, if not 0, drops the rows whose TimeColumn is older than
+	// that many days, or null, and RetentionReport is where a JSON report
+	// of the rows kept and dropped per input file is written, if set
+	// (-retain-days, -retention-report).
+	RetainDays      int
+	RetentionReport string
+	// DedupKeys lists the columns identifying duplicate rows (-dedup-keys,
+	// -dedup-prefer-latest, -dedup-time-column, -dedup-max-keys).
+	DedupKeys         []string
+	DedupPreferLatest bool
+	DedupTimeColumn   string
+	DedupMaxKeys      int
+	// DeleteKeys names a parquet or CSV file of keys, in its
+	// DeleteKeyColumn, whose rows are left out of the output: those whose
+	// DeleteKeyColumn has one of the keys, compared as text (-delete-keys,
+	// -delete-key-column).
+	DeleteKeys      string
+	DeleteKeyColumn string
+	// Limit and Offset select the rows written (-limit, -offset).
+	Limit, Offset int64
+	// Sample and SamplePerFile sample the rows written, using Seed, or a
+	// seed from the clock if it is 0 (-sample, -sample-per-file, -seed).
+	Sample        float64
+	SamplePerFile int
+	Seed          int64
+	// SourceColumn adds a column holding the file each row came from
+	// (-source-column).
+	SourceColumn string
+	// Derive maps the names of columns added to the output to expressions
+	// computing them from the other columns of each row (-derive).
+	Derive map[string]string
+	// Redact maps columns to how they are redacted once rows are filtered:
+	// sha256 replaces their values with the hex digest of RedactSalt and
+	// the value, mask with their first and last characters, and drop
+	// leaves them out of the output (-redact, -redact-salt).
+	Redact     map[string]string
+	RedactSalt string
+	// RowGroups maps input files to the row groups copied from them, as
+	// an index or a first-last range of indexes; their other row groups
+	// are skipped (-row-groups).
+	RowGroups map[string]string
+	// SortedBy is the column every input is sorted by, and Unsorted is
+	// reject or buffer (-sorted-by, -unsorted).
+	SortedBy string
+	Unsorted string
+	// SortBy sorts the output, in the syntax of -sortby (-sort-nulls,
+	// -sort-buffer-rows).
+	SortBy         string
+	SortNulls      string
+	SortBufferRows int64
+	// SortExternal sorts the whole output through sorted runs of
+	// SortBufferRows rows spilled to SortTempDir (-sort-external,
+	// -sort-temp-dir).
+	SortExternal bool
+	SortTempDir  string
+
+	// BatchSize is the number of rows copied at a time (-batch-size).
+	BatchSize  int
+	NoFastpath bool
+	// Prefetch is the number of files read ahead (-prefetch).
+	Prefetch int
+	// CopyJobs, if more than 1, is the number of workers decoding the row
+	// groups of the inputs in the background instead (-copy-jobs).
+	CopyJobs int
+	// MaxOpenFiles bounds the input files open at once, or is derived from
+	// the open file limit of the process if 0 (-max-open-files).
+	MaxOpenFiles int
+	// MaxMemory, in bytes, bounds buffered rows if it is not 0
+	// (-max-memory).
+	MaxMemory int64
+	CheckCRC  bool
+	// HTTPBlockSize, HTTPCacheBlocks, HTTPRetries and HTTPTimeout, the
+	// longest a request may take, configure reading http and https inputs
+	// (-http-block-size, -http-cache-blocks, -http-retries,
+	// -http-timeout).
+	HTTPBlockSize   int64
+	HTTPCacheBlocks int
+	HTTPRetries     int
+	HTTPTimeout     time.Duration
+
+	// OutFile is the file written, or with PartitionBy the directory
+	// (-outfile).  If Output is set, the merged file is written to it
+	// instead.
+	OutFile string
+	Output  io.Writer
+	// Bench merges as usual but throws the output away, so that reading
+	// and encoding the rows can be timed (-bench).
+	Bench bool
+	// OutputTemplate, MaxOutputRows and MaxOutputBytes split the output
+	// (-output-template, -max-output-rows, -max-output-bytes).
+	OutputTemplate string
+	MaxOutputRows  int64
+	MaxOutputBytes int64
+	// TargetOutputBytes is the size the output should not pass, estimated
+	// between input files.  With StopAtBudget no more input files are
+	// merged once it is reached, and Leftover lists those left for a later
+	// run; otherwise passing it is only logged (-target-output-bytes,
+	// -stop-at-budget, -leftover).
+	TargetOutputBytes int64
+	StopAtBudget      bool
+	Leftover          string
+	// StateFile records the inputs merged and the files written, so that a
+	// later run merges only new inputs, into the next file of the series
+	// named by OutputTemplate (-state).
+	StateFile string
+	// Watch keeps merging until the context passed to Run is canceled,
+	// looking for new inputs every WatchInterval and writing them to the
+	// next file of the StateFile series (-watch, -interval).
+	Watch         bool
+	WatchInterval time.Duration
+	// NoLock skips the lock file, OutFile with a .lock extension, that
+	// keeps two runs from writing the same output, and LockStaleAge is the
+	// age past which a lock is broken, if not 0 (-no-lock,
+	// -lock-stale-age).
+	NoLock       bool
+	LockStaleAge time.Duration
+	// Append merges the existing OutFile, if there is one, with the inputs
+	// and replaces it.  Its rows are copied first, and are not filtered by
+	// Where, After, Before or sampling (-append).
+	Append bool
+	// PartitionBy writes the output in a directory per value of a column
+	// (-partition-by, -drop-partition-column, -max-open-writers).
+	PartitionBy         string
+	DropPartitionColumn bool
+	MaxOpenWriters      int
+	// ShardBy and Shards write the output as Shards files, each row going
+	// to the one its ShardBy column hashes to (-shard-by, -shards).
+	ShardBy string
+	Shards  int
+	// Compression names the output codec, and ColumnCompression maps leaf
+	// columns to their own (-compression, -column-compression).
+	Compression       string
+	ColumnCompression map[string]string
+	RowGroupRows      int64
+	RowGroupBytes     int64
+	PageBufferSize    int
+	WriteBufferSize   int
+	// PageBufferPool is memory or file (-page-buffer-pool).
+	PageBufferPool string
+	BloomColumns   []string
+	BloomBits      uint
+	// KVConflict is join, array or drop (-kv-conflict).
+	KVConflict string
+	// Verify reopens the output to check it, decoding every row group with
+	// VerifyDeep (-verify, -verify-deep).
+	Verify     bool
+	VerifyDeep bool
+	// DryRun prints the plan to DryRunOutput, or stdout, instead of
+	// merging (-dry-run).
+	DryRun       bool
+	DryRunOutput io.Writer
+	// OnInterrupt is what happens to the output when the context passed
+	// to Run is canceled: finish closes it with the merged.partial key set,
+	// and discard removes it (-on-interrupt).  With Watch, a merge in
+	// progress is finished instead, and with Append the output is left
+	// as it was.
+	OnInterrupt string
+
+	// Logger receives the merge's events, with a phase attribute of find,
+	// scan, copy, write, verify or watch where one applies.  Per-file detail is
+	// logged at debug level.  If it is nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Quiet turns off progress reports, which are otherwise printed to
+	// ProgressOutput, or stdout, or stderr if Output is set (-quiet,
+	// -progress-interval, -progress-rows, -progress-json).
+	Quiet            bool
+	ProgressInterval time.Duration
+	ProgressRows     int64
+	ProgressJSON     bool
+	ProgressOutput   io.Writer
+}
+
+// DefaultOptions returns the options merger uses without flags.
+func DefaultOptions() Options {
+	return Options{
+		Deterministic:    true,
+		Order:            "name",
+		Extensions:       []string{".parquet"},
+		ScanJobs:         runtime.GOMAXPROCS(0),
+		MaxBadFiles:      -1,
+		OnConflict:       "widen",
+		OnUnsupported:    "fail",
+		OnCastError:      "fail",
+		NormalizeNames:   "none",
+		TimeColumn:       "timestamp",
+		DedupTimeColumn:  "timestamp",
+		DeleteKeyColumn:  "_id",
+		Unsorted:         "reject",
+		SortNulls:        "last",
+		SortBufferRows:   100000,
+		BatchSize:        1000,
+		Prefetch:         1,
+		CopyJobs:         1,
+		HTTPBlockSize:    1 << 20,
+		HTTPCacheBlocks:  16,
+		HTTPRetries:      3,
+		HTTPTimeout:      time.Minute,
+		MaxOpenWriters:   100,
+		Compression:      "zstd",
+		PageBufferSize:   parquet.DefaultPageBufferSize,
+		WriteBufferSize:  parquet.DefaultWriteBufferSize,
+		PageBufferPool:   "memory",
+		BloomBits:        10,
+		KVConflict:       "join",
+		ProgressInterval: 10 * time.Second,
+		OnInterrupt:      "finish",
+		WatchInterval:    30 * time.Second,
+		LockStaleAge:     24 * time.Hour,
+		SchemaOutFormat:  "json",
+	}
+}
+
+// minBufferSize is the smallest -page-buffer-size and -write-buffer-size
+// accepted.
+const minBufferSize = 4096
+
+// check returns an error if the options are invalid or conflict.
+func (o *Options) check() error {
+	if o.FileList != "" && (o.SourceDir != "" || len(o.Patterns) > 0) {
+		return errors.New("filelist cannot be combined with sourcedir or input patterns")
+	}
+	if o.SourceDir == "" && len(o.Patterns) == 0 && o.FileList == "" {
+		return errors.New("sourcedir, filelist or input patterns are required")
+	}
+	if len(o.Extensions) == 0 {
+		return errors.New("ext needs at least one extension")
+	}
+	for _, ext := range o.Extensions {
+		if ext == "" || ext == "." {
+			return errors.New("ext cannot name an empty extension")
+		}
+	}
+	if o.MinAge < 0 {
+		return errors.New("min-age cannot be negative")
+	}
+	switch o.Int96As {
+	case "", "timestamp-millis", "bytes":
+	default:
+		return fmt.Errorf("invalid -int96-as %q: must be timestamp-millis or bytes", o.Int96As)
+	}
+	switch o.UTF8 {
+	case "", "reject", "replace", "binary":
+	default:
+		return fmt.Errorf("invalid -utf8 %q: must be reject, replace or binary", o.UTF8)
+	}
+	for _, k := range sortedStrings(o.TypeOverrides) {
+		if _, err := overrideNode(o.TypeOverrides[k]); err != nil {
+			return fmt.Errorf("type override for %s: %w", k, err)
+		}
+	}
+	switch o.OnCastError {
+	case "fail", "null":
+	default:
+		return fmt.Errorf("invalid -on-cast-error %q: must be fail or null", o.OnCastError)
+	}
+	for _, k := range sortedStrings(o.TimeNormalize) {
+		if _, err := normalizeNode(o.TimeNormalize[k]); err != nil {
+			return fmt.Errorf("time normalize %s: %w", k, err)
+		}
+		if strings.Contains(k, ".") {
+			return fmt.Errorf("time normalize %s: only top-level columns can be normalized", k)
+		}
+		if _, ok := o.TypeOverrides[k]; ok {
+			return fmt.Errorf("time normalize %s: the column has a type override", k)
+		}
+	}
+	if _, ok := normalizeUnits[o.AssumeUnit]; o.AssumeUnit != "" && !ok {
+		return fmt.Errorf("invalid -assume-unit %q: must be seconds, millis, micros or nanos", o.AssumeUnit)
+	}
+	switch o.OnUnsupported {
+	case "fail", "drop", "stringify":
+	default:
+		return fmt.Errorf("invalid -on-unsupported %q: must be fail, drop or stringify", o.OnUnsupported)
+	}
+	if o.SortBy != "" && o.SortedBy != "" {
+		return errors.New("sortby cannot be combined with sorted-by")
+	}
+	switch o.SortNulls {
+	case "first", "last":
+	default:
+		return fmt.Errorf("invalid -sort-nulls %q: must be first or last", o.SortNulls)
+	}
+	if o.SortBufferRows < 1 {
+		return errors.New("sort-buffer-rows must be at least 1")
+	}
+	if o.SortExternal && o.SortBy == "" {
+		return errors.New("sort-external requires sortby")
+	}
+	switch o.Unsorted {
+	case "reject", "buffer":
+	default:
+		return fmt.Errorf("invalid -unsorted %q: must be reject or buffer", o.Unsorted)
+	}
+	if o.DedupMaxKeys > 0 && o.DedupPreferLatest {
+		// The latest row of every key is picked in memory before the copy.
+		return errors.New("dedup-max-keys cannot be combined with dedup-prefer-latest")
+	}
+	if o.MaxMemory < 0 {
+		return errors.New("max-memory cannot be negative")
+	}
+	if o.MaxMemory > 0 && o.SortBy != "" {
+		return errors.New("max-memory cannot be combined with sortby; use -sort-buffer-rows")
+	}
+	if o.BatchSize < 1 {
+		return errors.New("batch-size must be at least 1")
+	}
+	if o.ProgressInterval < 0 || o.ProgressRows < 0 {
+		return errors.New("progress-interval and progress-rows cannot be negative")
+	}
+	if o.RowGroupRows < 0 || o.RowGroupBytes < 0 {
+		return errors.New("row-group-rows and row-group-bytes cannot be negative")
+	}
+	if (o.RowGroupRows > 0 || o.RowGroupBytes > 0) && o.SortBy != "" && !o.SortExternal {
+		// parquet-go's SortingWriter drops MaxRowsPerRowGroup, and flushing
+		// it early writes a separately sorted run.
+		return errors.New("row-group-rows and row-group-bytes cannot be combined with sortby")
+	}
+	if o.PageBufferSize < minBufferSize || o.WriteBufferSize < minBufferSize {
+		return fmt.Errorf("page-buffer-size and write-buffer-size must be at least %d", minBufferSize)
+	}
+	switch o.PageBufferPool {
+	case "memory", "file":
+	default:
+		return fmt.Errorf("invalid -page-buffer-pool %q: must be memory or file", o.PageBufferPool)
+	}
+	switch o.KVConflict {
+	case "join", "array", "drop":
+	default:
+		return fmt.Errorf("invalid -kv-conflict %q: must be join, array or drop", o.KVConflict)
+	}
+	if o.BloomBits < 1 {
+		return errors.New("bloom-bits must be at least 1")
+	}
+	if o.MaxBadFiles >= 0 && !o.SkipBadFiles {
+		return errors.New("-max-bad-files requires -skip-bad-files")
+	}
+	if o.Output != nil {
+		if o.PartitionBy != "" {
+			return errors.New("-outfile - cannot be combined with partition-by")
+		}
+		if o.MaxOutputRows > 0 || o.MaxOutputBytes > 0 || o.OutputTemplate != "" {
+			return errors.New("-outfile - cannot be combined with max-output-rows, max-output-bytes or output-template")
+		}
+		if o.Verify {
+			return errors.New("-verify cannot be combined with -outfile -")
+		}
+	}
+	if o.Bench {
+		if o.Output != nil || o.PartitionBy != "" || o.ShardBy != "" || o.StateFile != "" || o.Append || o.Watch {
+			return errors.New("-bench cannot be combined with -outfile -, partition-by, shard-by, state, append or watch")
+		}
+		if o.MaxOutputRows > 0 || o.MaxOutputBytes > 0 || o.OutputTemplate != "" || o.Verify || o.DryRun {
+			return errors.New("-bench cannot be combined with max-output-rows, max-output-bytes, output-template, verify or dry-run")
+		}
+	}
+	if o.LockStaleAge < 0 {
+		return errors.New("lock-stale-age cannot be negative")
+	}
+	if o.Manifest != "" && (o.Output != nil || o.Bench) {
+		return errors.New("-manifest cannot be combined with -outfile - or -bench")
+	}
+	if o.VerifyDeep && !o.Verify {
+		return errors.New("-verify-deep requires -verify")
+	}
+	switch o.SchemaOutFormat {
+	case "json", "text":
+	default:
+		return fmt.Errorf("invalid -schema-out-format %q: must be json or text", o.SchemaOutFormat)
+	}
+	if o.DeleteKeys != "" && o.DeleteKeyColumn == "" {
+		return errors.New("-delete-keys requires -delete-key-column")
+	}
+	if o.Limit < 0 || o.Offset < 0 {
+		return errors.New("limit and offset cannot be negative")
+	}
+	if o.Sample < 0 || o.Sample >= 1 {
+		return errors.New("sample must be at least 0 and less than 1")
+	}
+	if o.SamplePerFile < 0 {
+		return errors.New("sample-per-file cannot be negative")
+	}
+	if o.Sample > 0 && o.SamplePerFile > 0 {
+		return errors.New("-sample and -sample-per-file cannot be combined")
+	}
+	if o.Reproducible {
+		if !o.Deterministic || o.Order != "name" {
+			return errors.New("-reproducible needs -deterministic with -order name")
+		}
+		if (o.Sample > 0 || o.SamplePerFile > 0) && o.Seed == 0 {
+			return errors.New("-reproducible needs -seed to sample")
+		}
+	}
+	switch o.Order {
+	case "name", "mtime":
+	default:
+		return fmt.Errorf("invalid -order %q: must be name or mtime", o.Order)
+	}
+	if o.HTTPBlockSize < 1 || o.HTTPCacheBlocks < 1 {
+		return errors.New("http-block-size and http-cache-blocks must be at least 1")
+	}
+	if o.HTTPRetries < 0 {
+		return errors.New("http-retries cannot be negative")
+	}
+	if o.HTTPTimeout <= 0 {
+		return errors.New("http-timeout must be positive")
+	}
+	switch o.OnConflict {
+	case "fail", "widen", "stringify", "skip-field", "skip-file":
+	default:
+		return fmt.Errorf("invalid -on-conflict %q: must be fail, widen, stringify, skip-field or skip-file", o.OnConflict)
+	}
+	switch o.NormalizeNames {
+	case "lower", "snake", "none":
+	default:
+		return fmt.Errorf("invalid -normalize-names %q: must be lower, snake or none", o.NormalizeNames)
+	}
+	switch o.OnInterrupt {
+	case "finish", "discard":
+	default:
+		return fmt.Errorf("invalid -on-interrupt %q: must be finish or discard", o.OnInterrupt)
+	}
+	if o.Prefetch < 0 {
+		return errors.New("prefetch cannot be negative")
+	}
+	if o.CopyJobs < 1 {
+		return errors.New("copy-jobs must be at least 1")
+	}
+	if o.MaxOpenFiles < 0 {
+		return errors.New("max-open-files cannot be negative")
+	}
+	if o.MaxOpenFiles > 0 && o.MaxOpenFiles < minOpenFiles(*o) {
+		return fmt.Errorf("max-open-files must be at least %d with -prefetch %d and -copy-jobs %d", minOpenFiles(*o), o.Prefetch, o.CopyJobs)
+	}
+	if o.MaxOpenWriters < 1 {
+		return errors.New("max-open-writers must be at least 1")
+	}
+	if o.StateFile != "" && (o.Output != nil || o.PartitionBy != "") {
+		return errors.New("-state cannot be combined with -outfile - or partition-by")
+	}
+	if o.Watch {
+		if o.StateFile == "" {
+			return errors.New("-watch requires -state")
+		}
+		if o.WatchInterval <= 0 {
+			return errors.New("interval must be more than 0")
+		}
+		if o.DryRun || o.FileList == "-" {
+			return errors.New("-watch cannot be combined with -dry-run or -filelist -")
+		}
+	}
+	if o.Append {
+		if o.Output != nil || o.PartitionBy != "" || o.StateFile != "" {
+			return errors.New("-append cannot be combined with -outfile -, partition-by or state")
+		}
+		if o.MaxOutputRows > 0 || o.MaxOutputBytes > 0 || o.OutputTemplate != "" {
+			return errors.New("-append cannot be combined with max-output-rows, max-output-bytes or output-template")
+		}
+		if o.Limit > 0 || o.Offset > 0 {
+			return errors.New("-append cannot be combined with limit or offset")
+		}
+	}
+	if (o.ShardBy == "") != (o.Shards == 0) {
+		return errors.New("-shard-by and -shards must be given together")
+	}
+	if o.ShardBy != "" {
+		if o.Shards < 1 {
+			return errors.New("shards must be at least 1")
+		}
+		if o.Output != nil || o.PartitionBy != "" || o.StateFile != "" || o.Append {
+			return errors.New("-shard-by cannot be combined with -outfile -, partition-by, state or append")
+		}
+		if o.MaxOutputRows > 0 || o.MaxOutputBytes > 0 || o.OutputTemplate != "" {
+			return errors.New("-shard-by cannot be combined with max-output-rows, max-output-bytes or output-template")
+		}
+	}
+	if o.PartitionBy != "" && o.OutputTemplate != "" {
+		return errors.New("output-template cannot be combined with partition-by")
+	}
+	if o.MaxOutputRows < 0 || o.MaxOutputBytes < 0 {
+		return errors.New("max-output-rows and max-output-bytes cannot be negative")
+	}
+	if o.TargetOutputBytes < 0 {
+		return errors.New("target-output-bytes cannot be negative")
+	}
+	if o.StopAtBudget && o.TargetOutputBytes == 0 {
+		return errors.New("-stop-at-budget needs -target-output-bytes")
+	}
+	if o.StopAtBudget && o.SortedBy != "" {
+		return errors.New("-stop-at-budget stops between input files, which -sorted-by merges all at once")
+	}
+	if o.Leftover != "" && !o.StopAtBudget {
+		return errors.New("-leftover needs -stop-at-budget")
+	}
+	if (o.MaxOutputRows > 0 || o.MaxOutputBytes > 0) && o.PartitionBy == "" && o.SortBy != "" && !o.SortExternal {
+		return errors.New("max-output-rows and max-output-bytes cannot be combined with sortby")
+	}
+	if o.RetainDays < 0 {
+		return errors.New("retain-days cannot be negative")
+	}
+	if o.RetentionReport != "" && o.RetainDays == 0 {
+		return errors.New("retention-report needs retain-days")
+	}
+	for _, name := range sortedStrings(o.Redact) {
+		switch method := o.Redact[name]; method {
+		case "sha256", "mask":
+		case "drop":
+			if strings.Contains(name, ".") {
+				return fmt.Errorf("redact: %s is not a top-level column, which is all drop can remove", name)
+			}
+			for _, used := range []struct{ flag, column string }{{"partition-by", o.PartitionBy}, {"shard-by", o.ShardBy}} {
+				if name == used.column {
+					return fmt.Errorf("redact cannot drop %s, the %s column", name, used.flag)
+				}
+			}
+		default:
+			return fmt.Errorf("invalid -redact method %q for %s: must be sha256, mask or drop", method, name)
+		}
+		if name == o.SortedBy {
+			return fmt.Errorf("redact cannot change %s, the sorted-by column", name)
+		}
+	}
+	groups, err := parseRequireFields(o.RequireFields)
+	if err != nil {
+		return err
+	}
+	for _, name := range o.DropColumns {
+		for _, group := range groups {
+			for _, field := range group {
+				if name == field.name {
+					return fmt.Errorf("column %s cannot be both required and dropped", name)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// mergeOutput is where the merged rows are written.
+type mergeOutput interface {
+	mergeWriter
+	// names returns the names of the files written.
+	names() []string
+	// written returns the number of bytes written so far.
+	written() int64
+	// report logs the files written, if there can be more than one.
+	report(logger *slog.Logger)
+	// discard removes the files written, finished or not.
+	discard()
+}
+
+// outputWriter writes the merged rows to outfile or, once a piece reaches
+// maxRows rows or maxBytes bytes, to a series of files named by template.
+// Each file is written as its name with .tmp appended, and renamed when it
+// is complete.
+type outputWriter struct {
+	newWriter func(io.Writer) mergeWriter
+	schema    *parquet.Schema
+	outfile   string
+	template  string
+	// first is the number of the first piece.
+	first    int
+	maxRows  int64
+	maxBytes int64
+	out      *countingWriter
+	file     *os.File
+	// writer is nil between pieces of a suspended writer.
+	writer mergeWriter
+	// start is out.n when the current piece was started, and rows the
+	// rows written to it.
+	start  int64
+	rows   int64
+	pieces []outputPiece
+}
+
+// outputPiece is a file written by an outputWriter.
+type outputPiece struct {
+	name string
+	rows int64
+}
+
+func newOutputWriter(outfile, template string, first int, maxRows, maxBytes int64, newWriter func(io.Writer) mergeWriter) (*outputWriter, error) {
+	w := &outputWriter{
+		newWriter: newWriter,
+		outfile:   outfile,
+		template:  template,
+		first:     first,
+		maxRows:   maxRows,
+		maxBytes:  maxBytes,
+		out:       &countingWriter{},
+	}
+	return w, w.open()
+}
+
+// split reports whether the output is split into pieces.
+func (w *outputWriter) split() bool {
+	return w.outfile == "" || w.maxRows > 0 || w.maxBytes > 0
+}
+
+// open starts the next piece.
+func (w *outputWriter) open() error {
+	name := w.outfile
+	if w.split() {
+		name = fmt.Sprintf(w.template, len(w.pieces)+w.first)
+	}
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.out.w = f
+	w.start = w.out.n
+	w.rows = 0
+	w.writer = w.newWriter(w.out)
+	w.schema = w.writer.Schema()
+	w.pieces = append(w.pieces, outputPiece{name: name})
+	return nil
+}
+
+// full reports whether the current piece has reached a limit.
+func (w *outputWriter) full() bool {
+	return (w.maxRows > 0 && w.rows >= w.maxRows) || (w.maxBytes > 0 && w.out.n-w.start >= w.maxBytes)
+}
+
+// closePiece finishes the current piece and moves it into place.
+func (w *outputWriter) closePiece() error {
+	piece := &w.pieces[len(w.pieces)-1]
+	piece.rows = w.rows
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	w.writer = nil
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(piece.name+".tmp", piece.name)
+}
+
+func (w *outputWriter) WriteRows(rows []parquet.Row) (int, error) {
+	written := 0
+	for len(rows) > 0 {
+		if w.writer == nil {
+			if err := w.open(); err != nil {
+				return written, err
+			}
+		}
+		if w.full() {
+			if err := w.closePiece(); err != nil {
+				return written, err
+			}
+			if err := w.open(); err != nil {
+				return written, err
+			}
+		}
+		batch := rows
+		if w.maxRows > 0 && int64(len(batch)) > w.maxRows-w.rows {
+			batch = batch[:w.maxRows-w.rows]
+		}
+		n, err := w.writer.WriteRows(batch)
+		written += n
+		w.rows += int64(n)
+		if err != nil {
+			return written, err
+		}
+		rows = rows[n:]
+	}
+	return written, nil
+}
+
+func (w *outputWriter) Schema() *parquet.Schema { return w.schema }
+
+func (w *outputWriter) Flush() error {
+	if w.writer == nil {
+		return nil
+	}
+	return w.writer.Flush()
+}
+
+// Close finishes the current piece.  A closed writer starts a new piece if
+// more rows are written to it.
+func (w *outputWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	return w.closePiece()
+}
+
+func (w *outputWriter) written() int64 { return w.out.n }
+
+// names returns the names of the files written.
+func (w *outputWriter) names() []string {
+	names := make([]string, len(w.pieces))
+	for i, p := range w.pieces {
+		names[i] = p.name
+	}
+	return names
+}
+
+// report logs the files written when the output was split.
+func (w *outputWriter) report(logger *slog.Logger) {
+	if !w.split() {
+		return
+	}
+	logger.Info("wrote files", "files", len(w.pieces))
+	for _, p := range w.pieces {
+		logger.Info("wrote a file", "file", p.name, "rows", p.rows)
+	}
+}
+
+// streamWriter writes the merged rows to an io.Writer, such as stdout, as
+// a single parquet file.  Nothing is renamed and no file names are reported.
+type streamWriter struct {
+	mergeWriter
+	out *countingWriter
+}
+
+func newStreamWriter(w io.Writer, newWriter func(io.Writer) mergeWriter) *streamWriter {
+	out := &countingWriter{w: w}
+	return &streamWriter{mergeWriter: newWriter(out), out: out}
+}
+
+func (w *streamWriter) names() []string { return nil }
+
+func (w *streamWriter) written() int64 { return w.out.n }
+
+func (w *streamWriter) report(*slog.Logger) {}
+
+// defaultTemplate returns the -output-template for outfile: its name with
+// a five digit piece number before the extension.
+func defaultTemplate(outfile string) string {
+	ext := filepath.Ext(outfile)
+	return strings.ReplaceAll(strings.TrimSuffix(outfile, ext), "%", "%%") + "-%05d" + ext
+}
+
+// checkOutputTemplate checks that template formats a piece number.
+func checkOutputTemplate(template string) error {
+	a, b := fmt.Sprintf(template, 1), fmt.Sprintf(template, 2)
+	if a == b || strings.Contains(a, "%!") {
+		return fmt.Errorf("invalid -output-template %q: must contain one integer verb such as %%05d", template)
+	}
+	return nil
+}
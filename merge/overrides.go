@@ -0,0 +1,247 @@
+package merge
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// overrideNode returns the node of a -type-overrides type: a name of
+// nodemap or STRING, in any case.
+func overrideNode(name string) (parquet.Node, error) {
+	name = strings.ToUpper(name)
+	if name == "STRING" {
+		return string_node, nil
+	}
+	if node, ok := nodemap[name]; ok {
+		return node, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// overriddenNodes returns nodes with the columns in overrides given their
+// override types, which is how they are merged.  Overridden columns must be
+// non-repeated leaf columns.
+func overriddenNodes(nodes map[string]parquet.Node, overrides map[string]parquet.Node) (map[string]parquet.Node, error) {
+	var out map[string]parquet.Node
+	for k, node := range nodes {
+		override, ok := overrides[k]
+		if !ok {
+			continue
+		}
+		if !node.Leaf() || node.Repeated() {
+			return nil, fmt.Errorf("type override for %s: column is %s, not a non-repeated leaf", k, nodeTypeName(node))
+		}
+		if out == nil {
+			out = make(map[string]parquet.Node, len(nodes))
+			for k, v := range nodes {
+				out[k] = v
+			}
+		}
+		out[k] = override
+	}
+	if out == nil {
+		return nodes, nil
+	}
+	return out, nil
+}
+
+// castValue converts v, read as from, to the type of a -type-overrides
+// column, failing if it does not fit in the type or does not parse as it.
+func castValue(v any, from, to parquet.Node) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	typ := nodeTypeName(to)
+	switch typ {
+	case "STRING":
+		if nodeTypeName(from) == "UUID" {
+			return formatUUID(v)
+		}
+		return formatString(v, from)
+	case "INT8", "INT16", "INT32", "INT64":
+		bits := to.Type().LogicalType().Integer.BitWidth
+		n, err := castInt(v, int(bits))
+		if err != nil {
+			return nil, err
+		}
+		if to.Type().Kind() == parquet.Int32 {
+			return int32(n), nil
+		}
+		return n, nil
+	case "UINT8", "UINT16", "UINT32", "UINT64":
+		bits := to.Type().LogicalType().Integer.BitWidth
+		n, err := castUint(v, int(bits))
+		if err != nil {
+			return nil, err
+		}
+		if to.Type().Kind() == parquet.Int32 {
+			return int32(uint32(n)), nil
+		}
+		return int64(n), nil
+	case "FLOAT":
+		f, err := castFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if f32 := float32(f); !math.IsInf(f, 0) && math.IsInf(float64(f32), 0) {
+			return nil, fmt.Errorf("%v overflows FLOAT", v)
+		}
+		return float32(f), nil
+	case "DOUBLE":
+		return castFloat(v)
+	case "BOOLEAN":
+		return castBool(v)
+	case "BYTE_ARRAY", "BSON":
+		s, err := formatString(v, from)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(s.(string)), nil
+	case "ENUM", "JSON":
+		return formatString(v, from)
+	case "UUID":
+		return castUUID(v)
+	}
+	return nil, fmt.Errorf("cannot cast %T to %s", v, typ)
+}
+
+// castInt converts an integer, an integral float or a decimal string to a
+// signed integer of bits bits.
+func castInt(v any, bits int) (int64, error) {
+	var n int64
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows INT%d", v, bits)
+		}
+		n = int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an INT%d", v, bits)
+		}
+		n = int64(f)
+	case reflect.String:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(rv.String()), 10, 64); err != nil {
+			return 0, fmt.Errorf("%q is not an INT%d", v, bits)
+		}
+	default:
+		return 0, fmt.Errorf("cannot cast %T to INT%d", v, bits)
+	}
+	if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+		return 0, fmt.Errorf("%v overflows INT%d", v, bits)
+	}
+	return n, nil
+}
+
+// castUint converts a non-negative integer, an integral float or a decimal
+// string to an unsigned integer of bits bits.
+func castUint(v any, bits int) (uint64, error) {
+	var n uint64
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			return 0, fmt.Errorf("%v is negative, not a UINT%d", v, bits)
+		}
+		n = uint64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, fmt.Errorf("%v is not a UINT%d", v, bits)
+		}
+		n = uint64(f)
+	case reflect.String:
+		var err error
+		if n, err = strconv.ParseUint(strings.TrimSpace(rv.String()), 10, 64); err != nil {
+			return 0, fmt.Errorf("%q is not a UINT%d", v, bits)
+		}
+	default:
+		return 0, fmt.Errorf("cannot cast %T to UINT%d", v, bits)
+	}
+	if bits < 64 && n >= 1<<bits {
+		return 0, fmt.Errorf("%v overflows UINT%d", v, bits)
+	}
+	return n, nil
+}
+
+func castFloat(v any) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("cannot cast %T to a number", v)
+}
+
+func castBool(v any) (bool, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := rv.Int(); n == 0 || n == 1 {
+			return n == 1, nil
+		}
+	case reflect.String:
+		if b, err := strconv.ParseBool(strings.TrimSpace(rv.String())); err == nil {
+			return b, nil
+		}
+	default:
+		return false, fmt.Errorf("cannot cast %T to BOOLEAN", v)
+	}
+	return false, fmt.Errorf("%v is not a BOOLEAN", v)
+}
+
+// castUUID converts 16 bytes, or a string of 32 hex digits with or
+// without hyphens, to a UUID.
+func castUUID(v any) ([]byte, error) {
+	switch x := v.(type) {
+	case []byte:
+		if len(x) == 16 {
+			return x, nil
+		}
+	case string:
+		if b, err := hex.DecodeString(strings.ReplaceAll(x, "-", "")); err == nil && len(b) == 16 {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("cannot cast %T to UUID", v)
+	}
+	return nil, fmt.Errorf("%q is not a UUID", v)
+}
+
+// castNull returns the fileRows.castNull of file with -on-cast-error null:
+// it counts the values nulled and logs the first of them.
+func (m *Merger) castNull(file string) func(error) {
+	logged := false
+	return func(err error) {
+		m.castNulls.Add(1)
+		if !logged {
+			logged = true
+			m.log.Warn("writing null for a value that cannot be cast", "phase", "copy", "file", file, "error", err)
+		}
+	}
+}
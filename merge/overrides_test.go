@@ -0,0 +1,43 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTypeOverrides(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMixedInputs(t, dir)
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, files...)
+	opts.TypeOverrides = map[string]string{"id": "STRING", "name": "INT64"}
+	opts.OnCastError = "null"
+	stats := runMerge(t, opts)
+	_, rows := readParquet(t, out)
+	var ids []string
+	for _, row := range rows {
+		id, _ := row["id"].(string)
+		ids = append(ids, id)
+		if name, ok := row["name"]; ok && name != nil {
+			t.Errorf("row %s has the name %v, which does not parse as INT64", id, name)
+		}
+	}
+	if want := []string{"1", "2", "3", "4", "5", "6"}; !slices.Equal(ids, want) {
+		t.Errorf("merged the ids %q, want %q", ids, want)
+	}
+	if stats.CastNulls != 3 {
+		t.Errorf("counted %d values cast to null, want 3", stats.CastNulls)
+	}
+
+	opts.OnCastError = "fail"
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrRead) {
+		t.Errorf("-on-cast-error fail: error %v, want ErrRead", err)
+	}
+}
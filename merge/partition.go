@@ -0,0 +1,255 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// defaultPartition is the directory of rows whose partition column is null
+// or missing, as Hive names it.
+const defaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// partitionWriter writes rows to dir/column=value/part-NNNN.parquet by the
+// value of a top-level column.  At most maxOpen partitions are written at
+// once; when another is needed the least recently used one is closed, and
+// a later row for it starts its next part file.
+type partitionWriter struct {
+	dir       string
+	column    string
+	index     int
+	drop      bool
+	maxOpen   int
+	maxRows   int64
+	maxBytes  int64
+	schema    *parquet.Schema
+	newWriter func(io.Writer) mergeWriter
+	writers   map[string]*outputWriter
+	// open lists the partitions with open files, least recently used first.
+	open []string
+}
+
+func newPartitionWriter(dir, column string, schema *parquet.Schema, drop bool, maxOpen int, maxRows, maxBytes int64, newWriter func(io.Writer) mergeWriter) *partitionWriter {
+	leaf, _ := schema.Lookup(column)
+	return &partitionWriter{
+		dir:       dir,
+		column:    column,
+		index:     leaf.ColumnIndex,
+		drop:      drop,
+		maxOpen:   maxOpen,
+		maxRows:   maxRows,
+		maxBytes:  maxBytes,
+		schema:    schema,
+		newWriter: newWriter,
+		writers:   map[string]*outputWriter{},
+	}
+}
+
+// checkPartitionColumn checks that name is a top-level leaf column of nodes
+// that is not repeated.
+func checkPartitionColumn(nodes map[string]parquet.Node, name string) error {
+	node, ok := nodes[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("partition column %s is not in the merged schema", name)
+	case !node.Leaf():
+		return fmt.Errorf("partition column %s is not a leaf column", name)
+	case node.Repeated():
+		return fmt.Errorf("partition column %s is repeated", name)
+	}
+	return nil
+}
+
+// checkDroppedPartition checks that a partition column left out of the
+// files is not needed to write them, as the -sorted-by column sortedBy or
+// otherwise.
+func checkDroppedPartition(column, sortedBy string, sorting []parquet.SortingColumn, blooms []parquet.BloomFilterColumn) error {
+	if sortedBy == column {
+		return fmt.Errorf("cannot drop partition column %s: it is the -sorted-by column", column)
+	}
+	for _, s := range sorting {
+		if s.Path()[0] == column {
+			return fmt.Errorf("cannot drop partition column %s: it is a -sortby column", column)
+		}
+	}
+	for _, b := range blooms {
+		if b.Path()[0] == column {
+			return fmt.Errorf("cannot drop partition column %s: it is a -bloom-columns column", column)
+		}
+	}
+	return nil
+}
+
+func (w *partitionWriter) WriteRows(rows []parquet.Row) (int, error) {
+	for i := 0; i < len(rows); {
+		value := w.partition(rows[i])
+		j := i + 1
+		for j < len(rows) && w.partition(rows[j]) == value {
+			j++
+		}
+		out, err := w.writer(value)
+		if err != nil {
+			return i, err
+		}
+		batch := rows[i:j]
+		if w.drop {
+			batch = make([]parquet.Row, len(batch))
+			for k, row := range rows[i:j] {
+				batch[k] = dropColumn(row, w.index)
+			}
+		}
+		n, err := out.WriteRows(batch)
+		if err != nil {
+			return i + n, err
+		}
+		i = j
+	}
+	return len(rows), nil
+}
+
+// partition returns the directory name of the partition of row.
+func (w *partitionWriter) partition(row parquet.Row) string {
+	for _, v := range row {
+		if v.Column() != w.index {
+			continue
+		}
+		if v.IsNull() {
+			return defaultPartition
+		}
+		s := v.String()
+		if v.Kind() == parquet.Double {
+			s = strconv.FormatFloat(v.Double(), 'g', -1, 64)
+		}
+		if s == "" {
+			return defaultPartition
+		}
+		return escapePartition(s)
+	}
+	return defaultPartition
+}
+
+// writer returns the writer of partition value, opening it and closing the
+// least recently used partition if needed.
+func (w *partitionWriter) writer(value string) (*outputWriter, error) {
+	for i, v := range w.open {
+		if v == value {
+			copy(w.open[i:], w.open[i+1:])
+			w.open[len(w.open)-1] = value
+			return w.writers[value], nil
+		}
+	}
+	if len(w.open) >= w.maxOpen {
+		if err := w.writers[w.open[0]].Close(); err != nil {
+			return nil, err
+		}
+		w.open = w.open[1:]
+	}
+	w.open = append(w.open, value)
+	if out, ok := w.writers[value]; ok {
+		return out, nil
+	}
+	dir := filepath.Join(w.dir, w.column+"="+value)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	out := &outputWriter{
+		newWriter: w.newWriter,
+		template:  filepath.Join(dir, "part-%04d.parquet"),
+		maxRows:   w.maxRows,
+		maxBytes:  w.maxBytes,
+		out:       &countingWriter{},
+	}
+	if err := out.open(); err != nil {
+		return nil, err
+	}
+	w.writers[value] = out
+	return out, nil
+}
+
+func (w *partitionWriter) Schema() *parquet.Schema { return w.schema }
+
+func (w *partitionWriter) Flush() error {
+	for _, v := range w.open {
+		if err := w.writers[v].Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *partitionWriter) Close() error {
+	for _, v := range w.open {
+		if err := w.writers[v].Close(); err != nil {
+			return err
+		}
+	}
+	w.open = nil
+	return nil
+}
+
+func (w *partitionWriter) names() []string {
+	var names []string
+	for _, out := range w.writers {
+		names = append(names, out.names()...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *partitionWriter) written() int64 {
+	var n int64
+	for _, out := range w.writers {
+		n += out.written()
+	}
+	return n
+}
+
+func (w *partitionWriter) report(logger *slog.Logger) {
+	var pieces []outputPiece
+	for _, out := range w.writers {
+		pieces = append(pieces, out.pieces...)
+	}
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].name < pieces[j].name })
+	logger.Info("wrote files", "files", len(pieces), "partitions", len(w.writers))
+	for _, p := range pieces {
+		logger.Info("wrote a file", "file", p.name, "rows", p.rows)
+	}
+}
+
+// dropColumn returns a copy of row without the values of column, with the
+// later columns renumbered.
+func dropColumn(row parquet.Row, column int) parquet.Row {
+	out := make(parquet.Row, 0, len(row))
+	for _, v := range row {
+		switch c := v.Column(); {
+		case c == column:
+		case c > column:
+			out = append(out, v.Level(v.RepetitionLevel(), v.DefinitionLevel(), c-1))
+		default:
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// escapePartition escapes the characters of a partition value that cannot
+// be used in a directory name the way Hive does, as %XX.
+func escapePartition(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
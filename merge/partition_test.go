@@ -0,0 +1,31 @@
+package merge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPartitionBy(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "merged")
+		opts := testOptions(out, writeMixedInputs(t, dir)...)
+		opts.PartitionBy, opts.DropPartitionColumn = "zone", drop
+		runMerge(t, opts)
+		for zone, want := range map[string][]int64{
+			"eu":             {3},
+			"us":             {4},
+			defaultPartition: {1, 2, 5, 6},
+		} {
+			file := filepath.Join(out, "zone="+zone, "part-0000.parquet")
+			if got := outputIDs(t, file); !slices.Equal(got, want) {
+				t.Errorf("drop %t: %s holds the ids %v, want %v", drop, file, got, want)
+			}
+			schema, _ := readParquet(t, file)
+			if _, ok := schema.Lookup("zone"); ok == drop {
+				t.Errorf("drop %t: %s has a zone column: %t", drop, file, ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package merge
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// prefetchBatches is the number of batches of rows read ahead for each
+// prefetched file.
+const prefetchBatches = 2
+
+// rowSource is an input being copied.
+type rowSource interface {
+	parquet.RowReader
+	Close() error
+	// counts returns the number of rows read so far, kept or not, and the
+	// number the footer gives for the row groups read.
+	counts() (read, footer int64)
+}
+
+// prefetcher opens the inputs in order, up to ahead files beyond the one
+// being copied, and reads and converts their rows in the background.  Rows
+// are filtered as they are taken, so keep functions, which may have state,
+// still see the rows of every input in order.  With ahead 0 each input is
+// opened when it is wanted.  With more than one job, the row groups of the
+// inputs are split into units decoded by that many workers instead.
+type prefetcher struct {
+	m         *Merger
+	inputs    []inputFile
+	merged    *parquet.Schema
+	rowSchema *parquet.Schema
+	// opened counts the inputs opened without prefetching.
+	opened int
+	files  chan *prefetchedFile
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	jobs   int
+	work   chan *copyUnit
+}
+
+// startPrefetch starts reading inputs, -prefetch files ahead, or with
+// -copy-jobs workers.
+func (m *Merger) startPrefetch(inputs []inputFile, merged, rowSchema *parquet.Schema) *prefetcher {
+	ahead := m.opts.Prefetch
+	p := &prefetcher{m: m, inputs: inputs, merged: merged, rowSchema: rowSchema, jobs: m.opts.CopyJobs}
+	if p.jobs > 1 {
+		// The workers bound how far ahead rows are read; the files only
+		// carry their units to the reader.
+		p.files = make(chan *prefetchedFile, p.jobs)
+		p.work = make(chan *copyUnit)
+		p.stop = make(chan struct{})
+		p.wg.Add(1 + p.jobs)
+		go p.dispatch()
+		for i := 0; i < p.jobs; i++ {
+			go p.decode()
+		}
+		return p
+	}
+	if ahead > 0 {
+		// One more file is opened while the sender waits for room.
+		p.files = make(chan *prefetchedFile, ahead-1)
+		p.stop = make(chan struct{})
+		p.wg.Add(1)
+		go p.open()
+	}
+	return p
+}
+
+// open opens each input and starts reading it.
+func (p *prefetcher) open() {
+	defer p.wg.Done()
+	defer close(p.files)
+	for _, input := range p.inputs {
+		f := &prefetchedFile{keep: input.keep, redact: input.redact}
+		rows, err := p.m.openFileRows(input, p.merged, p.rowSchema)
+		if err != nil {
+			f.openErr = err
+		} else {
+			f.footer = rows.numRows
+			f.batches = make(chan rowBatch, prefetchBatches)
+			f.done = make(chan struct{})
+			p.wg.Add(1)
+			go p.read(f, rows)
+		}
+		select {
+		case p.files <- f:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// read sends batches of rows to f until the file ends, fails, or is closed.
+func (p *prefetcher) read(f *prefetchedFile, rows *fileRows) {
+	defer p.wg.Done()
+	defer close(f.batches)
+	p.send(f.batches, f.done, rows)
+}
+
+// send sends batches of rows until they end, fail, or done is closed, and
+// closes rows.
+func (p *prefetcher) send(batches chan<- rowBatch, done <-chan struct{}, rows *fileRows) {
+	defer rows.Close()
+	for {
+		batch := make([]parquet.Row, p.m.opts.BatchSize)
+		n, err := rows.readRows(batch)
+		if rows.direct {
+			// Rows read directly share their values with the pages they
+			// were read from, which are reused by the next read.
+			for i, row := range batch[:n] {
+				batch[i] = row.Clone()
+			}
+		}
+		select {
+		case batches <- rowBatch{rows: batch[:n], err: err}:
+		case <-done:
+			return
+		case <-p.stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next input.  It must be called once for each input, in
+// order.
+func (p *prefetcher) next() (rowSource, error) {
+	if p.files == nil {
+		input := p.inputs[p.opened]
+		p.opened++
+		return p.m.openFileRows(input, p.merged, p.rowSchema)
+	}
+	f := <-p.files
+	if f.openErr != nil {
+		return nil, f.openErr
+	}
+	return f, nil
+}
+
+// Close stops reading ahead and waits for every file to be closed.
+func (p *prefetcher) Close() {
+	if p.files == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// rowBatch is a batch of converted rows, and the error that ended it.
+type rowBatch struct {
+	rows []parquet.Row
+	err  error
+}
+
+// prefetchedFile is an input read ahead by a prefetcher, as one series of
+// batches or, with -copy-jobs, as units read one after the other.
+type prefetchedFile struct {
+	openErr error
+	batches chan rowBatch
+	units   chan *copyUnit
+	unit    *copyUnit
+	done    chan struct{}
+	keep    func(parquet.Row, int64) (bool, error)
+	redact  func(parquet.Row)
+	index   int64
+	pending []parquet.Row
+	err     error
+	// read and footer are returned by counts.
+	read, footer int64
+}
+
+// ReadRows returns rows from the prefetched batches, leaving out those
+// rejected by keep, and redacts those kept.
+func (f *prefetchedFile) ReadRows(rows []parquet.Row) (int, error) {
+	for {
+		if len(f.pending) == 0 && f.err == nil {
+			batch, ok := f.nextBatch()
+			if !ok {
+				return 0, io.EOF
+			}
+			f.pending, f.err = batch.rows, batch.err
+		}
+		n := copy(rows, f.pending)
+		f.pending = f.pending[n:]
+		f.read += int64(n)
+		var err error
+		if len(f.pending) == 0 {
+			err = f.err
+		}
+		if f.keep != nil {
+			kept, kerr := keepRows(rows[:n], f.keep, &f.index)
+			if kerr != nil {
+				return kept, kerr
+			}
+			n = kept
+		}
+		if f.redact != nil {
+			for _, row := range rows[:n] {
+				f.redact(row)
+			}
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// nextBatch returns the next batch of rows of the file, and false once
+// there are no more.
+func (f *prefetchedFile) nextBatch() (rowBatch, bool) {
+	if f.units == nil {
+		batch, ok := <-f.batches
+		return batch, ok
+	}
+	for {
+		if f.unit == nil {
+			u, ok := <-f.units
+			if !ok {
+				return rowBatch{}, false
+			}
+			f.unit = u
+		}
+		batch, ok := <-f.unit.batches
+		if !ok {
+			f.unit = nil
+			continue
+		}
+		// The end of a unit is not the end of the file.
+		if errors.Is(batch.err, io.EOF) {
+			batch.err = nil
+			if len(batch.rows) == 0 {
+				continue
+			}
+		}
+		return batch, true
+	}
+}
+
+func (f *prefetchedFile) counts() (read, footer int64) { return f.read, f.footer }
+
+// Close stops reading the file.  The reader closes it.
+func (f *prefetchedFile) Close() error {
+	close(f.done)
+	return nil
+}
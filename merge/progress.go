@@ -0,0 +1,152 @@
+package merge
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// progress reports how far a merge has got, every -progress-interval and
+// every -progress-rows rows.
+type progress struct {
+	files     int
+	totalRows int64
+	file      int
+	rows      int64
+	written   func() int64
+	quiet     bool
+	interval  time.Duration
+	everyRows int64
+	// json is where -progress-json lines are written, if they are.
+	json     io.Writer
+	log      *slog.Logger
+	start    time.Time
+	last     time.Time
+	lastRows int64
+	// flushedBytes is the size of the output when it last grew, with
+	// flushedRows rows written, and inputBytes the size of the inputs, to
+	// estimate the size of the rows not flushed yet.
+	flushedBytes, flushedRows int64
+	inputBytes                int64
+}
+
+// newProgress returns the progress of copying totalRows rows from files
+// files.  With -progress-json, progress is written to out.
+func (m *Merger) newProgress(files int, totalRows int64, written func() int64, out io.Writer) *progress {
+	now := time.Now()
+	p := &progress{
+		files:      files,
+		totalRows:  totalRows,
+		written:    written,
+		quiet:      m.opts.Quiet,
+		interval:   m.opts.ProgressInterval,
+		everyRows:  m.opts.ProgressRows,
+		log:        m.log.With("phase", "copy"),
+		start:      now,
+		last:       now,
+		inputBytes: m.stats.InputBytes,
+	}
+	if m.opts.ProgressJSON {
+		p.json = out
+	}
+	return p
+}
+
+// startFile records that the i'th of the input files, counting from 1, is
+// being copied.
+func (p *progress) startFile(i int) {
+	p.file = i
+}
+
+// wrote records that n more rows were written, and reports if it is time.
+func (p *progress) wrote(n int) {
+	p.rows += int64(n)
+	if w := p.written(); w > p.flushedBytes {
+		p.flushedBytes, p.flushedRows = w, p.rows
+	}
+	if p.quiet {
+		return
+	}
+	now := time.Now()
+	due := p.interval > 0 && now.Sub(p.last) >= p.interval
+	if p.everyRows > 0 && p.rows/p.everyRows != p.lastRows/p.everyRows {
+		due = true
+	}
+	if due {
+		p.report(now)
+	}
+}
+
+// estimatedBytes estimates the size of the output once the rows written so
+// far are flushed: the rows not flushed yet are counted at the bytes per
+// row of those flushed, or of the inputs before the first flush.
+func (p *progress) estimatedBytes() int64 {
+	pending := float64(p.rows - p.flushedRows)
+	switch {
+	case p.flushedRows > 0:
+		return p.flushedBytes + int64(pending*float64(p.flushedBytes)/float64(p.flushedRows))
+	case p.totalRows > 0:
+		return p.flushedBytes + int64(pending*float64(p.inputBytes)/float64(p.totalRows))
+	}
+	return p.flushedBytes
+}
+
+// finish reports the final counts.
+func (p *progress) finish() {
+	if !p.quiet {
+		p.report(time.Now())
+	}
+}
+
+func (p *progress) report(now time.Time) {
+	p.last, p.lastRows = now, p.rows
+	elapsed := now.Sub(p.start)
+	// The footer row counts include rows that filters and deduplication
+	// leave out, so the estimate is pessimistic when they are used.
+	var eta time.Duration
+	if p.rows > 0 && p.totalRows > p.rows {
+		eta = time.Duration(float64(elapsed) * float64(p.totalRows-p.rows) / float64(p.rows))
+	}
+	if p.json != nil {
+		line, _ := json.Marshal(struct {
+			File      int     `json:"file"`
+			Files     int     `json:"files"`
+			Rows      int64   `json:"rows"`
+			TotalRows int64   `json:"total_rows"`
+			Bytes     int64   `json:"bytes"`
+			Elapsed   float64 `json:"elapsed_seconds"`
+			ETA       float64 `json:"eta_seconds"`
+		}{p.file, p.files, p.rows, p.totalRows, p.written(), elapsed.Seconds(), eta.Seconds()})
+		p.json.Write(append(line, '\n'))
+		return
+	}
+	p.log.Info("progress", "file", p.file, "files", p.files, "rows", p.rows, "total_rows", p.totalRows,
+		"bytes", p.written(), "elapsed", elapsed.Round(time.Second), "eta", eta.Round(time.Second))
+}
+
+// progressWriter counts the rows written through it.
+type progressWriter struct {
+	mergeWriter
+	p *progress
+}
+
+func (w progressWriter) WriteRows(rows []parquet.Row) (int, error) {
+	n, err := w.mergeWriter.WriteRows(rows)
+	w.p.wrote(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the output file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
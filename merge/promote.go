@@ -0,0 +1,549 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// widenings lists, for each numeric type, the types its values can be
+// promoted to without loss, narrowest first.  DOUBLE is the last resort
+// for every integer type.
+var widenings = map[string][]string{
+	"INT8":   {"INT16", "INT32", "INT64", "DOUBLE"},
+	"INT16":  {"INT32", "INT64", "DOUBLE"},
+	"INT32":  {"INT64", "DOUBLE"},
+	"INT64":  {"DOUBLE"},
+	"UINT8":  {"UINT16", "UINT32", "UINT64", "DOUBLE"},
+	"UINT16": {"UINT32", "UINT64", "DOUBLE"},
+	"UINT32": {"UINT64", "DOUBLE"},
+	"UINT64": {"DOUBLE"},
+	"FLOAT":  {"DOUBLE"},
+}
+
+// schemaConflict reports two nodes at the same path that cannot be merged.
+type schemaConflict struct {
+	path string
+	a, b parquet.Node
+}
+
+func (c *schemaConflict) Error() string {
+	return fmt.Sprintf("schema mismatch: %s: %s vs %s", c.path, nodeTypeName(c.a), nodeTypeName(c.b))
+}
+
+// nodeTypeName returns the nodemap name for a leaf node, or the node's
+// signature if it is not one of ours.
+func nodeTypeName(node parquet.Node) string {
+	if node.Leaf() {
+		typ := node.Type().String()
+		if typ == string_node.Type().String() {
+			return "STRING"
+		}
+		for name, n := range nodemap {
+			if n.Type().String() == typ {
+				return name
+			}
+		}
+		return leafSignature(node)
+	}
+	return nodeSignature(node)
+}
+
+// leafSignature describes the physical and logical type of a leaf node.
+func leafSignature(node parquet.Node) string {
+	typ := node.Type()
+	s := typ.Kind().String()
+	if typ.Kind() == parquet.FixedLenByteArray {
+		s += fmt.Sprintf("(%d)", typ.Length())
+	}
+	if lt := typ.LogicalType(); lt != nil {
+		s += " " + lt.String()
+	}
+	return s
+}
+
+// nodeSignature describes a node structurally, so nodes built separately
+// from different files can be compared.  Fields are listed by name, since
+// files may store columns in any order.
+func nodeSignature(node parquet.Node) string {
+	return signature(node, true)
+}
+
+func signature(node parquet.Node, groupTypes bool) string {
+	var b strings.Builder
+	switch {
+	case node.Repeated():
+		b.WriteString("repeated ")
+	case node.Optional():
+		b.WriteString("optional ")
+	default:
+		b.WriteString("required ")
+	}
+	if node.Leaf() {
+		b.WriteString(leafSignature(node))
+		return b.String()
+	}
+	if lt := node.Type().LogicalType(); lt != nil && groupTypes {
+		b.WriteString(lt.String())
+		b.WriteString(" ")
+	}
+	fields := append([]parquet.Field(nil), node.Fields()...)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name() < fields[j].Name() })
+	b.WriteString("{")
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(f.Name())
+		b.WriteString(" ")
+		b.WriteString(signature(f, groupTypes))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func isList(node parquet.Node) bool {
+	if node.Leaf() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	return lt != nil && lt.List != nil
+}
+
+// listElement returns the element node of a LIST node built by parquet.List.
+func listElement(node parquet.Node) parquet.Node {
+	return node.Fields()[0].Fields()[0]
+}
+
+// listItems returns the list elements of v, a LIST value read with the
+// plain groups of plainNode, each a map holding the element.
+func listItems(v any) ([]map[string]any, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %T to list", v)
+	}
+	l, _ := m["list"].([]any)
+	items := make([]map[string]any, len(l))
+	for i := range l {
+		if items[i], ok = l[i].(map[string]any); !ok {
+			return nil, fmt.Errorf("[%d]: cannot convert %T to list element", i, l[i])
+		}
+	}
+	return items, nil
+}
+
+func isMap(node parquet.Node) bool {
+	if node.Leaf() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	return lt != nil && lt.Map != nil
+}
+
+// mapValue returns the value node of a MAP node built by parquet.Map.
+func mapValue(node parquet.Node) parquet.Node {
+	for _, f := range node.Fields()[0].Fields() {
+		if f.Name() == "value" {
+			return f
+		}
+	}
+	panic("map node without value field")
+}
+
+// rawJSONType reads JSON values as strings instead of unmarshalling them.
+type rawJSONType struct {
+	parquet.Type
+}
+
+func (t rawJSONType) AssignValue(dst reflect.Value, src parquet.Value) error {
+	if dst.Kind() == reflect.String {
+		dst.SetString(string(src.ByteArray()))
+		return nil
+	}
+	dst.Set(reflect.ValueOf(string(src.ByteArray())))
+	return nil
+}
+
+// plainNode returns node with MAP and LIST annotations stripped, leaving
+// the underlying repeated key_value and list groups, and with JSON values
+// read as strings so parquet-go does not unmarshal them.  parquet-go reads
+// and writes the elements of LIST groups as required, so their nulls only
+// survive in the plain groups.
+func plainNode(node parquet.Node) parquet.Node {
+	if node.Leaf() {
+		if nodeTypeName(node) == "JSON" {
+			return withRepetition(parquet.Leaf(rawJSONType{node.Type()}), node)
+		}
+		return node
+	}
+	if isList(node) {
+		list := parquet.Group{"element": plainNode(listElement(node))}
+		return withRepetition(parquet.Group{"list": parquet.Repeated(list)}, node)
+	}
+	fields := parquet.Group{}
+	for _, f := range node.Fields() {
+		fields[f.Name()] = plainNode(f)
+	}
+	return withRepetition(fields, node)
+}
+
+// withRepetition wraps node with the repetition of like.
+func withRepetition(node, like parquet.Node) parquet.Node {
+	switch {
+	case like.Repeated():
+		return parquet.Repeated(node)
+	case like.Optional():
+		return parquet.Optional(node)
+	default:
+		return parquet.Required(node)
+	}
+}
+
+func sameNode(a, b parquet.Node) bool {
+	return nodeSignature(a) == nodeSignature(b)
+}
+
+// relaxes reports whether to is from with some of its required fields made
+// optional, which parquet.Convert does without decoding values.  The LIST
+// and MAP annotations of groups are compared only if groupTypes is set,
+// since parquet-go does not report them for the schema of an opened file.
+func relaxes(from, to parquet.Node, groupTypes bool) bool {
+	if from.Repeated() != to.Repeated() || (from.Optional() && !to.Optional()) {
+		return false
+	}
+	if from.Leaf() || to.Leaf() {
+		return from.Leaf() && to.Leaf() && leafSignature(from) == leafSignature(to)
+	}
+	if groupTypes && (isList(from) != isList(to) || isMap(from) != isMap(to)) {
+		return false
+	}
+	if len(from.Fields()) != len(to.Fields()) {
+		return false
+	}
+	for _, f := range to.Fields() {
+		ff := fieldOf(from, f.Name())
+		if ff == nil || !relaxes(ff, f, groupTypes) {
+			return false
+		}
+	}
+	return true
+}
+
+// promotion holds the options that decide which differing types merge.
+type promotion struct {
+	strict, coerceDecimal, uuidAsString bool
+}
+
+func (m *Merger) promotion() promotion {
+	return promotion{strict: m.opts.Strict, coerceDecimal: m.opts.CoerceDecimal, uuidAsString: m.opts.UUIDAsString}
+}
+
+// mergeNode merges two nodes found under the same path.  Groups are merged
+// field by field, and differing leaves are promoted to a common type unless
+// rules.strict is set.  The result is required only if both nodes are.
+func mergeNode(path string, a, b parquet.Node, rules promotion) (parquet.Node, error) {
+	if sameNode(a, b) {
+		return a, nil
+	}
+	if a.Repeated() != b.Repeated() {
+		return nil, &schemaConflict{path: path, a: a, b: b}
+	}
+	merged, err := mergeType(path, parquet.Optional(a), parquet.Optional(b), rules)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case a.Repeated():
+		return parquet.Repeated(merged), nil
+	case a.Required() && b.Required():
+		return parquet.Required(merged), nil
+	default:
+		return parquet.Optional(merged), nil
+	}
+}
+
+// mergeType merges two optional nodes, ignoring their repetition.
+func mergeType(path string, a, b parquet.Node, rules promotion) (parquet.Node, error) {
+	if sameNode(a, b) {
+		return a, nil
+	}
+	if a.Leaf() && b.Leaf() {
+		if rules.strict {
+			return nil, &schemaConflict{path: path, a: a, b: b}
+		}
+		if isDecimal(a) || isDecimal(b) {
+			if !rules.coerceDecimal || !isDecimal(a) || !isDecimal(b) {
+				return nil, &schemaConflict{path: path, a: a, b: b}
+			}
+			merged, err := mergeDecimal(a, b)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return merged, nil
+		}
+		if isTemporal(a) || isTemporal(b) {
+			if !isTemporal(a) || !isTemporal(b) {
+				return nil, &schemaConflict{path: path, a: a, b: b}
+			}
+			merged, ok := mergeTemporal(a, b)
+			if !ok {
+				return nil, &schemaConflict{path: path, a: a, b: b}
+			}
+			return merged, nil
+		}
+		if rules.uuidAsString && isUUIDAndString(a, b) {
+			return string_node, nil
+		}
+		if isTextAndString(a, b) {
+			return string_node, nil
+		}
+		promoted, ok := promoteNode(a, b)
+		if !ok {
+			return nil, &schemaConflict{path: path, a: a, b: b}
+		}
+		return promoted, nil
+	}
+	if a.Leaf() || b.Leaf() || isList(a) != isList(b) {
+		return nil, &schemaConflict{path: path, a: a, b: b}
+	}
+	if isMap(a) != isMap(b) {
+		return nil, &schemaConflict{path: path, a: a, b: b}
+	}
+	if isMap(a) {
+		value, err := mergeNode(path+".value", mapValue(a), mapValue(b), rules)
+		if err != nil {
+			return nil, err
+		}
+		return parquet.Optional(parquet.Map(parquet.String(), value)), nil
+	}
+	if isList(a) {
+		elem, err := mergeNode(path+".element", listElement(a), listElement(b), rules)
+		if err != nil {
+			return nil, err
+		}
+		return parquet.Optional(parquet.List(elem)), nil
+	}
+
+	fields := map[string]parquet.Node{}
+	for _, f := range a.Fields() {
+		if fieldOf(b, f.Name()) == nil {
+			fields[f.Name()] = optionalField(f)
+			continue
+		}
+		fields[f.Name()] = f
+	}
+	for _, f := range b.Fields() {
+		current, ok := fields[f.Name()]
+		if !ok {
+			fields[f.Name()] = optionalField(f)
+			continue
+		}
+		merged, err := mergeNode(path+"."+f.Name(), current, f, rules)
+		if err != nil {
+			return nil, err
+		}
+		fields[f.Name()] = merged
+	}
+	return parquet.Optional(parquet.Group(fields)), nil
+}
+
+// optionalField demotes a required field that is missing on one side of a
+// merge.
+func optionalField(node parquet.Node) parquet.Node {
+	if node.Required() {
+		return parquet.Optional(node)
+	}
+	return node
+}
+
+// promoteNode returns the narrowest leaf node both a and b can be widened to.
+func promoteNode(a, b parquet.Node) (parquet.Node, bool) {
+	aname, bname := nodeTypeName(a), nodeTypeName(b)
+	if aname == bname {
+		return a, true
+	}
+	for _, t := range widenings[aname] {
+		if t == bname {
+			return b, true
+		}
+	}
+	for _, t := range widenings[bname] {
+		if t == aname {
+			return a, true
+		}
+	}
+	for _, ta := range widenings[aname] {
+		for _, tb := range widenings[bname] {
+			if ta == tb {
+				return nodemap[ta], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// conversion describes how values of a column read from one file must be
+// converted to match the merged schema.  cast is set for columns of
+// -type-overrides, whose values are cast by castValue, and unit for those
+// of -time-normalize, whose values are in that unit.
+type conversion struct {
+	from, to parquet.Node
+	cast     bool
+	unit     time.Duration
+}
+
+// fieldOf returns the field of a group node with the given name, or nil.
+func fieldOf(node parquet.Node, name string) parquet.Node {
+	for _, f := range node.Fields() {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// coerceValue converts a value read as from into the Go type the writer
+// expects for to, descending into groups.
+func coerceValue(v any, from, to parquet.Node) (any, error) {
+	if v == nil || from == nil {
+		return v, nil
+	}
+	if stringified(from, to) {
+		return formatString(v, from)
+	}
+	if to.Repeated() {
+		l, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to repeated", v)
+		}
+		for i := range l {
+			cv, err := coerceElement(l[i], from, to)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			l[i] = cv
+		}
+		return l, nil
+	}
+	return coerceElement(v, from, to)
+}
+
+func coerceElement(v any, from, to parquet.Node) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if isList(to) {
+		items, err := listItems(v)
+		if err != nil {
+			return nil, err
+		}
+		fromElem, toElem := listElement(from), listElement(to)
+		for i, item := range items {
+			cv, err := coerceValue(item["element"], fromElem, toElem)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			item["element"] = cv
+		}
+		return v, nil
+	}
+	if !to.Leaf() {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to group", v)
+		}
+		for _, f := range to.Fields() {
+			cv, err := coerceValue(m[f.Name()], fieldOf(from, f.Name()), f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			if cv != nil {
+				m[f.Name()] = cv
+			}
+		}
+		return m, nil
+	}
+	if isInt96(from) {
+		return convertInt96(v, to)
+	}
+	if isDecimal(to) {
+		return rescaleDecimal(v, from, to)
+	}
+	if isTemporal(to) {
+		return convertTimeUnit(v, from, to)
+	}
+	if to.Type().Kind() == parquet.FixedLenByteArray {
+		if b, ok := v.([]byte); ok && len(b) != to.Type().Length() {
+			return nil, fmt.Errorf("cannot convert %d bytes to %s", len(b), leafSignature(to))
+		}
+	}
+	typ := nodeTypeName(to)
+	if typ == "STRING" && nodeTypeName(from) == "UUID" {
+		return formatUUID(v)
+	}
+	// Unsigned values are read as the signed values of the same bits.
+	unsigned := strings.HasPrefix(nodeTypeName(from), "UINT")
+	switch typ {
+	case "INT64", "UINT64":
+		switch x := v.(type) {
+		case int32:
+			if unsigned {
+				return int64(uint32(x)), nil
+			}
+			return int64(x), nil
+		case int64:
+			return x, nil
+		}
+	case "DOUBLE":
+		switch x := v.(type) {
+		case int32:
+			if unsigned {
+				return float64(uint32(x)), nil
+			}
+			return float64(x), nil
+		case int64:
+			if unsigned {
+				return float64(uint64(x)), nil
+			}
+			return float64(x), nil
+		case float32:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		}
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to %s", v, typ)
+}
+
+// textTypes are byte array annotations whose values are valid strings.  A
+// column declared as one of these in one file and as STRING in another is
+// merged as plain STRING, dropping the more specific annotation.
+var textTypes = map[string]bool{
+	"JSON": true,
+	"ENUM": true,
+}
+
+func isTextAndString(a, b parquet.Node) bool {
+	an, bn := nodeTypeName(a), nodeTypeName(b)
+	return (textTypes[an] && bn == "STRING") || (an == "STRING" && textTypes[bn])
+}
+
+func isUUIDAndString(a, b parquet.Node) bool {
+	an, bn := nodeTypeName(a), nodeTypeName(b)
+	return (an == "UUID" && bn == "STRING") || (an == "STRING" && bn == "UUID")
+}
+
+// formatUUID renders a 16-byte UUID value in canonical hyphenated form.
+func formatUUID(v any) (any, error) {
+	b, ok := v.([]byte)
+	if !ok || len(b) != 16 {
+		return nil, fmt.Errorf("cannot convert %T of length %d to UUID string", v, len(b))
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
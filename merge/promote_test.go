@@ -0,0 +1,117 @@
+package merge
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestOptionalRequiredHostname(t *testing.T) {
+	dir := t.TempDir()
+	required := parquet.Group{"id": parquet.Int(64), "hostname": parquet.String()}
+	optional := parquet.Group{"id": parquet.Int(64), "hostname": parquet.Optional(parquet.String())}
+	files := map[string]string{}
+	for name, input := range map[string]struct {
+		g    parquet.Group
+		rows []map[string]any
+	}{
+		"req1": {required, []map[string]any{{"id": int64(1), "hostname": "a"}, {"id": int64(2), "hostname": "b"}}},
+		"req2": {required, []map[string]any{{"id": int64(3), "hostname": "c"}}},
+		"opt1": {optional, []map[string]any{{"id": int64(4), "hostname": "d"}, {"id": int64(5)}, {"id": int64(6), "hostname": "f"}}},
+		"opt2": {optional, []map[string]any{{"id": int64(7)}, {"id": int64(8)}}},
+	} {
+		files[name] = filepath.Join(dir, name+".parquet")
+		writeParquet(t, files[name], input.g, input.rows)
+	}
+	tests := []struct {
+		name     string
+		inputs   []string
+		optional bool
+		// hostnames are the values merged, in file name order, "" for a
+		// null.
+		hostnames []string
+		nulls     int64
+	}{
+		{"required only", []string{"req1", "req2"}, false, []string{"a", "b", "c"}, 0},
+		{"optional only", []string{"opt1", "opt2"}, true, []string{"d", "", "f", "", ""}, 3},
+		{"mixed", []string{"req1", "opt1", "req2", "opt2"}, true, []string{"d", "", "f", "", "", "a", "b", "c"}, 3},
+	}
+	for _, tt := range tests {
+		for _, noFastpath := range []bool{false, true} {
+			var inputs []string
+			for _, name := range tt.inputs {
+				inputs = append(inputs, files[name])
+			}
+			out := filepath.Join(dir, "merged.parquet")
+			opts := testOptions(out, inputs...)
+			opts.NoFastpath = noFastpath
+			runMerge(t, opts)
+
+			f := openOutput(t, out)
+			leaf, ok := f.Schema().Lookup("hostname")
+			if !ok {
+				t.Fatalf("%s: no hostname column", tt.name)
+			}
+			if got := leaf.Node.Optional(); got != tt.optional {
+				t.Errorf("%s: hostname is optional: %v, want %v", tt.name, got, tt.optional)
+			}
+			var nulls int64
+			for _, rg := range f.Metadata().RowGroups {
+				nulls += rg.Columns[leaf.ColumnIndex].MetaData.Statistics.NullCount
+			}
+			if nulls != tt.nulls {
+				t.Errorf("%s (no fastpath %v): column chunks count %d nulls, want %d", tt.name, noFastpath, nulls, tt.nulls)
+			}
+			_, rows := readParquet(t, out)
+			var hostnames []string
+			for _, row := range rows {
+				h, _ := row["hostname"].(string)
+				hostnames = append(hostnames, h)
+			}
+			if len(hostnames) != len(tt.hostnames) {
+				t.Fatalf("%s: merged hostnames %q, want %q", tt.name, hostnames, tt.hostnames)
+			}
+			for i := range hostnames {
+				if hostnames[i] != tt.hostnames[i] || (hostnames[i] == "") != (rows[i]["hostname"] == nil) {
+					t.Errorf("%s (no fastpath %v): row %d has hostname %#v, want %q", tt.name, noFastpath, i, rows[i]["hostname"], tt.hostnames[i])
+				}
+			}
+		}
+	}
+}
+
+func TestWidenUnsigned(t *testing.T) {
+	tests := []struct {
+		name   string
+		narrow parquet.Node
+		wide   parquet.Node
+		value  any
+		want   any
+	}{
+		{"uint32 to uint64", parquet.Uint(32), parquet.Uint(64), uint32(1<<31 + 5), int64(1<<31 + 5)},
+		{"uint32 to double", parquet.Uint(32), parquet.Leaf(parquet.DoubleType), uint32(1<<32 - 1), float64(1<<32 - 1)},
+		{"uint64 to double", parquet.Uint(64), parquet.Leaf(parquet.DoubleType), uint64(1<<63 + 5), float64(1<<63 + 5)},
+		{"int32 to int64", parquet.Int(32), parquet.Int(64), int32(-5), int64(-5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, b := filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet")
+			writeParquet(t, a, parquet.Group{"v": tt.narrow}, []map[string]any{{"v": tt.value}})
+			writeParquet(t, b, parquet.Group{"v": tt.wide}, []map[string]any{{"v": tt.want}})
+			out := filepath.Join(dir, "merged.parquet")
+			runMerge(t, testOptions(out, a, b))
+			_, rows := readParquet(t, out)
+			if len(rows) != 2 {
+				t.Fatalf("merged %d rows, want 2", len(rows))
+			}
+			for i, row := range rows {
+				if got := fmt.Sprint(row["v"]); got != fmt.Sprint(tt.want) {
+					t.Errorf("row %d has %s, want %v", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package merge
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/segmentio/encoding/thrift"
+)
+
+// footerStatistics is the part of a file footer telling which column
+// chunks have a null count, which parquet-go reads as 0 when it is missing.
+type footerStatistics struct {
+	// Declared only because the decoder cannot skip it.
+	Schema    []format.SchemaElement `thrift:"2"`
+	RowGroups []struct {
+		Columns []struct {
+			MetaData struct {
+				Statistics struct {
+					NullCount *int64 `thrift:"3"`
+				} `thrift:"12"`
+			} `thrift:"3"`
+		} `thrift:"1"`
+	} `thrift:"4"`
+}
+
+// nullCounted reads the footer of the file r, of size bytes, and returns
+// whether each column chunk has a null count, by row group, or nil if the
+// footer cannot be read.
+func nullCounted(r io.ReaderAt, size int64) [][]bool {
+	var tail [8]byte
+	if size < int64(len(tail)) {
+		return nil
+	}
+	if _, err := r.ReadAt(tail[:], size-8); err != nil {
+		return nil
+	}
+	n := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if n > size-8 {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, size-8-n); err != nil && err != io.EOF {
+		return nil
+	}
+	var footer footerStatistics
+	if err := thrift.Unmarshal(new(thrift.CompactProtocol), b, &footer); err != nil {
+		return nil
+	}
+	counted := make([][]bool, len(footer.RowGroups))
+	for i, rg := range footer.RowGroups {
+		counted[i] = make([]bool, len(rg.Columns))
+		for j, c := range rg.Columns {
+			counted[i][j] = c.MetaData.Statistics.NullCount != nil
+		}
+	}
+	return counted
+}
+
+// omitsZeroNullCount reports whether the writer of f leaves out a null
+// count of 0, as parquet-go, and the merger with it, do: the statistics
+// of its chunks always have min and max values, or a null count.
+func omitsZeroNullCount(f *parquet.File) bool {
+	createdBy := f.Metadata().CreatedBy
+	return createdBy == reproducibleCreatedBy ||
+		strings.HasPrefix(createdBy, "github.com/parquet-go/parquet-go") ||
+		strings.HasPrefix(createdBy, "github.com/segmentio/parquet-go")
+}
+
+// columnNonNull returns the non-null values of the leaf columns of f, by
+// their dotted paths under their output names, as the statistics of its
+// column chunks give them, or -1 for the columns whose statistics do not.
+// renamed maps output column names to their names in f, and counted, from
+// nullCounted, tells the chunks with a null count.
+func columnNonNull(f *parquet.File, renamed map[string]string, counted [][]bool) map[string]int64 {
+	names := make(map[string]string, len(renamed))
+	for out, old := range renamed {
+		names[old] = out
+	}
+	columns := f.Schema().Columns()
+	out := make(map[string]int64, len(columns))
+	paths := make([]string, len(columns))
+	for i, path := range columns {
+		if name, ok := names[path[0]]; ok {
+			path = append([]string{name}, path[1:]...)
+		}
+		paths[i] = strings.Join(path, ".")
+	}
+	omitsZero := omitsZeroNullCount(f)
+	for i, path := range columns {
+		leaf, _ := f.Schema().Lookup(path...)
+		var n int64
+		for j, rg := range f.Metadata().RowGroups {
+			chunk := rg.Columns[i].MetaData
+			stats := chunk.Statistics
+			hasNullCount := j < len(counted) && i < len(counted[j]) && counted[j][i]
+			if !hasNullCount && omitsZero {
+				hasNullCount = len(stats.MinValue) > 0 || len(stats.MaxValue) > 0 || len(stats.Min) > 0 || len(stats.Max) > 0
+			}
+			switch {
+			case leaf.MaxDefinitionLevel == 0:
+				n += chunk.NumValues
+			case chunk.NumValues == 0 || hasNullCount:
+				n += chunk.NumValues - stats.NullCount
+			default:
+				n = -1
+			}
+			if n < 0 {
+				break
+			}
+		}
+		out[paths[i]] = n
+	}
+	return out
+}
+
+// uncounted reports whether the statistics of sf leave values of its
+// columns to be counted as it is copied, for -report.
+func uncounted(sf scannedFile) bool {
+	for _, n := range sf.nonNull {
+		if n < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// countNonNull adds the non-null values of rows to counts, by leaf column.
+func countNonNull(counts []int64, rows []parquet.Row) {
+	for _, row := range rows {
+		for _, v := range row {
+			if !v.IsNull() {
+				counts[v.Column()]++
+			}
+		}
+	}
+}
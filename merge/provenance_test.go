@@ -0,0 +1,60 @@
+package merge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// nonNullOf returns the non-null values of the columns of the file path, as
+// columnNonNull returns them.
+func nonNullOf(t *testing.T, path string) map[string]int64 {
+	t.Helper()
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(r, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columnNonNull(f, nil, nullCounted(r, info.Size()))
+}
+
+func TestColumnNonNull(t *testing.T) {
+	dir := t.TempDir()
+	g := parquet.Group{"id": parquet.Int(64), "name": parquet.Optional(parquet.String()), "note": parquet.Optional(parquet.String())}
+	rows := []map[string]any{{"id": int64(1), "name": "one"}, {"id": int64(2), "name": "two", "note": "x"}}
+
+	ours := filepath.Join(dir, "ours.parquet")
+	writeParquet(t, ours, g, rows)
+	if got := nonNullOf(t, ours); got["id"] != 2 || got["name"] != 2 || got["note"] != 1 {
+		t.Errorf("non-null values of a parquet-go file = %v, want id 2, name 2 and note 1", got)
+	}
+
+	// Another writer may leave out the null count of a chunk with min and
+	// max values, which then does not show it has no null.
+	other := filepath.Join(dir, "other.parquet")
+	f, err := os.Create(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := parquet.NewGenericWriter[map[string]any](f, parquet.NewSchema("test", g), parquet.CreatedBy("other", "1.0", ""))
+	if _, err := w.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := nonNullOf(t, other); got["id"] != 2 || got["name"] != -1 || got["note"] != 1 {
+		t.Errorf("non-null values of a file without null counts = %v, want id 2, name -1 and note 1", got)
+	}
+}
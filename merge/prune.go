@@ -0,0 +1,156 @@
+package merge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupPruner skips the row groups of the inputs whose column
+// statistics show that none of their rows match -where or the time range,
+// so they are never decoded.
+type rowGroupPruner struct {
+	where *whereExpr
+	// schema is the schema -where is compiled against.
+	schema *parquet.Schema
+	times  *timeRange
+	// retention, if set, skips the row groups past it, which it counts.
+	retention *retention
+	// groups records each row group looked at, by file and index, and
+	// whether it was pruned; inputs read twice are only counted once.
+	groups map[string]prunedGroup
+}
+
+type prunedGroup struct {
+	pruned bool
+	rows   int64
+}
+
+// skipper returns a function reporting whether row group i of file can be
+// skipped.  renamed maps output column names to their names in the file.
+func (p *rowGroupPruner) skipper(file string, renamed map[string]string) func(pf *parquet.File, i int) bool {
+	// -after, -before and -retain-days all apply to -time-column.
+	timeColumn := ""
+	if p.times != nil {
+		timeColumn = p.times.column
+	} else if p.retention != nil {
+		timeColumn = p.retention.times.column
+	}
+	return func(pf *parquet.File, i int) bool {
+		// Row groups past retention are counted as such even if they
+		// would be pruned anyway.
+		skip := p.retention != nil && p.retention.skip(file, pf, i, timeColumn, renamed)
+		if !skip && p.times != nil {
+			skip = p.times.skip(pf, i, timeColumn, renamed)
+		}
+		if !skip && p.where != nil {
+			skip = !p.where.mayMatch(p.schema, pf, i, renamed)
+		}
+		if p.groups == nil {
+			p.groups = map[string]prunedGroup{}
+		}
+		p.groups[fmt.Sprintf("%s#%d", file, i)] = prunedGroup{pruned: skip, rows: pf.Metadata().RowGroups[i].NumRows}
+		return skip
+	}
+}
+
+func (p *rowGroupPruner) report(logger *slog.Logger) {
+	pruned, rows := 0, int64(0)
+	for _, g := range p.groups {
+		if g.pruned {
+			pruned++
+			rows += g.rows
+		}
+	}
+	logger.Info("pruned row groups by their statistics", "pruned", pruned, "row_groups", len(p.groups), "rows_skipped", rows)
+}
+
+// mayMatch reports whether rows of row group i of pf may match e, judging
+// by the min/max statistics of its columns, compared as the columns of
+// schema are.  It answers true whenever it cannot tell: when statistics
+// are missing, or the column is not in the file or has another type there.
+func (e *whereExpr) mayMatch(schema *parquet.Schema, pf *parquet.File, i int, renamed map[string]string) bool {
+	switch e.op {
+	case "AND":
+		return e.left.mayMatch(schema, pf, i, renamed) && e.right.mayMatch(schema, pf, i, renamed)
+	case "OR":
+		return e.left.mayMatch(schema, pf, i, renamed) || e.right.mayMatch(schema, pf, i, renamed)
+	}
+	leaf, ok := lookupColumn(schema, e.column)
+	if !ok {
+		// The column is null in every row, see compile.
+		return false
+	}
+	path := schema.Columns()[leaf.ColumnIndex]
+	if old, ok := renamed[path[0]]; ok {
+		path = append([]string{old}, path[1:]...)
+	}
+	fileLeaf, ok := pf.Schema().Lookup(path...)
+	if !ok || leafSignature(fileLeaf.Node) != leafSignature(leaf.Node) {
+		return true
+	}
+	typ := leaf.Node.Type()
+	literal, err := literalValue(typ, e.literal, e.quoted)
+	if err != nil {
+		return true
+	}
+	chunk := pf.Metadata().RowGroups[i].Columns[fileLeaf.ColumnIndex].MetaData
+	if chunk.NumValues > 0 && chunk.Statistics.NullCount == chunk.NumValues {
+		// Comparisons with null are false.
+		return false
+	}
+	min, okMin := statisticValue(typ, chunk.Statistics.MinValue)
+	max, okMax := statisticValue(typ, chunk.Statistics.MaxValue)
+	if !okMin || !okMax {
+		return true
+	}
+	switch e.op {
+	case "==":
+		return typ.Compare(min, literal) <= 0 && typ.Compare(max, literal) >= 0
+	case "!=":
+		return typ.Compare(min, literal) != 0 || typ.Compare(max, literal) != 0
+	case "<":
+		return typ.Compare(min, literal) < 0
+	case "<=":
+		return typ.Compare(min, literal) <= 0
+	case ">":
+		return typ.Compare(max, literal) > 0
+	default:
+		return typ.Compare(max, literal) >= 0
+	}
+}
+
+// statisticValue decodes a min/max statistic of a column of type typ,
+// stored in its plain encoding.
+func statisticValue(typ parquet.Type, b []byte) (parquet.Value, bool) {
+	switch typ.Kind() {
+	case parquet.Boolean:
+		if len(b) == 1 {
+			return parquet.ValueOf(b[0] != 0), true
+		}
+	case parquet.Int32:
+		if len(b) == 4 {
+			return parquet.ValueOf(int32(binary.LittleEndian.Uint32(b))), true
+		}
+	case parquet.Int64:
+		if len(b) == 8 {
+			return parquet.ValueOf(int64(binary.LittleEndian.Uint64(b))), true
+		}
+	case parquet.Float:
+		if len(b) == 4 {
+			return parquet.ValueOf(math.Float32frombits(binary.LittleEndian.Uint32(b))), true
+		}
+	case parquet.Double:
+		if len(b) == 8 {
+			return parquet.ValueOf(math.Float64frombits(binary.LittleEndian.Uint64(b))), true
+		}
+	case parquet.ByteArray:
+		if b != nil {
+			return parquet.ByteArrayValue(b), true
+		}
+	}
+	return parquet.Value{}, false
+}
@@ -0,0 +1,131 @@
+package merge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// redactDropped returns the columns -redact drops.
+func redactDropped(redact map[string]string) []string {
+	var out []string
+	for _, name := range sortedStrings(redact) {
+		if redact[name] == "drop" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// redactValues returns the function replacing, in place, the values of the
+// sha256 and mask columns of a row of schema, the merged schema, or nil if
+// there are none.
+func redactValues(schema *parquet.Schema, redact map[string]string, salt string) (func(parquet.Row), error) {
+	columns := make([]func([]byte) []byte, len(schema.Columns()))
+	redacts := false
+	for _, name := range sortedStrings(redact) {
+		method := redact[name]
+		if method == "drop" {
+			if fieldOf(schema, name) == nil {
+				return nil, fmt.Errorf("redact column %s is not in any input file", name)
+			}
+			continue
+		}
+		leaf, ok := lookupColumn(schema, name)
+		if !ok {
+			return nil, fmt.Errorf("redact column %s is not a leaf column of any input file", name)
+		}
+		if leaf.Node.Type().Kind() != parquet.ByteArray {
+			return nil, fmt.Errorf("redact column %s is %s, but only BYTE_ARRAY columns can be hashed or masked", name, leafSignature(leaf.Node))
+		}
+		if method == "sha256" {
+			columns[leaf.ColumnIndex] = func(b []byte) []byte { return hashValue(salt, b) }
+		} else {
+			columns[leaf.ColumnIndex] = maskValue
+		}
+		redacts = true
+	}
+	if !redacts {
+		return nil, nil
+	}
+	return func(row parquet.Row) {
+		for i, v := range row {
+			if redact := columns[v.Column()]; redact != nil && !v.IsNull() {
+				row[i] = parquet.ByteArrayValue(redact(v.ByteArray())).Level(v.RepetitionLevel(), v.DefinitionLevel(), v.Column())
+			}
+		}
+	}, nil
+}
+
+// hashValue returns the hex SHA-256 digest of b, prefixed with salt.
+func hashValue(salt string, b []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write(b)
+	return hex.AppendEncode(nil, h.Sum(nil))
+}
+
+// maskValue replaces every character of b but the first and last with *,
+// and all of them if there are no more than two.
+func maskValue(b []byte) []byte {
+	n := utf8.RuneCount(b)
+	out := make([]byte, 0, len(b))
+	i := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if n > 2 && (i == 0 || i == n-1) {
+			out = utf8.AppendRune(out, r)
+		} else {
+			out = append(out, '*')
+		}
+		b = b[size:]
+		i++
+	}
+	return out
+}
+
+// columnDropper writes rows of schema to its output, whose schema lacks the
+// columns -redact drops.
+type columnDropper struct {
+	mergeOutput
+	schema *parquet.Schema
+	// columns maps the leaf columns of schema to those of the output, or
+	// to -1 if they are dropped.
+	columns []int
+	rows    []parquet.Row
+}
+
+func newColumnDropper(output mergeOutput, schema, outSchema *parquet.Schema) *columnDropper {
+	d := &columnDropper{mergeOutput: output, schema: schema}
+	for _, path := range schema.Columns() {
+		column := -1
+		if leaf, ok := outSchema.Lookup(path...); ok {
+			column = leaf.ColumnIndex
+		}
+		d.columns = append(d.columns, column)
+	}
+	return d
+}
+
+// Schema returns the schema of the rows written, with the dropped columns.
+func (d *columnDropper) Schema() *parquet.Schema { return d.schema }
+
+func (d *columnDropper) WriteRows(rows []parquet.Row) (int, error) {
+	if cap(d.rows) < len(rows) {
+		d.rows = make([]parquet.Row, len(rows))
+	}
+	out := d.rows[:len(rows)]
+	for i, row := range rows {
+		o := out[i][:0]
+		for _, v := range row {
+			if c := d.columns[v.Column()]; c >= 0 {
+				o = append(o, v.Level(v.RepetitionLevel(), v.DefinitionLevel(), c))
+			}
+		}
+		out[i] = o
+	}
+	return d.mergeOutput.WriteRows(out)
+}
@@ -0,0 +1,45 @@
+package merge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, writeMixedInputs(t, dir)...)
+	opts.Redact = map[string]string{"name": "mask", "zone": "sha256", "score": "drop"}
+	opts.RedactSalt = "salt"
+	// Filters see the values before they are redacted.
+	opts.Where = `name == "one" OR id > 2`
+	stats := runMerge(t, opts)
+	if got, want := outputIDs(t, out), []int64{1, 3, 4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("merged the ids %v, want %v", got, want)
+	}
+	schema, rows := readParquet(t, out)
+	if _, ok := schema.Lookup("score"); ok {
+		t.Error("the output has the dropped score column")
+	}
+	eu := sha256.Sum256([]byte("salteu"))
+	want := map[int64][2]any{
+		1: {"o*e", nil},
+		3: {nil, hex.EncodeToString(eu[:])},
+		5: {"f**e", nil},
+	}
+	for _, row := range rows {
+		w, ok := want[row["id"].(int64)]
+		if !ok {
+			continue
+		}
+		if row["name"] != w[0] || row["zone"] != w[1] {
+			t.Errorf("row %v has name %v and zone %v, want %v and %v", row["id"], row["name"], row["zone"], w[0], w[1])
+		}
+	}
+	if len(stats.Redacted) != 3 {
+		t.Errorf("the stats list the redacted columns %v", stats.Redacted)
+	}
+}
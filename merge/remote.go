@@ -0,0 +1,284 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// inputReader is an opened input file.
+type inputReader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// isRemote reports whether name is an http or https URL.
+func isRemote(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// openInput opens the input file name, a local path or an http or https
+// URL, and returns it with its size and modification time.  Local files
+// wait for a place in the open file budget.
+func (m *Merger) openInput(name string) (inputReader, int64, time.Time, error) {
+	if isRemote(name) {
+		f, err := m.openHTTPFile(name)
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		return f, f.size, f.modTime, nil
+	}
+	m.openFiles.acquire()
+	r, size, modTime, err := openLocal(name)
+	if err != nil {
+		m.openFiles.release()
+		return nil, 0, time.Time{}, err
+	}
+	return &budgetedInput{inputReader: r, budget: m.openFiles}, size, modTime, nil
+}
+
+// openLocal opens the local file name and returns it with its size and
+// modification time.
+func openLocal(name string) (inputReader, int64, time.Time, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, time.Time{}, err
+	}
+	return f, stat.Size(), stat.ModTime(), nil
+}
+
+// openInputFile opens the parquet file name, a local path or an http or
+// https URL.
+func (m *Merger) openInputFile(name string, options ...parquet.FileOption) (*parquet.File, io.Closer, error) {
+	r, size, _, err := m.openInput(name)
+	if err != nil {
+		return nil, nil, markError(ErrRead, err)
+	}
+	pf, closer, err := openParquetReader(r, size, options...)
+	return pf, closer, markError(ErrRead, err)
+}
+
+// openParquetReader opens the parquet file in r, closing r if it cannot.
+func openParquetReader(r inputReader, size int64, options ...parquet.FileOption) (*parquet.File, io.Closer, error) {
+	pf, err := parquet.OpenFile(r, size, options...)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	return pf, r, nil
+}
+
+// httpFile reads a remote file with ranged GET requests of whole blocks of
+// -http-block-size bytes, keeping the -http-cache-blocks most recently used
+// blocks.
+type httpFile struct {
+	url       string
+	size      int64
+	modTime   time.Time
+	blockSize int64
+	maxBlocks int
+	retries   int
+	log       *slog.Logger
+	// client makes the requests, canceled with ctx.
+	client *http.Client
+	ctx    context.Context
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	// used lists the cached block numbers, least recently used first.
+	used []int64
+}
+
+// errTransient marks request failures that are worth retrying.
+var errTransient = errors.New("transient error")
+
+// openHTTPFile finds the size of url with a HEAD request and reads the
+// blocks holding the footer with a single GET, so that parquet.OpenFile
+// needs no more requests in the common case.
+func (m *Merger) openHTTPFile(url string) (*httpFile, error) {
+	f := &httpFile{
+		url:       url,
+		blockSize: m.opts.HTTPBlockSize,
+		maxBlocks: m.opts.HTTPCacheBlocks,
+		retries:   m.opts.HTTPRetries,
+		log:       m.log,
+		client:    m.http,
+		ctx:       m.ctx,
+		blocks:    map[int64][]byte{},
+	}
+	err := f.retry(func() error {
+		req, err := http.NewRequestWithContext(f.ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errTransient, err)
+		}
+		resp.Body.Close()
+		if err := checkStatus(url, resp, http.StatusOK); err != nil {
+			return err
+		}
+		if resp.ContentLength < 0 {
+			return fmt.Errorf("%s: server did not report the file size", url)
+		}
+		f.size = resp.ContentLength
+		if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			f.modTime = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.size > 0 {
+		last := (f.size - 1) / f.blockSize
+		first := max(f.size-f.blockSize, 0) / f.blockSize
+		if err := f.fetch(first, last); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < f.size {
+		block, err := f.block(off / f.blockSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], block[off%f.blockSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *httpFile) Close() error { return nil }
+
+// block returns block b, reading it if it is not cached.
+func (f *httpFile) block(b int64) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if data, ok := f.blocks[b]; ok {
+		f.touch(b)
+		return data, nil
+	}
+	if err := f.fetchLocked(b, b); err != nil {
+		return nil, err
+	}
+	return f.blocks[b], nil
+}
+
+// fetch reads blocks first to last with one request and caches them.
+func (f *httpFile) fetch(first, last int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetchLocked(first, last)
+}
+
+func (f *httpFile) fetchLocked(first, last int64) error {
+	start, end := first*f.blockSize, min((last+1)*f.blockSize, f.size)
+	data := make([]byte, end-start)
+	err := f.retry(func() error {
+		req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errTransient, err)
+		}
+		defer resp.Body.Close()
+		if err := checkStatus(f.url, resp, http.StatusPartialContent); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(resp.Body, data); err != nil {
+			return fmt.Errorf("%w: %s: reading bytes %d-%d: %v", errTransient, f.url, start, end-1, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for b := first; b <= last; b++ {
+		off := (b - first) * f.blockSize
+		f.blocks[b] = data[off:min(off+f.blockSize, int64(len(data)))]
+		f.touch(b)
+	}
+	for len(f.used) > f.maxBlocks {
+		delete(f.blocks, f.used[0])
+		f.used = f.used[1:]
+	}
+	return nil
+}
+
+// touch marks block b as the most recently used.
+func (f *httpFile) touch(b int64) {
+	for i, u := range f.used {
+		if u == b {
+			f.used = append(f.used[:i], f.used[i+1:]...)
+			break
+		}
+	}
+	f.used = append(f.used, b)
+}
+
+// retry calls do until it succeeds, returns an error that is not
+// transient, or has failed -http-retries more times, doubling the wait
+// between attempts.  It gives up as soon as the context is canceled.
+func (f *httpFile) retry(do func() error) error {
+	wait := 250 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := do()
+		if err != nil && f.ctx.Err() != nil {
+			return fmt.Errorf("%s: %w", f.url, f.ctx.Err())
+		}
+		if err == nil || !errors.Is(err, errTransient) || attempt >= f.retries {
+			return err
+		}
+		f.log.Warn("retrying", "url", f.url, "wait", wait, "error", err)
+		select {
+		case <-f.ctx.Done():
+			return fmt.Errorf("%s: %w", f.url, f.ctx.Err())
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// checkStatus returns an error if resp does not have status want.  Server
+// errors and 429 Too Many Requests are transient.
+func checkStatus(url string, resp *http.Response, want int) error {
+	switch {
+	case resp.StatusCode == want:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s: %s", errTransient, url, resp.Status)
+	case want == http.StatusPartialContent && resp.StatusCode == http.StatusOK:
+		return fmt.Errorf("%s: server does not support range requests", url)
+	}
+	return fmt.Errorf("%s: %s", url, resp.Status)
+}
@@ -0,0 +1,63 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingServer returns a server that answers no request until the test
+// ends.
+func stallingServer(t *testing.T) *httptest.Server {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	srv := stallingServer(t)
+	opts := testOptions("merged.parquet", srv.URL+"/in.parquet")
+	opts.HTTPTimeout = 50 * time.Millisecond
+	opts.HTTPRetries = 1
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := m.openHTTPFile(srv.URL + "/in.parquet"); !errors.Is(err, errTransient) {
+		t.Errorf("openHTTPFile error = %v, want a transient one", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("openHTTPFile took %v", d)
+	}
+}
+
+func TestHTTPCanceled(t *testing.T) {
+	srv := stallingServer(t)
+	m, err := New(testOptions("merged.parquet", srv.URL+"/in.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.ctx = ctx
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := m.openHTTPFile(srv.URL + "/in.parquet"); !errors.Is(err, context.Canceled) {
+		t.Errorf("openHTTPFile error = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("openHTTPFile took %v", d)
+	}
+}
@@ -0,0 +1,143 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// requiredField is one -requireFields entry: a field name and, optionally,
+// the type it must have.
+type requiredField struct {
+	name, typ string
+}
+
+func (f requiredField) String() string {
+	if f.typ == "" {
+		return f.name
+	}
+	return f.name + ":" + f.typ
+}
+
+// parseRequireFields parses -requireFields: groups of comma separated
+// name or name:type entries, separated by |.  A file is merged if it has
+// every field of any one group.  Space around entries, and empty entries
+// and groups, are ignored, so an empty s requires nothing.
+func parseRequireFields(s string) ([][]requiredField, error) {
+	var groups [][]requiredField
+	for _, group := range strings.Split(s, "|") {
+		var fields []requiredField
+		seen := map[string]bool{}
+		for _, entry := range strings.Split(group, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			name, typ, _ := strings.Cut(entry, ":")
+			name, typ = strings.TrimSpace(name), strings.TrimSpace(typ)
+			if err := checkFieldName(name); err != nil {
+				return nil, fmt.Errorf("invalid -requireFields entry %q: %w", entry, err)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("invalid -requireFields: %s is listed twice in a group", name)
+			}
+			seen[name] = true
+			fields = append(fields, requiredField{name: name, typ: typ})
+		}
+		if len(fields) > 0 {
+			groups = append(groups, fields)
+		}
+	}
+	return groups, nil
+}
+
+// checkFieldName returns an error if name cannot be the name of a column:
+// if it is empty, not UTF-8, or has control characters.
+func checkFieldName(name string) error {
+	if name == "" {
+		return errors.New("no field name")
+	}
+	if !utf8.ValidString(name) {
+		return errors.New("field name is not valid UTF-8")
+	}
+	if i := strings.IndexFunc(name, unicode.IsControl); i >= 0 {
+		return fmt.Errorf("field name has the control character %q", name[i])
+	}
+	return nil
+}
+
+// hasType reports whether node is of type typ, which may name the merger
+// type (INT64, STRING, UUID), the physical type, or the logical type
+// (TIMESTAMP, DECIMAL, LIST) of the node, in any case.
+func hasType(node parquet.Node, typ string) bool {
+	name := nodeTypeName(node)
+	if strings.EqualFold(name, typ) {
+		return true
+	}
+	if node.Leaf() && strings.EqualFold(node.Type().Kind().String(), typ) {
+		return true
+	}
+	if lt := node.Type().LogicalType(); lt != nil {
+		logical, _, _ := strings.Cut(lt.String(), "(")
+		return strings.EqualFold(logical, typ)
+	}
+	return false
+}
+
+// matchRequired returns the index of the first group whose fields are all
+// in nodes with the required types, or -1 and a description of what each
+// group is missing.  Without groups, every file matches.
+func matchRequired(nodes map[string]parquet.Node, groups [][]requiredField) (int, string) {
+	if len(groups) == 0 {
+		return 0, ""
+	}
+	var reasons []string
+	for i, group := range groups {
+		missing := missingFields(nodes, group)
+		if len(missing) == 0 {
+			return i, ""
+		}
+		reasons = append(reasons, fmt.Sprintf("group %d (%s) is missing %s", i+1, joinFields(group), strings.Join(missing, ",")))
+	}
+	return -1, strings.Join(reasons, "; ")
+}
+
+// missingFields returns the fields of group that nodes lacks, or has with
+// another type.
+func missingFields(nodes map[string]parquet.Node, group []requiredField) []string {
+	var missing []string
+	for _, field := range group {
+		node, ok := nodes[field.name]
+		switch {
+		case !ok:
+			missing = append(missing, field.name)
+		case field.typ != "" && !hasType(node, field.typ):
+			missing = append(missing, fmt.Sprintf("%s as %s (it is %s)", field.name, field.typ, nodeTypeName(node)))
+		}
+	}
+	return missing
+}
+
+func joinFields(fields []requiredField) string {
+	s := make([]string, len(fields))
+	for i, f := range fields {
+		s[i] = f.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// hasTypedFields reports whether any entry of groups has a type.
+func hasTypedFields(groups [][]requiredField) bool {
+	for _, group := range groups {
+		for _, f := range group {
+			if f.typ != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
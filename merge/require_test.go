@@ -0,0 +1,59 @@
+package merge
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequireFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want [][]requiredField
+		err  string
+	}{
+		{in: "", want: nil},
+		{in: " , ,| ", want: nil},
+		{in: "id", want: [][]requiredField{{{name: "id"}}}},
+		{in: " id , name ", want: [][]requiredField{{{name: "id"}, {name: "name"}}}},
+		{in: "id,,name,", want: [][]requiredField{{{name: "id"}, {name: "name"}}}},
+		{in: "id:int64, ts : TIMESTAMP", want: [][]requiredField{{{name: "id", typ: "int64"}, {name: "ts", typ: "TIMESTAMP"}}}},
+		{in: "id:", want: [][]requiredField{{{name: "id"}}}},
+		{in: "id|uuid:UUID, name", want: [][]requiredField{{{name: "id"}}, {{name: "uuid", typ: "UUID"}, {name: "name"}}}},
+		{in: "id||name|", want: [][]requiredField{{{name: "id"}}, {{name: "name"}}}},
+		{in: "id|id", want: [][]requiredField{{{name: "id"}}, {{name: "id"}}}},
+		{in: "a.b.c:string", want: [][]requiredField{{{name: "a.b.c", typ: "string"}}}},
+		{in: "id,id", err: "id is listed twice in a group"},
+		{in: "id:int64, id:string", err: "id is listed twice in a group"},
+		{in: "id, id ", err: "id is listed twice in a group"},
+		{in: ":int64", err: `entry ":int64": no field name`},
+		{in: " : ", err: "no field name"},
+		{in: "a\tb", err: "control character"},
+		{in: "a\xffb", err: "not valid UTF-8"},
+	}
+	for _, tt := range tests {
+		got, err := parseRequireFields(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseRequireFields(%q) error = %v, want one containing %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRequireFields(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRequireFields(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRequiredFieldString(t *testing.T) {
+	for _, f := range []requiredField{{name: "id"}, {name: "id", typ: "INT64"}} {
+		groups, err := parseRequireFields(f.String())
+		if err != nil || len(groups) != 1 || len(groups[0]) != 1 || groups[0][0] != f {
+			t.Errorf("%q parses as %v, %v, want %v", f.String(), groups, err, f)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// retention drops the rows whose time column is before cutoff, or null,
+// counting per input file the rows it keeps and drops and the times seen
+// (-retain-days).
+type retention struct {
+	days   int
+	cutoff time.Time
+	times  *timeRange
+	// column and node are the time column in the merged schema.
+	column int
+	node   parquet.Node
+	// files holds the rows looked at per file, and pruned the row groups
+	// skipped by their statistics, by file and index, so that inputs
+	// read twice are only counted once.
+	files  map[string]*retainedFile
+	pruned map[retainedGroup]retainedFile
+}
+
+type retainedGroup struct {
+	file  string
+	index int
+}
+
+// retainedFile counts the rows of a file on either side of the cutoff.
+type retainedFile struct {
+	kept, dropped int64
+	// min and max are the times seen, if seen is set.
+	seen     bool
+	min, max time.Time
+}
+
+func (f *retainedFile) see(t time.Time) {
+	if !f.seen || t.Before(f.min) {
+		f.min = t
+	}
+	if !f.seen || t.After(f.max) {
+		f.max = t
+	}
+	f.seen = true
+}
+
+func newRetention(column string, days int, now time.Time) *retention {
+	cutoff := now.Add(-time.Duration(days) * 24 * time.Hour)
+	return &retention{
+		days:   days,
+		cutoff: cutoff,
+		times:  &timeRange{column: column, after: cutoff},
+		files:  map[string]*retainedFile{},
+		pruned: map[retainedGroup]retainedFile{},
+	}
+}
+
+// compile looks the time column up in schema, the merged schema.
+func (r *retention) compile(schema *parquet.Schema) error {
+	leaf, ok := schema.Lookup(r.times.column)
+	if !ok || leaf.MaxRepetitionLevel > 0 {
+		return fmt.Errorf("time column %s must be a non-repeated column of the merged schema", r.times.column)
+	}
+	if _, err := timeUnits(leaf.Node, r.cutoff); err != nil {
+		return fmt.Errorf("%s: %w", r.times.column, err)
+	}
+	r.column, r.node = leaf.ColumnIndex, leaf.Node
+	return nil
+}
+
+// keep returns the keep function of file: rows at or after the cutoff are
+// passed on to next, if set, and the others dropped.
+func (r *retention) keep(file string, next func(parquet.Row, int64) (bool, error)) func(parquet.Row, int64) (bool, error) {
+	lo, _ := timeUnits(r.node, r.cutoff)
+	return func(row parquet.Row, index int64) (bool, error) {
+		f := r.files[file]
+		if f == nil {
+			f = &retainedFile{}
+			r.files[file] = f
+		}
+		v := columnValue(row, r.column)
+		if v.IsNull() {
+			f.dropped++
+			return false, nil
+		}
+		t := v.Int64()
+		if v.Kind() == parquet.Int32 {
+			t = int64(v.Int32())
+		}
+		f.see(unitsTime(r.node, t))
+		if t < lo {
+			f.dropped++
+			return false, nil
+		}
+		f.kept++
+		if next == nil {
+			return true, nil
+		}
+		return next(row, index)
+	}
+}
+
+// skip reports whether row group i of pf, in file, has only rows before
+// the cutoff, judging by the statistics of column, looked up as fileColumn
+// does, and counts them as dropped if so.
+func (r *retention) skip(file string, pf *parquet.File, i int, column string, renamed map[string]string) bool {
+	if !r.times.skip(pf, i, column, renamed) {
+		return false
+	}
+	g := retainedFile{dropped: pf.Metadata().RowGroups[i].NumRows}
+	if leaf, ok := fileColumn(pf.Schema(), column, renamed); ok {
+		chunk := pf.Metadata().RowGroups[i].Columns[leaf.ColumnIndex].MetaData
+		min, okMin := statValue(chunk.Type, chunk.Statistics.MinValue)
+		max, okMax := statValue(chunk.Type, chunk.Statistics.MaxValue)
+		if okMin && okMax && chunk.Statistics.NullCount < g.dropped {
+			g.see(r.times.fileTime(leaf.Node, min))
+			g.see(r.times.fileTime(leaf.Node, max))
+		}
+	}
+	r.pruned[retainedGroup{file, i}] = g
+	return true
+}
+
+// reset forgets the rows looked at, when the inputs are read again.
+func (r *retention) reset() { clear(r.files) }
+
+// retentionReport is the -retention-report file.
+type retentionReport struct {
+	RetainDays  int             `json:"retain_days"`
+	TimeColumn  string          `json:"time_column"`
+	Cutoff      string          `json:"cutoff"`
+	RowsKept    int64           `json:"rows_kept"`
+	RowsDropped int64           `json:"rows_dropped"`
+	Files       []retentionFile `json:"files"`
+}
+
+type retentionFile struct {
+	File        string `json:"file"`
+	RowsKept    int64  `json:"rows_kept"`
+	RowsDropped int64  `json:"rows_dropped"`
+	// RowsPruned are the rows dropped with the row groups skipped by
+	// their statistics, without being read.
+	RowsPruned   int64  `json:"rows_pruned"`
+	MinTimestamp string `json:"min_timestamp,omitempty"`
+	MaxTimestamp string `json:"max_timestamp,omitempty"`
+}
+
+// report returns the report of files, the inputs in order.
+func (r *retention) report(files []string) retentionReport {
+	out := retentionReport{RetainDays: r.days, TimeColumn: r.times.column, Cutoff: r.cutoff.UTC().Format(time.RFC3339Nano), Files: []retentionFile{}}
+	pruned := map[string][]retainedFile{}
+	for key, g := range r.pruned {
+		pruned[key.file] = append(pruned[key.file], g)
+	}
+	for _, file := range files {
+		f := retainedFile{}
+		if read := r.files[file]; read != nil {
+			f = *read
+		}
+		rf := retentionFile{File: file, RowsKept: f.kept}
+		for _, g := range pruned[file] {
+			rf.RowsPruned += g.dropped
+			if g.seen {
+				f.see(g.min)
+				f.see(g.max)
+			}
+		}
+		rf.RowsDropped = f.dropped + rf.RowsPruned
+		if f.seen {
+			rf.MinTimestamp = f.min.UTC().Format(time.RFC3339Nano)
+			rf.MaxTimestamp = f.max.UTC().Format(time.RFC3339Nano)
+		}
+		out.RowsKept += rf.RowsKept
+		out.RowsDropped += rf.RowsDropped
+		out.Files = append(out.Files, rf)
+	}
+	return out
+}
+
+// write writes the report of files to name.
+func (r *retention) write(name string, files []string) error {
+	b, err := json.MarshalIndent(r.report(files), "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(name, append(b, '\n'))
+}
+
+func (r *retention) log(logger *slog.Logger, files []string) {
+	rep := r.report(files)
+	logger.Info("dropped rows past retention", "retain_days", r.days, "cutoff", rep.Cutoff, "rows_kept", rep.RowsKept, "rows_dropped", rep.RowsDropped)
+}
+
+// inputFiles returns the names of inputs.
+func inputFiles(inputs []inputFile) []string {
+	files := make([]string, len(inputs))
+	for i, input := range inputs {
+		files[i] = input.file
+	}
+	return files
+}
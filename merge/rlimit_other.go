@@ -0,0 +1,6 @@
+//go:build !unix
+
+package merge
+
+// openFileLimit returns 0: the open file limit is only known on Unix.
+func openFileLimit() int { return 0 }
@@ -0,0 +1,21 @@
+//go:build unix
+
+package merge
+
+import (
+	"math"
+	"syscall"
+)
+
+// openFileLimit returns the soft limit on the files the process can have
+// open, or 0 if it is unlimited or unknown.
+func openFileLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0
+	}
+	if uint64(limit.Cur) > math.MaxInt32 {
+		return 0
+	}
+	return int(limit.Cur)
+}
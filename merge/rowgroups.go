@@ -0,0 +1,109 @@
+package merge
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupWriter ends the current row group once the rows written to it
+// reach maxBytes, estimated from the size of their uncompressed values.
+// The writer itself ends row groups at maxRows rows, so the estimate
+// starts over there too.
+type rowGroupWriter struct {
+	mergeWriter
+	maxRows, maxBytes int64
+	rows, bytes       int64
+}
+
+func (w *rowGroupWriter) WriteRows(rows []parquet.Row) (int, error) {
+	n, err := w.mergeWriter.WriteRows(rows)
+	for _, row := range rows[:n] {
+		w.rows++
+		w.bytes += rowSize(row)
+		if w.rows == w.maxRows {
+			w.rows, w.bytes = 0, 0
+		}
+	}
+	if err == nil && w.bytes >= w.maxBytes {
+		err = w.Flush()
+		w.rows, w.bytes = 0, 0
+	}
+	return n, err
+}
+
+// rowSize returns the size of the values of row.
+func rowSize(row parquet.Row) int64 {
+	var size int64
+	for _, v := range row {
+		switch {
+		case v.IsNull():
+		case v.Kind() == parquet.Boolean:
+			size++
+		case v.Kind() == parquet.Int32 || v.Kind() == parquet.Float:
+			size += 4
+		case v.Kind() == parquet.Int64 || v.Kind() == parquet.Double:
+			size += 8
+		case v.Kind() == parquet.Int96:
+			size += 12
+		default:
+			size += int64(len(v.ByteArray()))
+		}
+	}
+	return size
+}
+
+// reportOutput logs the number of row groups in the output files and their
+// average size, and the size of their bloom filters, and returns the number
+// of row groups.
+func reportOutput(logger *slog.Logger, files ...string) int64 {
+	var groups, rows, size int64
+	var columns [][]string
+	var blooms []int64
+	for _, file := range files {
+		pf, closer, err := openParquet(file)
+		if err != nil {
+			logger.Warn("error reading output", "file", file, "error", err)
+			return groups
+		}
+		for _, rg := range pf.Metadata().RowGroups {
+			groups++
+			rows += rg.NumRows
+			size += rg.TotalCompressedSize
+		}
+		if columns == nil {
+			columns = pf.Schema().Columns()
+			blooms = make([]int64, len(columns))
+		}
+		for _, rg := range pf.RowGroups() {
+			for i, chunk := range rg.ColumnChunks() {
+				if filter := chunk.BloomFilter(); filter != nil {
+					blooms[i] += filter.Size()
+				}
+			}
+		}
+		closer.Close()
+	}
+	if groups == 0 {
+		logger.Info("wrote row groups", "row_groups", 0)
+		return 0
+	}
+	logger.Info("wrote row groups", "row_groups", groups, "average_rows", rows/groups, "average_bytes", size/groups)
+	for i, size := range blooms {
+		if size > 0 {
+			logger.Info("wrote bloom filters", "column", strings.Join(columns[i], "."), "bytes", size)
+		}
+	}
+	return groups
+}
+
+// openParquet opens the local parquet file name, without its page index.
+func openParquet(name string) (*parquet.File, io.Closer, error) {
+	r, size, _, err := openLocal(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return openParquetReader(r, size, parquet.SkipPageIndex(true))
+}
@@ -0,0 +1,419 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// inputFile is a file selected for the merge, along with the schema its
+// rows are read with and the conversions its columns need.
+type inputFile struct {
+	file string
+	// rows is the number of rows the footer gives.
+	rows   int64
+	schema *parquet.Schema
+	coerce map[string]conversion
+	// renamed maps output names of renamed columns to their names in the
+	// file, and flattened holds the groups of the file expanded by
+	// -flatten.
+	renamed   map[string]string
+	flattened map[string]parquet.Node
+	// tightened lists the columns the file stores as optional that
+	// -tighten-nullability made required.
+	tightened []string
+	// keep, if set, decides whether the row at the given index of the file
+	// is written.
+	keep func(row parquet.Row, index int64) (bool, error)
+	// skip, if set, decides whether a row group of the file can be skipped
+	// without reading it.
+	skip func(pf *parquet.File, i int) bool
+	// source, if set, is written to the -source-column of every row.
+	source string
+	// derived, if set, writes the -derive columns of a row, and redact
+	// replaces the values of its -redact columns once it is kept.
+	derived func(parquet.Row)
+	redact  func(parquet.Row)
+	// defaults are written to the columns the file lacks.
+	defaults []parquet.Value
+	// utf8, if set, marks the leaf columns of the merged schema whose
+	// values are checked for -utf8.
+	utf8 []bool
+	// transplant is set if the row groups of the file are copied as they
+	// are stored instead of being read.
+	transplant bool
+	// nonNull, if set, counts the non-null values read, by leaf column.
+	nonNull []int64
+	// timeBounds, if set, are the plausible values of the -time-normalize
+	// columns, by leaf column.
+	timeBounds []timeBound
+}
+
+// fileRows reads the rows of an input file converted to the merged schema.
+// Files whose schema already matches are read directly; the others are
+// decoded into maps, coerced, and deconstructed with rowSchema, the merged
+// schema with MAP and LIST annotations removed, since parquet-go cannot
+// convert MAP columns, or the null elements of LIST columns, to and from
+// map[string]any values.
+type fileRows struct {
+	inf       io.Closer
+	rows      parquet.Rows
+	numRows   int64
+	schema    *parquet.Schema
+	merged    *parquet.Schema
+	rowSchema *parquet.Schema
+	coerce    map[string]conversion
+	renamed   map[string]string
+	flattened map[string]parquet.Node
+	tightened []string
+	keep      func(parquet.Row, int64) (bool, error)
+	source    parquet.Value
+	defaults  []parquet.Value
+	derived   func(parquet.Row)
+	redact    func(parquet.Row)
+	nonNull   []int64
+	index     int64
+	// read counts the rows read from the file, kept or not.
+	read    int64
+	direct  bool
+	in      []parquet.Row
+	records []map[string]any
+	// utf8 marks the columns checked for -utf8, whose invalid values are
+	// replaced if replaceUTF8 is set and rejected otherwise.
+	utf8        []bool
+	replaceUTF8 bool
+	// castNull, if set, is called with the error of each value of a
+	// -type-overrides column that could not be cast, which is written as
+	// null.  Otherwise the error fails the file.
+	castNull func(err error)
+	// timeBounds are the plausible ranges of the -time-normalize columns,
+	// whose values outside them are passed to suspectTime.
+	timeBounds  []timeBound
+	suspectTime func(err error)
+}
+
+func (m *Merger) openFileRows(input inputFile, merged, rowSchema *parquet.Schema) (*fileRows, error) {
+	if input.rows == 0 {
+		// The footer read by the scan holds no rows, so the file is not
+		// opened again.
+		return &fileRows{rows: emptyRows{merged}, schema: input.schema, merged: merged, rowSchema: rowSchema}, nil
+	}
+	pf, inf, err := m.openInputFile(input.file)
+	if err != nil {
+		return nil, err
+	}
+	var groups []parquet.RowGroup
+	for i, rg := range pf.RowGroups() {
+		if input.skip != nil && input.skip(pf, i) {
+			continue
+		}
+		if m.opts.CheckCRC {
+			rg = crcRowGroup{RowGroup: rg, index: i}
+		}
+		groups = append(groups, rg)
+	}
+	r := &fileRows{
+		inf:       inf,
+		schema:    input.schema,
+		merged:    merged,
+		rowSchema: rowSchema,
+		coerce:    input.coerce,
+		renamed:   input.renamed,
+		flattened: input.flattened,
+		tightened: input.tightened,
+		keep:      input.keep,
+		defaults:  input.defaults,
+		derived:   input.derived,
+		redact:    input.redact,
+		nonNull:   input.nonNull,
+	}
+	if input.utf8 != nil {
+		r.utf8, r.replaceUTF8 = input.utf8, m.opts.UTF8 == "replace"
+	}
+	if m.opts.OnCastError == "null" {
+		r.castNull = m.castNull(input.file)
+	}
+	if input.timeBounds != nil {
+		r.timeBounds, r.suspectTime = input.timeBounds, m.suspectTime(input.file)
+	}
+	if input.source != "" {
+		leaf, _ := merged.Lookup(m.opts.SourceColumn)
+		r.source = parquet.ValueOf(input.source).Level(0, 1, leaf.ColumnIndex)
+	}
+	if len(groups) == 0 {
+		r.rows = emptyRows{merged}
+		return r, nil
+	}
+	rg := parquet.MultiRowGroup(groups...)
+	r.numRows = rg.NumRows()
+	// Every column of the file already has its merged type, so if the file
+	// also has every merged column, laid out the same way, rows can be
+	// copied as they are.  Columns stored in a different order are
+	// rearranged, and columns not in the merged schema dropped, by the
+	// conversion.
+	target := input.schema
+	if !m.opts.NoFastpath && len(input.coerce) == 0 && len(input.renamed) == 0 && len(input.flattened) == 0 && coversLayout(pf.Schema(), merged, m.addedColumns()) {
+		r.direct = true
+		target = merged
+	}
+	conv, err := parquet.Convert(target, rg.Schema())
+	if err != nil {
+		inf.Close()
+		return nil, err
+	}
+	r.rows = parquet.ConvertRowGroup(rg, conv).Rows()
+	return r, nil
+}
+
+// emptyRows is the parquet.Rows of a file whose row groups were all skipped.
+type emptyRows struct{ schema *parquet.Schema }
+
+func (emptyRows) ReadRows([]parquet.Row) (int, error) { return 0, io.EOF }
+func (r emptyRows) Schema() *parquet.Schema           { return r.schema }
+func (emptyRows) SeekToRow(int64) error               { return nil }
+func (emptyRows) Close() error                        { return nil }
+
+// ReadRows reads converted rows, leaving out those rejected by keep, and
+// redacts those kept.
+func (r *fileRows) ReadRows(rows []parquet.Row) (int, error) {
+	n, err := r.keptRows(rows)
+	if r.redact != nil {
+		for _, row := range rows[:n] {
+			r.redact(row)
+		}
+	}
+	return n, err
+}
+
+func (r *fileRows) keptRows(rows []parquet.Row) (int, error) {
+	if r.keep == nil {
+		return r.readRows(rows)
+	}
+	for {
+		n, err := r.readRows(rows)
+		kept, kerr := keepRows(rows[:n], r.keep, &r.index)
+		if kerr != nil {
+			return kept, kerr
+		}
+		if kept > 0 || n == 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// keepRows moves the rows accepted by keep to the front of rows and returns
+// how many there are.  index is the index in the file of the first row, and
+// is advanced past the rows looked at.
+func keepRows(rows []parquet.Row, keep func(parquet.Row, int64) (bool, error), index *int64) (int, error) {
+	kept := 0
+	for i := range rows {
+		ok, err := keep(rows[i], *index)
+		if err != nil {
+			return kept, err
+		}
+		*index++
+		if ok {
+			rows[kept], rows[i] = rows[i], rows[kept]
+			kept++
+		}
+	}
+	return kept, nil
+}
+
+func (r *fileRows) readRows(rows []parquet.Row) (int, error) {
+	var n int
+	var err error
+	if r.direct {
+		n, err = r.rows.ReadRows(rows)
+	} else {
+		n, err = r.decodeRows(rows)
+	}
+	r.read += int64(n)
+	if r.utf8 != nil {
+		if valid, verr := r.checkUTF8(rows[:n], r.read-int64(n)); verr != nil {
+			return valid, verr
+		}
+	}
+	if r.timeBounds != nil {
+		r.checkTimes(rows[:n], r.read-int64(n))
+	}
+	if !r.source.IsNull() {
+		for _, row := range rows[:n] {
+			stampValue(row, r.source)
+		}
+	}
+	for _, v := range r.defaults {
+		for _, row := range rows[:n] {
+			stampValue(row, v)
+		}
+	}
+	if r.derived != nil {
+		for _, row := range rows[:n] {
+			r.derived(row)
+		}
+	}
+	if r.nonNull != nil {
+		countNonNull(r.nonNull, rows[:n])
+	}
+	return n, err
+}
+
+// decodeRows reads rows into maps, renames and coerces their columns, and
+// deconstructs them with the merged schema.
+func (r *fileRows) decodeRows(rows []parquet.Row) (int, error) {
+	for len(r.in) < len(rows) {
+		r.in = append(r.in, nil)
+		r.records = append(r.records, map[string]any{})
+	}
+	n, err := r.rows.ReadRows(r.in[:len(rows)])
+	for i := 0; i < n; i++ {
+		record := r.records[i]
+		clear(record)
+		if err := r.schema.Reconstruct(&record, r.in[i]); err != nil {
+			return i, err
+		}
+		if len(r.renamed) > 0 {
+			renameRecord(record, r.renamed)
+		}
+		if len(r.flattened) > 0 {
+			flattenRecord(record, r.flattened)
+		}
+		for k, c := range r.coerce {
+			if c.unit != 0 {
+				unit, _ := temporalUnit(c.to)
+				v, err := rescaleTime(record[k], c.unit, timeUnitOf(unit).Duration())
+				if err != nil {
+					return i, fmt.Errorf("column %s: %w", k, err)
+				}
+				record[k] = v
+				continue
+			}
+			if c.cast {
+				v, err := castValue(record[k], c.from, c.to)
+				if err != nil {
+					err = fmt.Errorf("row %d: column %s: %w", r.read+int64(i), k, err)
+					if r.castNull == nil {
+						return i, err
+					}
+					r.castNull(err)
+				}
+				record[k] = v
+				continue
+			}
+			v, err := coerceValue(record[k], c.from, c.to)
+			if err != nil {
+				return i, fmt.Errorf("column %s: %w", k, err)
+			}
+			record[k] = v
+		}
+		for _, k := range r.tightened {
+			if record[k] == nil {
+				return i, fmt.Errorf("row %d: column %s: %w, though the statistics of the file show none", r.read+int64(i), k, errTightenedNull)
+			}
+		}
+		rows[i] = r.rowSchema.Deconstruct(rows[i][:0], record)
+	}
+	return n, err
+}
+
+func (r *fileRows) Schema() *parquet.Schema { return r.merged }
+
+func (r *fileRows) SeekToRow(row int64) error { return r.rows.SeekToRow(row) }
+
+func (r *fileRows) counts() (read, footer int64) { return r.read, r.numRows }
+
+func (r *fileRows) Close() error {
+	r.rows.Close()
+	if r.inf == nil {
+		return nil
+	}
+	return r.inf.Close()
+}
+
+// copyRows copies every row of src to dst, batchSize rows at a time, and
+// returns the number of rows copied.  Errors reading src are returned as
+// a *readError.  It stops between batches once ctx is canceled.
+func (m *Merger) copyRows(ctx context.Context, dst parquet.RowWriter, src parquet.RowReader) (int64, error) {
+	rows := make([]parquet.Row, m.opts.BatchSize)
+	var copied int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		n, err := src.ReadRows(rows)
+		if n > 0 {
+			written, werr := dst.WriteRows(rows[:n])
+			copied += int64(written)
+			if werr != nil {
+				if !errors.Is(werr, errLimit) {
+					werr = markError(ErrWrite, werr)
+				}
+				return copied, werr
+			}
+			if written != n {
+				return copied, markError(ErrWrite, fmt.Errorf("expected to write %d records, wrote %d", n, written))
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return copied, nil
+			}
+			return copied, &readError{err}
+		}
+	}
+}
+
+// readError is an error reading rows from an input file, as opposed to
+// writing them to the output.
+type readError struct{ err error }
+
+func (e *readError) Error() string { return e.err.Error() }
+func (e *readError) Unwrap() error { return e.err }
+
+// Is reports a readError as ErrRead.
+func (e *readError) Is(target error) bool { return target == ErrRead }
+
+// stampValue replaces the null value of the column of value in row.
+func stampValue(row parquet.Row, value parquet.Value) {
+	for i, v := range row {
+		if v.Column() == value.Column() {
+			row[i] = value
+			return
+		}
+	}
+}
+
+// coversLayout reports whether file has every top-level field of merged,
+// laid out the same way but for required fields made optional, other than
+// the added columns.
+func coversLayout(file, merged parquet.Node, added []string) bool {
+	for _, f := range merged.Fields() {
+		if slices.Contains(added, f.Name()) {
+			continue
+		}
+		ff := fieldOf(file, f.Name())
+		if ff == nil || !relaxes(ff, f, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// renameRecord moves the values of renamed columns to their output names.
+// All values are read before any is moved, so names can be swapped.
+func renameRecord(record map[string]any, renamed map[string]string) {
+	values := make(map[string]any, len(renamed))
+	for name, old := range renamed {
+		values[name] = record[old]
+	}
+	for _, old := range renamed {
+		delete(record, old)
+	}
+	for name, v := range values {
+		record[name] = v
+	}
+}
@@ -0,0 +1,99 @@
+package merge
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupRange is the row groups of a file copied with -row-groups, by
+// their indexes from first to last.
+type rowGroupRange struct {
+	first, last int
+}
+
+// parseRowGroups parses Options.RowGroups, keyed by the cleaned names of
+// the files.
+func parseRowGroups(spec map[string]string) (map[string]rowGroupRange, error) {
+	out := make(map[string]rowGroupRange, len(spec))
+	for _, file := range sortedStrings(spec) {
+		r, err := parseRowGroupRange(spec[file])
+		if err != nil {
+			return nil, fmt.Errorf("row groups of %s: %w", file, err)
+		}
+		key := filepath.Clean(file)
+		if _, ok := out[key]; ok {
+			return nil, fmt.Errorf("row groups of %s are given twice", file)
+		}
+		out[key] = r
+	}
+	return out, nil
+}
+
+// parseRowGroupRange parses an index, such as 3, or a range of them, such
+// as 3-7.
+func parseRowGroupRange(s string) (rowGroupRange, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		last = first
+	}
+	a, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil || a < 0 {
+		return rowGroupRange{}, fmt.Errorf("%q is not a row group index or first-last range", s)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(last))
+	if err != nil || b < a {
+		return rowGroupRange{}, fmt.Errorf("%q is not a row group index or first-last range", s)
+	}
+	return rowGroupRange{a, b}, nil
+}
+
+func (r rowGroupRange) String() string {
+	if r.first == r.last {
+		return strconv.Itoa(r.first)
+	}
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+// checkRowGroups checks that the files -row-groups names are among
+// scanned, and have the row groups it selects.
+func checkRowGroups(ranges map[string]rowGroupRange, scanned []scannedFile) error {
+	found := map[string]bool{}
+	for _, sf := range scanned {
+		key := filepath.Clean(sf.file)
+		r, ok := ranges[key]
+		if !ok {
+			continue
+		}
+		found[key] = true
+		if r.last >= sf.rowGroups {
+			return fmt.Errorf("row groups %s of %s: the file has %d row groups", r, sf.file, sf.rowGroups)
+		}
+	}
+	var missing []string
+	for key := range ranges {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("row groups of %s: it is not an input file", missing[0])
+	}
+	return nil
+}
+
+// skipper returns a skip function skipping the row groups outside r, and
+// those next skips, if set.
+func (r rowGroupRange) skipper(next func(pf *parquet.File, i int) bool) func(pf *parquet.File, i int) bool {
+	return func(pf *parquet.File, i int) bool {
+		if i < r.first || i > r.last {
+			return true
+		}
+		return next != nil && next(pf, i)
+	}
+}
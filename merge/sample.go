@@ -0,0 +1,124 @@
+package merge
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// sampler keeps a random subset of the rows accepted by the other filters.
+// Every draw depends only on the seed and the rows, so the same inputs, flags
+// and seed always give the same sample, however often the inputs are read.
+type sampler struct {
+	rng        *rand.Rand
+	seed       int64
+	seen, kept int64
+}
+
+func newSampler(seed int64) *sampler {
+	return &sampler{rng: rand.New(rand.NewSource(seed)), seed: seed}
+}
+
+// fraction returns a keep function that keeps each row of file accepted by
+// prev with probability p.  The draw for a row is a hash of the seed, the
+// file and the row's index, so a row read twice gets the same answer.
+func (s *sampler) fraction(file string, p float64, prev func(parquet.Row, int64) (bool, error)) func(parquet.Row, int64) (bool, error) {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, s.seed)
+	h.Write([]byte(file))
+	base := h.Sum64()
+	return func(row parquet.Row, index int64) (bool, error) {
+		if prev != nil {
+			if ok, err := prev(row, index); !ok || err != nil {
+				return false, err
+			}
+		}
+		s.seen++
+		if draw(base, index) >= p {
+			return false, nil
+		}
+		s.kept++
+		return true, nil
+	}
+}
+
+// draw returns a number in [0, 1) for the row at index, mixing it into base
+// with the splitmix64 finalizer.
+func draw(base uint64, index int64) float64 {
+	z := base + uint64(index)*0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+// perFile reads every input once and picks at most n of the rows accepted
+// by its keep function, uniformly at random.  It sets the keep function of
+// every input to write only the picked rows.
+func (s *sampler) perFile(ctx context.Context, m *Merger, inputs []inputFile, merged, rowSchema *parquet.Schema, n int) error {
+	for i, input := range inputs {
+		rows, err := m.openFileRows(input, merged, rowSchema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.file, err)
+		}
+		var picked []int64
+		var seen int64
+		rows.keep = func(row parquet.Row, index int64) (bool, error) {
+			if input.keep != nil {
+				if ok, err := input.keep(row, index); !ok || err != nil {
+					return false, err
+				}
+			}
+			seen++
+			if len(picked) < n {
+				picked = append(picked, index)
+			} else if j := s.rng.Int63n(seen); j < int64(n) {
+				picked[j] = index
+			}
+			return false, nil
+		}
+		_, err = m.copyRows(ctx, discardRows{}, rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.file, err)
+		}
+		keep := make(map[int64]bool, len(picked))
+		for _, index := range picked {
+			keep[index] = true
+		}
+		prev := input.keep
+		inputs[i].keep = func(row parquet.Row, index int64) (bool, error) {
+			if prev != nil {
+				if ok, err := prev(row, index); !ok || err != nil {
+					return false, err
+				}
+			}
+			s.seen++
+			if !keep[index] {
+				return false, nil
+			}
+			s.kept++
+			return true, nil
+		}
+	}
+	return nil
+}
+
+// reset forgets the rows counted, when the inputs are read again.
+func (s *sampler) reset() {
+	s.seen, s.kept = 0, 0
+}
+
+// report logs how many rows were sampled and the ratio achieved.
+func (s *sampler) report(logger *slog.Logger) {
+	ratio := 0.0
+	if s.seen > 0 {
+		ratio = float64(s.kept) / float64(s.seen)
+	}
+	logger.Info("sampled rows", "rows", s.kept, "seen", s.seen, "ratio", ratio, "seed", s.seed)
+}
@@ -0,0 +1,43 @@
+package merge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestSampleDedupLatest(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	rows := make([]map[string]any, 10000)
+	for i := range rows {
+		rows[i] = map[string]any{"id": int64(i), "timestamp": int64(i)}
+	}
+	writeParquet(t, in, parquet.Group{"id": parquet.Int(64), "timestamp": parquet.Int(64)}, rows)
+
+	sample := func(latest bool) []int64 {
+		out := filepath.Join(dir, "merged.parquet")
+		opts := testOptions(out, in)
+		opts.Sample, opts.Seed = 0.5, 3
+		if latest {
+			opts.DedupKeys, opts.DedupPreferLatest = []string{"id"}, true
+		}
+		runMerge(t, opts)
+		_, rows := readParquet(t, out)
+		var ids []int64
+		for _, row := range rows {
+			ids = append(ids, row["id"].(int64))
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	plain, latest := sample(false), sample(true)
+	if n := len(plain); n < 4800 || n > 5200 {
+		t.Errorf("sampled %d of 10000 rows at 0.5", n)
+	}
+	if !slices.Equal(plain, latest) {
+		t.Errorf("sampled %d rows, but %d when preferring the latest duplicates", len(plain), len(latest))
+	}
+}
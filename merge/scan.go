@@ -0,0 +1,245 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// scannedFile holds the schema nodes and sizes read from the footer of one
+// input file.
+type scannedFile struct {
+	file    string
+	nodes   map[string]parquet.Node
+	renamed map[string]string
+	// flattened holds the groups -flatten expanded into the top-level
+	// columns of nodes, by name.
+	flattened map[string]parquet.Node
+	// unsupported lists the columns -on-unsupported dropped or
+	// stringified.
+	unsupported []unsupportedColumn
+	// fingerprint identifies the schema of nodes and renamed.
+	fingerprint string
+	rows        int64
+	rowGroups   int
+	// size is the size of the file, and uncompressed the total
+	// uncompressed size of its row groups.
+	size         int64
+	uncompressed int64
+	modTime      time.Time
+	// metadata is the key/value metadata of the file.
+	metadata map[string]string
+	// layout is how the file stores its rows, or nil if its row groups
+	// cannot be transplanted.
+	layout *storedLayout
+	// nonNull holds the non-null values of the columns, for -report, as
+	// columnNonNull returns them.
+	nonNull map[string]int64
+	// nullFree holds the columns the statistics show hold no null, for
+	// -tighten-nullability.
+	nullFree map[string]bool
+	err      error
+}
+
+// errEmptyFile is the scan error of a zero-byte file, which is skipped
+// without -skip-bad-files.
+var errEmptyFile = errors.New("file is empty")
+
+// scanFile reads the footer of file.
+func (m *Merger) scanFile(file string) scannedFile {
+	sf := scannedFile{file: file}
+	r, size, modTime, err := m.openInput(file)
+	if err != nil {
+		sf.err = markError(ErrRead, err)
+		return sf
+	}
+	if size == 0 {
+		r.Close()
+		sf.err = errEmptyFile
+		return sf
+	}
+	f, err := parquet.OpenFile(r, size)
+	var counted [][]bool
+	if err == nil && (m.opts.ReportFile != "" || m.opts.TightenNullability) {
+		counted = nullCounted(r, size)
+	}
+	// The rest is read from the footer, so the file is closed at once to
+	// give its place to the next.
+	r.Close()
+	if err != nil {
+		sf.err = markError(ErrRead, fmt.Errorf("%s: %w", file, err))
+		return sf
+	}
+	sf.size = size
+	sf.modTime = modTime
+	sf.rows = f.NumRows()
+	sf.rowGroups = len(f.Metadata().RowGroups)
+	for _, rg := range f.Metadata().RowGroups {
+		sf.uncompressed += rg.TotalByteSize
+	}
+	if kv := f.Metadata().KeyValueMetadata; len(kv) > 0 {
+		sf.metadata = make(map[string]string, len(kv))
+		for _, e := range kv {
+			sf.metadata[e.Key] = e.Value
+		}
+	}
+	sf.layout = layoutOf(f.Metadata())
+	sf.nodes, sf.renamed, sf.unsupported, err = m.getSchemaNodes(file, f)
+	if err != nil {
+		sf.err = markError(ErrSchemaConflict, err)
+		return sf
+	}
+	if m.opts.Flatten {
+		if sf.nodes, sf.flattened, err = flattenNodes(sf.nodes); err != nil {
+			sf.err = markError(ErrSchemaConflict, fmt.Errorf("%s: %w", file, err))
+			return sf
+		}
+	}
+	// Files are fingerprinted as they store their columns, which is how
+	// they are read.
+	sf.fingerprint = schemaFingerprint(unflattenNodes(sf.nodes, sf.flattened), sf.renamed)
+	if m.opts.ReportFile != "" {
+		sf.nonNull = columnNonNull(f, sf.renamed, counted)
+	}
+	if m.opts.TightenNullability {
+		sf.nullFree = nullFreeColumns(sf.nodes, columnNonNull(f, sf.renamed, counted), sf.rows)
+	}
+	return sf
+}
+
+// scanSchemas reads the schema of every file using jobs concurrent workers.
+// Results are returned in the order of files, so they do not depend on
+// scheduling, with the files that could not be read returned separately.
+func (m *Merger) scanSchemas(files []string, jobs int) (scanned, failed []scannedFile) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	work := make(chan int)
+	results := make([]scannedFile, len(files))
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = m.scanFile(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, r)
+			continue
+		}
+		scanned = append(scanned, r)
+	}
+	return scanned, failed
+}
+
+// orderFiles sorts scanned files by name, or by modification time and then
+// name when order is "mtime".
+func orderFiles(files []scannedFile, order string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if order == "mtime" && !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+		return files[i].file < files[j].file
+	})
+}
+
+// emptyFiles splits the zero-byte files out of failed.
+func emptyFiles(failed []scannedFile) (bad, empty []scannedFile) {
+	for _, sf := range failed {
+		if errors.Is(sf.err, errEmptyFile) {
+			empty = append(empty, sf)
+			continue
+		}
+		bad = append(bad, sf)
+	}
+	return bad, empty
+}
+
+// scanErrors joins the errors of files that could not be scanned.
+func scanErrors(failed []scannedFile) error {
+	errs := make([]error, len(failed))
+	for i, sf := range failed {
+		errs[i] = sf.err
+	}
+	return errors.Join(errs...)
+}
+
+// sortedKeys returns the keys of nodes in sorted order.
+func sortedKeys(nodes map[string]parquet.Node) []string {
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkProjection verifies that every column in projection is in at least
+// one scanned file.
+func checkProjection(scanned []scannedFile, projection []string) error {
+	for _, name := range projection {
+		found := false
+		for _, sf := range scanned {
+			if _, ok := sf.nodes[name]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("column %s is not in any input file", name)
+		}
+	}
+	return nil
+}
+
+// projectNodes returns the nodes named in projection.
+func projectNodes(nodes map[string]parquet.Node, projection []string) map[string]parquet.Node {
+	out := map[string]parquet.Node{}
+	for _, name := range projection {
+		if node, ok := nodes[name]; ok {
+			out[name] = node
+		}
+	}
+	return out
+}
+
+// dropNodes returns nodes without the ones named in dropped.
+func dropNodes(nodes map[string]parquet.Node, dropped []string) map[string]parquet.Node {
+	out := make(map[string]parquet.Node, len(nodes))
+	for name, node := range nodes {
+		out[name] = node
+	}
+	for _, name := range dropped {
+		delete(out, name)
+	}
+	return out
+}
+
+// originalNames returns nodes keyed by the names they have in the file.
+func originalNames(nodes map[string]parquet.Node, renamed map[string]string) map[string]parquet.Node {
+	if len(renamed) == 0 {
+		return nodes
+	}
+	out := make(map[string]parquet.Node, len(nodes))
+	for name, node := range nodes {
+		if old, ok := renamed[name]; ok {
+			name = old
+		}
+		out[name] = node
+	}
+	return out
+}
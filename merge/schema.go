@@ -0,0 +1,315 @@
+package merge
+
+import (
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// getSchemaNodes returns the top-level nodes of a file, keyed by their
+// names after -rename and -normalize-names, along with the original names
+// of renamed columns and the columns -on-unsupported dropped or
+// stringified.
+func (m *Merger) getSchemaNodes(fname string, f *parquet.File) (map[string]parquet.Node, map[string]string, []unsupportedColumn, error) {
+	md := f.Metadata()
+	if len(md.Schema) == 0 {
+		return nil, nil, nil, fmt.Errorf("%s: empty schema", fname)
+	}
+	var unsupported []unsupportedColumn
+	nodes, _, err := groupNodes(withConvertedTypes(md.Schema), 0, m.unsupportedHandler(f, &unsupported))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if m.opts.Int96As == "" {
+		if column := int96Column(nodes); column != "" {
+			return nil, nil, nil, fmt.Errorf("%s: column %s: INT96 is not supported without -int96-as", fname, column)
+		}
+	}
+	if len(m.opts.Renames) == 0 && m.opts.NormalizeNames == "none" {
+		return nodes, nil, unsupported, nil
+	}
+	renamed := map[string]string{}
+	out := map[string]parquet.Node{}
+	for _, name := range sortedKeys(nodes) {
+		target := name
+		if to, ok := m.opts.Renames[name]; ok {
+			target = to
+		}
+		target = normalizeName(target, m.opts.NormalizeNames)
+		if _, ok := out[target]; ok {
+			from := target
+			if old, ok := renamed[target]; ok {
+				from = old
+			}
+			return nil, nil, nil, fmt.Errorf("%s: columns %s and %s both become %s", fname, from, name, target)
+		}
+		out[target] = nodes[name]
+		if target != name {
+			renamed[target] = name
+		}
+	}
+	for i := range unsupported {
+		name := unsupported[i].Column
+		if to, ok := m.opts.Renames[name]; ok {
+			name = to
+		}
+		unsupported[i].Column = normalizeName(name, m.opts.NormalizeNames)
+	}
+	return out, renamed, unsupported, nil
+}
+
+// unsupportedFunc decides what becomes of a top-level column whose node
+// cannot be built because of err: it returns the node to read it with, nil
+// to leave it out, or an error to fail the file.
+type unsupportedFunc func(name string, err error) (parquet.Node, error)
+
+// groupNodes rebuilds the children of the group element at index i of the
+// flattened, depth-first schema list, returning them along with the index
+// of the element following the group.  Children that cannot be built are
+// passed to unsupported, if it is not nil.
+func groupNodes(elements []format.SchemaElement, i int, unsupported unsupportedFunc) (map[string]parquet.Node, int, error) {
+	nodes := map[string]parquet.Node{}
+	group := elements[i]
+	next := i + 1
+	for c := 0; c < int(group.NumChildren); c++ {
+		if next >= len(elements) {
+			return nil, next, fmt.Errorf("group %s: truncated schema", group.Name)
+		}
+		schema := elements[next]
+		node, n, err := elementNode(elements, next)
+		if err != nil {
+			end, ok := skipElement(elements, next)
+			if unsupported == nil || !ok {
+				return nil, n, err
+			}
+			if node, err = unsupported(schema.Name, err); err != nil {
+				return nil, n, err
+			}
+			n = end
+		}
+		next = n
+		if node == nil {
+			continue
+		}
+		if _, ok := nodes[schema.Name]; ok {
+			return nil, next, fmt.Errorf("schema mismatch: duplicate field %s", schema.Name)
+		}
+		nodes[schema.Name] = withFieldID(node, int(schema.FieldID))
+	}
+	return nodes, next, nil
+}
+
+// elementNode builds the node of the element at index i, returning it
+// along with the index of the element following it.
+func elementNode(elements []format.SchemaElement, i int) (parquet.Node, int, error) {
+	schema := elements[i]
+	switch {
+	case schema.LogicalType != nil && schema.LogicalType.Map != nil:
+		key, value, n, err := mapNodes(elements, i)
+		if err != nil {
+			return nil, n, err
+		}
+		return withElementRepetition(parquet.Map(key, value), schema), n, nil
+	case schema.LogicalType != nil && schema.LogicalType.List != nil:
+		elem, n, err := listElementNode(elements, i)
+		if err != nil {
+			return nil, n, err
+		}
+		return withElementRepetition(parquet.List(elem), schema), n, nil
+	case schema.Type == nil:
+		children, n, err := groupNodes(elements, i, nil)
+		if err != nil {
+			return nil, n, err
+		}
+		return withElementRepetition(parquet.Group(children), schema), n, nil
+	}
+	stype, err := leafNode(schema)
+	if err != nil {
+		return nil, i + 1, err
+	}
+	return withElementRepetition(stype, schema), i + 1, nil
+}
+
+// skipElement returns the index of the element following the element at
+// index i and its children, and false if the schema ends before them.
+func skipElement(elements []format.SchemaElement, i int) (int, bool) {
+	next := i + 1
+	for c := 0; c < int(elements[i].NumChildren); c++ {
+		if next >= len(elements) {
+			return next, false
+		}
+		var ok bool
+		if next, ok = skipElement(elements, next); !ok {
+			return next, false
+		}
+	}
+	return next, true
+}
+
+// listElementNode returns the element node of the LIST-annotated group at
+// index i, along with the index of the element following the list.  Both
+// the standard three-level layout and the legacy two-level layout with a
+// repeated primitive are accepted.
+func listElementNode(elements []format.SchemaElement, i int) (parquet.Node, int, error) {
+	list := elements[i]
+	if list.NumChildren != 1 || i+1 >= len(elements) {
+		return nil, i + 1, fmt.Errorf("list %s: expected a single repeated child", list.Name)
+	}
+	repeated := elements[i+1]
+	element := repeated
+	next := i + 2
+	if repeated.Type == nil {
+		if repeated.NumChildren != 1 || i+2 >= len(elements) {
+			return nil, next, fmt.Errorf("list %s: lists of groups are not supported", list.Name)
+		}
+		element = elements[i+2]
+		next = i + 3
+	}
+	if element.Type == nil {
+		return nil, next, fmt.Errorf("list %s: lists of groups are not supported", list.Name)
+	}
+	node, err := leafNode(element)
+	if err != nil {
+		return nil, next, fmt.Errorf("list %s: %w", list.Name, err)
+	}
+	// The repeated primitive of the legacy layout is itself the element,
+	// and cannot be null.
+	if next == i+2 {
+		return withFieldID(parquet.Required(node), int(element.FieldID)), next, nil
+	}
+	return withFieldID(withElementRepetition(node, element), int(element.FieldID)), next, nil
+}
+
+// mapNodes returns the key and value nodes of the MAP-annotated group at
+// index i, along with the index of the element following the map.  Only
+// STRING keys and primitive values are supported.
+func mapNodes(elements []format.SchemaElement, i int) (parquet.Node, parquet.Node, int, error) {
+	m := elements[i]
+	if m.NumChildren != 1 || i+3 >= len(elements) || elements[i+1].NumChildren != 2 {
+		return nil, nil, i + 1, fmt.Errorf("map %s: expected a repeated key_value group with key and value", m.Name)
+	}
+	key, value := elements[i+2], elements[i+3]
+	next := i + 4
+	if key.Type == nil || key.LogicalType == nil || key.LogicalType.UTF8 == nil {
+		return nil, nil, next, fmt.Errorf("map %s: unsupported key type %s, only STRING keys are supported", m.Name, schemaElementType(key))
+	}
+	if value.Type == nil {
+		return nil, nil, next, fmt.Errorf("map %s: maps of groups are not supported", m.Name)
+	}
+	node, err := leafNode(value)
+	if err != nil {
+		return nil, nil, next, fmt.Errorf("map %s: %w", m.Name, err)
+	}
+	return withFieldID(parquet.String(), int(key.FieldID)), withFieldID(withElementRepetition(node, value), int(value.FieldID)), next, nil
+}
+
+// withElementRepetition wraps node with the repetition declared by e,
+// defaulting to optional when the element does not declare one.
+func withElementRepetition(node parquet.Node, e format.SchemaElement) parquet.Node {
+	if e.RepetitionType != nil {
+		switch *e.RepetitionType {
+		case format.Required:
+			return parquet.Required(node)
+		case format.Repeated:
+			return parquet.Repeated(node)
+		}
+	}
+	return parquet.Optional(node)
+}
+
+// withFieldID gives node the field ID id, unless it is 0, which parquet
+// uses for no ID.
+func withFieldID(node parquet.Node, id int) parquet.Node {
+	if id == 0 {
+		return node
+	}
+	return parquet.FieldID(node, id)
+}
+
+// schemaElementType describes the type of a schema element for messages.
+func schemaElementType(e format.SchemaElement) string {
+	if e.Type == nil {
+		return "group"
+	}
+	if e.LogicalType != nil {
+		return fmt.Sprintf("%s (%s)", e.Type, e.LogicalType)
+	}
+	return e.Type.String()
+}
+
+var (
+	nodemap = map[string]parquet.Node{
+		"INT8":       parquet.Optional(parquet.Int(8)),
+		"INT16":      parquet.Optional(parquet.Int(16)),
+		"INT32":      parquet.Optional(parquet.Int(32)),
+		"INT64":      parquet.Optional(parquet.Int(64)),
+		"UINT8":      parquet.Optional(parquet.Uint(8)),
+		"UINT16":     parquet.Optional(parquet.Uint(16)),
+		"UINT32":     parquet.Optional(parquet.Uint(32)),
+		"UINT64":     parquet.Optional(parquet.Uint(64)),
+		"FLOAT":      parquet.Optional(parquet.Leaf(parquet.FloatType)),
+		"DOUBLE":     parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		"BOOLEAN":    parquet.Optional(parquet.Leaf(parquet.BooleanType)),
+		"BYTE_ARRAY": parquet.Optional(parquet.Leaf(parquet.ByteArrayType)),
+		"UUID":       parquet.Optional(parquet.UUID()),
+		"ENUM":       parquet.Optional(parquet.Enum()),
+		"JSON":       parquet.Optional(parquet.JSON()),
+		"BSON":       parquet.Optional(parquet.BSON()),
+	}
+	string_node = parquet.Optional(parquet.String())
+
+	intLogicalTypes = map[string]string{
+		"INT(8,true)":   "INT8",
+		"INT(16,true)":  "INT16",
+		"INT(32,true)":  "INT32",
+		"INT(64,true)":  "INT64",
+		"INT(8,false)":  "UINT8",
+		"INT(16,false)": "UINT16",
+		"INT(32,false)": "UINT32",
+		"INT(64,false)": "UINT64",
+	}
+)
+
+// leafNode builds the node for a primitive schema element.  Logical types
+// carrying parameters are handled here; everything else is looked up by name.
+func leafNode(e format.SchemaElement) (parquet.Node, error) {
+	if e.LogicalType != nil && e.LogicalType.Decimal != nil {
+		return decimalNode(e)
+	}
+	if e.LogicalType != nil {
+		if node := temporalNode(e); node != nil {
+			return node, nil
+		}
+	}
+	if *e.Type == format.Int96 {
+		return parquet.Optional(parquet.Leaf(parquet.Int96Type)), nil
+	}
+	if *e.Type == format.FixedLenByteArray && (e.LogicalType == nil || e.LogicalType.UUID == nil) {
+		if e.TypeLength == nil || *e.TypeLength <= 0 {
+			return nil, fmt.Errorf("fixed length byte array %s: missing type length", e.Name)
+		}
+		return parquet.Optional(parquet.Leaf(parquet.FixedLenByteArrayType(int(*e.TypeLength)))), nil
+	}
+	logicalType := ""
+	if e.LogicalType != nil {
+		logicalType = e.LogicalType.String()
+	}
+	return schemaTypeToNode(e.Type.String(), logicalType)
+}
+
+func schemaTypeToNode(typ, logical string) (parquet.Node, error) {
+	if logical == "STRING" {
+		return string_node, nil
+	}
+	if _, ok := nodemap[logical]; ok {
+		typ = logical
+	}
+	if name, ok := intLogicalTypes[logical]; ok {
+		typ = name
+	}
+	if node, ok := nodemap[typ]; ok {
+		return node, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %s, logical %s", typ, logical)
+}
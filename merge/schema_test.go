@@ -0,0 +1,168 @@
+package merge
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestNestedAttributes(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet")
+	writeParquet(t, a, parquet.Group{
+		"id": parquet.Int(64),
+		"attributes": parquet.Group{
+			"host": parquet.String(),
+			"port": parquet.Int(32),
+		},
+	}, []map[string]any{{"id": int64(1), "attributes": map[string]any{"host": "a", "port": int32(80)}}})
+	writeParquet(t, b, parquet.Group{
+		"id": parquet.Int(64),
+		"attributes": parquet.Group{
+			"host":   parquet.String(),
+			"port":   parquet.Int(64),
+			"region": parquet.String(),
+		},
+	}, []map[string]any{{"id": int64(2), "attributes": map[string]any{"host": "b", "port": int64(443), "region": "eu"}}})
+	out := filepath.Join(dir, "merged.parquet")
+	runMerge(t, testOptions(out, a, b))
+	schema, rows := readParquet(t, out)
+
+	// The group is merged field by field: port is widened, and region,
+	// which only b has, is optional.
+	attributes, ok := fieldByName(schema, "attributes")
+	if !ok || attributes.Leaf() || attributes.Optional() {
+		t.Fatalf("attributes is %v, want a required group", attributes)
+	}
+	want := map[string]struct {
+		kind     parquet.Kind
+		optional bool
+	}{
+		"host":   {parquet.ByteArray, false},
+		"port":   {parquet.Int64, false},
+		"region": {parquet.ByteArray, true},
+	}
+	fields := attributes.Fields()
+	if len(fields) != len(want) {
+		t.Errorf("attributes has %d fields, want %d", len(fields), len(want))
+	}
+	for _, f := range fields {
+		w, ok := want[f.Name()]
+		if !ok {
+			t.Errorf("attributes has the field %s", f.Name())
+			continue
+		}
+		if f.Type().Kind() != w.kind || f.Optional() != w.optional {
+			t.Errorf("attributes.%s is %v, optional %v, want %v, optional %v", f.Name(), f.Type().Kind(), f.Optional(), w.kind, w.optional)
+		}
+	}
+
+	wantRows := []map[string]any{
+		{"id": int64(1), "attributes": map[string]any{"host": "a", "port": int64(80), "region": nil}},
+		{"id": int64(2), "attributes": map[string]any{"host": "b", "port": int64(443), "region": "eu"}},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("merged the rows %v, want %v", rows, wantRows)
+	}
+}
+
+// fieldByName returns the field of node named name.
+func fieldByName(node parquet.Node, name string) (parquet.Field, bool) {
+	for _, f := range node.Fields() {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// writeRows writes rows, given as values with their levels, to a file of
+// the columns of g at path.
+func writeRows(t *testing.T, path string, g parquet.Group, rows ...parquet.Row) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := parquet.NewWriter(f, parquet.NewSchema("test", g))
+	if _, err := w.WriteRows(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listColumn returns the elements of the list column name of row, read by
+// readParquet.
+func listColumn(row map[string]any, name string) []any {
+	var l []any
+	m, _ := row[name].(map[string]any)
+	items, _ := m["list"].([]any)
+	for _, item := range items {
+		l = append(l, item.(map[string]any)["element"])
+	}
+	return l
+}
+
+func TestListNullElements(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet"), filepath.Join(dir, "c.parquet")
+	// The elements of l are optional in a and b, and required in c.
+	writeRows(t, a, parquet.Group{"id": parquet.Int(64), "l": parquet.List(parquet.Optional(parquet.Int(32)))},
+		parquet.Row{
+			parquet.Int64Value(1).Level(0, 0, 0),
+			parquet.Int32Value(10).Level(0, 2, 1),
+			parquet.NullValue().Level(1, 1, 1),
+			parquet.Int32Value(20).Level(1, 2, 1),
+		},
+		parquet.Row{parquet.Int64Value(2).Level(0, 0, 0), parquet.NullValue().Level(0, 0, 1)})
+	writeRows(t, b, parquet.Group{"id": parquet.Int(64), "l": parquet.List(parquet.Optional(parquet.Int(64)))},
+		parquet.Row{
+			parquet.Int64Value(3).Level(0, 0, 0),
+			parquet.NullValue().Level(0, 1, 1),
+			parquet.Int64Value(30).Level(1, 2, 1),
+		})
+	writeRows(t, c, parquet.Group{"id": parquet.Int(64), "l": parquet.List(parquet.Int(32))},
+		parquet.Row{
+			parquet.Int64Value(4).Level(0, 0, 0),
+			parquet.Int32Value(0).Level(0, 1, 1),
+			parquet.Int32Value(2).Level(1, 1, 1),
+		})
+
+	want := [][]any{{int64(10), nil, int64(20)}, nil, {nil, int64(30)}, {int64(0), int64(2)}}
+	for _, noFastpath := range []bool{false, true} {
+		out := filepath.Join(dir, "merged.parquet")
+		opts := testOptions(out, a, b, c)
+		opts.NoFastpath = noFastpath
+		runMerge(t, opts)
+		_, rows := readParquet(t, out)
+		var got [][]any
+		for _, row := range rows {
+			got = append(got, listColumn(row, "l"))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("no fastpath %v: merged the lists %v, want %v", noFastpath, got, want)
+		}
+	}
+
+	// Stringified, the null is written as JSON null.
+	d := filepath.Join(dir, "d.parquet")
+	writeParquet(t, d, parquet.Group{"id": parquet.Int(64), "l": parquet.String()}, []map[string]any{{"id": int64(5), "l": "x"}})
+	out := filepath.Join(dir, "stringified.parquet")
+	opts := testOptions(out, a, d)
+	opts.OnConflict = "stringify"
+	runMerge(t, opts)
+	_, rows := readParquet(t, out)
+	var got []any
+	for _, row := range rows {
+		got = append(got, row["l"])
+	}
+	if want := []any{"[10,null,20]", "[]", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stringified the lists %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package merge
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// schemaField describes a field of the merged schema in the -schema-out
+// file.  Groups have fields and no physical type.
+type schemaField struct {
+	Name         string        `json:"name"`
+	PhysicalType string        `json:"physical_type,omitempty"`
+	TypeLength   int           `json:"type_length,omitempty"`
+	LogicalType  string        `json:"logical_type,omitempty"`
+	Repetition   string        `json:"repetition"`
+	FieldID      int           `json:"field_id,omitempty"`
+	Fields       []schemaField `json:"fields,omitempty"`
+}
+
+func describeField(name string, node parquet.Node) schemaField {
+	f := schemaField{Name: name, Repetition: "REQUIRED", FieldID: node.ID()}
+	switch {
+	case node.Repeated():
+		f.Repetition = "REPEATED"
+	case node.Optional():
+		f.Repetition = "OPTIONAL"
+	}
+	if lt := node.Type().LogicalType(); lt != nil {
+		f.LogicalType = lt.String()
+	}
+	if node.Leaf() {
+		f.PhysicalType = node.Type().Kind().String()
+		if node.Type().Kind() == parquet.FixedLenByteArray {
+			f.TypeLength = node.Type().Length()
+		}
+		return f
+	}
+	for _, child := range node.Fields() {
+		f.Fields = append(f.Fields, describeField(child.Name(), child))
+	}
+	return f
+}
+
+// writeSchemaOut writes the -schema-out description of schema, the
+// schema of the output written.
+func (m *Merger) writeSchemaOut(schema *parquet.Schema) error {
+	name := m.opts.SchemaOut
+	if m.opts.SchemaOutFormat == "text" {
+		if err := replaceFile(schemaTextName(name), []byte(schema.String()+"\n")); err != nil {
+			return err
+		}
+	}
+	var fields []schemaField
+	for _, f := range schema.Fields() {
+		fields = append(fields, describeField(f.Name(), f))
+	}
+	b, err := json.MarshalIndent(struct {
+		Fields []schemaField `json:"fields"`
+	}{fields}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(name, append(b, '\n'))
+}
+
+// schemaTextName returns the name of the text schema written beside the
+// JSON one, name: name with a .txt extension.
+func schemaTextName(name string) string {
+	if filepath.Ext(name) == ".txt" {
+		return name + ".txt"
+	}
+	return name[:len(name)-len(filepath.Ext(name))] + ".txt"
+}
+
+// replaceFile replaces the file name with b, by writing it as name.tmp and
+// renaming it, so that readers never see a partly written file.
+func replaceFile(name string, b []byte) error {
+	if err := os.WriteFile(name+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
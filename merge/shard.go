@@ -0,0 +1,140 @@
+package merge
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/bloom/xxhash"
+)
+
+// shardWriter writes each row to one of a fixed number of files by a hash
+// of its key column, so that rows with the same key always end up in the
+// same shard.  Every shard is opened up front, so that a shard no row
+// hashes to is still written, as a file without rows.
+type shardWriter struct {
+	column int
+	schema *parquet.Schema
+	shards []*outputWriter
+}
+
+// checkShardColumn checks that name is a non-repeated leaf column of
+// schema, and returns its index.
+func checkShardColumn(schema *parquet.Schema, name string) (int, error) {
+	leaf, ok := lookupColumn(schema, name)
+	switch {
+	case !ok:
+		return 0, fmt.Errorf("shard column %s is not in the merged schema", name)
+	case leaf.MaxRepetitionLevel > 0:
+		return 0, fmt.Errorf("shard column %s is repeated", name)
+	}
+	return leaf.ColumnIndex, nil
+}
+
+// shardTemplate returns the names of the shards of outfile: its name with
+// -shard- and a two digit or wider shard number before the extension.
+func shardTemplate(outfile string, shards int) string {
+	ext := filepath.Ext(outfile)
+	width := max(2, len(strconv.Itoa(shards-1)))
+	return fmt.Sprintf("%s-shard-%%0%dd%s", strings.ReplaceAll(strings.TrimSuffix(outfile, ext), "%", "%%"), width, ext)
+}
+
+func newShardWriter(outfile string, shards, column int, schema *parquet.Schema, newWriter func(io.Writer) mergeWriter) (*shardWriter, error) {
+	w := &shardWriter{column: column, schema: schema}
+	template := shardTemplate(outfile, shards)
+	for i := 0; i < shards; i++ {
+		out, err := newOutputWriter(fmt.Sprintf(template, i), "", 0, 0, 0, newWriter)
+		if err != nil {
+			w.discard()
+			return nil, err
+		}
+		w.shards = append(w.shards, out)
+	}
+	return w, nil
+}
+
+// shard returns the shard of row: the xxHash of the bytes of its key
+// modulo the number of shards, and 0 if the key is null.
+func (w *shardWriter) shard(row parquet.Row) int {
+	v := columnValue(row, w.column)
+	if v.IsNull() {
+		return 0
+	}
+	return int(xxhash.Sum64(v.Bytes()) % uint64(len(w.shards)))
+}
+
+func (w *shardWriter) WriteRows(rows []parquet.Row) (int, error) {
+	for i := 0; i < len(rows); {
+		shard := w.shard(rows[i])
+		j := i + 1
+		for j < len(rows) && w.shard(rows[j]) == shard {
+			j++
+		}
+		n, err := w.shards[shard].WriteRows(rows[i:j])
+		if err != nil {
+			return i + n, err
+		}
+		i = j
+	}
+	return len(rows), nil
+}
+
+func (w *shardWriter) Schema() *parquet.Schema { return w.schema }
+
+func (w *shardWriter) Flush() error {
+	for _, out := range w.shards {
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *shardWriter) Close() error {
+	for _, out := range w.shards {
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *shardWriter) names() []string {
+	var names []string
+	for _, out := range w.shards {
+		names = append(names, out.names()...)
+	}
+	return names
+}
+
+func (w *shardWriter) written() int64 {
+	var n int64
+	for _, out := range w.shards {
+		n += out.written()
+	}
+	return n
+}
+
+// rows returns the rows written to each shard.
+func (w *shardWriter) rows() []int64 {
+	rows := make([]int64, len(w.shards))
+	for i, out := range w.shards {
+		for _, p := range out.pieces {
+			rows[i] += p.rows
+		}
+	}
+	return rows
+}
+
+func (w *shardWriter) report(logger *slog.Logger) {
+	logger.Info("wrote shards", "shards", len(w.shards))
+	for i, out := range w.shards {
+		for _, p := range out.pieces {
+			logger.Info("wrote a shard", "shard", i, "file", p.name, "rows", p.rows)
+		}
+	}
+}
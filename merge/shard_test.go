@@ -0,0 +1,58 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go/bloom/xxhash"
+)
+
+func TestShardBy(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "merged.parquet")
+	opts := testOptions(out, writeMixedInputs(t, dir)...)
+	opts.ShardBy, opts.Shards = "name", 3
+	stats := runMerge(t, opts)
+	if len(stats.OutputFiles) != 3 {
+		t.Fatalf("wrote %v, want 3 shards", stats.OutputFiles)
+	}
+	var total int64
+	for i, file := range stats.OutputFiles {
+		if want := filepath.Join(dir, fmt.Sprintf("merged-shard-%02d.parquet", i)); file != want {
+			t.Errorf("wrote shard %d to %s, want %s", i, file, want)
+		}
+		_, rows := readParquet(t, file)
+		if int64(len(rows)) != stats.ShardRows[i] {
+			t.Errorf("shard %d has %d rows, but the stats count %d", i, len(rows), stats.ShardRows[i])
+		}
+		total += int64(len(rows))
+		for _, row := range rows {
+			want := 0
+			if name, ok := row["name"].(string); ok {
+				want = int(xxhash.Sum64([]byte(name)) % 3)
+			}
+			if want != i {
+				t.Errorf("row %v is in shard %d, want %d", row, i, want)
+			}
+		}
+	}
+	if total != 6 {
+		t.Errorf("the shards hold %d rows, want 6", total)
+	}
+}
+
+func TestShardColumn(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions(filepath.Join(dir, "merged.parquet"), writeMixedInputs(t, dir)...)
+	opts.ShardBy, opts.Shards = "missing", 2
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("sharding by a column no file has: error %v, want ErrInvalidOptions", err)
+	}
+}
@@ -0,0 +1,192 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// checkSortKey verifies that key names a top-level, non-repeated leaf
+// column of the merged schema.
+func checkSortKey(mergedSchema map[string]parquet.Node, key string) error {
+	node, ok := mergedSchema[key]
+	if !ok {
+		return fmt.Errorf("sort column %s is not in any input file", key)
+	}
+	if !node.Leaf() || node.Repeated() {
+		return fmt.Errorf("sort column %s must be a non-repeated primitive column", key)
+	}
+	return nil
+}
+
+// parseSortColumns parses a -sortby list such as "timestamp,_id:desc",
+// with nulls sorted first or last.  Columns missing from some inputs sort
+// as nulls.
+func parseSortColumns(spec, nulls string, mergedSchema map[string]parquet.Node) ([]parquet.SortingColumn, error) {
+	var columns []parquet.SortingColumn
+	for _, field := range strings.Split(spec, ",") {
+		name, order, _ := strings.Cut(strings.TrimSpace(field), ":")
+		if err := checkSortKey(mergedSchema, name); err != nil {
+			return nil, err
+		}
+		var column parquet.SortingColumn
+		switch order {
+		case "", "asc":
+			column = parquet.Ascending(name)
+		case "desc":
+			column = parquet.Descending(name)
+		default:
+			return nil, fmt.Errorf("sortby %s: unknown order %q, must be asc or desc", name, order)
+		}
+		if nulls == "first" {
+			column = parquet.NullsFirst(column)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// sortedInput presents an input file, already sorted by the merge key, as
+// a row group parquet.MergeRowGroups can merge.
+type sortedInput struct {
+	rows    *fileRows
+	sorting []parquet.SortingColumn
+}
+
+func (s *sortedInput) NumRows() int64 { return s.rows.numRows }
+
+// ColumnChunks returns empty chunks; merging only reads rows.
+func (s *sortedInput) ColumnChunks() []parquet.ColumnChunk {
+	return make([]parquet.ColumnChunk, len(s.rows.merged.Columns()))
+}
+
+func (s *sortedInput) Schema() *parquet.Schema { return s.rows.merged }
+
+func (s *sortedInput) SortingColumns() []parquet.SortingColumn { return s.sorting }
+
+// Rows clones the rows of files read directly, whose values otherwise
+// point into pages reused while the merge still holds them.
+func (s *sortedInput) Rows() parquet.Rows {
+	if s.rows.direct {
+		return clonedRows{s.rows}
+	}
+	return s.rows
+}
+
+// mergeSorted writes the rows of inputs to writer in order of -sorted-by,
+// using a k-way merge of the inputs.  Inputs found not to be sorted are
+// rejected, or sorted in memory when -unsorted=buffer.
+func (m *Merger) mergeSorted(ctx context.Context, writer mergeWriter, inputs []inputFile, rowSchema *parquet.Schema, fileNodes map[string]map[string]parquet.Node) error {
+	if err := m.checkSortedOpenFiles(len(inputs)); err != nil {
+		return err
+	}
+	sorting := []parquet.SortingColumn{parquet.Ascending(m.opts.SortedBy)}
+	var groups []parquet.RowGroup
+	merging := false
+	defer func() {
+		if merging {
+			return
+		}
+		for _, g := range groups {
+			if s, ok := g.(*sortedInput); ok {
+				s.rows.Close()
+			}
+		}
+	}()
+	// read holds the rows of each input, to count the rows read from it.
+	read := make([]*fileRows, len(inputs))
+	for i, input := range inputs {
+		unsorted := int64(-1)
+		if node, ok := fileNodes[input.file][m.opts.SortedBy]; ok {
+			var err error
+			unsorted, err = m.firstUnsortedRow(input.file, m.opts.SortedBy, node)
+			if err != nil {
+				return fmt.Errorf("%s: %w", input.file, err)
+			}
+			if unsorted >= 0 && m.opts.Unsorted == "reject" {
+				return fmt.Errorf("%s is not sorted by %s: row %d is out of order", input.file, m.opts.SortedBy, unsorted)
+			}
+		}
+		rows, err := m.openFileRows(input, writer.Schema(), rowSchema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.file, err)
+		}
+		read[i] = rows
+		if unsorted >= 0 {
+			m.log.Info("file is not sorted, sorting it in memory", "phase", "copy", "file", input.file, "column", m.opts.SortedBy, "row", unsorted)
+			buf, err := m.bufferSorted(ctx, rows, sorting)
+			rows.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", input.file, err)
+			}
+			groups = append(groups, buf)
+			continue
+		}
+		groups = append(groups, &sortedInput{rows: rows, sorting: sorting})
+	}
+
+	merged, err := parquet.MergeRowGroups(groups, parquet.SortingRowGroupConfig(parquet.SortingColumns(sorting...)))
+	if err != nil {
+		return err
+	}
+	// Closing the merged rows closes the rows of every input.
+	merging = true
+	rows := merged.Rows()
+	defer rows.Close()
+	_, err = m.copyRows(ctx, writer, rows)
+	complete := err == nil
+	for i, r := range read {
+		m.countRows(inputs[i].file, r.read, r.numRows, complete)
+	}
+	return err
+}
+
+// bufferSorted reads all of rows into memory and sorts them.
+func (m *Merger) bufferSorted(ctx context.Context, rows *fileRows, sorting []parquet.SortingColumn) (*parquet.Buffer, error) {
+	buf := parquet.NewBuffer(rows.Schema(), parquet.SortingRowGroupConfig(parquet.SortingColumns(sorting...)))
+	if _, err := m.copyRows(ctx, buf, rows); err != nil {
+		return nil, err
+	}
+	sort.Stable(buf)
+	return buf, nil
+}
+
+// firstUnsortedRow returns the index of the first row of file whose key is
+// smaller than the key before it, or -1 if the file is sorted.  Nulls sort
+// last.
+func (m *Merger) firstUnsortedRow(file, key string, node parquet.Node) (int64, error) {
+	pf, inf, err := m.openInputFile(file)
+	if err != nil {
+		return 0, err
+	}
+	defer inf.Close()
+	r := parquet.NewReader(pf, parquet.NewSchema("key", parquet.Group{key: node}))
+	defer r.Close()
+
+	typ := node.Type()
+	rows := make([]parquet.Row, m.opts.BatchSize)
+	var prev parquet.Value
+	var index int64
+	for {
+		n, err := r.ReadRows(rows)
+		for _, row := range rows[:n] {
+			v := row[0]
+			if index > 0 && (prev.IsNull() && !v.IsNull() || !prev.IsNull() && !v.IsNull() && typ.Compare(prev, v) > 0) {
+				return index, nil
+			}
+			prev = v.Clone()
+			index++
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return -1, nil
+			}
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package merge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// mergeState is the -state file: the inputs merged by earlier runs, keyed
+// by path, and the output files they were written to, in order.
+type mergeState struct {
+	Outputs []string              `json:"outputs"`
+	Inputs  map[string]stateInput `json:"inputs"`
+}
+
+// stateInput is an input file as it was when it was merged.
+type stateInput struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Rows    int64     `json:"rows"`
+}
+
+// loadState reads the state file name, or returns an empty state if there
+// is none yet.
+func loadState(name string) (*mergeState, error) {
+	s := &mergeState{Inputs: map[string]stateInput{}}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if s.Inputs == nil {
+		s.Inputs = map[string]stateInput{}
+	}
+	return s, nil
+}
+
+// write replaces the state file name with s, so the old state survives a
+// failed write.
+func (s *mergeState) write(name string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(name, append(b, '\n'))
+}
+
+// unmergedFiles returns the files that are not in the state with the size
+// and modification time they have now.  Files that changed since they were
+// merged are merged again.
+func (m *Merger) unmergedFiles(files []string) ([]string, error) {
+	var out []string
+	for _, file := range files {
+		prev, ok := m.state.Inputs[file]
+		if !ok {
+			out = append(out, file)
+			continue
+		}
+		r, size, modTime, err := m.openInput(file)
+		if err != nil {
+			return nil, markError(ErrRead, err)
+		}
+		r.Close()
+		if sameInput(prev, stateInput{Size: size, ModTime: modTime}) {
+			m.skipFile(file, "already merged", "")
+			continue
+		}
+		m.log.Warn("file changed since it was merged, merging it again", "phase", "find", "file", file)
+		out = append(out, file)
+	}
+	return out, nil
+}
+
+// record adds the files written and the inputs read to the end, which are
+// looked up in scanned, to s.
+func (s *mergeState) record(outputs []string, merged []FileStats, scanned []scannedFile) {
+	s.Outputs = append(s.Outputs, outputs...)
+	files := make(map[string]scannedFile, len(scanned))
+	for _, sf := range scanned {
+		files[sf.file] = sf
+	}
+	for _, f := range merged {
+		sf := files[f.File]
+		s.Inputs[f.File] = stateInput{Size: sf.size, ModTime: sf.modTime, Rows: f.RowsRead}
+	}
+}
@@ -0,0 +1,50 @@
+package merge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStateIncremental(t *testing.T) {
+	dir := t.TempDir()
+	files := writeMixedInputs(t, dir)
+	out := filepath.Join(dir, "merged.parquet")
+	state := filepath.Join(dir, "state.json")
+	merge := func(files ...string) Stats {
+		opts := testOptions(out, files...)
+		opts.StateFile = state
+		return runMerge(t, opts)
+	}
+
+	merge(files[:2]...)
+	if got, want := outputIDs(t, out), []int64{1, 2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("the first run merged the ids %v, want %v", got, want)
+	}
+	stats := merge(files...)
+	next := filepath.Join(dir, "merged-00002.parquet")
+	if !slices.Equal(stats.OutputFiles, []string{next}) {
+		t.Errorf("the second run wrote %v, want %s", stats.OutputFiles, next)
+	}
+	if got, want := outputIDs(t, next), []int64{5, 6}; !slices.Equal(got, want) {
+		t.Errorf("the second run merged the ids %v, want %v", got, want)
+	}
+	if got, want := outputIDs(t, out), []int64{1, 2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("the second run left the ids %v in the first file, want %v", got, want)
+	}
+	stats = merge(files...)
+	if len(stats.OutputFiles) != 0 || stats.FilesSkipped != 3 {
+		t.Errorf("the third run wrote %v and skipped %d files, want nothing written and 3 skipped", stats.OutputFiles, stats.FilesSkipped)
+	}
+
+	s, err := loadState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.Outputs, []string{out, next}) {
+		t.Errorf("the state lists the outputs %v, want %v", s.Outputs, []string{out, next})
+	}
+	if s.Inputs[files[1]].Rows != 2 {
+		t.Errorf("the state records %d rows of %s, want 2", s.Inputs[files[1]].Rows, files[1])
+	}
+}
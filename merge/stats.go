@@ -0,0 +1,209 @@
+package merge
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Stats summarizes a run.
+type Stats struct {
+	// FilesScanned is the number of input files found, FilesIncluded the
+	// number whose rows were merged, and FilesSkipped the rest, which are
+	// listed in Skipped.
+	FilesScanned  int           `json:"files_scanned"`
+	FilesIncluded int           `json:"files_included"`
+	FilesSkipped  int           `json:"files_skipped"`
+	Skipped       []SkippedFile `json:"skipped,omitempty"`
+	// Leftover lists the input files left for a later run once the output
+	// reached Options.TargetOutputBytes.
+	Leftover []string `json:"leftover,omitempty"`
+	// Files lists the rows read from each merged file, and RowsRead their
+	// total.
+	Files       []FileStats `json:"files,omitempty"`
+	RowsRead    int64       `json:"rows_read"`
+	RowsWritten int64       `json:"rows_written"`
+	// RowsDeleted counts the rows left out by Options.DeleteKeys.
+	RowsDeleted int64 `json:"rows_deleted"`
+	// CastNulls counts the values written as null because they could not
+	// be cast to their Options.TypeOverrides type.
+	CastNulls int64 `json:"cast_nulls,omitempty"`
+	// SuspiciousTimes counts the values of Options.TimeNormalize columns
+	// written although they are before 1970 or from 2200 on.
+	SuspiciousTimes int64 `json:"suspicious_times,omitempty"`
+	// Redacted maps the columns transformed by Options.Redact to how.
+	Redacted map[string]string `json:"redacted,omitempty"`
+	// InputBytes is the size of the merged files and BytesWritten the size
+	// of the output.
+	InputBytes   int64 `json:"input_bytes"`
+	BytesWritten int64 `json:"bytes_written"`
+	RowGroups    int64 `json:"row_groups"`
+	// OutputFiles lists the files written, unless Options.Output was set.
+	OutputFiles []string `json:"output_files,omitempty"`
+	// RowGroupsTransplanted counts the row groups copied from the inputs
+	// without re-encoding them, and RowGroupsReencoded the others, when the
+	// merge could do so.
+	RowGroupsTransplanted int64 `json:"row_groups_transplanted,omitempty"`
+	RowGroupsReencoded    int64 `json:"row_groups_reencoded,omitempty"`
+	// ShardRows counts the rows written to each shard with Options.ShardBy.
+	ShardRows []int64 `json:"shard_rows,omitempty"`
+	// SortRuns counts the sorted runs spilled with Options.SortExternal,
+	// and SortTempBytes their total size.
+	SortRuns      int   `json:"sort_runs,omitempty"`
+	SortTempBytes int64 `json:"sort_temp_bytes,omitempty"`
+	// Duration is the time the run took, ScanDuration the part of it
+	// spent reading footers and merging schemas, and CopyDuration the part
+	// spent copying rows.
+	Duration     time.Duration `json:"-"`
+	ScanDuration time.Duration `json:"-"`
+	CopyDuration time.Duration `json:"-"`
+}
+
+// SkippedFile is an input file left out of the merge.
+type SkippedFile struct {
+	File string `json:"file"`
+	// Reason is one of "bad file", "empty file", "missing required
+	// fields", "no selected columns", "schema conflict" or "already
+	// merged", and Detail says more.
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FileStats counts the rows read from a merged file: the rows its footer
+// gives for the row groups read, and the rows actually read.  They differ
+// if the file was not read to the end, or if its footer is wrong.
+// RowsDeleted counts the rows read that Options.DeleteKeys left out.
+type FileStats struct {
+	File        string `json:"file"`
+	FooterRows  int64  `json:"footer_rows"`
+	RowsRead    int64  `json:"rows_read"`
+	RowsDeleted int64  `json:"rows_deleted,omitempty"`
+}
+
+// CompressionRatio returns the size of the output as a fraction of the
+// size of the merged files.
+func (s Stats) CompressionRatio() float64 {
+	if s.InputBytes == 0 {
+		return 0
+	}
+	return float64(s.BytesWritten) / float64(s.InputBytes)
+}
+
+// RowsPerSecond returns the rows written per second of the run.
+func (s Stats) RowsPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.RowsWritten) / s.Duration.Seconds()
+}
+
+// MBPerSecond returns the megabytes (of 1024 KB) of merged files read per
+// second of the run.
+func (s Stats) MBPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.InputBytes) / (1 << 20) / s.Duration.Seconds()
+}
+
+// MarshalJSON adds the durations, in seconds, and the rates to the fields.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type stats Stats
+	return json.Marshal(struct {
+		stats
+		DurationSeconds  float64 `json:"duration_seconds"`
+		ScanSeconds      float64 `json:"scan_seconds"`
+		CopySeconds      float64 `json:"copy_seconds"`
+		CompressionRatio float64 `json:"compression_ratio"`
+		RowsPerSecond    float64 `json:"rows_per_second"`
+		MBPerSecond      float64 `json:"mb_per_second"`
+	}{stats(s), s.Duration.Seconds(), s.ScanDuration.Seconds(), s.CopyDuration.Seconds(), s.CompressionRatio(), s.RowsPerSecond(), s.MBPerSecond()})
+}
+
+// add adds the counts, lists and phase durations of o, a later merge, to
+// s, but not its duration.
+func (s *Stats) add(o Stats) {
+	s.FilesScanned += o.FilesScanned
+	s.FilesIncluded += o.FilesIncluded
+	s.FilesSkipped += o.FilesSkipped
+	s.Skipped = append(s.Skipped, o.Skipped...)
+	s.Files = append(s.Files, o.Files...)
+	s.RowsRead += o.RowsRead
+	s.RowsWritten += o.RowsWritten
+	s.RowsDeleted += o.RowsDeleted
+	s.CastNulls += o.CastNulls
+	s.SuspiciousTimes += o.SuspiciousTimes
+	s.InputBytes += o.InputBytes
+	s.BytesWritten += o.BytesWritten
+	s.RowGroups += o.RowGroups
+	s.OutputFiles = append(s.OutputFiles, o.OutputFiles...)
+	s.ScanDuration += o.ScanDuration
+	s.CopyDuration += o.CopyDuration
+}
+
+// skipFile records that file was left out of the merge.
+func (m *Merger) skipFile(file, reason, detail string) {
+	m.stats.Skipped = append(m.stats.Skipped, SkippedFile{File: file, Reason: reason, Detail: detail})
+}
+
+// countRows records the rows read from file and the rows its footer gives
+// for the row groups read, warning if they differ although the file was
+// read to the end.
+func (m *Merger) countRows(file string, read, footer int64, complete bool) {
+	deleted := m.deletes.count(file)
+	m.stats.Files = append(m.stats.Files, FileStats{File: file, FooterRows: footer, RowsRead: read, RowsDeleted: deleted})
+	m.stats.RowsRead += read
+	m.stats.RowsDeleted += deleted
+	if complete {
+		m.merged = append(m.merged, m.stats.Files[len(m.stats.Files)-1])
+	}
+	if complete && read != footer {
+		m.log.Warn("rows read differ from the footer", "phase", "copy", "file", file, "footer_rows", footer, "rows", read)
+	}
+}
+
+// logSummary logs the stats of a finished run.
+func (m *Merger) logSummary() {
+	s := m.stats
+	m.log.Info("summary", "files_scanned", s.FilesScanned, "files_included", s.FilesIncluded, "files_skipped", s.FilesSkipped,
+		"rows_read", s.RowsRead, "rows", s.RowsWritten, "input_bytes", s.InputBytes, "bytes", s.BytesWritten,
+		"compression_ratio", s.CompressionRatio(), "row_groups", s.RowGroups, "duration", s.Duration,
+		"rows_per_second", s.RowsPerSecond(), "mb_per_second", s.MBPerSecond())
+	if len(s.Redacted) > 0 {
+		var columns []string
+		for _, k := range sortedStrings(s.Redacted) {
+			columns = append(columns, k+"="+s.Redacted[k])
+		}
+		m.log.Info("redacted columns", "columns", columns)
+	}
+	if s.CastNulls > 0 {
+		m.log.Info("wrote null for values that cannot be cast", "values", s.CastNulls)
+	}
+	if s.SuspiciousTimes > 0 {
+		m.log.Warn("wrote times outside the plausible range", "values", s.SuspiciousTimes)
+	}
+	if s.RowGroupsTransplanted > 0 {
+		m.log.Info("transplanted row groups without re-encoding them", "transplanted", s.RowGroupsTransplanted, "re_encoded", s.RowGroupsReencoded)
+	}
+	if len(s.ShardRows) > 0 {
+		m.log.Info("rows per shard", "rows", s.ShardRows)
+	}
+	var reasons []string
+	counts := map[string]int{}
+	for _, f := range s.Skipped {
+		if counts[f.Reason] == 0 {
+			reasons = append(reasons, f.Reason)
+		}
+		counts[f.Reason]++
+	}
+	for _, reason := range reasons {
+		m.log.Info("skipped files", "reason", reason, "files", counts[reason])
+	}
+	if m.deletes != nil {
+		m.log.Info("deleted rows", "rows", s.RowsDeleted)
+		for _, f := range s.Files {
+			if f.RowsDeleted > 0 {
+				m.log.Info("deleted rows", "file", f.File, "rows", f.RowsDeleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package merge
+
+import (
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// localTimeType carries a TIME or TIMESTAMP annotation that is not adjusted
+// to UTC.  parquet.Time and parquet.Timestamp always set isAdjustedToUTC, so
+// without it the flag would be lost in the merged footer.
+type localTimeType struct {
+	parquet.Type
+	logical format.LogicalType
+}
+
+func (t *localTimeType) String() string { return t.logical.String() }
+
+func (t *localTimeType) LogicalType() *format.LogicalType { return &t.logical }
+
+// The legacy converted types imply UTC, so none is written for local times.
+func (t *localTimeType) ConvertedType() *deprecated.ConvertedType { return nil }
+
+func timeUnitOf(u format.TimeUnit) parquet.TimeUnit {
+	switch {
+	case u.Nanos != nil:
+		return parquet.Nanosecond
+	case u.Micros != nil:
+		return parquet.Microsecond
+	default:
+		return parquet.Millisecond
+	}
+}
+
+// temporalNode builds the node for a DATE, TIME or TIMESTAMP element, or
+// returns nil if the element has none of those logical types.
+func temporalNode(e format.SchemaElement) parquet.Node {
+	lt := e.LogicalType
+	switch {
+	case lt.Date != nil:
+		return parquet.Optional(parquet.Date())
+	case lt.Time != nil:
+		return parquet.Optional(timeNode(lt.Time.Unit, lt.Time.IsAdjustedToUTC))
+	case lt.Timestamp != nil:
+		return parquet.Optional(timestampNode(lt.Timestamp.Unit, lt.Timestamp.IsAdjustedToUTC))
+	}
+	return nil
+}
+
+func timeNode(unit format.TimeUnit, utc bool) parquet.Node {
+	node := parquet.Time(timeUnitOf(unit))
+	if utc {
+		return node
+	}
+	return parquet.Leaf(&localTimeType{
+		Type:    node.Type(),
+		logical: format.LogicalType{Time: &format.TimeType{IsAdjustedToUTC: false, Unit: unit}},
+	})
+}
+
+func timestampNode(unit format.TimeUnit, utc bool) parquet.Node {
+	node := parquet.Timestamp(timeUnitOf(unit))
+	if utc {
+		return node
+	}
+	return parquet.Leaf(&localTimeType{
+		Type:    node.Type(),
+		logical: format.LogicalType{Timestamp: &format.TimestampType{IsAdjustedToUTC: false, Unit: unit}},
+	})
+}
+
+func isTemporal(node parquet.Node) bool {
+	if !node.Leaf() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	return lt != nil && (lt.Date != nil || lt.Time != nil || lt.Timestamp != nil)
+}
+
+// temporalUnit returns the unit and UTC flag of a TIME or TIMESTAMP node.
+func temporalUnit(node parquet.Node) (format.TimeUnit, bool) {
+	lt := node.Type().LogicalType()
+	if lt.Time != nil {
+		return lt.Time.Unit, lt.Time.IsAdjustedToUTC
+	}
+	return lt.Timestamp.Unit, lt.Timestamp.IsAdjustedToUTC
+}
+
+// mergeTemporal returns the finer-grained of two TIME or two TIMESTAMP nodes
+// that agree on UTC adjustment.
+func mergeTemporal(a, b parquet.Node) (parquet.Node, bool) {
+	alt, blt := a.Type().LogicalType(), b.Type().LogicalType()
+	if (alt.Time != nil) != (blt.Time != nil) || (alt.Timestamp != nil) != (blt.Timestamp != nil) {
+		return nil, false
+	}
+	if alt.Date != nil || blt.Date != nil {
+		return nil, false
+	}
+	aunit, autc := temporalUnit(a)
+	bunit, butc := temporalUnit(b)
+	if autc != butc {
+		return nil, false
+	}
+	if timeUnitOf(aunit).Duration() <= timeUnitOf(bunit).Duration() {
+		return a, true
+	}
+	return b, true
+}
+
+// convertTimeUnit rescales a TIME or TIMESTAMP value read as from into the
+// unit of to.
+func convertTimeUnit(v any, from, to parquet.Node) (any, error) {
+	if !isTemporal(from) || from.Type().LogicalType().Date != nil {
+		return nil, fmt.Errorf("cannot convert %s to %s", nodeTypeName(from), nodeTypeName(to))
+	}
+	var n int64
+	switch x := v.(type) {
+	case int32:
+		n = int64(x)
+	case int64:
+		n = x
+	default:
+		return nil, fmt.Errorf("cannot convert %T to %s", v, nodeTypeName(to))
+	}
+	fromUnit, _ := temporalUnit(from)
+	toUnit, _ := temporalUnit(to)
+	n *= int64(timeUnitOf(fromUnit).Duration() / timeUnitOf(toUnit).Duration())
+	if to.Type().Kind() == parquet.Int32 {
+		return int32(n), nil
+	}
+	return n, nil
+}
@@ -0,0 +1,52 @@
+package merge
+
+import (
+	"errors"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// errTightenedNull is the error of a null read from a column
+// -tighten-nullability made required, whose file's statistics are then
+// wrong.  It fails the merge even with -skip-bad-files.
+var errTightenedNull = errors.New("null in a column -tighten-nullability made required")
+
+// nullFreeColumns returns the top-level leaf columns of nodes, other than
+// repeated ones, that hold a value in each of the rows of their file, as
+// nonNull, given by columnNonNull, shows.
+func nullFreeColumns(nodes map[string]parquet.Node, nonNull map[string]int64, rows int64) map[string]bool {
+	free := map[string]bool{}
+	for k, node := range nodes {
+		if node.Leaf() && !node.Repeated() && nonNull[k] == rows {
+			free[k] = true
+		}
+	}
+	return free
+}
+
+// tightenedColumns returns the optional top-level leaf columns of merged
+// that every file in fileNodes has and holds no null in, which
+// -tighten-nullability makes required.  -type-overrides columns are left
+// optional with -on-cast-error null, as their values can be nulled.
+func (m *Merger) tightenedColumns(scanned []scannedFile, fileNodes map[string]map[string]parquet.Node, merged map[string]parquet.Node) []string {
+	var out []string
+	for _, k := range sortedKeys(merged) {
+		if node := merged[k]; !node.Optional() || !node.Leaf() {
+			continue
+		}
+		if _, ok := m.overrides[k]; ok && m.opts.OnCastError == "null" {
+			continue
+		}
+		free := true
+		for _, sf := range scanned {
+			if nodes, ok := fileNodes[sf.file]; ok && (nodes[k] == nil || !sf.nullFree[k]) {
+				free = false
+				break
+			}
+		}
+		if free {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package merge
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestTightenNullability(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.parquet"), filepath.Join(dir, "b.parquet")
+	writeParquet(t, a, parquet.Group{
+		"name":  parquet.Optional(parquet.String()),
+		"note":  parquet.Optional(parquet.String()),
+		"extra": parquet.Optional(parquet.String()),
+	}, []map[string]any{{"name": "x", "note": "n", "extra": "e"}, {"name": "y"}})
+	writeParquet(t, b, parquet.Group{
+		"name": parquet.Optional(parquet.String()),
+		"note": parquet.Optional(parquet.String()),
+	}, []map[string]any{{"name": "z", "note": "m"}})
+
+	for _, tighten := range []bool{false, true} {
+		out := filepath.Join(dir, "merged.parquet")
+		opts := testOptions(out, a, b)
+		opts.TightenNullability = tighten
+		runMerge(t, opts)
+		schema, rows := readParquet(t, out)
+		// Only name is in every file without nulls.
+		for _, column := range []string{"name", "note", "extra"} {
+			f, _ := fieldByName(schema, column)
+			if want := tighten && column == "name"; f.Required() != want {
+				t.Errorf("tighten %t: %s is required %t, want %t", tighten, column, f.Required(), want)
+			}
+		}
+		if len(rows) != 3 || rows[2]["name"] != "z" {
+			t.Errorf("tighten %t: wrote the rows %v", tighten, rows)
+		}
+	}
+}
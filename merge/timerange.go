@@ -0,0 +1,179 @@
+package merge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// timeRange selects rows whose time column falls in [after, before).  A
+// zero bound is open.
+type timeRange struct {
+	column        string
+	after, before time.Time
+	// normalized is set if -time-normalize rewrites the column, which
+	// files store in the units normalizeFrom finds with assume.
+	normalized bool
+	assume     time.Duration
+}
+
+// timeUnits returns t in the units of a time column: the unit of a
+// TIMESTAMP, days for a DATE, and milliseconds for a plain integer column,
+// the convention for epoch times in our data.
+func timeUnits(node parquet.Node, t time.Time) (int64, error) {
+	typ := node.Type()
+	if lt := typ.LogicalType(); lt != nil {
+		switch {
+		case lt.Timestamp != nil:
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				return t.UnixMilli(), nil
+			case lt.Timestamp.Unit.Micros != nil:
+				return t.UnixMicro(), nil
+			default:
+				return t.UnixNano(), nil
+			}
+		case lt.Date != nil:
+			return t.Unix() / (24 * 60 * 60), nil
+		case lt.Integer != nil:
+		default:
+			return 0, fmt.Errorf("time column has unsupported type %s", leafSignature(node))
+		}
+	}
+	switch typ.Kind() {
+	case parquet.Int32, parquet.Int64:
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("time column has unsupported type %s", leafSignature(node))
+}
+
+// unitsTime is the inverse of timeUnits: it returns the time of v, a value
+// of the time column node.
+func unitsTime(node parquet.Node, v int64) time.Time {
+	if lt := node.Type().LogicalType(); lt != nil {
+		switch {
+		case lt.Timestamp != nil && lt.Timestamp.Unit.Micros != nil:
+			return time.UnixMicro(v)
+		case lt.Timestamp != nil && lt.Timestamp.Unit.Nanos != nil:
+			return time.Unix(0, v)
+		case lt.Date != nil:
+			return time.Unix(v*24*60*60, 0)
+		}
+	}
+	return time.UnixMilli(v)
+}
+
+// bounds returns the range in the units of node, with open bounds set to
+// the extremes of int64.
+func (tr *timeRange) bounds(node parquet.Node) (lo, hi int64, err error) {
+	lo, hi = -1<<63, 1<<63-1
+	if !tr.after.IsZero() {
+		if lo, err = timeUnits(node, tr.after); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !tr.before.IsZero() {
+		if hi, err = timeUnits(node, tr.before); err != nil {
+			return 0, 0, err
+		}
+	}
+	return lo, hi, nil
+}
+
+// fileBounds returns the range in the units of node, the column in a file,
+// widened to whole units where they are coarser than the range.
+func (tr *timeRange) fileBounds(node parquet.Node) (lo, hi int64, err error) {
+	if !tr.normalized {
+		return tr.bounds(node)
+	}
+	unit, err := normalizeFrom(node, tr.assume)
+	if err != nil {
+		return 0, 0, err
+	}
+	per := int64(time.Second / unit)
+	lo, hi = -1<<63, 1<<63-1
+	if !tr.after.IsZero() {
+		lo = tr.after.Unix()*per + int64(tr.after.Nanosecond())/int64(unit)
+	}
+	if !tr.before.IsZero() {
+		hi = tr.before.Unix()*per + int64(tr.before.Nanosecond())/int64(unit)
+		if int64(tr.before.Nanosecond())%int64(unit) != 0 {
+			hi++
+		}
+	}
+	return lo, hi, nil
+}
+
+// fileTime returns the time of v, a value of node, the column in a file.
+func (tr *timeRange) fileTime(node parquet.Node, v int64) time.Time {
+	if !tr.normalized {
+		return unitsTime(node, v)
+	}
+	unit, _ := normalizeFrom(node, tr.assume)
+	per := int64(time.Second / unit)
+	sec, rem := v/per, v%per
+	if rem < 0 {
+		sec, rem = sec-1, rem+per
+	}
+	return time.Unix(sec, rem*int64(unit))
+}
+
+// match returns a function reporting whether a row of schema is in range.
+// Rows where the column is null or missing are not.
+func (tr *timeRange) match(schema *parquet.Schema) (func(parquet.Row) bool, error) {
+	leaf, ok := schema.Lookup(tr.column)
+	if !ok || leaf.MaxRepetitionLevel > 0 {
+		return nil, fmt.Errorf("time column %s must be a non-repeated column of the merged schema", tr.column)
+	}
+	lo, hi, err := tr.bounds(leaf.Node)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tr.column, err)
+	}
+	column := leaf.ColumnIndex
+	return func(row parquet.Row) bool {
+		v := columnValue(row, column)
+		if v.IsNull() {
+			return false
+		}
+		t := v.Int64()
+		if v.Kind() == parquet.Int32 {
+			t = int64(v.Int32())
+		}
+		return t >= lo && t < hi
+	}, nil
+}
+
+// skip reports whether row group i of pf has no rows in range, judging by
+// the statistics of column, looked up as fileColumn does.  Row groups
+// whose column is not found are read, to be judged row by row.
+func (tr *timeRange) skip(pf *parquet.File, i int, column string, renamed map[string]string) bool {
+	leaf, ok := fileColumn(pf.Schema(), column, renamed)
+	if !ok {
+		return false
+	}
+	lo, hi, err := tr.fileBounds(leaf.Node)
+	if err != nil {
+		return false
+	}
+	chunk := pf.Metadata().RowGroups[i].Columns[leaf.ColumnIndex].MetaData
+	min, okMin := statValue(chunk.Type, chunk.Statistics.MinValue)
+	max, okMax := statValue(chunk.Type, chunk.Statistics.MaxValue)
+	if !okMin || !okMax {
+		return false
+	}
+	return max < lo || min >= hi
+}
+
+// statValue decodes an INT32 or INT64 min/max statistic.
+func statValue(typ format.Type, b []byte) (int64, bool) {
+	switch {
+	case typ == format.Int32 && len(b) == 4:
+		return int64(int32(binary.LittleEndian.Uint32(b))), true
+	case typ == format.Int64 && len(b) == 8:
+		return int64(binary.LittleEndian.Uint64(b)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package merge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestFlattenedTimeColumn(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Millisecond)
+	// The old and the recent rows of each file are in row groups of their
+	// own, which the statistics of resource.ts can prune.
+	for i, group := range []string{"resource", "res"} {
+		var chunks [][]map[string]any
+		for _, age := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+			var rows []map[string]any
+			for r := 0; r < 2; r++ {
+				id := int64(len(chunks)*2 + r + i*4 + 1)
+				rows = append(rows, map[string]any{"id": id, group: map[string]any{"ts": now.Add(-age)}})
+			}
+			chunks = append(chunks, rows)
+		}
+		writeParquet(t, filepath.Join(dir, group+".parquet"), parquet.Group{
+			"id":  parquet.Int(64),
+			group: parquet.Group{"ts": parquet.Timestamp(parquet.Millisecond)},
+		}, chunks...)
+	}
+	tests := []struct {
+		name string
+		set  func(o *Options)
+	}{
+		{"after", func(o *Options) { o.After = now.Add(-24 * time.Hour) }},
+		{"retain-days", func(o *Options) { o.RetainDays = 5 }},
+	}
+	for _, tt := range tests {
+		for _, renamed := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				out := filepath.Join(t.TempDir(), "merged.parquet")
+				opts := testOptions(out, filepath.Join(dir, "resource.parquet"))
+				want := []int64{3, 4}
+				if renamed {
+					opts.Patterns = append(opts.Patterns, filepath.Join(dir, "res.parquet"))
+					opts.Renames = map[string]string{"res": "resource"}
+					want = []int64{7, 8, 3, 4}
+				}
+				opts.Flatten = true
+				opts.TimeColumn = "resource.ts"
+				tt.set(&opts)
+				runMerge(t, opts)
+				_, rows := readParquet(t, out)
+				var ids []int64
+				for _, row := range rows {
+					ids = append(ids, row["id"].(int64))
+				}
+				if !slices.Equal(ids, want) {
+					t.Errorf("renamed %v: merged the ids %v, want %v", renamed, ids, want)
+				}
+			})
+		}
+	}
+}
+
+func TestNormalizedTimeColumn(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	// a holds seconds in a plain INT64, b a TIMESTAMP in micros, each with
+	// the old and the recent rows in row groups of their own.
+	inputs := []struct {
+		name string
+		node parquet.Node
+		time func(time.Time) any
+	}{
+		{"a", parquet.Int(64), func(t time.Time) any { return t.Unix() }},
+		{"b", parquet.Timestamp(parquet.Microsecond), func(t time.Time) any { return t }},
+	}
+	var files []string
+	for i, in := range inputs {
+		var chunks [][]map[string]any
+		for c, age := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+			chunks = append(chunks, []map[string]any{{"id": int64(i*2 + c + 1), "ts": in.time(now.Add(-age))}})
+		}
+		files = append(files, filepath.Join(dir, in.name+".parquet"))
+		writeParquet(t, files[i], parquet.Group{"id": parquet.Int(64), "ts": in.node}, chunks...)
+	}
+	tests := []struct {
+		name string
+		set  func(o *Options)
+	}{
+		{"after", func(o *Options) { o.After = now.Add(-24 * time.Hour) }},
+		{"before", func(o *Options) { o.Before = now.Add(-24 * time.Hour) }},
+		{"retain-days", func(o *Options) { o.RetainDays = 5 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "merged.parquet")
+			opts := testOptions(out, files...)
+			opts.TimeColumn = "ts"
+			opts.TimeNormalize = map[string]string{"ts": "millis"}
+			opts.AssumeUnit = "seconds"
+			tt.set(&opts)
+			runMerge(t, opts)
+			_, rows := readParquet(t, out)
+			var ids []int64
+			for _, row := range rows {
+				ids = append(ids, row["id"].(int64))
+			}
+			want := []int64{2, 4}
+			if tt.name == "before" {
+				want = []int64{1, 3}
+			}
+			if !slices.Equal(ids, want) {
+				t.Errorf("merged the ids %v, want %v", ids, want)
+			}
+		})
+	}
+}
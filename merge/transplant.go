@@ -0,0 +1,417 @@
+package merge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/segmentio/encoding/thrift"
+)
+
+// storedLayout is how a file stores its rows, as far as transplanting its
+// row groups goes: its schema elements and the codec of its column chunks.
+type storedLayout struct {
+	schema []format.SchemaElement
+	codec  format.CompressionCodec
+}
+
+// layoutOf returns the layout of the file with footer md, or nil if its
+// column chunks cannot be copied as they are: if they mix codecs, are
+// encrypted or are stored in other files.
+func layoutOf(md *format.FileMetaData) *storedLayout {
+	l := &storedLayout{schema: md.Schema, codec: -1}
+	for _, rg := range md.RowGroups {
+		for _, c := range rg.Columns {
+			if c.FilePath != "" || c.EncryptedColumnMetadata != nil || c.CryptoMetadata != (format.ColumnCryptoMetaData{}) {
+				return nil
+			}
+			if l.codec >= 0 && c.MetaData.Codec != l.codec {
+				return nil
+			}
+			l.codec = c.MetaData.Codec
+		}
+	}
+	return l
+}
+
+// transplants reports whether the merge can copy row groups as they are
+// stored: when it writes every row of its inputs, unchanged and in order,
+// to a single file whose row groups are neither resized nor sorted, given
+// bloom filters, or compressed per column.
+func (m *Merger) transplants() bool {
+	o := m.opts
+	return o.Output == nil && !o.Bench && o.PartitionBy == "" && o.ShardBy == "" && o.MaxOutputRows == 0 && o.MaxOutputBytes == 0 &&
+		o.StateFile == "" && m.appendTo == "" && o.SortBy == "" && o.SortedBy == "" && o.RowGroupRows == 0 &&
+		o.RowGroupBytes == 0 && o.MaxMemory == 0 && len(o.BloomColumns) == 0 && m.columnCodec == nil &&
+		o.Limit == 0 && o.Offset == 0 && m.rowFilter == nil && m.rowTimes == nil && o.RetainDays == 0 && m.deletes == nil &&
+		o.Sample == 0 && o.SamplePerFile == 0 && len(o.DedupKeys) == 0 && o.SourceColumn == "" && len(o.Derive) == 0 && len(o.Redact) == 0 && len(o.RowGroups) == 0 && len(o.TimeNormalize) == 0 &&
+		o.UTF8 != "reject" && o.UTF8 != "replace" && !o.NoFastpath && !o.CheckCRC
+}
+
+// transplantWriter writes the merged file itself, copying the row groups of
+// the inputs stored exactly as it stores them column chunk by column chunk,
+// without decompressing them.  The rows of the other inputs are written by
+// a parquet-go writer to a temporary file, whose row groups are then copied
+// in the same way.
+type transplantWriter struct {
+	name      string
+	file      *os.File
+	buf       *bufio.Writer
+	out       *countingWriter
+	schema    *parquet.Schema
+	newWriter func(io.Writer) mergeWriter
+	open      func(name string, options ...parquet.FileOption) (*parquet.File, io.Closer, error)
+	// footer is that of an empty file written by newWriter, to which the
+	// row groups copied are added, with their page indexes in
+	// columnIndexes and offsetIndexes.
+	footer        format.FileMetaData
+	codec         format.CompressionCodec
+	columnIndexes [][]*format.ColumnIndex
+	offsetIndexes [][]*format.OffsetIndex
+	// pending is the temporary file the rows written since the last input
+	// transplanted are encoded to, until they are copied in turn.
+	pending       *os.File
+	pendingWriter mergeWriter
+	interrupted   *bool
+	// files holds the inputs transplanted, and transplanted and reencoded
+	// count the row groups copied from them and from pending.
+	files        map[string]bool
+	transplanted int64
+	reencoded    int64
+}
+
+// newTransplantWriter returns a transplantWriter for Options.OutFile, or nil
+// if none of the included files can be transplanted, since copying the
+// rows of every file after encoding them is only slower.
+func (m *Merger) newTransplantWriter(included []scannedFile, newWriter func(io.Writer) mergeWriter, interrupted *bool) (*transplantWriter, error) {
+	var empty bytes.Buffer
+	writer := newWriter(&empty)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	pf, err := parquet.OpenFile(bytes.NewReader(empty.Bytes()), int64(empty.Len()))
+	if err != nil {
+		return nil, err
+	}
+	w := &transplantWriter{
+		name:        m.opts.OutFile,
+		schema:      writer.Schema(),
+		newWriter:   newWriter,
+		open:        m.openInputFile,
+		footer:      *pf.Metadata(),
+		codec:       m.codec.CompressionCodec(),
+		interrupted: interrupted,
+		files:       map[string]bool{},
+	}
+	for _, sf := range included {
+		if reason := w.reason(sf); reason != "" {
+			m.log.Debug("re-encoding a file", "phase", "copy", "file", sf.file, "reason", reason)
+			continue
+		}
+		w.files[sf.file] = true
+	}
+	if len(w.files) == 0 {
+		return nil, nil
+	}
+	if w.file, err = os.Create(w.name + ".tmp"); err != nil {
+		return nil, err
+	}
+	w.buf = bufio.NewWriterSize(w.file, 1<<20)
+	w.out = &countingWriter{w: w.buf}
+	if _, err := io.WriteString(w.out, "PAR1"); err != nil {
+		w.discard()
+		return nil, err
+	}
+	return w, nil
+}
+
+// reason returns why the row groups of sf cannot be transplanted, or "" if
+// they can.
+func (w *transplantWriter) reason(sf scannedFile) string {
+	switch {
+	case len(sf.renamed) > 0:
+		return "its columns are renamed"
+	case sf.layout == nil:
+		return "its column chunks are encrypted, in other files or compressed with several codecs"
+	case !sameElements(sf.layout.schema, w.footer.Schema):
+		return "its schema is not exactly the merged schema"
+	case sf.layout.codec >= 0 && sf.layout.codec != w.codec:
+		return fmt.Sprintf("it is compressed with %s, not %s", sf.layout.codec, w.codec)
+	case uncounted(sf):
+		return "-report counts the values its statistics do not"
+	}
+	return ""
+}
+
+// sameElements reports whether a file with schema a stores its columns as
+// one with schema b does, whatever the name of the root and legacy
+// annotations.
+func sameElements(a, b []format.SchemaElement) bool {
+	if len(a) != len(b) || len(a) == 0 || a[0].NumChildren != b[0].NumChildren {
+		return false
+	}
+	a, b = withConvertedTypes(a[1:]), withConvertedTypes(b[1:])
+	for i := range a {
+		x, y := a[i], b[i]
+		x.ConvertedType, y.ConvertedType = nil, nil
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteRows encodes rows to the pending file.
+func (w *transplantWriter) WriteRows(rows []parquet.Row) (int, error) {
+	if w.pendingWriter == nil {
+		f, err := os.CreateTemp("", "merger-rows.*")
+		if err != nil {
+			return 0, err
+		}
+		w.pending, w.pendingWriter = f, w.newWriter(f)
+	}
+	return w.pendingWriter.WriteRows(rows)
+}
+
+func (w *transplantWriter) Schema() *parquet.Schema { return w.schema }
+
+func (w *transplantWriter) Flush() error {
+	if w.pendingWriter == nil {
+		return nil
+	}
+	return w.pendingWriter.Flush()
+}
+
+// copyRowGroups copies the row groups of input, and returns the rows they
+// hold.  Errors reading input are returned as a *readError, once the row
+// groups before the one that failed are copied.
+func (w *transplantWriter) copyRowGroups(input inputFile) (int64, error) {
+	if err := w.copyPending(); err != nil {
+		return 0, err
+	}
+	if input.rows == 0 {
+		return 0, nil
+	}
+	pf, inf, err := w.open(input.file)
+	if err != nil {
+		return 0, &readError{err}
+	}
+	defer inf.Close()
+	if l := layoutOf(pf.Metadata()); l == nil || !sameElements(l.schema, w.footer.Schema) || (l.codec >= 0 && l.codec != w.codec) {
+		return 0, &readError{fmt.Errorf("%s has changed since it was scanned", input.file)}
+	}
+	rows, groups, err := w.copyFile(pf)
+	w.transplanted += groups
+	return rows, err
+}
+
+// copyPending copies the row groups of the pending file, if rows were
+// written to it, and removes it.
+func (w *transplantWriter) copyPending() error {
+	if w.pendingWriter == nil {
+		return nil
+	}
+	defer w.removePending()
+	if err := w.pendingWriter.Close(); err != nil {
+		return err
+	}
+	size, err := w.pending.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	pf, err := parquet.OpenFile(w.pending, size)
+	if err != nil {
+		return err
+	}
+	_, groups, err := w.copyFile(pf)
+	w.reencoded += groups
+	if rerr, ok := err.(*readError); ok {
+		// The pending file is the output's, so this is no read error.
+		err = rerr.err
+	}
+	if err != nil {
+		return fmt.Errorf("error copying re-encoded rows: %w", err)
+	}
+	return nil
+}
+
+func (w *transplantWriter) removePending() {
+	if w.pending != nil {
+		w.pending.Close()
+		os.Remove(w.pending.Name())
+	}
+	w.pending, w.pendingWriter = nil, nil
+}
+
+// copyFile appends the column chunks of every row group of pf to the
+// output, and their metadata to the footer, with their offsets moved to
+// where they now are, and returns the rows and row groups copied.  A row
+// group is only added to the footer once all its chunks are copied: the
+// bytes of one that fails are left unused.
+func (w *transplantWriter) copyFile(pf *parquet.File) (rows, groups int64, err error) {
+	md := pf.Metadata()
+	columnIndexes, offsetIndexes := pf.ColumnIndexes(), pf.OffsetIndexes()
+	for i, rg := range md.RowGroups {
+		if rg.NumRows == 0 {
+			continue
+		}
+		group := format.RowGroup{
+			Columns:       make([]format.ColumnChunk, len(rg.Columns)),
+			TotalByteSize: rg.TotalByteSize,
+			NumRows:       rg.NumRows,
+			Ordinal:       int16(len(w.footer.RowGroups)),
+		}
+		columns := make([]*format.ColumnIndex, len(rg.Columns))
+		offsets := make([]*format.OffsetIndex, len(rg.Columns))
+		for j, c := range rg.Columns {
+			start := c.MetaData.DataPageOffset
+			if d := c.MetaData.DictionaryPageOffset; d > 0 && d < start {
+				start = d
+			}
+			moved := w.out.n
+			if _, err := io.Copy(w.out, io.NewSectionReader(pf, start, c.MetaData.TotalCompressedSize)); err != nil {
+				return rows, groups, &readError{fmt.Errorf("row group %d: column chunk %d: %w", i, j, err)}
+			}
+			delta := moved - start
+			c.FileOffset += delta
+			c.MetaData.DataPageOffset += delta
+			if c.MetaData.DictionaryPageOffset > 0 {
+				c.MetaData.DictionaryPageOffset += delta
+			}
+			if c.MetaData.IndexPageOffset > 0 {
+				c.MetaData.IndexPageOffset += delta
+			}
+			// Bloom filters are not copied, and page indexes are written
+			// again at the end.
+			c.MetaData.BloomFilterOffset = 0
+			c.ColumnIndexOffset, c.ColumnIndexLength = 0, 0
+			c.OffsetIndexOffset, c.OffsetIndexLength = 0, 0
+			group.Columns[j] = c
+			group.TotalCompressedSize += c.MetaData.TotalCompressedSize
+			if j == 0 {
+				group.FileOffset = moved
+			}
+			k := i*len(rg.Columns) + j
+			if rg.Columns[j].ColumnIndexLength > 0 && k < len(columnIndexes) {
+				columns[j] = &columnIndexes[k]
+			}
+			if rg.Columns[j].OffsetIndexLength > 0 && k < len(offsetIndexes) {
+				index := format.OffsetIndex{PageLocations: append([]format.PageLocation(nil), offsetIndexes[k].PageLocations...)}
+				for p := range index.PageLocations {
+					index.PageLocations[p].Offset += delta
+				}
+				offsets[j] = &index
+			}
+		}
+		w.footer.RowGroups = append(w.footer.RowGroups, group)
+		w.columnIndexes = append(w.columnIndexes, columns)
+		w.offsetIndexes = append(w.offsetIndexes, offsets)
+		rows += rg.NumRows
+		groups++
+	}
+	return rows, groups, nil
+}
+
+// Close copies the pending rows, writes the page indexes and the footer,
+// and moves the file into place.
+func (w *transplantWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.copyPending(); err != nil {
+		return err
+	}
+	if *w.interrupted {
+		w.footer.KeyValueMetadata = append(w.footer.KeyValueMetadata, format.KeyValue{Key: partialKey, Value: "true"})
+		sort.Slice(w.footer.KeyValueMetadata, func(i, j int) bool {
+			return w.footer.KeyValueMetadata[i].Key < w.footer.KeyValueMetadata[j].Key
+		})
+	}
+	encoder := thrift.NewEncoder(new(thrift.CompactProtocol).NewWriter(w.out))
+	w.footer.NumRows = 0
+	for i := range w.footer.RowGroups {
+		w.footer.NumRows += w.footer.RowGroups[i].NumRows
+		for j, index := range w.columnIndexes[i] {
+			if index != nil {
+				c := &w.footer.RowGroups[i].Columns[j]
+				c.ColumnIndexOffset = w.out.n
+				if err := encoder.Encode(index); err != nil {
+					return err
+				}
+				c.ColumnIndexLength = int32(w.out.n - c.ColumnIndexOffset)
+			}
+		}
+	}
+	for i := range w.footer.RowGroups {
+		for j, index := range w.offsetIndexes[i] {
+			if index != nil {
+				c := &w.footer.RowGroups[i].Columns[j]
+				c.OffsetIndexOffset = w.out.n
+				if err := encoder.Encode(index); err != nil {
+					return err
+				}
+				c.OffsetIndexLength = int32(w.out.n - c.OffsetIndexOffset)
+			}
+		}
+	}
+	footer, err := thrift.Marshal(new(thrift.CompactProtocol), &w.footer)
+	if err != nil {
+		return err
+	}
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	if _, err := w.out.Write(append(footer, "PAR1"...)); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	f := w.file
+	w.file = nil
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.name+".tmp", w.name)
+}
+
+func (w *transplantWriter) written() int64 { return w.out.n }
+
+func (w *transplantWriter) names() []string { return []string{w.name} }
+
+func (w *transplantWriter) report(*slog.Logger) {}
+
+// discard removes the file, finished or not.
+func (w *transplantWriter) discard() {
+	w.removePending()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+		os.Remove(w.name + ".tmp")
+		return
+	}
+	os.Remove(w.name)
+}
+
+// rowGroupCopier is a writer inputs can be transplanted to.
+type rowGroupCopier interface {
+	copyRowGroups(input inputFile) (int64, error)
+}
+
+func (w *rowCounter) copyRowGroups(input inputFile) (int64, error) {
+	n, err := w.mergeWriter.(rowGroupCopier).copyRowGroups(input)
+	w.rows += n
+	return n, err
+}
+
+func (w progressWriter) copyRowGroups(input inputFile) (int64, error) {
+	n, err := w.mergeWriter.(rowGroupCopier).copyRowGroups(input)
+	w.p.wrote(int(n))
+	return n, err
+}
@@ -0,0 +1,245 @@
+package merge
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// transplantGroup is the schema of the transplant test inputs.
+var transplantGroup = parquet.Group{
+	"id":        parquet.Int(64),
+	"name":      parquet.Optional(parquet.String()),
+	"part":      parquet.String(),
+	"timestamp": parquet.Timestamp(parquet.Millisecond),
+}
+
+// writeTransplantInputs writes files of transplantGroup, as the merger
+// writes them, so that their row groups can be transplanted, and returns
+// their paths.  With compression, the last is compressed with it instead.
+func writeTransplantInputs(t *testing.T, dir, compression string) []string {
+	t.Helper()
+	now := time.Now().Truncate(time.Millisecond)
+	var files []string
+	for f := 0; f < 3; f++ {
+		raw := filepath.Join(dir, "raw", string(rune('a'+f))+".parquet")
+		if err := os.MkdirAll(filepath.Dir(raw), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var chunks [][]map[string]any
+		for c := 0; c < 2; c++ {
+			var rows []map[string]any
+			for r := 0; r < 3; r++ {
+				id := int64(f*6 + c*3 + r + 1)
+				row := map[string]any{"id": id, "part": []string{"x", "y"}[r%2], "timestamp": now.Add(-time.Duration(id) * time.Minute)}
+				if id%4 != 0 {
+					row["name"] = strings.Repeat("n", int(id))
+				}
+				rows = append(rows, row)
+			}
+			chunks = append(chunks, rows)
+		}
+		writeParquet(t, raw, transplantGroup, chunks...)
+		in := filepath.Join(dir, "in", string(rune('a'+f))+".parquet")
+		if err := os.MkdirAll(filepath.Dir(in), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		opts := testOptions(in, raw)
+		opts.RowGroupRows = 3
+		if f == 2 && compression != "" {
+			opts.Compression = compression
+		}
+		runMerge(t, opts)
+		files = append(files, in)
+	}
+	return files
+}
+
+// readOutputs returns the rows of each parquet file under dir, by path
+// under it.
+func readOutputs(t *testing.T, dir string) map[string][]map[string]any {
+	t.Helper()
+	out := map[string][]map[string]any{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".parquet") {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		_, out[rel] = readParquet(t, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// mergeBothWays merges files as set configures, transplanting if it can
+// and with NoFastpath, and returns the stats of both runs after checking
+// that they wrote the same rows.
+func mergeBothWays(t *testing.T, dir string, files []string, set func(t *testing.T, o *Options, dir string)) (fast, slow Stats) {
+	t.Helper()
+	var outputs [2]map[string][]map[string]any
+	var stats [2]Stats
+	for i, noFastpath := range []bool{false, true} {
+		out := filepath.Join(dir, []string{"fast", "slow"}[i])
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		opts := testOptions(filepath.Join(out, "merged.parquet"), files...)
+		opts.NoFastpath = noFastpath
+		if set != nil {
+			set(t, &opts, out)
+		}
+		stats[i] = runMerge(t, opts)
+		if opts.Output != nil {
+			opts.Output.(*os.File).Close()
+		}
+		outputs[i] = readOutputs(t, out)
+	}
+	if !reflect.DeepEqual(outputs[0], outputs[1]) {
+		t.Errorf("transplanting wrote %v, re-encoding %v", outputs[0], outputs[1])
+	}
+	if stats[1].RowGroupsTransplanted != 0 || stats[1].RowGroupsReencoded != 0 {
+		t.Errorf("-no-fastpath transplanted %d row groups and re-encoded %d, want none", stats[1].RowGroupsTransplanted, stats[1].RowGroupsReencoded)
+	}
+	return stats[0], stats[1]
+}
+
+func TestTransplantMatchesReencode(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTransplantInputs(t, dir, "")
+	fast, _ := mergeBothWays(t, dir, files, nil)
+	if fast.RowGroupsTransplanted != 6 || fast.RowGroupsReencoded != 0 {
+		t.Errorf("transplanted %d row groups and re-encoded %d, want 6 and 0", fast.RowGroupsTransplanted, fast.RowGroupsReencoded)
+	}
+	_, rows := readParquet(t, filepath.Join(dir, "fast", "merged.parquet"))
+	if len(rows) != 18 {
+		t.Errorf("merged %d rows, want 18", len(rows))
+	}
+}
+
+// writeLastInput writes, as the merger writes them, the rows of a file of
+// transplantGroup with column name renamed to rename, or left out if
+// rename is "", and returns its path.
+func writeLastInput(t *testing.T, dir, rename string) string {
+	t.Helper()
+	g := parquet.Group{}
+	for k, v := range transplantGroup {
+		g[k] = v
+	}
+	delete(g, "name")
+	row := map[string]any{"id": int64(19), "part": "x", "timestamp": time.Now().Truncate(time.Millisecond)}
+	if rename != "" {
+		g[rename] = transplantGroup["name"]
+		row[rename] = "renamed"
+	}
+	raw := filepath.Join(dir, "raw", "last.parquet")
+	writeParquet(t, raw, g, []map[string]any{row})
+	in := filepath.Join(dir, "in", "last.parquet")
+	runMerge(t, testOptions(in, raw))
+	return in
+}
+
+func TestTransplantFileFallback(t *testing.T) {
+	// In each case, the row groups of the first two files are transplanted
+	// and those of the last re-encoded.
+	tests := []struct {
+		name        string
+		compression string
+		rename      string
+		set         func(t *testing.T, o *Options, dir string)
+	}{
+		{name: "codec", compression: "snappy"},
+		{name: "renamed", rename: "label", set: func(t *testing.T, o *Options, dir string) {
+			o.Renames = map[string]string{"label": "name"}
+		}},
+		{name: "schema", rename: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := writeTransplantInputs(t, dir, tt.compression)
+			if tt.compression == "" {
+				files[2] = writeLastInput(t, dir, tt.rename)
+			}
+			fast, _ := mergeBothWays(t, dir, files, tt.set)
+			if fast.RowGroupsTransplanted != 4 || fast.RowGroupsReencoded == 0 {
+				t.Errorf("transplanted %d row groups and re-encoded %d, want 4 and some", fast.RowGroupsTransplanted, fast.RowGroupsReencoded)
+			}
+		})
+	}
+}
+
+func TestTransplantFallback(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTransplantInputs(t, dir, "")
+	// Each of these options rules out transplanting.
+	tests := []struct {
+		name string
+		set  func(t *testing.T, o *Options, dir string)
+	}{
+		{"output", func(t *testing.T, o *Options, dir string) {
+			f, err := os.Create(filepath.Join(dir, "output.parquet"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.Output = f
+		}},
+		{"bench", func(t *testing.T, o *Options, dir string) { o.Bench = true }},
+		{"partition-by", func(t *testing.T, o *Options, dir string) { o.OutFile = dir; o.PartitionBy = "part" }},
+		{"shard-by", func(t *testing.T, o *Options, dir string) { o.ShardBy = "id"; o.Shards = 2 }},
+		{"max-output-rows", func(t *testing.T, o *Options, dir string) { o.MaxOutputRows = 7 }},
+		{"max-output-bytes", func(t *testing.T, o *Options, dir string) { o.MaxOutputBytes = 1 << 20 }},
+		{"state", func(t *testing.T, o *Options, dir string) { o.StateFile = filepath.Join(dir, "state.json") }},
+		{"append", func(t *testing.T, o *Options, dir string) {
+			o.Append = true
+			runMerge(t, testOptions(o.OutFile, files[0]))
+			o.Patterns = files[1:]
+		}},
+		{"sort-by", func(t *testing.T, o *Options, dir string) { o.SortBy = "name" }},
+		{"sorted-by", func(t *testing.T, o *Options, dir string) { o.SortedBy = "id" }},
+		{"row-group-rows", func(t *testing.T, o *Options, dir string) { o.RowGroupRows = 4 }},
+		{"row-group-bytes", func(t *testing.T, o *Options, dir string) { o.RowGroupBytes = 1 << 20 }},
+		{"max-memory", func(t *testing.T, o *Options, dir string) { o.MaxMemory = 64 << 20 }},
+		{"bloom-columns", func(t *testing.T, o *Options, dir string) { o.BloomColumns = []string{"id"} }},
+		{"column-compression", func(t *testing.T, o *Options, dir string) { o.ColumnCompression = map[string]string{"name": "snappy"} }},
+		{"limit", func(t *testing.T, o *Options, dir string) { o.Limit = 5 }},
+		{"offset", func(t *testing.T, o *Options, dir string) { o.Offset = 5 }},
+		{"where", func(t *testing.T, o *Options, dir string) { o.Where = "id > 0" }},
+		{"after", func(t *testing.T, o *Options, dir string) { o.After = time.Now().Add(-24 * time.Hour) }},
+		{"retain-days", func(t *testing.T, o *Options, dir string) { o.RetainDays = 30 }},
+		{"delete-keys", func(t *testing.T, o *Options, dir string) {
+			keys := filepath.Join(dir, "keys.csv")
+			if err := os.WriteFile(keys, []byte("id\n100\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			o.DeleteKeys, o.DeleteKeyColumn = keys, "id"
+		}},
+		{"sample", func(t *testing.T, o *Options, dir string) { o.Sample, o.Seed = 0.5, 1 }},
+		{"sample-per-file", func(t *testing.T, o *Options, dir string) { o.SamplePerFile, o.Seed = 4, 1 }},
+		{"dedup", func(t *testing.T, o *Options, dir string) { o.DedupKeys = []string{"id"} }},
+		{"source-column", func(t *testing.T, o *Options, dir string) { o.SourceColumn = "source" }},
+		{"derive", func(t *testing.T, o *Options, dir string) { o.Derive = map[string]string{"upper": "upper(part)"} }},
+		{"redact", func(t *testing.T, o *Options, dir string) { o.Redact = map[string]string{"name": "mask"} }},
+		{"row-groups", func(t *testing.T, o *Options, dir string) { o.RowGroups = map[string]string{files[0]: "1"} }},
+		{"time-normalize", func(t *testing.T, o *Options, dir string) { o.TimeNormalize = map[string]string{"timestamp": "micros"} }},
+		{"utf8-reject", func(t *testing.T, o *Options, dir string) { o.UTF8 = "reject" }},
+		{"utf8-replace", func(t *testing.T, o *Options, dir string) { o.UTF8 = "replace" }},
+		{"check-crc", func(t *testing.T, o *Options, dir string) { o.CheckCRC = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, _ := mergeBothWays(t, t.TempDir(), files, tt.set)
+			if fast.RowGroupsTransplanted != 0 || fast.RowGroupsReencoded != 0 {
+				t.Errorf("transplanted %d row groups and re-encoded %d, want none", fast.RowGroupsTransplanted, fast.RowGroupsReencoded)
+			}
+		})
+	}
+}
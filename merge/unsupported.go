@@ -0,0 +1,69 @@
+package merge
+
+import (
+	"github.com/parquet-go/parquet-go"
+)
+
+// unsupportedColumn is a top-level column of a file whose type the merger
+// cannot read, and what -on-unsupported did with it.
+type unsupportedColumn struct {
+	Column string `json:"column"`
+	Error  string `json:"error"`
+	// Action is "dropped" or "stored as STRING".
+	Action string `json:"action"`
+}
+
+// unsupportedHandler returns the unsupportedFunc applying -on-unsupported
+// to the columns of f, appending each column handled to columns, or nil
+// with -on-unsupported fail.  Stringified columns are read with the node
+// parquet-go gives them.
+func (m *Merger) unsupportedHandler(f *parquet.File, columns *[]unsupportedColumn) unsupportedFunc {
+	if m.opts.OnUnsupported == "fail" {
+		return nil
+	}
+	return func(name string, err error) (parquet.Node, error) {
+		c := unsupportedColumn{Column: name, Error: err.Error(), Action: "dropped"}
+		var node parquet.Node
+		if m.opts.OnUnsupported == "stringify" {
+			field, ok := fileField(f.Schema(), name)
+			if !ok {
+				return nil, err
+			}
+			node, c.Action = field, "stored as STRING"
+		}
+		*columns = append(*columns, c)
+		return node, nil
+	}
+}
+
+// fileField returns the top-level field name of schema.
+func fileField(schema *parquet.Schema, name string) (parquet.Node, bool) {
+	for _, f := range schema.Fields() {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// stringifiedNodes returns nodes with the columns stored as STRING by
+// -on-unsupported stringify typed as STRING, which is how they are merged.
+func stringifiedNodes(nodes map[string]parquet.Node, columns []unsupportedColumn) map[string]parquet.Node {
+	var out map[string]parquet.Node
+	for _, c := range columns {
+		if _, ok := nodes[c.Column]; !ok || c.Action != "stored as STRING" {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]parquet.Node, len(nodes))
+			for k, v := range nodes {
+				out[k] = v
+			}
+		}
+		out[c.Column] = parquet.Optional(parquet.String())
+	}
+	if out == nil {
+		return nodes
+	}
+	return out
+}
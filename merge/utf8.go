@@ -0,0 +1,186 @@
+package merge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// replacementChar is what -utf8 replace writes for each run of invalid
+// bytes.
+var replacementChar = []byte(string(utf8.RuneError))
+
+// stringLeaves returns the paths of the STRING leaves of nodes.
+func stringLeaves(nodes map[string]parquet.Node) [][]string {
+	var paths [][]string
+	for _, k := range sortedKeys(nodes) {
+		paths = appendStringLeaves(paths, []string{k}, nodes[k])
+	}
+	return paths
+}
+
+func appendStringLeaves(paths [][]string, path []string, node parquet.Node) [][]string {
+	if node.Leaf() {
+		if nodeTypeName(node) == "STRING" {
+			paths = append(paths, append([]string(nil), path...))
+		}
+		return paths
+	}
+	for _, f := range node.Fields() {
+		paths = appendStringLeaves(paths, append(path, f.Name()), f)
+	}
+	return paths
+}
+
+// utf8Columns returns which leaf columns of merged -utf8 checks in files
+// with nodes: those that are STRING both in the files and in merged.  It
+// returns nil if there are none, so files without STRING columns are
+// copied as they were.
+func utf8Columns(nodes map[string]parquet.Node, merged *parquet.Schema) []bool {
+	var checked []bool
+	for _, path := range stringLeaves(nodes) {
+		leaf, ok := merged.Lookup(path...)
+		if !ok || nodeTypeName(leaf.Node) != "STRING" {
+			continue
+		}
+		if checked == nil {
+			checked = make([]bool, len(merged.Columns()))
+		}
+		checked[leaf.ColumnIndex] = true
+	}
+	return checked
+}
+
+// checkUTF8 replaces the invalid UTF-8 values of rows in the columns
+// r.utf8 checks, or with -utf8 reject, returns the number of rows before
+// the first row holding one and an error naming it.  first is the index in
+// the file of the first row.
+func (r *fileRows) checkUTF8(rows []parquet.Row, first int64) (int, error) {
+	for i, row := range rows {
+		for j, v := range row {
+			c := v.Column()
+			if c >= len(r.utf8) || !r.utf8[c] || v.IsNull() || utf8.Valid(v.ByteArray()) {
+				continue
+			}
+			if !r.replaceUTF8 {
+				return i, fmt.Errorf("row %d: column %s: STRING value is not valid UTF-8", first+int64(i), strings.Join(r.merged.Columns()[c], "."))
+			}
+			row[j] = parquet.ByteArrayValue(bytes.ToValidUTF8(v.ByteArray(), replacementChar)).Level(v.RepetitionLevel(), v.DefinitionLevel(), c)
+		}
+	}
+	return len(rows), nil
+}
+
+// invalidStrings reads the STRING columns of files, with their nodes in
+// fileNodes, and returns the paths, joined with dots, of those holding a
+// value that is not valid UTF-8, each with the first file found holding
+// one.
+func (m *Merger) invalidStrings(files []scannedFile, fileNodes map[string]map[string]parquet.Node) (map[string]string, error) {
+	invalid := map[string]string{}
+	for _, sf := range files {
+		nodes, ok := fileNodes[sf.file]
+		if !ok || sf.rows == 0 {
+			continue
+		}
+		var paths [][]string
+		for _, path := range stringLeaves(nodes) {
+			if _, ok := invalid[strings.Join(path, ".")]; !ok {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		pf, closer, err := m.openInputFile(sf.file)
+		if err != nil {
+			return nil, markError(ErrRead, fmt.Errorf("error opening %s: %w", sf.file, err))
+		}
+		for _, path := range paths {
+			name := path
+			if old, ok := sf.renamed[path[0]]; ok {
+				name = append([]string{old}, path[1:]...)
+			}
+			leaf, ok := pf.Schema().Lookup(name...)
+			if !ok {
+				continue
+			}
+			valid, err := validColumn(pf, leaf.ColumnIndex)
+			if err != nil {
+				closer.Close()
+				return nil, markError(ErrRead, fmt.Errorf("%s: %w", sf.file, err))
+			}
+			if !valid {
+				invalid[strings.Join(path, ".")] = sf.file
+			}
+		}
+		closer.Close()
+	}
+	return invalid, nil
+}
+
+// validColumn reports whether every value of the leaf column of pf is
+// valid UTF-8.
+func validColumn(pf *parquet.File, column int) (bool, error) {
+	values := make([]parquet.Value, 1024)
+	for _, rg := range pf.RowGroups() {
+		pages := rg.ColumnChunks()[column].Pages()
+		for {
+			page, err := pages.ReadPage()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				pages.Close()
+				return false, err
+			}
+			r := page.Values()
+			for {
+				n, err := r.ReadValues(values)
+				for _, v := range values[:n] {
+					if !v.IsNull() && !utf8.Valid(v.ByteArray()) {
+						pages.Close()
+						return false, nil
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					pages.Close()
+					return false, err
+				}
+			}
+		}
+		pages.Close()
+	}
+	return true, nil
+}
+
+// binaryStrings returns node, found at path, with its STRING leaves whose
+// paths are in invalid made plain BYTE_ARRAY, as -utf8 binary does.
+func binaryStrings(node parquet.Node, path string, invalid map[string]string) parquet.Node {
+	if node.Leaf() {
+		if _, ok := invalid[path]; ok && nodeTypeName(node) == "STRING" {
+			return withRepetition(parquet.Leaf(parquet.ByteArrayType), node)
+		}
+		return node
+	}
+	if isList(node) {
+		return withRepetition(parquet.List(binaryStrings(listElement(node), path+".list.element", invalid)), node)
+	}
+	if isMap(node) {
+		key := fieldOf(node.Fields()[0], "key")
+		return withRepetition(parquet.Map(binaryStrings(key, path+".key_value.key", invalid),
+			binaryStrings(mapValue(node), path+".key_value.value", invalid)), node)
+	}
+	fields := parquet.Group{}
+	for _, f := range node.Fields() {
+		fields[f.Name()] = binaryStrings(f, path+"."+f.Name(), invalid)
+	}
+	return withRepetition(fields, node)
+}
@@ -0,0 +1,56 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestUTF8(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	writeParquet(t, in, parquet.Group{"id": parquet.Int(64), "name": parquet.String()},
+		[]map[string]any{{"id": int64(1), "name": "ok"}, {"id": int64(2), "name": "a\xff\xfeb"}})
+
+	tests := []struct {
+		mode, name, typ string
+	}{
+		{"replace", "a�b", "STRING"},
+		{"binary", "a\xff\xfeb", "BYTE_ARRAY"},
+		{"", "a\xff\xfeb", "STRING"},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "merged-"+tt.mode+".parquet")
+		opts := testOptions(out, in)
+		opts.UTF8 = tt.mode
+		runMerge(t, opts)
+		schema, rows := readParquet(t, out)
+		name, _ := fieldByName(schema, "name")
+		if typ := nodeTypeName(name); typ != tt.typ {
+			t.Errorf("-utf8 %s: wrote name as %s, want %s", tt.mode, typ, tt.typ)
+		}
+		var names []string
+		for _, row := range rows {
+			// BYTE_ARRAY columns read back as []byte.
+			names = append(names, fmt.Sprintf("%s", row["name"]))
+		}
+		if want := []string{"ok", tt.name}; !slices.Equal(names, want) {
+			t.Errorf("-utf8 %s: wrote the names %q, want %q", tt.mode, names, want)
+		}
+	}
+
+	opts := testOptions(filepath.Join(dir, "merged-reject.parquet"), in)
+	opts.UTF8 = "reject"
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrRead) {
+		t.Errorf("-utf8 reject: error %v, want ErrRead", err)
+	}
+}
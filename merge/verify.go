@@ -0,0 +1,82 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowCounter counts the rows written to the output.
+type rowCounter struct {
+	mergeWriter
+	rows int64
+}
+
+func (w *rowCounter) WriteRows(rows []parquet.Row) (int, error) {
+	n, err := w.mergeWriter.WriteRows(rows)
+	w.rows += int64(n)
+	return n, err
+}
+
+// verifyOutput reopens the output files and checks that they hold rows
+// rows between them.  With -verify-deep it also decodes every row group.
+func (m *Merger) verifyOutput(files []string, rows int64) error {
+	var found int64
+	for _, file := range files {
+		pf, closer, err := openParquet(file)
+		if err != nil {
+			return fmt.Errorf("%s does not parse: %w", file, err)
+		}
+		found += pf.NumRows()
+		if m.opts.VerifyDeep {
+			err = decodeRowGroups(pf, m.opts.BatchSize)
+		}
+		closer.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if found != rows {
+		return fmt.Errorf("output holds %d rows but %d were written", found, rows)
+	}
+	return nil
+}
+
+// decodeRowGroups reads every row of pf, batchSize rows at a time, checking
+// that each row group decodes to the number of rows in its metadata.
+func decodeRowGroups(pf *parquet.File, batchSize int) error {
+	buf := make([]parquet.Row, batchSize)
+	for i, rg := range pf.RowGroups() {
+		rows := rg.Rows()
+		var n int64
+		for {
+			c, err := rows.ReadRows(buf)
+			n += int64(c)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("row group %d: row %d: %w", i, n, err)
+			}
+		}
+		rows.Close()
+		if n != rg.NumRows() {
+			return fmt.Errorf("row group %d decodes to %d rows but its metadata says %d", i, n, rg.NumRows())
+		}
+	}
+	return nil
+}
+
+// quarantine renames each of files to its name with .bad appended.
+func quarantine(logger *slog.Logger, files []string) {
+	for _, file := range files {
+		if err := os.Rename(file, file+".bad"); err != nil {
+			logger.Warn("error renaming", "file", file, "error", err)
+		}
+	}
+}
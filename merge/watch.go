@@ -0,0 +1,127 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watch looks for input files every WatchInterval until ctx is canceled,
+// merging the ones that are ready into the next output file.  A merge in
+// progress when ctx is canceled is finished, and the state written, before
+// watch returns the stats of every merge.
+func (m *Merger) watch(ctx context.Context) (Stats, error) {
+	logger := m.log.With("phase", "watch")
+	start := time.Now()
+	var total Stats
+	// last is what the previous scan found, and rejected the files merges
+	// skipped, which are only tried again once they change.
+	last := map[string]stateInput{}
+	rejected := map[string]stateInput{}
+	ticker := time.NewTicker(m.opts.WatchInterval)
+	defer ticker.Stop()
+	logger.Info("watching for input files", "interval", m.opts.WatchInterval)
+	for {
+		files, err := m.inputFiles()
+		if err != nil && !errors.Is(err, ErrNoInputs) {
+			total.Duration = time.Since(start)
+			return total, err
+		}
+		ready, found, err := m.readyFiles(files, last, rejected)
+		if err != nil {
+			total.Duration = time.Since(start)
+			return total, err
+		}
+		last = found
+		if len(ready) > 0 && ctx.Err() == nil {
+			m.stats, m.merged = Stats{}, nil
+			begun := time.Now()
+			err := m.merge(context.WithoutCancel(ctx), ready)
+			m.stats.Duration = time.Since(begun)
+			total.add(m.stats)
+			switch {
+			case errors.Is(err, ErrNoInputs):
+				logger.Warn("none of the new files can be merged", "files", len(ready))
+			case err != nil:
+				total.Duration = time.Since(start)
+				return total, err
+			default:
+				m.logSummary()
+			}
+			for _, f := range m.stats.Skipped {
+				rejected[f.File] = found[f.File]
+			}
+		}
+		select {
+		case <-ctx.Done():
+			total.Duration = time.Since(start)
+			logger.Info("stopped watching", "files_included", total.FilesIncluded, "rows", total.RowsWritten, "output_files", len(total.OutputFiles))
+			return total, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readyFiles returns the files to merge: those that changed since they were
+// merged or rejected, if ever, that the previous scan, last, found at the
+// same size and modification time, and that have no .tmp sibling.  The
+// rest are still being written, or done with.  It also returns what it
+// found, for the next scan.
+func (m *Merger) readyFiles(files []string, last, rejected map[string]stateInput) ([]string, map[string]stateInput, error) {
+	logger := m.log.With("phase", "watch")
+	found := make(map[string]stateInput, len(files))
+	var ready []string
+	for _, file := range files {
+		r, size, modTime, err := m.openInput(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, nil, markError(ErrRead, err)
+		}
+		r.Close()
+		now := stateInput{Size: size, ModTime: modTime}
+		found[file] = now
+		if prev, ok := m.state.Inputs[file]; ok && sameInput(prev, now) {
+			continue
+		}
+		if prev, ok := rejected[file]; ok && sameInput(prev, now) {
+			continue
+		}
+		if prev, ok := last[file]; !ok || !sameInput(prev, now) {
+			logger.Debug("deferring a file that may still be written", "file", file, "size", size)
+			continue
+		}
+		if hasTempSibling(file) {
+			logger.Debug("deferring a file with a .tmp sibling", "file", file)
+			continue
+		}
+		ready = append(ready, file)
+	}
+	return ready, found, nil
+}
+
+// sameInput reports whether a and b have the same size and modification
+// time.
+func sameInput(a, b stateInput) bool {
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+// hasTempSibling reports whether the local file has a file.tmp or, without
+// its extension, a .tmp sibling, as writers that rename into place leave.
+func hasTempSibling(file string) bool {
+	if isRemote(file) {
+		return false
+	}
+	for _, name := range []string{file + ".tmp", strings.TrimSuffix(file, filepath.Ext(file)) + ".tmp"} {
+		if _, err := os.Stat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// waitFor waits until path exists, failing the test after a while.
+func waitFor(t *testing.T, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+	t.Fatalf("%s was never written", path)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := parquet.Group{"id": parquet.Int(64)}
+	writeParquet(t, filepath.Join(in, "a.parquet"), g, []map[string]any{{"id": int64(1)}})
+
+	opts := testOptions(filepath.Join(out, "merged.parquet"))
+	opts.Patterns, opts.SourceDir = nil, in
+	opts.StateFile = filepath.Join(dir, "state.json")
+	opts.Watch, opts.WatchInterval = true, 20*time.Millisecond
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type result struct {
+		stats Stats
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		stats, err := m.Run(ctx)
+		done <- result{stats, err}
+	}()
+
+	waitFor(t, filepath.Join(out, "merged.parquet"))
+	// A file with a .tmp sibling is still being written.
+	writeParquet(t, filepath.Join(in, "b.parquet"), g, []map[string]any{{"id": int64(2)}})
+	if err := os.WriteFile(filepath.Join(in, "b.parquet.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	next := filepath.Join(out, "merged-00002.parquet")
+	if _, err := os.Stat(next); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("merged b.parquet while it had a .tmp sibling: %v", err)
+	}
+	if err := os.Remove(filepath.Join(in, "b.parquet.tmp")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, next)
+	cancel()
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if got, want := r.stats.OutputFiles, []string{filepath.Join(out, "merged.parquet"), next}; !slices.Equal(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+	if r.stats.FilesIncluded != 2 || r.stats.RowsWritten != 2 {
+		t.Errorf("merged %d files and %d rows, want 2 and 2", r.stats.FilesIncluded, r.stats.RowsWritten)
+	}
+	if got := outputIDs(t, next); !slices.Equal(got, []int64{2}) {
+		t.Errorf("the second file holds the ids %v, want [2]", got)
+	}
+}
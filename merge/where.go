@@ -0,0 +1,292 @@
+package merge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// A whereExpr is a parsed -where expression: comparisons of a column with a
+// literal, combined with AND and OR and grouped with parentheses.
+type whereExpr struct {
+	op          string // "AND", "OR", or a comparison operator
+	left, right *whereExpr
+	column      string
+	literal     string
+	quoted      bool
+}
+
+// parseWhere parses a -where expression such as
+// `level == "error" AND (timestamp >= 1717200000000 OR urgent == true)`.
+func parseWhere(s string) (*whereExpr, error) {
+	p := &whereParser{}
+	if err := p.tokenize(s); err != nil {
+		return nil, err
+	}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("where: unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+type whereToken struct {
+	text   string
+	quoted bool
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, whereToken{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("where: unterminated string at %d", i)
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return fmt.Errorf("where: bad string at %d: %w", i, err)
+			}
+			p.tokens = append(p.tokens, whereToken{text: text, quoted: true})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			j := i + 1
+			for j < len(s) && strings.ContainsRune("=&|", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, whereToken{text: s[i:j]})
+			i = j
+		default:
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune("_.-+", rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("where: unexpected %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, whereToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *whereParser) peek() string {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted {
+		return strings.ToUpper(p.tokens[p.pos].text)
+	}
+	return ""
+}
+
+func (p *whereParser) next() (whereToken, error) {
+	if p.pos >= len(p.tokens) {
+		return whereToken{}, fmt.Errorf("where: unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *whereParser) or() (*whereExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "OR" || op == "||"; op = p.peek() {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &whereExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) and() (*whereExpr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "AND" || op == "&&"; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &whereExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) term() (*whereExpr, error) {
+	if p.peek() == "(" {
+		p.pos++
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.next(); err != nil || tok.text != ")" {
+			return nil, fmt.Errorf("where: missing )")
+		}
+		return expr, nil
+	}
+	column, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if column.quoted || !isColumnName(column.text) {
+		return nil, fmt.Errorf("where: expected a column name, got %q", column.text)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch op.text {
+	case "=":
+		op.text = "=="
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("where: unknown operator %q", op.text)
+	}
+	literal, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !literal.quoted && (literal.text == "(" || literal.text == ")") {
+		return nil, fmt.Errorf("where: expected a literal after %s %s", column.text, op.text)
+	}
+	return &whereExpr{op: op.text, column: column.text, literal: literal.text, quoted: literal.quoted}, nil
+}
+
+func isColumnName(s string) bool {
+	return s != "" && (unicode.IsLetter(rune(s[0])) || s[0] == '_')
+}
+
+// compile binds the expression to the columns of schema, returning a
+// function reporting whether a row matches.  Comparisons with null are
+// false.  A column not in the schema, which no file has, is an error.
+func (e *whereExpr) compile(schema *parquet.Schema) (func(parquet.Row) bool, error) {
+	switch e.op {
+	case "AND", "OR":
+		left, err := e.left.compile(schema)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.right.compile(schema)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "AND" {
+			return func(row parquet.Row) bool { return left(row) && right(row) }, nil
+		}
+		return func(row parquet.Row) bool { return left(row) || right(row) }, nil
+	}
+
+	leaf, ok := lookupColumn(schema, e.column)
+	if !ok {
+		return nil, fmt.Errorf("where: no input file has the column %s", e.column)
+	}
+	if leaf.MaxRepetitionLevel > 0 {
+		return nil, fmt.Errorf("where: %s is a repeated column", e.column)
+	}
+	typ := leaf.Node.Type()
+	literal, err := literalValue(typ, e.literal, e.quoted)
+	if err != nil {
+		return nil, fmt.Errorf("where: %s: %w", e.column, err)
+	}
+	column, op := leaf.ColumnIndex, e.op
+	return func(row parquet.Row) bool {
+		v := columnValue(row, column)
+		if v.IsNull() {
+			return false
+		}
+		c := typ.Compare(v, literal)
+		switch op {
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}, nil
+}
+
+// literalValue converts a literal to a value of the column type typ.
+func literalValue(typ parquet.Type, s string, quoted bool) (parquet.Value, error) {
+	if typ.Kind() == parquet.ByteArray {
+		if !quoted {
+			return parquet.Value{}, fmt.Errorf("string column compared with unquoted %s", s)
+		}
+		return parquet.ValueOf(s), nil
+	}
+	if quoted {
+		return parquet.Value{}, fmt.Errorf("%s column compared with string %q", typ.Kind(), s)
+	}
+	switch typ.Kind() {
+	case parquet.Boolean:
+		b, err := strconv.ParseBool(s)
+		return parquet.ValueOf(b), err
+	case parquet.Int32:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil && typ.LogicalType() != nil && typ.LogicalType().Integer != nil && !typ.LogicalType().Integer.IsSigned {
+			u, uerr := strconv.ParseUint(s, 10, 32)
+			return parquet.ValueOf(int32(u)), uerr
+		}
+		return parquet.ValueOf(int32(n)), err
+	case parquet.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil && typ.LogicalType() != nil && typ.LogicalType().Integer != nil && !typ.LogicalType().Integer.IsSigned {
+			u, uerr := strconv.ParseUint(s, 10, 64)
+			return parquet.ValueOf(int64(u)), uerr
+		}
+		return parquet.ValueOf(n), err
+	case parquet.Float:
+		f, err := strconv.ParseFloat(s, 32)
+		return parquet.ValueOf(float32(f)), err
+	case parquet.Double:
+		f, err := strconv.ParseFloat(s, 64)
+		return parquet.ValueOf(f), err
+	}
+	return parquet.Value{}, fmt.Errorf("comparisons with %s columns are not supported", typ.Kind())
+}
+
+// columnValue returns the value of a non-repeated column in row, or null
+// if it has none.
+func columnValue(row parquet.Row, column int) parquet.Value {
+	for _, v := range row {
+		if v.Column() == column {
+			return v
+		}
+	}
+	return parquet.Value{}
+}
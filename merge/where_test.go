@@ -0,0 +1,52 @@
+package merge
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestWhereColumns(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.parquet")
+	writeParquet(t, in, parquet.Group{"id": parquet.Int(64), "level": parquet.String()},
+		[]map[string]any{{"id": int64(1), "level": "info"}, {"id": int64(5), "level": "ERROR"}})
+	tests := []struct {
+		where string
+		// rows is the number of rows merged, or -1 for ErrInvalidOptions.
+		rows   int
+		derive map[string]string
+	}{
+		{where: "id > 3", rows: 1},
+		{where: `id > 3 OR level == "info"`, rows: 2},
+		{where: "nosuch > 3", rows: -1},
+		{where: `id > 3 AND nosuch == "x"`, rows: -1},
+		{where: `lower_level == "error"`, rows: 1, derive: map[string]string{"lower_level": "lower(level)"}},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "merged.parquet")
+		opts := testOptions(out, in)
+		opts.Where, opts.Derive = tt.where, tt.derive
+		m, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.Run(context.Background())
+		if tt.rows < 0 {
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("-where %s: error %v, want ErrInvalidOptions", tt.where, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("-where %s: %v", tt.where, err)
+			continue
+		}
+		if _, rows := readParquet(t, out); len(rows) != tt.rows {
+			t.Errorf("-where %s merged %d rows, want %d", tt.where, len(rows), tt.rows)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/skandragon/parquet-sandbox/merge"
+)
+
+// startProfiles starts the -cpuprofile profile and returns the function
+// that stops it and writes the -memprofile profile, logging any error.
+func startProfiles(cpuFile, memFile string) (func(), error) {
+	var cpu *os.File
+	if cpuFile != "" {
+		var err error
+		if cpu, err = os.Create(cpuFile); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				slog.Error("error writing CPU profile", "error", err)
+			}
+		}
+		if memFile != "" {
+			if err := writeHeapProfile(memFile); err != nil {
+				slog.Error("error writing memory profile", "error", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	// The profile shows the heap as of the last collection.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printBench writes the throughput of a -bench run to w.
+func printBench(w io.Writer, stats merge.Stats) {
+	seconds := stats.Duration.Seconds()
+	fmt.Fprintf(w, "read %d rows, %d bytes, from %d files in %s\n", stats.RowsRead, stats.InputBytes, stats.FilesIncluded, stats.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "scan %s, copy %s\n", stats.ScanDuration.Round(time.Millisecond), stats.CopyDuration.Round(time.Millisecond))
+	if seconds > 0 {
+		fmt.Fprintf(w, "%.0f rows/s, %.2f MB/s\n", float64(stats.RowsRead)/seconds, stats.MBPerSecond())
+	}
+	if rss := peakRSS(); rss > 0 {
+		fmt.Fprintf(w, "peak RSS %.1f MB\n", float64(rss)/(1<<20))
+	}
+}
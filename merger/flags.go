@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultList collects repeated -default column=value flags.
+type defaultList []string
+
+func (l *defaultList) String() string { return strings.Join(*l, ",") }
+
+func (l *defaultList) Set(s string) error {
+	if name, _, ok := strings.Cut(s, "="); !ok || name == "" {
+		return fmt.Errorf("%q is not column=value", s)
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// loadDefaults returns the column defaults given by -defaults-file, a JSON
+// object of column names to values, and then by -default flags, which win.
+func loadDefaults(list []string, file string) (map[string]string, error) {
+	out := map[string]string{}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]any
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&values); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for k, v := range values {
+			switch v.(type) {
+			case string, json.Number, bool:
+				out[k] = fmt.Sprint(v)
+			default:
+				return nil, fmt.Errorf("%s: default for %s must be a string, number or boolean", file, k)
+			}
+		}
+	}
+	for _, entry := range list {
+		name, value, _ := strings.Cut(entry, "=")
+		out[name] = value
+	}
+	return out, nil
+}
+
+// deriveList collects repeated -derive column=expression flags.
+type deriveList []string
+
+func (l *deriveList) String() string { return strings.Join(*l, ";") }
+
+func (l *deriveList) Set(s string) error {
+	if name, expr, ok := strings.Cut(s, "="); !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(expr) == "" {
+		return fmt.Errorf("%q is not column=expression", s)
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// loadDerives maps the columns named by -derive flags to their expressions.
+func loadDerives(list []string) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	out := map[string]string{}
+	for _, entry := range list {
+		name, expr, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if _, ok := out[name]; ok {
+			return nil, fmt.Errorf("-derive gives column %s twice", name)
+		}
+		out[name] = expr
+	}
+	return out, nil
+}
+
+// rowGroupList collects repeated -row-groups file=range flags.
+type rowGroupList []string
+
+func (l *rowGroupList) String() string { return strings.Join(*l, ";") }
+
+func (l *rowGroupList) Set(s string) error {
+	// File names may hold "=", ranges do not.
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || strings.TrimSpace(s[i+1:]) == "" {
+		return fmt.Errorf("%q is not file=first-last", s)
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// loadRowGroups maps the files named by -row-groups flags to their ranges.
+func loadRowGroups(list []string) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	out := map[string]string{}
+	for _, entry := range list {
+		i := strings.LastIndex(entry, "=")
+		file := entry[:i]
+		if _, ok := out[file]; ok {
+			return nil, fmt.Errorf("-row-groups gives file %s twice", file)
+		}
+		out[file] = entry[i+1:]
+	}
+	return out, nil
+}
+
+// loadRenames parses the -rename list and -rename-file mapping.
+func loadRenames(list, file string) (map[string]string, error) {
+	renames := map[string]string{}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &renames); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if list == "" {
+		return renames, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid rename %q: expected old=new", pair)
+		}
+		renames[from] = to
+	}
+	return renames, nil
+}
+
+// loadTypeOverrides reads -type-overrides, a JSON object of column names
+// to type names.
+func loadTypeOverrides(file string) (map[string]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return overrides, nil
+}
+
+// parseColumnCodecs parses -column-compression, a comma separated list of
+// column=codec pairs.
+func parseColumnCodecs(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		column, name, ok := strings.Cut(pair, "=")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid -column-compression entry %q: want column=codec", pair)
+		}
+		out[column] = name
+	}
+	return out, nil
+}
+
+// parseTimeNormalize parses -time-normalize, a comma separated list of
+// column=unit pairs.
+func parseTimeNormalize(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		column, unit, ok := strings.Cut(pair, "=")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid -time-normalize entry %q: want column=unit", pair)
+		}
+		if _, ok := out[column]; ok {
+			return nil, fmt.Errorf("-time-normalize gives column %s twice", column)
+		}
+		out[column] = unit
+	}
+	return out, nil
+}
+
+// parseRedact parses -redact, a comma separated list of column=method
+// pairs.
+func parseRedact(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		column, method, ok := strings.Cut(pair, "=")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid -redact entry %q: want column=method", pair)
+		}
+		if _, ok := out[column]; ok {
+			return nil, fmt.Errorf("-redact gives column %s twice", column)
+		}
+		out[column] = method
+	}
+	return out, nil
+}
+
+// splitList splits a comma separated flag, returning nil for an empty one.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "defaults.json")
+	if err := os.WriteFile(file, []byte(`{"level": "info", "port": 8080, "ok": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var list defaultList
+	for _, s := range []string{"level=warn", "zone="} {
+		if err := list.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := list.Set("=x"); err == nil {
+		t.Error("-default =x was accepted")
+	}
+	got, err := loadDefaults(list, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flags win over the file.
+	want := map[string]string{"level": "warn", "port": "8080", "ok": "true", "zone": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadDefaults = %v, want %v", got, want)
+	}
+}
+
+func TestLoadRowGroups(t *testing.T) {
+	var list rowGroupList
+	for _, s := range []string{"a=b.parquet=3-7", "c.parquet=2"} {
+		if err := list.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := loadRowGroups(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a=b.parquet": "3-7", "c.parquet": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadRowGroups = %v, want %v", got, want)
+	}
+	if _, err := loadRowGroups(append(list, "c.parquet=4")); err == nil {
+		t.Error("-row-groups giving a file twice was accepted")
+	}
+}
+
+func TestLoadDerives(t *testing.T) {
+	got, err := loadDerives([]string{`env = split(service, "-", 1)`, "day=date_trunc(ts)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"env": ` split(service, "-", 1)`, "day": "date_trunc(ts)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadDerives = %q, want %q", got, want)
+	}
+	if _, err := loadDerives([]string{"a=lower(x)", "a =upper(x)"}); err == nil {
+		t.Error("-derive giving a column twice was accepted")
+	}
+}
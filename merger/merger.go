@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/parquet-go/parquet-go"
+	"github.com/skandragon/parquet-sandbox/merge"
 )
 
 //
@@ -18,199 +23,421 @@ import (
 // in memory at once, so long as the writer does not hold on to too many unwritten records.
 //
 
+// defaults holds the defaults of the flags.
+var defaults = merge.DefaultOptions()
+
 var (
-	sourcedir     = flag.String("sourcedir", "", "directory containing parquet files to merge")
-	outfile       = flag.String("outfile", "", "output file to write merged records to")
-	requireFields = flag.String("requireFields", "", "comma separated list of fields that must be present in a file to merge")
+	sourcedir        = flag.String("sourcedir", "", "directory containing parquet files to merge")
+	outfile          = flag.String("outfile", "", "output file to write merged records to, or - for stdout")
+	requireFields    = flag.String("requireFields", "", "comma separated list of fields that must be present in a file to merge; name:type also checks the type; separate alternative lists with |")
+	strict           = flag.Bool("strict", false, "fail on any column type mismatch instead of promoting numeric types")
+	onConflict       = flag.String("on-conflict", defaults.OnConflict, "what to do with columns whose types cannot be merged: fail without promoting types, widen numeric types and fail on the rest, stringify, skip-field or skip-file")
+	coerceDecimal    = flag.Bool("coerce-decimal", false, "rescale DECIMAL columns whose precision or scale differ between files")
+	uuidAsString     = flag.Bool("uuid-as-string", false, "merge UUID columns with STRING columns of the same name as hyphenated strings")
+	recursive        = flag.Bool("recursive", false, "search sourcedir and its subdirectories for parquet files")
+	filelist         = flag.String("filelist", "", "file listing the parquet files to merge, one per line, or - for stdin")
+	exclude          = flag.String("exclude", "", "comma separated glob patterns of files to skip; a pattern ending in / skips a directory")
+	ext              = flag.String("ext", strings.Join(defaults.Extensions, ","), "comma separated extensions of the files to merge from sourcedir")
+	minAge           = flag.Duration("min-age", 0, "skip files in sourcedir modified less than this long ago, which may still be being written")
+	verbose          = flag.Bool("verbose", false, "same as -v")
+	debugLog         = flag.Bool("v", false, "also log per-file detail, such as why each file was skipped")
+	quietLog         = flag.Bool("q", false, "log only warnings and errors, and do not report progress")
+	quiet            = flag.Bool("quiet", false, "same as -q")
+	logFormat        = flag.String("log-format", "text", "log format: text, or json for one object per event")
+	columns          = flag.String("columns", "", "comma separated columns to include in the output; all columns if empty")
+	dropColumns      = flag.String("drop-columns", "", "comma separated columns to leave out of the output")
+	rename           = flag.String("rename", "", "comma separated old=new column renames applied before merging")
+	renameFile       = flag.String("rename-file", "", "JSON object mapping old column names to new ones")
+	normalizeNames   = flag.String("normalize-names", defaults.NormalizeNames, "normalize top-level column names after -rename: lower, snake or none")
+	flatten          = flag.Bool("flatten", false, "expand groups other than LISTs and MAPs into top-level columns named by dotted paths, such as resource.host, after -rename")
+	nestByDots       = flag.Bool("nest-by-dots", false, "write columns named by dotted paths, such as k8s.pod.name, nested in groups")
+	tightenNulls     = flag.Bool("tighten-nullability", false, "make required the optional columns every file has and whose statistics show no nulls")
+	where            = flag.String("where", "", "only merge rows matching an expression such as 'level == \"error\" AND timestamp >= 1717200000000'")
+	timeColumn       = flag.String("time-column", defaults.TimeColumn, "column -after, -before and -retain-days apply to")
+	after            = flag.String("after", "", "only merge rows whose -time-column is at or after this RFC 3339 time")
+	before           = flag.String("before", "", "only merge rows whose -time-column is before this RFC 3339 time")
+	retainDays       = flag.Int("retain-days", 0, "drop rows whose -time-column is more than this many days old, or null; 0 keeps them")
+	retentionReport  = flag.String("retention-report", "", "write a JSON report of the rows -retain-days kept and dropped per input file to this file")
+	dedupKeys        = flag.String("dedup-keys", "", "comma separated columns identifying duplicate rows; only the first row with each key is written")
+	dedupLatest      = flag.Bool("dedup-prefer-latest", false, "keep the duplicate with the greatest -dedup-time-column instead of the first")
+	dedupTime        = flag.String("dedup-time-column", defaults.DedupTimeColumn, "column compared by -dedup-prefer-latest")
+	dedupMaxKeys     = flag.Int("dedup-max-keys", 0, "spill -dedup-keys keys to disk after this many are held in memory; 0 never spills (not with -dedup-prefer-latest)")
+	deleteKeys       = flag.String("delete-keys", "", "parquet or CSV file of keys whose rows are left out of the output; a CSV file needs a header row")
+	deleteKeyColumn  = flag.String("delete-key-column", defaults.DeleteKeyColumn, "column holding the -delete-keys keys, in the keys file and the inputs")
+	batchSize        = flag.Int("batch-size", defaults.BatchSize, "number of rows to copy at a time")
+	noFastpath       = flag.Bool("no-fastpath", false, "always decode rows, even from files whose schema matches the merged schema")
+	sortedBy         = flag.String("sorted-by", "", "column every input is sorted by; the output is merged in order of it")
+	sortBy           = flag.String("sortby", "", "comma separated columns to sort the output by, each optionally followed by :desc")
+	sortNulls        = flag.String("sort-nulls", defaults.SortNulls, "where -sortby places nulls: first or last")
+	sortBufferRows   = flag.Int64("sort-buffer-rows", defaults.SortBufferRows, "number of rows -sortby sorts in memory at a time")
+	sortExternal     = flag.Bool("sort-external", false, "sort the whole -sortby output through sorted runs spilled to temporary files")
+	sortTempDir      = flag.String("sort-temp-dir", "", "directory for the runs of -sort-external (default the system temporary directory)")
+	unsorted         = flag.String("unsorted", defaults.Unsorted, "what to do with -sorted-by inputs that are not sorted: reject or buffer")
+	scanJobs         = flag.Int("scan-jobs", defaults.ScanJobs, "number of files to read schemas from concurrently")
+	int96As          = flag.String("int96-as", "", "how to merge legacy INT96 columns: timestamp-millis or bytes")
+	typeOverrides    = flag.String("type-overrides", "", "JSON file mapping columns to the types they are merged as, such as {\"port\": \"INT32\", \"user_id\": \"string\"}")
+	onCastError      = flag.String("on-cast-error", defaults.OnCastError, "what to do with values that cannot be cast to their -type-overrides type: fail or null")
+	timeNormalize    = flag.String("time-normalize", "", "comma separated column=unit pairs, such as timestamp=millis, merging the columns as TIMESTAMPs in millis, micros or nanos converted from the unit of each file")
+	assumeUnit       = flag.String("assume-unit", "", "unit of the plain INT64 columns of -time-normalize: seconds, millis, micros or nanos")
+	onUnsupported    = flag.String("on-unsupported", defaults.OnUnsupported, "what to do with columns of types that cannot be read: fail the file, drop the column, or stringify it")
+	utf8Policy       = flag.String("utf8", "", "what to do with invalid UTF-8 in STRING columns: reject, replace or binary (default: not checked)")
+	skipBadFiles     = flag.Bool("skip-bad-files", false, "log and skip files that cannot be read instead of failing")
+	maxBadFiles      = flag.Int("max-bad-files", defaults.MaxBadFiles, "with -skip-bad-files, fail without writing the output if more than this many files were skipped; -1 for no limit")
+	progressInterval = flag.Duration("progress-interval", defaults.ProgressInterval, "report progress this often; 0 to report only by -progress-rows")
+	progressRows     = flag.Int64("progress-rows", 0, "also report progress every this many rows; 0 to report only by -progress-interval")
+	progressJSON     = flag.Bool("progress-json", false, "print progress to stdout, or stderr when the output is stdout, as JSON lines")
+	compression      = flag.String("compression", defaults.Compression, "output compression: zstd, snappy, gzip, lz4, brotli or none")
+	columnCodecs     = flag.String("column-compression", "", "comma separated column=codec overrides of -compression for leaf columns")
+	rowGroupRows     = flag.Int64("row-group-rows", 0, "maximum rows per output row group; 0 for the parquet-go default")
+	rowGroupBytes    = flag.Int64("row-group-bytes", 0, "end output row groups once their uncompressed values reach this many bytes; 0 for no limit")
+	pageBufferSize   = flag.Int("page-buffer-size", defaults.PageBufferSize, "bytes of column values buffered before they are written as a page")
+	writeBufferSize  = flag.Int("write-buffer-size", defaults.WriteBufferSize, "bytes buffered before writing to outfile")
+	pageBufferPool   = flag.String("page-buffer-pool", defaults.PageBufferPool, "where pages are buffered while a row group is written: memory or file, for temporary files")
+	bloomColumns     = flag.String("bloom-columns", "", "comma separated leaf columns to write bloom filters for")
+	bloomBits        = flag.Uint("bloom-bits", defaults.BloomBits, "bits per value of -bloom-columns filters")
+	kvConflict       = flag.String("kv-conflict", defaults.KVConflict, "how to merge footer metadata keys whose values differ between files: join, array or drop")
+	sourceColumn     = flag.String("source-column", "", "add a STRING column with this name holding the path of the file each row came from")
+	redact           = flag.String("redact", "", "comma separated column=method pairs redacting columns once rows are filtered: sha256 hashes values, mask keeps their first and last characters, drop removes the column")
+	redactSalt       = flag.String("redact-salt", "", "salt prepended to the values -redact hashes with sha256")
+	maxOutputRows    = flag.Int64("max-output-rows", 0, "start a new output file after this many rows; 0 for no limit")
+	maxOutputBytes   = flag.Int64("max-output-bytes", 0, "start a new output file at the first row group boundary after this many bytes; 0 for no limit")
+	targetBytes      = flag.String("target-output-bytes", "", "size, such as 1GB, the output should not pass, estimated between input files; passing it is logged unless -stop-at-budget")
+	stopAtBudget     = flag.Bool("stop-at-budget", false, "merge no more input files once the output reaches -target-output-bytes")
+	leftover         = flag.String("leftover", "", "with -stop-at-budget, write the input files left for a later run to this file, one per line")
+	outputTemplate   = flag.String("output-template", "", "fmt pattern naming split output files, such as merged-%05d.parquet; defaults to outfile with a number added")
+	stateFile        = flag.String("state", "", "JSON file recording the inputs merged and files written; inputs already merged are skipped and new ones written to the next output file")
+	watch            = flag.Bool("watch", false, "keep running, looking for new input files every -interval and merging them into the next output file of the -state series, until SIGINT or SIGTERM")
+	watchInterval    = flag.Duration("interval", defaults.WatchInterval, "how often -watch looks for new input files")
+	appendOutput     = flag.Bool("append", false, "merge an existing outfile with the inputs, its rows first, and replace it with the result")
+	partitionBy      = flag.String("partition-by", "", "write the output under the outfile directory in column=value subdirectories by this column")
+	dropPartition    = flag.Bool("drop-partition-column", false, "leave the -partition-by column out of the partitioned files")
+	shardBy          = flag.String("shard-by", "", "write the output as -shards files, sending each row to the one a hash of this column picks")
+	shards           = flag.Int("shards", 0, "number of -shard-by files, named like merged-shard-00.parquet")
+	maxOpenWriters   = flag.Int("max-open-writers", defaults.MaxOpenWriters, "most -partition-by partitions written at once; the least recently used is closed to open another")
+	deterministic    = flag.Bool("deterministic", defaults.Deterministic, "merge files in the order set by -order instead of the order they were found or listed")
+	reproducible     = flag.Bool("reproducible", false, "write byte-identical output for the same inputs: name order, and no wall clock or library version in the footer")
+	order            = flag.String("order", defaults.Order, "order to merge files in with -deterministic: name or mtime")
+	limit            = flag.Int64("limit", 0, "stop after writing this many rows; 0 for no limit")
+	offset           = flag.Int64("offset", 0, "skip this many rows, across all inputs, before writing any")
+	sample           = flag.Float64("sample", 0, "keep each row, after -where and time range filtering, with this probability; 0 keeps every row")
+	samplePerFile    = flag.Int("sample-per-file", 0, "keep at most this many randomly chosen rows from each input file instead of -sample; 0 for no limit")
+	seed             = flag.Int64("seed", 0, "random seed for -sample and -sample-per-file; 0 picks one from the clock, which is logged")
+	httpBlockSize    = flag.Int64("http-block-size", defaults.HTTPBlockSize, "bytes read by each ranged GET of an http or https input")
+	httpCacheBlocks  = flag.Int("http-cache-blocks", defaults.HTTPCacheBlocks, "blocks of each http or https input kept in memory")
+	httpRetries      = flag.Int("http-retries", defaults.HTTPRetries, "times to retry an http or https request after a server or connection error")
+	httpTimeout      = flag.Duration("http-timeout", defaults.HTTPTimeout, "longest an http or https request may take, reading its response included")
+	maxMemory        = flag.String("max-memory", "", "bound the rows buffered for writing and copied at a time to about this much memory, such as 512MB; empty for no bound")
+	prefetch         = flag.Int("prefetch", defaults.Prefetch, "number of input files to open and start reading ahead of the one being copied; 0 reads them one at a time")
+	copyJobs         = flag.Int("copy-jobs", defaults.CopyJobs, "number of workers decoding row groups of the inputs at once; more than 1 replaces -prefetch")
+	maxOpenFiles     = flag.Int("max-open-files", 0, "maximum number of input files open at once; 0 derives it from the open file limit")
+	defaultsFile     = flag.String("defaults-file", "", "JSON object of column names to the values written when a file lacks the column; -default flags win")
+	compatReportFile = flag.String("report", "", "write a JSON report of every scanned file's columns, missing required fields, conflicting columns and whether it was merged to this file")
+	schemaOut        = flag.String("schema-out", "", "once the output is written, write a JSON description of its schema to this file")
+	schemaOutFormat  = flag.String("schema-out-format", defaults.SchemaOutFormat, "json, or text to also write the schema as text beside -schema-out, with a .txt extension")
+	manifest         = flag.String("manifest", "", "once the merge is complete, write a JSON manifest of the output files, with their SHA-256, and of the inputs to this file")
+	checkCRC         = flag.Bool("check-crc", false, "report the column, row group and page of input pages that fail their checksum or cannot be read")
+	verify           = flag.Bool("verify", false, "reopen the output once it is written and check it parses and holds every row written")
+	verifyDeep       = flag.Bool("verify-deep", false, "with -verify, also decode every row group of the output")
+	dryRun           = flag.Bool("dry-run", false, "print the files that would be merged and the merged schema without writing outfile")
+	statsJSON        = flag.String("stats-json", "", "write the end-of-run statistics to this file as JSON, even if the merge fails")
+	onInterrupt      = flag.String("on-interrupt", defaults.OnInterrupt, "what to do with the output on SIGINT or SIGTERM: finish writing what was merged and mark it partial, or discard it")
+	bench            = flag.Bool("bench", false, "merge without writing outfile, and print the rows and bytes read per second, the time spent scanning and copying, and the peak RSS")
+	cpuProfile       = flag.String("cpuprofile", "", "write a pprof CPU profile of the run to this file")
+	memProfile       = flag.String("memprofile", "", "write a pprof heap profile to this file at the end of the run")
+	noLock           = flag.Bool("no-lock", false, "do not take the outfile.lock lock file that keeps two runs from writing the same output")
+	lockStaleAge     = flag.Duration("lock-stale-age", defaults.LockStaleAge, "break a lock file older than this; 0 never breaks a lock whose process may be running")
 )
 
-func main() {
-	flag.Parse()
-
-	if *sourcedir == "" {
-		log.Fatal("sourcedir is required")
-	}
-
-	if *outfile == "" {
-		*outfile = "merged.parquet"
-	}
+// Exit statuses.  exitInterrupted is what a shell reports for a process
+// killed by SIGINT.
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitNoInputs    = 3
+	exitSchema      = 4
+	exitRead        = 5
+	exitWrite       = 6
+	exitVerify      = 7
+	exitLocked      = 8
+	exitInterrupted = 130
+)
 
-	rfields := strings.Split(*requireFields, ",")
-	files := findFiles(*sourcedir)
+// defaultFlags holds the -default flags, deriveFlags the -derive flags and
+// rowGroupFlags the -row-groups flags.
+var (
+	defaultFlags  defaultList
+	deriveFlags   deriveList
+	rowGroupFlags rowGroupList
+)
 
-	merge(*outfile, rfields, files)
-}
+func main() {
+	flag.Var(&defaultFlags, "default", "column=value written when a file lacks the column, instead of null; may be repeated")
+	flag.Var(&deriveFlags, "derive", `column=expression adding a column computed from others, as in date=date_trunc(ts) or env=split(service,"-",1), using date_trunc, split, lower, upper, concat and coalesce; may be repeated`)
+	flag.Var(&rowGroupFlags, "row-groups", "file.parquet=3-7 copying only those row groups of the input file, by index from 0, or one as in file.parquet=3; may be repeated")
+	flag.Parse()
 
-func findFiles(dir string) []string {
-	files, err := os.ReadDir(dir)
+	logger, err := newLogger(*logFormat, *verbose || *debugLog, *quiet || *quietLog)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitUsage)
 	}
-	var out []string
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		if strings.HasSuffix(file.Name(), ".parquet") {
-			out = append(out, dir+"/"+file.Name())
-		}
-	}
-	return out
-}
-
-func merge(outfile string, rfields, files []string) {
-	mergedSchema := map[string]parquet.Node{}
-	fileSchema := map[string]*parquet.Schema{}
-	for _, file := range files {
-		nodes, err := getSchemaNodes(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-		keep := true
-		for _, field := range rfields {
-			if _, ok := nodes[field]; !ok {
-				keep = false
-				break
-			}
-		}
-		if !keep {
-			continue
-		}
-		fileSchema[file] = parquet.NewSchema(file, parquet.Group(nodes))
-		for k, v := range nodes {
-			if currentNode, ok := mergedSchema[k]; ok {
-				if currentNode != v {
-					log.Fatalf("schema mismatch: %s", k)
-				}
-			} else {
-				mergedSchema[k] = v
-			}
-		}
+	slog.SetDefault(logger)
+	opts, err := options()
+	if err != nil {
+		fatal(err, exitUsage)
 	}
-	schema := parquet.NewSchema("merged", parquet.Group(mergedSchema))
-
-	outf, err := os.Create(outfile)
+	m, err := merge.New(opts)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err, exitStatus(err))
 	}
-	wc, err := parquet.NewWriterConfig(schema, parquet.Compression(&parquet.Zstd))
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
 	if err != nil {
-		log.Fatalf("error creating writer config: %v", err)
+		fatal(err, exitWrite)
 	}
-	writer := parquet.NewGenericWriter[map[string]any](outf, wc)
-	for file := range fileSchema {
-		stat, err := os.Stat(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-		inf, err := os.Open(file)
-		if err != nil {
-			log.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal kills the merger outright.
+		<-ctx.Done()
+		stop()
+	}()
+	stats, err := m.Run(ctx)
+	stopProfiles()
+	if err == nil && *bench {
+		printBench(os.Stdout, stats)
+	}
+	if *statsJSON != "" {
+		if werr := writeStats(*statsJSON, stats); werr != nil {
+			if err == nil {
+				fatal(werr, exitWrite)
+			}
+			slog.Error("error writing stats", "error", werr)
 		}
-		copyFromFile(inf, stat.Size(), writer, fileSchema[file])
-		inf.Close()
 	}
-
-	if err := writer.Close(); err != nil {
-		log.Fatalf("error closing writer: %v", err)
+	if err != nil {
+		fatal(err, exitStatus(err))
 	}
 }
 
-func copyFromFile(inf io.ReaderAt, size int64, writer *parquet.GenericWriter[map[string]any], schema *parquet.Schema) error {
-	pf, err := parquet.OpenFile(inf, size)
+// writeStats writes stats to file as indented JSON.
+func writeStats(file string, stats merge.Stats) error {
+	b, err := json.MarshalIndent(stats, "", "  ")
 	if err != nil {
 		return err
 	}
-	f := parquet.NewReader(pf, schema)
-	defer f.Close()
+	return os.WriteFile(file, append(b, '\n'), 0o644)
+}
 
-	for {
-		record := map[string]any{}
-		err := f.Read(&record)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return err
-		}
-		n, err := writer.Write([]map[string]any{record})
-		if err != nil {
-			return err
-		}
-		if n != 1 {
-			return fmt.Errorf("expected to write 1 record, wrote %d", n)
-		}
+// exitStatus returns the exit status for err, by the kind of error it is.
+func exitStatus(err error) int {
+	switch {
+	case errors.Is(err, merge.ErrInterrupted):
+		return exitInterrupted
+	case errors.Is(err, merge.ErrInvalidOptions):
+		return exitUsage
+	case errors.Is(err, merge.ErrNoInputs):
+		return exitNoInputs
+	case errors.Is(err, merge.ErrVerify):
+		return exitVerify
+	case errors.Is(err, merge.ErrLocked):
+		return exitLocked
+	case errors.Is(err, merge.ErrSchemaConflict):
+		return exitSchema
+	case errors.Is(err, merge.ErrRead):
+		return exitRead
+	case errors.Is(err, merge.ErrWrite):
+		return exitWrite
 	}
-	return nil
+	return exitFailure
 }
 
-func getSchemaNodes(fname string) (map[string]parquet.Node, error) {
-	stat, err := os.Stat(fname)
-	if err != nil {
-		return nil, err
+// newLogger returns the logger set by -log-format, -v and -q, writing to
+// stderr.
+func newLogger(format string, debug, quiet bool) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
 	}
-	r, err := os.Open(fname)
-	if err != nil {
-		return nil, err
+	if quiet {
+		level = slog.LevelWarn
 	}
-	f, err := parquet.OpenFile(r, stat.Size())
-	if err != nil {
-		return nil, err
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
 	}
-	defer r.Close()
+	return nil, fmt.Errorf("invalid -log-format %q: must be text or json", format)
+}
+
+// fatal logs err as the last event and exits with status.
+func fatal(err error, status int) {
+	slog.Error("merge failed", "error", err)
+	os.Exit(status)
+}
 
-	md := f.Metadata()
-	nodes := map[string]parquet.Node{}
-	for _, schema := range md.Schema {
-		if schema.Type == nil {
-			continue
+// options returns the merge options set by the flags.
+func options() (merge.Options, error) {
+	opts := merge.Options{
+		SourceDir:           *sourcedir,
+		Recursive:           *recursive,
+		FileList:            *filelist,
+		Patterns:            flag.Args(),
+		Exclude:             splitList(*exclude),
+		Extensions:          splitList(*ext),
+		MinAge:              *minAge,
+		Deterministic:       *deterministic,
+		Reproducible:        *reproducible,
+		Order:               *order,
+		ScanJobs:            *scanJobs,
+		SkipBadFiles:        *skipBadFiles,
+		MaxBadFiles:         *maxBadFiles,
+		RequireFields:       *requireFields,
+		OnConflict:          *onConflict,
+		Strict:              *strict,
+		CoerceDecimal:       *coerceDecimal,
+		UUIDAsString:        *uuidAsString,
+		Int96As:             *int96As,
+		UTF8:                *utf8Policy,
+		OnUnsupported:       *onUnsupported,
+		OnCastError:         *onCastError,
+		AssumeUnit:          *assumeUnit,
+		Columns:             splitList(*columns),
+		DropColumns:         splitList(*dropColumns),
+		NormalizeNames:      *normalizeNames,
+		Flatten:             *flatten,
+		NestByDots:          *nestByDots,
+		TightenNullability:  *tightenNulls,
+		ReportFile:          *compatReportFile,
+		SchemaOut:           *schemaOut,
+		SchemaOutFormat:     *schemaOutFormat,
+		Manifest:            *manifest,
+		NoLock:              *noLock,
+		LockStaleAge:        *lockStaleAge,
+		Where:               *where,
+		TimeColumn:          *timeColumn,
+		RetainDays:          *retainDays,
+		RetentionReport:     *retentionReport,
+		DedupKeys:           splitList(*dedupKeys),
+		DedupPreferLatest:   *dedupLatest,
+		DedupTimeColumn:     *dedupTime,
+		DedupMaxKeys:        *dedupMaxKeys,
+		DeleteKeys:          *deleteKeys,
+		DeleteKeyColumn:     *deleteKeyColumn,
+		Limit:               *limit,
+		Offset:              *offset,
+		Sample:              *sample,
+		SamplePerFile:       *samplePerFile,
+		Seed:                *seed,
+		SourceColumn:        *sourceColumn,
+		RedactSalt:          *redactSalt,
+		SortedBy:            *sortedBy,
+		Unsorted:            *unsorted,
+		SortBy:              *sortBy,
+		SortNulls:           *sortNulls,
+		SortBufferRows:      *sortBufferRows,
+		SortExternal:        *sortExternal,
+		SortTempDir:         *sortTempDir,
+		BatchSize:           *batchSize,
+		NoFastpath:          *noFastpath,
+		Prefetch:            *prefetch,
+		CopyJobs:            *copyJobs,
+		MaxOpenFiles:        *maxOpenFiles,
+		CheckCRC:            *checkCRC,
+		HTTPBlockSize:       *httpBlockSize,
+		HTTPCacheBlocks:     *httpCacheBlocks,
+		HTTPRetries:         *httpRetries,
+		HTTPTimeout:         *httpTimeout,
+		OutFile:             *outfile,
+		OutputTemplate:      *outputTemplate,
+		MaxOutputRows:       *maxOutputRows,
+		MaxOutputBytes:      *maxOutputBytes,
+		StopAtBudget:        *stopAtBudget,
+		Leftover:            *leftover,
+		StateFile:           *stateFile,
+		Watch:               *watch,
+		Append:              *appendOutput,
+		WatchInterval:       *watchInterval,
+		PartitionBy:         *partitionBy,
+		ShardBy:             *shardBy,
+		Shards:              *shards,
+		DropPartitionColumn: *dropPartition,
+		MaxOpenWriters:      *maxOpenWriters,
+		Compression:         *compression,
+		RowGroupRows:        *rowGroupRows,
+		RowGroupBytes:       *rowGroupBytes,
+		PageBufferSize:      *pageBufferSize,
+		WriteBufferSize:     *writeBufferSize,
+		PageBufferPool:      *pageBufferPool,
+		BloomColumns:        splitList(*bloomColumns),
+		BloomBits:           *bloomBits,
+		KVConflict:          *kvConflict,
+		Verify:              *verify,
+		VerifyDeep:          *verifyDeep,
+		DryRun:              *dryRun,
+		OnInterrupt:         *onInterrupt,
+		Bench:               *bench,
+		Quiet:               *quiet || *quietLog,
+		ProgressInterval:    *progressInterval,
+		ProgressRows:        *progressRows,
+		ProgressJSON:        *progressJSON,
+	}
+	if *outfile == "-" {
+		opts.Output = os.Stdout
+	}
+	var err error
+	if *after != "" {
+		if opts.After, err = time.Parse(time.RFC3339, *after); err != nil {
+			return opts, fmt.Errorf("invalid -after: %w", err)
 		}
-		typ := schema.Type.String()
-		logicalType := ""
-		if schema.LogicalType != nil {
-			logicalType = schema.LogicalType.String()
+	}
+	if *before != "" {
+		if opts.Before, err = time.Parse(time.RFC3339, *before); err != nil {
+			return opts, fmt.Errorf("invalid -before: %w", err)
 		}
-
-		stype, err := schemaTypeToNode(typ, logicalType)
-		if err != nil {
-			return nil, err
+	}
+	if *targetBytes != "" {
+		if opts.TargetOutputBytes, err = merge.ParseSize(*targetBytes); err != nil {
+			return opts, fmt.Errorf("invalid -target-output-bytes: %w", err)
 		}
-		if currentNode, ok := nodes[schema.Name]; ok {
-			if currentNode != stype {
-				return nil, fmt.Errorf("schema mismatch: %s", schema.Name)
-			}
-		} else {
-			nodes[schema.Name] = stype
+	}
+	if *maxMemory != "" {
+		if opts.MaxMemory, err = merge.ParseSize(*maxMemory); err != nil {
+			return opts, fmt.Errorf("invalid -max-memory: %w", err)
+		}
+		if opts.MaxMemory == 0 {
+			return opts, errors.New("max-memory must be more than 0")
 		}
 	}
-
-	return nodes, nil
-}
-
-var (
-	nodemap = map[string]parquet.Node{
-		"INT8":       parquet.Optional(parquet.Int(8)),
-		"INT16":      parquet.Optional(parquet.Int(16)),
-		"INT32":      parquet.Optional(parquet.Int(32)),
-		"INT64":      parquet.Optional(parquet.Int(64)),
-		"UINT8":      parquet.Optional(parquet.Uint(8)),
-		"UINT16":     parquet.Optional(parquet.Uint(16)),
-		"UINT32":     parquet.Optional(parquet.Uint(32)),
-		"UINT64":     parquet.Optional(parquet.Uint(64)),
-		"FLOAT":      parquet.Optional(parquet.Leaf(parquet.FloatType)),
-		"DOUBLE":     parquet.Optional(parquet.Leaf(parquet.DoubleType)),
-		"BOOLEAN":    parquet.Optional(parquet.Leaf(parquet.BooleanType)),
-		"BYTE_ARRAY": parquet.Optional(parquet.Leaf(parquet.ByteArrayType)),
-	}
-	string_node = parquet.Optional(parquet.String())
-)
-
-func schemaTypeToNode(typ, logical string) (parquet.Node, error) {
-	if logical == "STRING" {
-		return string_node, nil
+	if *columnCodecs != "" {
+		if opts.ColumnCompression, err = parseColumnCodecs(*columnCodecs); err != nil {
+			return opts, err
+		}
+	}
+	if *timeNormalize != "" {
+		if opts.TimeNormalize, err = parseTimeNormalize(*timeNormalize); err != nil {
+			return opts, err
+		}
+	}
+	if *redact != "" {
+		if opts.Redact, err = parseRedact(*redact); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Renames, err = loadRenames(*rename, *renameFile); err != nil {
+		return opts, err
+	}
+	if opts.Defaults, err = loadDefaults(defaultFlags, *defaultsFile); err != nil {
+		return opts, err
+	}
+	if opts.TypeOverrides, err = loadTypeOverrides(*typeOverrides); err != nil {
+		return opts, err
+	}
+	if opts.Derive, err = loadDerives(deriveFlags); err != nil {
+		return opts, err
 	}
-	if node, ok := nodemap[typ]; ok {
-		return node, nil
+	if opts.RowGroups, err = loadRowGroups(rowGroupFlags); err != nil {
+		return opts, err
 	}
-	return nil, fmt.Errorf("unsupported type: %s, logical %s", typ, logical)
+	return opts, nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/skandragon/parquet-sandbox/merge"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("other"), exitFailure},
+		{merge.ErrInvalidOptions, exitUsage},
+		{merge.ErrNoInputs, exitNoInputs},
+		{merge.ErrSchemaConflict, exitSchema},
+		{merge.ErrRead, exitRead},
+		{merge.ErrWrite, exitWrite},
+		{merge.ErrVerify, exitVerify},
+		{merge.ErrLocked, exitLocked},
+		{merge.ErrInterrupted, exitInterrupted},
+		// An interrupted merge exits as interrupted, whatever failed
+		// because of it.
+		{fmt.Errorf("%w: %w", merge.ErrInterrupted, merge.ErrWrite), exitInterrupted},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", tt.err)
+		if got := exitStatus(err); got != tt.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", err, got, tt.want)
+		}
+	}
+}
+
+// runMerger runs the merger with args in dir, and returns its exit status
+// and what it logged.
+func runMerger(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMergerMain$", "--"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "MERGER_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, string(out)
+}
+
+// TestMergerMain runs main with the arguments after -- that runMerger
+// passes it.
+func TestMergerMain(t *testing.T) {
+	if os.Getenv("MERGER_MAIN") != "1" {
+		t.Skip("run by runMerger")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"merger"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+// writeFile writes a parquet file of one row holding id to path.
+func writeFile(t *testing.T, path string, id any) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	node := parquet.Node(parquet.Int(64))
+	if _, ok := id.(string); ok {
+		node = parquet.String()
+	}
+	w := parquet.NewGenericWriter[map[string]any](f, parquet.NewSchema("test", parquet.Group{"id": node}))
+	if _, err := w.Write([]map[string]any{{"id": id}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the merger")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.parquet"), int64(1))
+	writeFile(t, filepath.Join(dir, "b.parquet"), "two")
+	if err := os.WriteFile(filepath.Join(dir, "c.parquet"), []byte("not parquet"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"merged", []string{"-q", "-outfile", "ok.parquet", "a.parquet"}, 0},
+		{"bad flag", []string{"-no-such-flag"}, exitUsage},
+		{"bad option", []string{"-q", "-int96-as", "bogus", "a.parquet"}, exitUsage},
+		{"no inputs", []string{"-q", "-outfile", "none.parquet", "-requireFields", "missing", "a.parquet"}, exitNoInputs},
+		{"conflict", []string{"-q", "-outfile", "conflict.parquet", "-on-conflict", "fail", "a.parquet", "b.parquet"}, exitSchema},
+		{"no files", []string{"-q", "-outfile", "none.parquet", "missing.parquet"}, exitNoInputs},
+		{"unreadable", []string{"-q", "-outfile", "bad.parquet", "a.parquet", "c.parquet"}, exitRead},
+	}
+	for _, tt := range tests {
+		if got, out := runMerger(t, dir, tt.args...); got != tt.want {
+			t.Errorf("%s: merger %s exited with %d, want %d\n%s", tt.name, strings.Join(tt.args, " "), got, tt.want, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ok.parquet")); err != nil {
+		t.Errorf("the merge that succeeded wrote no output: %v", err)
+	}
+}
+
+func TestLogFlags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the merger")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.parquet"), int64(1))
+	tests := []struct {
+		flag  string
+		level string
+		want  bool
+	}{
+		{"-q", "level=INFO", false},
+		{"-quiet", "level=INFO", false},
+		{"-v", "level=DEBUG", true},
+		{"-verbose", "level=DEBUG", true},
+	}
+	for _, tt := range tests {
+		code, out := runMerger(t, dir, tt.flag, "-outfile", "merged.parquet", "a.parquet")
+		if code != 0 {
+			t.Fatalf("merger %s exited with %d\n%s", tt.flag, code, out)
+		}
+		if got := strings.Contains(out, tt.level); got != tt.want {
+			t.Errorf("merger %s logged %s: %v, want %v\n%s", tt.flag, tt.level, got, tt.want, out)
+		}
+		os.Remove(filepath.Join(dir, "merged.parquet"))
+	}
+}
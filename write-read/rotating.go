@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// RotationConfig decides when a RotatingMapWriter starts a new file.
+type RotationConfig struct {
+	// Template names the files: {seq}, or {seq:05d} with a format, is
+	// replaced by the sequence number of the file, from 1, and {start_ts}
+	// by the UTC time it was started at, as 20060102T150405Z.
+	Template string
+	// MaxFileBytes, MaxFileRows and MaxFileAge end a file once it holds
+	// about that many bytes, counting the rows not yet flushed by the size
+	// of their values, that many rows, or was started that long ago.  Age
+	// is checked as rows are written and flushed.  Zero is no limit.
+	MaxFileBytes int64
+	MaxFileRows  int64
+	MaxFileAge   time.Duration
+	// OnRotate, if set, is called with the path and rows of each file
+	// once it is finished and renamed into place.
+	OnRotate func(path string, rows int64)
+}
+
+// RotatingMapWriter writes rows to a series of files, each written by a
+// ParquetMapWriter, starting a new one whenever the current one reaches
+// a limit of its RotationConfig.
+type RotatingMapWriter struct {
+	schema *parquet.Schema
+	config RotationConfig
+	opts   []Option
+	// current is the file being written, if any, started at start and
+	// holding rows rows.  seq is the sequence number of the last file
+	// started.
+	current *ParquetMapWriter
+	start   time.Time
+	rows    int64
+	seq     int
+	// metadata holds the pairs of SetKeyValueMetadata, written to the
+	// footer of every file from then on.
+	metadata map[string]string
+	// closed is set by Close and Abort, and closeErr is what Close
+	// returns from then on.
+	closed   bool
+	closeErr error
+}
+
+var _ MapWriter = (*RotatingMapWriter)(nil)
+
+// templateField matches the fields of RotationConfig.Template.
+var templateField = regexp.MustCompile(`\{[^}]*\}`)
+
+// seqFormat matches the formats of {seq:...}.
+var seqFormat = regexp.MustCompile(`^0?[0-9]*d$`)
+
+// NewRotatingMapWriter returns a RotatingMapWriter writing rows of schema
+// as config says, with ParquetMapWriters configured by opts.  The first
+// file is started by the first rows written.
+func NewRotatingMapWriter(schema *parquet.Schema, config RotationConfig, opts ...Option) (*RotatingMapWriter, error) {
+	if err := checkTemplate(config.Template); err != nil {
+		return nil, err
+	}
+	return &RotatingMapWriter{schema: schema, config: config, opts: opts}, nil
+}
+
+// checkTemplate checks that template has only known fields, and a {seq}
+// so that files are not named alike.
+func checkTemplate(template string) error {
+	seq := false
+	for _, field := range templateField.FindAllString(template, -1) {
+		name, format, hasFormat := strings.Cut(field[1:len(field)-1], ":")
+		switch {
+		case name == "seq" && (!hasFormat || seqFormat.MatchString(format)):
+			seq = true
+		case name == "start_ts" && !hasFormat:
+		default:
+			return fmt.Errorf("template %q: unknown field %s", template, field)
+		}
+	}
+	if !seq {
+		return fmt.Errorf("template %q has no {seq}", template)
+	}
+	return nil
+}
+
+// path returns the name of file seq, started at start.
+func (w *RotatingMapWriter) path(seq int, start time.Time) string {
+	return templateField.ReplaceAllStringFunc(w.config.Template, func(field string) string {
+		name, format, _ := strings.Cut(field[1:len(field)-1], ":")
+		if name == "start_ts" {
+			return start.UTC().Format("20060102T150405Z")
+		}
+		if format == "" {
+			format = "d"
+		}
+		return fmt.Sprintf("%"+format, seq)
+	})
+}
+
+// WriteRows writes rows, finishing the current file and starting another
+// whenever it reaches a limit.
+func (w *RotatingMapWriter) WriteRows(rows []map[string]any) (count int, err error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	for len(rows) > 0 {
+		if w.expired() {
+			if err := w.rotate(); err != nil {
+				return count, err
+			}
+		}
+		if w.current == nil {
+			w.start = time.Now()
+			w.seq++
+			w.rows = 0
+			opts := w.opts
+			if len(w.metadata) > 0 {
+				opts = append(opts[:len(opts):len(opts)], WithKeyValueMetadata(w.metadata))
+			}
+			if w.current, err = NewParquetMapWriter(w.path(w.seq, w.start), w.schema, opts...); err != nil {
+				return count, err
+			}
+			w.current.sizeRows = w.config.MaxFileBytes > 0
+		}
+		n, full := w.fill(rows)
+		written, err := w.current.WriteRows(rows[:n])
+		count += written
+		w.rows += int64(written)
+		if err != nil {
+			return count, err
+		}
+		if full {
+			if err := w.rotate(); err != nil {
+				return count, err
+			}
+		}
+		rows = rows[n:]
+	}
+	return count, nil
+}
+
+// fill returns how many of rows go into the current file, and whether
+// they fill it.
+func (w *RotatingMapWriter) fill(rows []map[string]any) (int, bool) {
+	if w.config.MaxFileRows <= 0 && w.config.MaxFileBytes <= 0 {
+		return len(rows), false
+	}
+	var size int64
+	if w.config.MaxFileBytes > 0 {
+		size = w.current.estimatedSize()
+	}
+	for i, row := range rows {
+		if w.config.MaxFileRows > 0 && w.rows+int64(i+1) >= w.config.MaxFileRows {
+			return i + 1, true
+		}
+		if w.config.MaxFileBytes > 0 {
+			if size += rowSize(row); size >= w.config.MaxFileBytes {
+				return i + 1, true
+			}
+		}
+	}
+	return len(rows), false
+}
+
+// expired reports whether the current file has reached MaxFileAge.
+func (w *RotatingMapWriter) expired() bool {
+	return w.current != nil && w.config.MaxFileAge > 0 && time.Since(w.start) >= w.config.MaxFileAge
+}
+
+// rotate finishes the current file and passes it to OnRotate.
+func (w *RotatingMapWriter) rotate() error {
+	current := w.current
+	w.current = nil
+	if err := current.Close(); err != nil {
+		return err
+	}
+	if w.config.OnRotate != nil {
+		w.config.OnRotate(current.filename, w.rows)
+	}
+	return nil
+}
+
+// SetKeyValueMetadata sets key to value in the footer of the current file
+// and of those started after it.  It fails once the writer is closed.
+func (w *RotatingMapWriter) SetKeyValueMetadata(key, value string) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.metadata == nil {
+		w.metadata = map[string]string{}
+	}
+	w.metadata[key] = value
+	if w.current != nil {
+		return w.current.SetKeyValueMetadata(key, value)
+	}
+	return nil
+}
+
+// Flush ends the row group of the current file, or finishes the file if
+// it has reached MaxFileAge.
+func (w *RotatingMapWriter) Flush() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.current == nil {
+		return nil
+	}
+	if w.expired() {
+		return w.rotate()
+	}
+	return w.current.Flush()
+}
+
+// Close finishes the current file.  Calling it again returns the same
+// error.
+func (w *RotatingMapWriter) Close() error {
+	if w.closed {
+		return w.closeErr
+	}
+	w.closed = true
+	if w.current != nil {
+		w.closeErr = w.rotate()
+	}
+	return w.closeErr
+}
+
+// Abort removes the current file without renaming it into place.  The
+// files already finished are kept.  Like ParquetMapWriter.Abort, it does
+// nothing once the writer is closed or aborted.
+func (w *RotatingMapWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.closeErr = errWriterAborted
+	if w.current == nil {
+		return nil
+	}
+	return w.current.Abort()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	type rotated struct {
+		path string
+		rows int64
+	}
+	var got []rotated
+	w, err := NewRotatingMapWriter(testSchema(t), RotationConfig{
+		Template:    filepath.Join(dir, "out-{seq:03d}.parquet"),
+		MaxFileRows: 3,
+		OnRotate:    func(path string, rows int64) { got = append(got, rotated{path, rows}) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetKeyValueMetadata("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteRows(testRows(7, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var want []rotated
+	for i, rows := range []int64{3, 3, 1} {
+		want = append(want, rotated{filepath.Join(dir, fmt.Sprintf("out-%03d.parquet", i+1)), rows})
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("rotated %v, want %v", got, want)
+	}
+	for _, r := range want {
+		pf := openFile(t, r.path)
+		if pf.NumRows() != r.rows {
+			t.Errorf("%s has %d rows, want %d", r.path, pf.NumRows(), r.rows)
+		}
+		if v, _ := pf.Lookup("k"); v != "v" {
+			t.Errorf("%s has k = %q, want v", r.path, v)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("wrote %d files, want %d", len(entries), len(want))
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	for _, template := range []string{"out.parquet", "out-{seq:x}.parquet", "out-{start_ts}.parquet", "out-{seq}-{host}.parquet"} {
+		if _, err := NewRotatingMapWriter(nil, RotationConfig{Template: template}); err == nil {
+			t.Errorf("template %q was accepted", template)
+		}
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 
+	kzstd "github.com/klauspost/compress/zstd"
 	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/compress/zstd"
 )
 
 type LogRow struct {
@@ -65,47 +70,473 @@ func schemaFromMap(name string, typemap map[string]any) (*parquet.Schema, error)
 
 type MapWriter interface {
 	WriteRows(rows []map[string]any) (count int, err error)
+	// Flush ends the current row group, writing out the rows buffered so
+	// far.
+	Flush() error
 	Close() error
 }
 
 type ParquetMapWriter struct {
-	writer   *parquet.GenericWriter[map[string]any]
+	writer   rowWriter
+	file     *os.File
 	filename string
 	tmpname  string
+	config   MapWriterConfig
+	// buffered and bufferedBytes count the rows written since the last
+	// flush and the approximate size of their values, and rowGroups the
+	// row groups flushed.  Rows are only sized with MaxBytesPerGroup or,
+	// set by a RotatingMapWriter limiting the size of its files, sizeRows.
+	buffered      int
+	bufferedBytes int64
+	rowGroups     int
+	sizeRows      bool
+	// closed is set by Close and Abort, and closeErr is what Close
+	// returns from then on.
+	closed   bool
+	closeErr error
+}
+
+// MapWriterConfig is the configuration of a ParquetMapWriter.
+type MapWriterConfig struct {
+	// MaxRowsPerGroup and MaxBytesPerGroup end a row group once it holds
+	// that many rows or about that many bytes of values.  Zero leaves it
+	// to parquet-go.
+	MaxRowsPerGroup  int64
+	MaxBytesPerGroup int64
+	// Codec compresses the pages: snappy, gzip, zstd, lz4 or none.
+	// ZstdLevel is the zstd level, from 1 to 22, which the Go encoder
+	// has four speeds for: 1-2, 3-5, 6-9 and 10-22.  Levels of the same
+	// speed write the same output.
+	Codec     string
+	ZstdLevel int
+	// DataPageSize is the size pages are cut at.
+	DataPageSize int
+	// Dictionary dictionary-encodes the columns other than booleans, and
+	// PageStatistics writes statistics in the page headers as well as the
+	// column chunks.
+	Dictionary     bool
+	PageStatistics bool
+	// SortingColumns sort the rows of each row group, as sortingColumn
+	// parses them.
+	SortingColumns []string
+	// KeyValueMetadata is written to the footer of the file.
+	KeyValueMetadata map[string]string
+	// SyncOnFlush makes Flush also fsync the file, so the row groups
+	// flushed survive a crash.
+	SyncOnFlush bool
+}
+
+// rowWriter is what a ParquetMapWriter writes with, a
+// parquet.GenericWriter or, to sort rows, a parquet.SortingWriter.
+type rowWriter interface {
+	Write(rows []map[string]any) (int, error)
+	SetKeyValueMetadata(key, value string)
+	Flush() error
+	Close() error
+}
+
+// sortBufferRows is the number of rows a sorting writer sorts in memory at
+// a time, unless row groups are smaller.
+const sortBufferRows = 64 * 1024
+
+// defaultMapWriterConfig is the configuration of a ParquetMapWriter
+// without options.
+var defaultMapWriterConfig = MapWriterConfig{
+	Codec:        "zstd",
+	ZstdLevel:    3,
+	DataPageSize: parquet.DefaultPageBufferSize,
+}
+
+// Option configures a ParquetMapWriter.
+type Option func(*MapWriterConfig)
+
+// WithMaxRowsPerGroup ends row groups at n rows.
+func WithMaxRowsPerGroup(n int64) Option {
+	return func(c *MapWriterConfig) { c.MaxRowsPerGroup = n }
+}
+
+// WithMaxBytesPerGroup ends row groups once their values take about b
+// bytes, as valueSize estimates them.
+func WithMaxBytesPerGroup(b int64) Option {
+	return func(c *MapWriterConfig) { c.MaxBytesPerGroup = b }
+}
+
+// WithCodec compresses the pages with codec: snappy, gzip, zstd, lz4 or
+// none.
+func WithCodec(codec string) Option {
+	return func(c *MapWriterConfig) { c.Codec = codec }
+}
+
+// WithZstdLevel sets the zstd level, from 1 to 22, in the four speeds
+// of MapWriterConfig.ZstdLevel.
+func WithZstdLevel(level int) Option {
+	return func(c *MapWriterConfig) { c.ZstdLevel = level }
+}
+
+// WithDataPageSize cuts pages at size bytes.
+func WithDataPageSize(size int) Option {
+	return func(c *MapWriterConfig) { c.DataPageSize = size }
+}
+
+// WithDictionary turns dictionary encoding on or off.
+func WithDictionary(enabled bool) Option {
+	return func(c *MapWriterConfig) { c.Dictionary = enabled }
+}
+
+// WithPageStatistics turns the statistics of the page headers on or off.
+// The column chunks always have theirs.
+func WithPageStatistics(enabled bool) Option {
+	return func(c *MapWriterConfig) { c.PageStatistics = enabled }
+}
+
+// WithSortingColumns sorts the rows of each row group by columns, given
+// as specs like timestamp:asc:nullslast.
+func WithSortingColumns(columns ...string) Option {
+	return func(c *MapWriterConfig) { c.SortingColumns = columns }
+}
+
+// WithKeyValueMetadata writes metadata to the footer of the file, along
+// with the pairs of earlier options and those set with
+// SetKeyValueMetadata, the last value of a key winning.
+func WithKeyValueMetadata(metadata map[string]string) Option {
+	return func(c *MapWriterConfig) {
+		kv := make(map[string]string, len(c.KeyValueMetadata)+len(metadata))
+		for k, v := range c.KeyValueMetadata {
+			kv[k] = v
+		}
+		for k, v := range metadata {
+			kv[k] = v
+		}
+		c.KeyValueMetadata = kv
+	}
+}
+
+// WithSyncOnFlush makes Flush also fsync the file.
+func WithSyncOnFlush(enabled bool) Option {
+	return func(c *MapWriterConfig) { c.SyncOnFlush = enabled }
+}
+
+// sortingColumn parses spec, a column name followed by :asc or :desc and
+// :nullsfirst or :nullslast, ascending with nulls last by default, for the
+// columns of schema.  Rows missing an optional column sort as nulls, and
+// those missing a required one by its zero value, which they are written
+// with.
+func sortingColumn(schema *parquet.Schema, spec string) (parquet.SortingColumn, error) {
+	parts := strings.Split(spec, ":")
+	path := strings.Split(parts[0], ".")
+	if _, ok := schema.Lookup(path...); !ok {
+		return nil, fmt.Errorf("sorting column %s is not a leaf column of the schema", parts[0])
+	}
+	descending, nullsFirst := false, false
+	for _, p := range parts[1:] {
+		switch p {
+		case "asc":
+			descending = false
+		case "desc":
+			descending = true
+		case "nullsfirst":
+			nullsFirst = true
+		case "nullslast":
+			nullsFirst = false
+		default:
+			return nil, fmt.Errorf("sorting column %s: unknown order %q", parts[0], p)
+		}
+	}
+	column := parquet.Ascending(path...)
+	if descending {
+		column = parquet.Descending(path...)
+	}
+	if nullsFirst {
+		column = parquet.NullsFirst(column)
+	}
+	return column, nil
+}
+
+// codec returns the compression codec of c.
+func (c MapWriterConfig) codec() (compress.Codec, error) {
+	switch c.Codec {
+	case "snappy":
+		return &parquet.Snappy, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "zstd":
+		if c.ZstdLevel < 1 || c.ZstdLevel > 22 {
+			return nil, fmt.Errorf("zstd level %d is not between 1 and 22", c.ZstdLevel)
+		}
+		return &zstd.Codec{Level: kzstd.EncoderLevelFromZstd(c.ZstdLevel)}, nil
+	case "lz4":
+		return &parquet.Lz4Raw, nil
+	case "none":
+		return &parquet.Uncompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", c.Codec)
+	}
+}
+
+// dictionaryNode returns node with its leaves other than booleans
+// dictionary-encoded.  LISTs and MAPs are left as they are.
+func dictionaryNode(node parquet.Node) parquet.Node {
+	if node.Leaf() {
+		if node.Type().Kind() == parquet.Boolean {
+			return node
+		}
+		return parquet.Encoded(node, &parquet.RLEDictionary)
+	}
+	if node.Type().LogicalType() != nil {
+		return node
+	}
+	group := parquet.Group{}
+	for _, f := range node.Fields() {
+		group[f.Name()] = dictionaryNode(f)
+	}
+	switch {
+	case node.Optional():
+		return parquet.Optional(group)
+	case node.Repeated():
+		return parquet.Repeated(group)
+	}
+	return group
 }
 
 var (
 	_ MapWriter = (*ParquetMapWriter)(nil)
 )
 
-func NewParquetMapWriter(filename string, schema *parquet.Schema) (*ParquetMapWriter, error) {
+var (
+	errWriterClosed  = errors.New("writer closed")
+	errWriterAborted = errors.New("writer aborted")
+)
+
+func NewParquetMapWriter(filename string, schema *parquet.Schema, opts ...Option) (*ParquetMapWriter, error) {
+	config := defaultMapWriterConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	codec, err := config.codec()
+	if err != nil {
+		return nil, err
+	}
+	if config.DataPageSize <= 0 {
+		return nil, fmt.Errorf("data page size %d is not positive", config.DataPageSize)
+	}
+	if config.Dictionary {
+		schema = parquet.NewSchema(schema.Name(), dictionaryNode(schema))
+	}
+	var sorting []parquet.SortingColumn
+	for _, spec := range config.SortingColumns {
+		column, err := sortingColumn(schema, spec)
+		if err != nil {
+			return nil, err
+		}
+		sorting = append(sorting, column)
+	}
 	tmpname := filename + ".tmp"
 	f, err := os.Create(tmpname)
 	if err != nil {
 		return nil, fmt.Errorf("error creating file: %v", err)
 	}
-	wc, err := parquet.NewWriterConfig(schema, parquet.Compression(&parquet.Zstd))
+	wc, err := parquet.NewWriterConfig(
+		schema,
+		parquet.Compression(codec),
+		parquet.PageBufferSize(config.DataPageSize),
+		parquet.DataPageStatistics(config.PageStatistics),
+		parquet.SortingWriterConfig(parquet.SortingColumns(sorting...)),
+	)
 	if err != nil {
+		f.Close()
+		os.Remove(tmpname)
 		return nil, fmt.Errorf("error creating writer config: %v", err)
 	}
-	writer := parquet.NewGenericWriter[map[string]any](f, wc)
-	return &ParquetMapWriter{writer: writer, filename: filename, tmpname: tmpname}, nil
+	var writer rowWriter
+	if len(sorting) > 0 {
+		// The sorting writer sorts the rows buffered when flushed, so
+		// each row group is sorted.
+		bufferRows := int64(sortBufferRows)
+		if config.MaxRowsPerGroup > 0 && config.MaxRowsPerGroup < bufferRows {
+			bufferRows = config.MaxRowsPerGroup
+		}
+		writer = parquet.NewSortingWriter[map[string]any](f, bufferRows, wc)
+	} else {
+		writer = parquet.NewGenericWriter[map[string]any](f, wc)
+	}
+	for k, v := range config.KeyValueMetadata {
+		writer.SetKeyValueMetadata(k, v)
+	}
+	return &ParquetMapWriter{writer: writer, file: f, filename: filename, tmpname: tmpname, config: config}, nil
 }
 
+// WriteRows writes rows, flushing each row group that reaches the
+// configured rows or bytes.
 func (w *ParquetMapWriter) WriteRows(rows []map[string]any) (count int, err error) {
-	return w.writer.Write(rows)
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	for len(rows) > 0 {
+		n, full := w.fill(rows)
+		written, err := w.writer.Write(rows[:n])
+		count += written
+		w.buffered += written
+		if err != nil {
+			return count, err
+		}
+		if full {
+			if err := w.Flush(); err != nil {
+				return count, err
+			}
+		}
+		rows = rows[n:]
+	}
+	return count, nil
+}
+
+// fill returns how many of rows go into the current row group, adding
+// their size to bufferedBytes, and whether they fill it.
+func (w *ParquetMapWriter) fill(rows []map[string]any) (int, bool) {
+	sized := w.sizeRows || w.config.MaxBytesPerGroup > 0
+	if !sized && w.config.MaxRowsPerGroup <= 0 {
+		return len(rows), false
+	}
+	for i, row := range rows {
+		if sized {
+			w.bufferedBytes += rowSize(row)
+		}
+		if w.config.MaxRowsPerGroup > 0 && int64(w.buffered+i+1) >= w.config.MaxRowsPerGroup {
+			return i + 1, true
+		}
+		if w.config.MaxBytesPerGroup > 0 && w.bufferedBytes >= w.config.MaxBytesPerGroup {
+			return i + 1, true
+		}
+	}
+	return len(rows), false
+}
+
+// rowSize returns the approximate encoded size of the values of row.
+func rowSize(row map[string]any) int64 {
+	var size int64
+	for _, v := range row {
+		size += valueSize(v)
+	}
+	return size
+}
+
+// valueSize returns the approximate encoded size of v, stored as
+// nodeFromType stores it.
+func valueSize(v any) int64 {
+	switch x := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int8, byte, int16, int32, int:
+		return 4
+	case string:
+		// Byte arrays are prefixed by their length.
+		return 4 + int64(len(x))
+	case []byte:
+		return 4 + int64(len(x))
+	default:
+		return 8
+	}
 }
 
+// SetKeyValueMetadata sets key to value in the footer of the file,
+// replacing any earlier value.  It fails once the writer is closed.
+func (w *ParquetMapWriter) SetKeyValueMetadata(key, value string) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	w.writer.SetKeyValueMetadata(key, value)
+	return nil
+}
+
+// Config returns the configuration of the writer.
+func (w *ParquetMapWriter) Config() MapWriterConfig {
+	return w.config
+}
+
+// estimatedSize returns the bytes written to the file so far plus the
+// approximate size of the values of the rows not yet flushed, which are
+// counted only if the rows are sized.
+func (w *ParquetMapWriter) estimatedSize() int64 {
+	written, _ := w.file.Seek(0, io.SeekCurrent)
+	return written + w.bufferedBytes
+}
+
+// RowGroups returns the number of row groups written so far.
+func (w *ParquetMapWriter) RowGroups() int {
+	return w.rowGroups
+}
+
+// Flush writes the buffered rows out as a row group.  It does nothing if
+// no rows were written since the last flush.
+func (w *ParquetMapWriter) Flush() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.buffered == 0 {
+		return nil
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("error flushing writer: %v", err)
+	}
+	w.buffered, w.bufferedBytes = 0, 0
+	w.rowGroups++
+	if w.config.SyncOnFlush {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("error syncing file: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close finishes the file and renames it into place, or removes it if it
+// cannot be finished.  Calling it again returns the same error.
 func (w *ParquetMapWriter) Close() error {
+	if w.closed {
+		return w.closeErr
+	}
+	w.closed = true
+	w.closeErr = w.finish()
+	if w.closeErr != nil {
+		w.file.Close()
+		os.Remove(w.tmpname)
+	}
+	return w.closeErr
+}
+
+func (w *ParquetMapWriter) finish() error {
 	if err := w.writer.Close(); err != nil {
 		return fmt.Errorf("error closing writer: %v", err)
 	}
+	if w.buffered > 0 {
+		w.rowGroups++
+		w.buffered, w.bufferedBytes = 0, 0
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing file: %v", err)
+	}
 	if err := os.Rename(w.tmpname, w.filename); err != nil {
 		return fmt.Errorf("error renaming file: %v", err)
 	}
 	return nil
 }
 
+// Abort closes the file and removes it without renaming it into place.
+// It does nothing once the writer is closed or aborted, so it can be
+// deferred to clean up after errors.
+func (w *ParquetMapWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.closeErr = errWriterAborted
+	w.file.Close()
+	if err := os.Remove(w.tmpname); err != nil {
+		return fmt.Errorf("error removing file: %v", err)
+	}
+	return nil
+}
+
 func main() {
 	typemap := map[string]any{
 		"timestamp":    int64(0),
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// testSchema returns the schema of the rows of testRows.
+func testSchema(t *testing.T) *parquet.Schema {
+	t.Helper()
+	schema, err := schemaFromMap("test", map[string]any{"id": int64(0), "message": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// testRows returns n rows with ids from 0 and messages of size bytes.
+func testRows(n, size int) []map[string]any {
+	rows := make([]map[string]any, n)
+	for i := range rows {
+		rows[i] = map[string]any{"id": int64(i), "message": strings.Repeat("x", size)}
+	}
+	return rows
+}
+
+// openFile opens the parquet file path.
+func openFile(t *testing.T, path string) *parquet.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pf
+}
+
+// groupRows returns the number of rows of each row group of pf.
+func groupRows(pf *parquet.File) []int64 {
+	var rows []int64
+	for _, rg := range pf.RowGroups() {
+		rows = append(rows, rg.NumRows())
+	}
+	return rows
+}
+
+// writeFile writes rows to path, in one call, with a writer configured by
+// opts, and returns the writer, closed.
+func writeFile(t *testing.T, path string, rows []map[string]any, opts ...Option) *ParquetMapWriter {
+	t.Helper()
+	w, err := NewParquetMapWriter(path, testSchema(t), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteRows(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestRowGroupRollover(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want []int64
+	}{
+		{"rows", WithMaxRowsPerGroup(4), []int64{4, 4, 2}},
+		// Each row is about 8 + 4 + 96 bytes, so three fill 300.
+		{"bytes", WithMaxBytesPerGroup(300), []int64{3, 3, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.parquet")
+			w := writeFile(t, path, testRows(10, 96), tt.opt)
+			got := groupRows(openFile(t, path))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("wrote row groups of %v rows, want %v", got, tt.want)
+			}
+			if w.RowGroups() != len(tt.want) {
+				t.Errorf("RowGroups() = %d, want %d", w.RowGroups(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestFlushAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	w, err := NewParquetMapWriter(path, testSchema(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush with no rows: %v", err)
+	}
+	if w.RowGroups() != 0 {
+		t.Errorf("Flush with no rows wrote %d row groups", w.RowGroups())
+	}
+	if _, err := w.WriteRows(testRows(3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.RowGroups() != 1 {
+		t.Errorf("RowGroups() = %d, want 1", w.RowGroups())
+	}
+	if err := w.Flush(); !errors.Is(err, errWriterClosed) {
+		t.Errorf("Flush after Close: %v, want %v", err, errWriterClosed)
+	}
+	if _, err := w.WriteRows(testRows(1, 1)); !errors.Is(err, errWriterClosed) {
+		t.Errorf("WriteRows after Close: %v, want %v", err, errWriterClosed)
+	}
+	if err := w.Abort(); err != nil {
+		t.Errorf("Abort after Close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close again: %v", err)
+	}
+	if got := groupRows(openFile(t, path)); !slices.Equal(got, []int64{3}) {
+		t.Errorf("wrote row groups of %v rows, want [3]", got)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	w, err := NewParquetMapWriter(path, testSchema(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteRows(testRows(3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, errWriterAborted) {
+		t.Errorf("Close after Abort: %v, want %v", err, errWriterAborted)
+	}
+	for _, name := range []string{path, path + ".tmp"} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s is left after Abort: %v", name, err)
+		}
+	}
+}
+
+func TestSortingColumnsFooter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	writeFile(t, path, testRows(5, 1), WithSortingColumns("id:desc:nullsfirst"))
+	pf := openFile(t, path)
+	for i, rg := range pf.RowGroups() {
+		sorting := rg.SortingColumns()
+		if len(sorting) != 1 {
+			t.Fatalf("row group %d has %d sorting columns, want 1", i, len(sorting))
+		}
+		s := sorting[0]
+		if got := strings.Join(s.Path(), "."); got != "id" || !s.Descending() || !s.NullsFirst() {
+			t.Errorf("row group %d is sorted by %s descending %t nulls first %t, want id descending nulls first", i, got, s.Descending(), s.NullsFirst())
+		}
+	}
+	rows := make([]parquet.Row, 5)
+	n, _ := pf.RowGroups()[0].Rows().ReadRows(rows)
+	var ids []int64
+	for _, row := range rows[:n] {
+		ids = append(ids, row[0].Int64())
+	}
+	if want := []int64{4, 3, 2, 1, 0}; !slices.Equal(ids, want) {
+		t.Errorf("wrote the ids %v, want %v", ids, want)
+	}
+}
+
+func TestKeyValueOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	w, err := NewParquetMapWriter(path, testSchema(t),
+		WithKeyValueMetadata(map[string]string{"a": "1", "b": "1"}),
+		WithKeyValueMetadata(map[string]string{"b": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetKeyValueMetadata("a", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteRows(testRows(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetKeyValueMetadata("a", "4"); !errors.Is(err, errWriterClosed) {
+		t.Errorf("SetKeyValueMetadata after Close: %v, want %v", err, errWriterClosed)
+	}
+	pf := openFile(t, path)
+	for key, want := range map[string]string{"a": "3", "b": "2"} {
+		if got, _ := pf.Lookup(key); got != want {
+			t.Errorf("key %s = %q, want %q", key, got, want)
+		}
+	}
+	if n := len(pf.Metadata().KeyValueMetadata); n != 2 {
+		t.Errorf("footer has %d key-value pairs, want 2", n)
+	}
+}